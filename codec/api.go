@@ -103,8 +103,36 @@ type (
 	// See the documentation on the Encoder function interface for more discussion of multicodecs,
 	// the multicodec table, and how this is typically connected to linking.
 	Decoder func(datamodel.NodeAssembler, io.Reader) error
+
+	// LimitedDecoder defines the shape of a function which returns a Decoder
+	// that will respect the given DecodeLimits.
+	//
+	// Codecs which support resource limits can offer a LimitedDecoder
+	// in addition to their plain Decoder, and register it in the multicodec registry.
+	// A LinkSystem will use this to apply its DecodeLimits to whatever codec a Link calls for
+	// (see LinkSystem.LimitedDecoderChooser).
+	LimitedDecoder func(DecodeLimits) Decoder
 )
 
+// DecodeLimits describes resource limits which a caller wants a Decoder to respect.
+// It carries the subset of configuration that is common to many codecs;
+// codecs with more specific options still offer those on their own config types.
+//
+// Zero values in any field mean "use the codec's default".
+type DecodeLimits struct {
+	// AllocationBudget caps the resources (nodes, map entries, list elements, string and bytes content)
+	// the decoder may allocate.  Codecs that don't track allocations may ignore this.
+	AllocationBudget int64
+
+	// MaxDepth caps how deeply maps and lists may nest.
+	MaxDepth int64
+}
+
+// IsZero returns true if no limits are set.
+func (l DecodeLimits) IsZero() bool {
+	return l == DecodeLimits{}
+}
+
 // -------------------
 //  Errors
 //
@@ -115,6 +143,22 @@ func (e ErrBudgetExhausted) Error() string {
 	return "decoder resource budget exhausted (message too long or too complex)"
 }
 
+// ErrLimitExceeded is returned by decoders which halted because one of their resource limits
+// (such as those described by DecodeLimits) was reached.
+// Codecs may keep their own sentinel values of this type
+// (declared as plain error variables, as they always have been, so that they can still be compared with ==);
+// use errors.Is with a zero ErrLimitExceeded, or errors.As, to recognize any of them.
+type ErrLimitExceeded struct {
+	Detail string
+}
+
+func (e ErrLimitExceeded) Error() string { return e.Detail }
+
+func (e ErrLimitExceeded) Is(target error) bool {
+	t, ok := target.(ErrLimitExceeded)
+	return ok && (t.Detail == "" || t.Detail == e.Detail)
+}
+
 // ---------------------
 //  Other valuable and reused constants
 //
@@ -9,13 +9,15 @@ import (
 )
 
 var (
-	_ codec.Decoder = Decode
-	_ codec.Encoder = Encode
+	_ codec.Decoder        = Decode
+	_ codec.Encoder        = Encode
+	_ codec.LimitedDecoder = LimitedDecoder
 )
 
 func init() {
 	multicodec.RegisterEncoder(0x71, Encode)
 	multicodec.RegisterDecoder(0x71, Decode)
+	multicodec.RegisterLimitedDecoder(0x71, LimitedDecoder)
 }
 
 // Decode deserializes data from the given io.Reader and feeds it into the given datamodel.NodeAssembler.
@@ -32,6 +34,20 @@ func Decode(na datamodel.NodeAssembler, r io.Reader) error {
 	}.Decode(na, r)
 }
 
+// LimitedDecoder returns a Decoder with the same defaults as Decode,
+// but which also respects the given codec.DecodeLimits.
+// LimitedDecoder fits the codec.LimitedDecoder function interface.
+//
+// This is the function that will be registered in the default multicodec registry during package init time,
+// and is what a LinkSystem with DecodeLimits set will use for dag-cbor data.
+func LimitedDecoder(limits codec.DecodeLimits) codec.Decoder {
+	return DecodeOptions{
+		AllowLinks:       true,
+		AllocationBudget: limits.AllocationBudget,
+		MaxDepth:         limits.MaxDepth,
+	}.Decode
+}
+
 // Encode walks the given datamodel.Node and serializes it to the given io.Writer.
 // Encode fits the codec.Encoder function interface.
 //
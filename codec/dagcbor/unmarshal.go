@@ -11,16 +11,17 @@ import (
 	"github.com/polydawn/refmt/shared"
 	"github.com/polydawn/refmt/tok"
 
+	"github.com/ipld/go-ipld-prime/codec"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
 )
 
 var (
-	ErrInvalidMultibase         = errors.New("invalid multibase on IPLD link")
-	ErrAllocationBudgetExceeded = errors.New("message structure demanded too many resources to process")
-	ErrDecodeDepthExceeded      = errors.New("message structure exceeded maximum nesting depth")
-	ErrTrailingBytes            = errors.New("unexpected content after end of cbor object")
+	ErrInvalidMultibase               = errors.New("invalid multibase on IPLD link")
+	ErrAllocationBudgetExceeded error = codec.ErrLimitExceeded{Detail: "message structure demanded too many resources to process"}
+	ErrDecodeDepthExceeded      error = codec.ErrLimitExceeded{Detail: "message structure exceeded maximum nesting depth"}
+	ErrTrailingBytes                  = errors.New("unexpected content after end of cbor object")
 )
 
 const (
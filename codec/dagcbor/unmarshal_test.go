@@ -9,6 +9,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/ipld/go-ipld-prime/codec"
 	"github.com/ipld/go-ipld-prime/datamodel"
 
 	qt "github.com/frankban/quicktest"
@@ -255,6 +256,9 @@ func TestDecodeOptions_MaxDepth(t *testing.T) {
 		nb := basicnode.Prototype.Any.NewBuilder()
 		err := Decode(nb, bytes.NewReader(payload))
 		qt.Assert(t, err, qt.Equals, ErrDecodeDepthExceeded)
+		qt.Check(t, errors.Is(err, codec.ErrLimitExceeded{}), qt.IsTrue)
+		var limitErr codec.ErrLimitExceeded
+		qt.Check(t, errors.As(err, &limitErr), qt.IsTrue)
 	})
 
 	t.Run("structure at default depth decodes", func(t *testing.T) {
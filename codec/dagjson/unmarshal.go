@@ -2,7 +2,6 @@ package dagjson
 
 import (
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"io"
 
@@ -11,13 +10,14 @@ import (
 	"github.com/polydawn/refmt/shared"
 	"github.com/polydawn/refmt/tok"
 
+	"github.com/ipld/go-ipld-prime/codec"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 )
 
 // ErrDecodeDepthExceeded is returned when a decoded structure nests deeper
 // than the configured MaxDepth.
-var ErrDecodeDepthExceeded = errors.New("message structure exceeded maximum nesting depth")
+var ErrDecodeDepthExceeded error = codec.ErrLimitExceeded{Detail: "message structure exceeded maximum nesting depth"}
 
 const defaultMaxDepth int64 = 1024
 
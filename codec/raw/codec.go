@@ -19,13 +19,15 @@ import (
 const rawMulticodec = 0x55
 
 var (
-	_ codec.Decoder = Decode
-	_ codec.Encoder = Encode
+	_ codec.Decoder        = Decode
+	_ codec.Encoder        = Encode
+	_ codec.LimitedDecoder = LimitedDecoder
 )
 
 func init() {
 	multicodec.RegisterEncoder(rawMulticodec, Encode)
 	multicodec.RegisterDecoder(rawMulticodec, Decode)
+	multicodec.RegisterLimitedDecoder(rawMulticodec, LimitedDecoder)
 }
 
 // Decode implements decoding of a node with the raw codec.
@@ -54,6 +56,15 @@ func Decode(am datamodel.NodeAssembler, r io.Reader) error {
 	return am.AssignBytes(data)
 }
 
+// LimitedDecoder returns Decode.
+// The raw codec builds a single bytes node, so there is nothing for the codec.DecodeLimits to constrain;
+// LinkSystem.MaxBlockSize is the limit that matters for raw blocks.
+// LimitedDecoder fits the codec.LimitedDecoder function interface,
+// and is registered in the default multicodec registry during package init time.
+func LimitedDecoder(codec.DecodeLimits) codec.Decoder {
+	return Decode
+}
+
 // Encode implements encoding of a node with the raw codec.
 //
 // Note that Encode won't copy the node's bytes as returned by AsBytes, but the
@@ -16,6 +16,8 @@ import (
 // During selection of encoders, decoders, and hashers, it examines the multicodec indicator numbers and multihash indicator numbers from the CID,
 // and uses the default global multicodec registry (see the go-ipld-prime/multicodec package) for resolving codec implementations,
 // and the default global multihash registry (see the go-multihash/core package) for resolving multihash implementations.
+// If DecodeLimits are set on the returned LinkSystem, decoders are resolved using the registry's limited decoders
+// (see multicodec.RegisterLimitedDecoder), so only codecs which registered one can be loaded.
 //
 // No storage functions are present in the returned LinkSystem.
 // The caller can assign those themselves as desired.
@@ -55,6 +57,19 @@ func LinkSystemUsingMulticodecRegistry(mcReg multicodec.Registry) linking.LinkSy
 				return nil, fmt.Errorf("this decoderChooser can only handle cidlink.LinkPrototype; got %T", lp)
 			}
 		},
+		LimitedDecoderChooser: func(lnk datamodel.Link, limits codec.DecodeLimits) (codec.Decoder, error) {
+			lp := lnk.Prototype()
+			switch lp2 := lp.(type) {
+			case LinkPrototype:
+				fn, err := mcReg.LookupLimitedDecoder(lp2.GetCodec())
+				if err != nil {
+					return nil, err
+				}
+				return fn(limits), nil
+			default:
+				return nil, fmt.Errorf("this limitedDecoderChooser can only handle cidlink.LinkPrototype; got %T", lp)
+			}
+		},
 		HasherChooser: func(lp datamodel.LinkPrototype) (hash.Hash, error) {
 			switch lp2 := lp.(type) {
 			case LinkPrototype:
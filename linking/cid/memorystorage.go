@@ -45,6 +45,9 @@ func (store *Memory) OpenWrite(lnkCtx linking.LinkContext) (io.Writer, linking.B
 	store.beInitialized()
 	buf := bytes.Buffer{}
 	return &buf, func(lnk datamodel.Link) error {
+		if lnk == nil {
+			return nil // abandoned write; nothing to keep.
+		}
 		cl, ok := lnk.(Link)
 		if !ok {
 			return fmt.Errorf("incompatible link type: %T", lnk)
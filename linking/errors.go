@@ -28,3 +28,32 @@ type ErrHashMismatch struct {
 func (e ErrHashMismatch) Error() string {
 	return fmt.Sprintf("hash mismatch!  %v (actual) != %v (expected)", e.Actual, e.Expected)
 }
+
+// ErrBlockTooLarge is returned when a block exceeds LinkSystem.MaxBlockSize,
+// either while loading it from storage or while encoding it in LinkSystem.Store.
+//
+// When loading, Link is the link that was being loaded;
+// when storing, Link is the link the block would have had.
+type ErrBlockTooLarge struct {
+	Link  datamodel.Link
+	Limit int64
+}
+
+func (e ErrBlockTooLarge) Error() string {
+	return fmt.Sprintf("block %v exceeds the maximum block size of %d bytes", e.Link, e.Limit)
+}
+
+// ErrDecodeLimitExceeded is returned when loading a block and the decoder halts
+// because one of the LinkSystem.DecodeLimits was reached.
+// The Cause is the error from the decoder; it will match codec.ErrLimitExceeded.
+//
+// This error is only returned after the block's hash has been verified.
+type ErrDecodeLimitExceeded struct {
+	Link  datamodel.Link
+	Cause error
+}
+
+func (e ErrDecodeLimitExceeded) Error() string {
+	return fmt.Sprintf("decoding block %v: %v", e.Link, e.Cause)
+}
+func (e ErrDecodeLimitExceeded) Unwrap() error { return e.Cause }
@@ -79,9 +79,9 @@ func (lsys *LinkSystem) MustLoad(lnkCtx LinkContext, lnk datamodel.Link, np data
 // This function is meant for convenience when data sizes are small enough that fitting them into memory at once is not a problem.
 func (lsys *LinkSystem) LoadPlusRaw(lnkCtx LinkContext, lnk datamodel.Link, np datamodel.NodePrototype) (datamodel.Node, []byte, error) {
 	// Choose all the parts.
-	decoder, err := lsys.DecoderChooser(lnk)
+	decoder, err := lsys.chooseDecoder(lnk)
 	if err != nil {
-		return nil, nil, ErrLinkingSetup{"could not choose a decoder", err}
+		return nil, nil, err
 	}
 	// Use LoadRaw to get the data.
 	//  If we're going to have everything in memory at once, we might as well do that first, and then give the codec and the hasher the whole thing at once.
@@ -94,7 +94,7 @@ func (lsys *LinkSystem) LoadPlusRaw(lnkCtx LinkContext, lnk datamodel.Link, np d
 	// Build the node.
 	nb := np.NewBuilder()
 	if err := decoder(nb, bytes.NewBuffer(block)); err != nil {
-		return nil, block, err
+		return nil, block, decodeError(nil, lnk, err)
 	}
 	nd := nb.Build()
 	// Consider applying NodeReifier, if applicable.
@@ -132,6 +132,7 @@ func (lsys *LinkSystem) LoadRaw(lnkCtx LinkContext, lnk datamodel.Link) ([]byte,
 	if closer, ok := reader.(io.Closer); ok {
 		defer closer.Close()
 	}
+	reader = lsys.limitReader(reader, lnk)
 	var buf bytes.Buffer
 	if _, err := io.Copy(&buf, reader); err != nil {
 		return nil, err
@@ -161,9 +162,9 @@ func (lsys *LinkSystem) Fill(lnkCtx LinkContext, lnk datamodel.Link, na datamode
 		lnkCtx.Ctx = context.Background()
 	}
 	// Choose all the parts.
-	decoder, err := lsys.DecoderChooser(lnk)
+	decoder, err := lsys.chooseDecoder(lnk)
 	if err != nil {
-		return ErrLinkingSetup{"could not choose a decoder", err}
+		return err
 	}
 	hasher, err := lsys.HasherChooser(lnk.Prototype())
 	if err != nil {
@@ -180,10 +181,15 @@ func (lsys *LinkSystem) Fill(lnkCtx LinkContext, lnk datamodel.Link, na datamode
 	if closer, ok := reader.(io.Closer); ok {
 		defer closer.Close()
 	}
+	// If there's a MaxBlockSize, the reader stops yielding data (and returns ErrBlockTooLarge) once it's exceeded.
+	reader = lsys.limitReader(reader, lnk)
 	// TrustedStorage indicates the data coming out of this reader has already been hashed and verified earlier.
 	// As a result, we can skip rehashing it
 	if lsys.TrustedStorage {
-		return decoder(na, reader)
+		if err := decoder(na, reader); err != nil {
+			return decodeError(reader, lnk, err)
+		}
+		return nil
 	}
 	// Tee the stream so that the hasher is fed as the unmarshal progresses through the stream.
 	tee := io.TeeReader(reader, hasher)
@@ -211,7 +217,7 @@ func (lsys *LinkSystem) Fill(lnkCtx LinkContext, lnk datamodel.Link, na datamode
 	// If we got all the way through IO and through the hash check:
 	// now, finally, if we did get an error from the codec, we can admit to that.
 	if decodeErr != nil {
-		return decodeErr
+		return decodeError(reader, lnk, decodeErr)
 	}
 	return nil
 }
@@ -246,12 +252,59 @@ func (lsys *LinkSystem) Store(lnkCtx LinkContext, lp datamodel.LinkPrototype, n
 	if err != nil {
 		return nil, err
 	}
+	// If there's a MaxBlockSize, the storage stops receiving data once it's exceeded;
+	// the hasher still sees everything, so that we can report what the link would have been.
+	var sizer *blockSizeWriter
+	if lsys.MaxBlockSize > 0 {
+		sizer = &blockSizeWriter{w: writer, remaining: lsys.MaxBlockSize}
+		writer = sizer
+	}
 	tee := io.MultiWriter(writer, hasher)
 	err = encoder(n, tee)
 	if err != nil {
 		return nil, err
 	}
 	lnk := lp.BuildLink(hasher.Sum(nil))
+	if sizer != nil && sizer.exceeded {
+		commitFn(nil) // abort, so the storage can clean up whatever it buffered so far.
+		return nil, ErrBlockTooLarge{Link: lnk, Limit: lsys.MaxBlockSize}
+	}
+	return lnk, commitFn(lnk)
+}
+
+// StoreRaw puts a block of already-serialized data into storage, and returns the Link for it.
+// The Link is computed by hashing the data as the LinkPrototype says.
+//
+// StoreRaw does not decode the data, nor check that it's valid for the codec that the LinkPrototype indicates!
+// It's the counterpart of LoadRaw, and is typically used to move blocks from one storage system to another
+// without the cost of decoding and re-encoding them:
+// giving it the raw data from LoadRaw along with the loaded link's own prototype yields the same link again.
+func (lsys *LinkSystem) StoreRaw(lnkCtx LinkContext, lp datamodel.LinkPrototype, raw []byte) (datamodel.Link, error) {
+	if lnkCtx.Ctx == nil {
+		lnkCtx.Ctx = context.Background()
+	}
+	// Choose all the parts.
+	hasher, err := lsys.HasherChooser(lp)
+	if err != nil {
+		return nil, ErrLinkingSetup{"could not choose a hasher", err}
+	}
+	if lsys.StorageWriteOpener == nil {
+		return nil, ErrLinkingSetup{"no storage configured for writing", io.ErrClosedPipe} // REVIEW: better cause?
+	}
+	hasher.Write(raw)
+	lnk := lp.BuildLink(hasher.Sum(nil))
+	if lsys.MaxBlockSize > 0 && int64(len(raw)) > lsys.MaxBlockSize {
+		return nil, ErrBlockTooLarge{Link: lnk, Limit: lsys.MaxBlockSize}
+	}
+	// Open storage write stream, and write the whole thing.
+	writer, commitFn, err := lsys.StorageWriteOpener(lnkCtx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(raw); err != nil {
+		commitFn(nil) // abort, so the storage can clean up whatever it buffered so far.
+		return nil, err
+	}
 	return lnk, commitFn(lnk)
 }
 
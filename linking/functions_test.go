@@ -3,16 +3,24 @@ package linking_test
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage/fsstore"
 	"github.com/ipld/go-ipld-prime/storage/memstore"
 	"github.com/multiformats/go-multicodec"
 )
@@ -71,3 +79,149 @@ func TestLinkSystem_LoadHashMismatch(t *testing.T) {
 		})
 	}
 }
+
+func TestLinkSystem_MaxBlockSize(t *testing.T) {
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	lp := cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    uint64(multicodec.DagCbor),
+		MhType:   uint64(multicodec.Sha2_256),
+		MhLength: -1,
+	}}
+	lctx := ipld.LinkContext{Ctx: context.TODO()}
+
+	small := basicnode.NewString("small")
+	big := basicnode.NewString(string(bytes.Repeat([]byte{'x'}, 100)))
+	smallLink := lsys.MustStore(lctx, lp, small)
+	bigLink := lsys.MustStore(lctx, lp, big)
+
+	lsys.MaxBlockSize = 64
+
+	t.Run("store refuses", func(t *testing.T) {
+		store2 := &memstore.Store{}
+		lsys2 := lsys
+		lsys2.SetWriteStorage(store2)
+		_, err := lsys2.Store(lctx, lp, big)
+		qt.Check(t, err, qt.Equals, linking.ErrBlockTooLarge{Link: bigLink, Limit: 64})
+		qt.Check(t, store2.Bag, qt.HasLen, 0)
+		_, err = lsys2.Store(lctx, lp, small)
+		qt.Check(t, err, qt.IsNil)
+	})
+	t.Run("store refusal leaves nothing behind", func(t *testing.T) {
+		dir := t.TempDir()
+		store2 := &fsstore.Store{}
+		qt.Assert(t, store2.InitDefaults(dir), qt.IsNil)
+		lsys2 := lsys
+		lsys2.SetWriteStorage(store2)
+		_, err := lsys2.Store(lctx, lp, big)
+		qt.Check(t, err, qt.Equals, linking.ErrBlockTooLarge{Link: bigLink, Limit: 64})
+		staged, err := os.ReadDir(filepath.Join(dir, ".temp"))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, staged, qt.HasLen, 0)
+	})
+	t.Run("load refuses", func(t *testing.T) {
+		_, err := lsys.Load(lctx, bigLink, basicnode.Prototype.Any)
+		qt.Check(t, err, qt.Equals, linking.ErrBlockTooLarge{Link: bigLink, Limit: 64})
+		_, err = lsys.LoadRaw(lctx, bigLink)
+		qt.Check(t, err, qt.Equals, linking.ErrBlockTooLarge{Link: bigLink, Limit: 64})
+		_, _, err = lsys.LoadPlusRaw(lctx, bigLink, basicnode.Prototype.Any)
+		qt.Check(t, err, qt.Equals, linking.ErrBlockTooLarge{Link: bigLink, Limit: 64})
+		lsys2 := lsys
+		lsys2.TrustedStorage = true
+		_, err = lsys2.Load(lctx, bigLink, basicnode.Prototype.Any)
+		qt.Check(t, err, qt.Equals, linking.ErrBlockTooLarge{Link: bigLink, Limit: 64})
+	})
+	t.Run("load allows", func(t *testing.T) {
+		n, err := lsys.Load(lctx, smallLink, basicnode.Prototype.Any)
+		qt.Check(t, err, qt.IsNil)
+		qt.Check(t, ipld.DeepEqual(small, n), qt.IsTrue)
+	})
+	t.Run("exact size allowed", func(t *testing.T) {
+		raw, err := lsys.LoadRaw(lctx, smallLink)
+		qt.Assert(t, err, qt.IsNil)
+		lsys2 := lsys
+		lsys2.MaxBlockSize = int64(len(raw))
+		_, err = lsys2.Load(lctx, smallLink, basicnode.Prototype.Any)
+		qt.Check(t, err, qt.IsNil)
+		lsys2.MaxBlockSize--
+		_, err = lsys2.Load(lctx, smallLink, basicnode.Prototype.Any)
+		qt.Check(t, err, qt.Equals, linking.ErrBlockTooLarge{Link: smallLink, Limit: lsys2.MaxBlockSize})
+	})
+}
+
+func TestLinkSystem_DecodeLimits(t *testing.T) {
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	lctx := ipld.LinkContext{Ctx: context.TODO()}
+
+	deep := fluent.MustBuildList(basicnode.Prototype.List, 1, func(la fluent.ListAssembler) {
+		la.AssembleValue().CreateList(1, func(la fluent.ListAssembler) {
+			la.AssembleValue().CreateList(1, func(la fluent.ListAssembler) {
+				la.AssembleValue().AssignInt(1)
+			})
+		})
+	})
+	for _, mc := range []multicodec.Code{multicodec.DagCbor, multicodec.DagJson} {
+		t.Run(mc.String(), func(t *testing.T) {
+			lp := cidlink.LinkPrototype{Prefix: cid.Prefix{
+				Version:  1,
+				Codec:    uint64(mc),
+				MhType:   uint64(multicodec.Sha2_256),
+				MhLength: -1,
+			}}
+			lnk := lsys.MustStore(lctx, lp, deep)
+
+			lsys2 := lsys
+			lsys2.DecodeLimits = codec.DecodeLimits{MaxDepth: 3}
+			n, err := lsys2.Load(lctx, lnk, basicnode.Prototype.Any)
+			qt.Check(t, err, qt.IsNil)
+			qt.Check(t, ipld.DeepEqual(deep, n), qt.IsTrue)
+
+			lsys2.DecodeLimits = codec.DecodeLimits{MaxDepth: 2}
+			for _, err := range []error{
+				func() error { _, err := lsys2.Load(lctx, lnk, basicnode.Prototype.Any); return err }(),
+				func() error { _, _, err := lsys2.LoadPlusRaw(lctx, lnk, basicnode.Prototype.Any); return err }(),
+			} {
+				var dle linking.ErrDecodeLimitExceeded
+				qt.Assert(t, errors.As(err, &dle), qt.IsTrue)
+				qt.Check(t, dle.Link, qt.Equals, lnk)
+				qt.Check(t, errors.Is(err, codec.ErrLimitExceeded{}), qt.IsTrue)
+			}
+
+			lsys2.LimitedDecoderChooser = nil
+			_, err = lsys2.Load(lctx, lnk, basicnode.Prototype.Any)
+			qt.Check(t, err, qt.ErrorAs, new(linking.ErrLinkingSetup))
+		})
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestLinkSystem_StoreRawWriteFailure(t *testing.T) {
+	lsys := cidlink.DefaultLinkSystem()
+	var committed []datamodel.Link
+	lsys.StorageWriteOpener = func(linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		return failingWriter{}, func(lnk datamodel.Link) error {
+			committed = append(committed, lnk)
+			return nil
+		}, nil
+	}
+	lp := cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    uint64(multicodec.Raw),
+		MhType:   uint64(multicodec.Sha2_256),
+		MhLength: -1,
+	}}
+	_, err := lsys.StoreRaw(ipld.LinkContext{}, lp, []byte("content"))
+	qt.Check(t, err, qt.ErrorMatches, "disk full")
+	// The write is abandoned, rather than left open.
+	qt.Check(t, committed, qt.DeepEquals, []datamodel.Link{nil})
+}
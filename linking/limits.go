@@ -0,0 +1,98 @@
+package linking
+
+import (
+	"errors"
+	"io"
+
+	"github.com/ipld/go-ipld-prime/codec"
+	"github.com/ipld/go-ipld-prime/datamodel"
+)
+
+// chooseDecoder picks the decoder for a link, applying DecodeLimits if any are set.
+func (lsys *LinkSystem) chooseDecoder(lnk datamodel.Link) (codec.Decoder, error) {
+	if lsys.DecodeLimits.IsZero() {
+		decoder, err := lsys.DecoderChooser(lnk)
+		if err != nil {
+			return nil, ErrLinkingSetup{"could not choose a decoder", err}
+		}
+		return decoder, nil
+	}
+	if lsys.LimitedDecoderChooser == nil {
+		return nil, ErrLinkingSetup{"could not choose a decoder", errors.New("DecodeLimits are set but there is no LimitedDecoderChooser")}
+	}
+	decoder, err := lsys.LimitedDecoderChooser(lnk, lsys.DecodeLimits)
+	if err != nil {
+		return nil, ErrLinkingSetup{"could not choose a decoder", err}
+	}
+	return decoder, nil
+}
+
+// limitReader wraps a reader from the StorageReadOpener if MaxBlockSize is set.
+// It returns the reader unchanged otherwise.
+func (lsys *LinkSystem) limitReader(reader io.Reader, lnk datamodel.Link) io.Reader {
+	if lsys.MaxBlockSize <= 0 {
+		return reader
+	}
+	return &blockSizeReader{r: reader, remaining: lsys.MaxBlockSize, err: ErrBlockTooLarge{Link: lnk, Limit: lsys.MaxBlockSize}}
+}
+
+// decodeError gives a decode error its final form:
+// if the block turned out to be too large, that's what we report;
+// if the decoder hit one of its limits, we say which link it was decoding.
+func decodeError(reader io.Reader, lnk datamodel.Link, err error) error {
+	if bsr, ok := reader.(*blockSizeReader); ok && bsr.tripped {
+		return bsr.err
+	}
+	if errors.Is(err, codec.ErrLimitExceeded{}) {
+		return ErrDecodeLimitExceeded{Link: lnk, Cause: err}
+	}
+	return err
+}
+
+// blockSizeReader passes through reads until more than the limit has been read,
+// and then returns ErrBlockTooLarge.
+// It reads at most one byte past the limit from the underlying reader.
+type blockSizeReader struct {
+	r         io.Reader
+	remaining int64
+	err       ErrBlockTooLarge
+	tripped   bool
+}
+
+func (bsr *blockSizeReader) Read(p []byte) (int, error) {
+	if bsr.tripped {
+		return 0, bsr.err
+	}
+	if int64(len(p)) > bsr.remaining+1 {
+		p = p[:bsr.remaining+1]
+	}
+	n, err := bsr.r.Read(p)
+	if int64(n) > bsr.remaining {
+		bsr.tripped = true
+		return int(bsr.remaining), bsr.err
+	}
+	bsr.remaining -= int64(n)
+	return n, err
+}
+
+// blockSizeWriter counts bytes written by an encoder,
+// and stops passing them to the underlying writer once there are more than the limit.
+// It continues to accept writes (so that the rest of the encode can still be hashed),
+// and the caller is expected to check the exceeded flag when the encoder is done.
+type blockSizeWriter struct {
+	w         io.Writer
+	remaining int64
+	exceeded  bool
+}
+
+func (bsw *blockSizeWriter) Write(p []byte) (int, error) {
+	if bsw.exceeded {
+		return len(p), nil
+	}
+	if int64(len(p)) > bsw.remaining {
+		bsw.exceeded = true
+		return len(p), nil
+	}
+	bsw.remaining -= int64(len(p))
+	return bsw.w.Write(p)
+}
@@ -1,6 +1,7 @@
 package linking_test
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ipfs/go-cid"
@@ -10,6 +11,7 @@ import (
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage"
 	"github.com/ipld/go-ipld-prime/storage/memstore"
 )
 
@@ -131,3 +133,39 @@ func ExampleLinkSystem_Load() {
 	// Output:
 	// we loaded a map with 1 entries
 }
+
+func ExampleLinkSystem_SetWriteStorage_batch() {
+	// When writing a DAG that spans several blocks, we often want all of it to land in storage, or none of it.
+	//  A storage.Batch wrapped around the real store holds the writes back until we commit it.
+	realStore := memstore.Store{}
+	batch := &storage.Batch{Store: &realStore}
+
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetWriteStorage(batch)
+	lp := cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    0x71, // dag-cbor
+		MhType:   0x12, // sha2-256
+		MhLength: 32,
+	}}
+
+	// Store a leaf, and then a parent that links to it.
+	leaf := basicnode.NewString("leaf")
+	leafLink := lsys.MustStore(linking.LinkContext{}, lp, leaf)
+	parent := fluent.MustBuildMap(basicnode.Prototype.Map, 1, func(na fluent.MapAssembler) {
+		na.AssembleEntry("child").AssignLink(leafLink)
+	})
+	lsys.MustStore(linking.LinkContext{}, lp, parent)
+
+	fmt.Printf("pending: %d, stored: %d\n", batch.Len(), len(realStore.Bag))
+
+	// If anything had gone wrong, we'd call batch.Abort() instead, and nothing would be left behind.
+	if err := batch.Commit(context.Background()); err != nil {
+		panic(err)
+	}
+	fmt.Printf("stored after commit: %d\n", len(realStore.Bag))
+
+	// Output:
+	// pending: 2, stored: 0
+	// stored after commit: 2
+}
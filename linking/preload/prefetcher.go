@@ -0,0 +1,361 @@
+package preload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+)
+
+// PrefetcherConfig holds the options for NewPrefetcher.
+// Zero values in any field mean "use the default".
+type PrefetcherConfig struct {
+	// Workers is the number of blocks fetched concurrently.  Defaults to 8.
+	Workers int
+
+	// MaxBufferBytes is the most data that will be held in the buffer,
+	// waiting for the traversal to ask for it.  Defaults to 16 MiB.
+	// When the buffer is full, workers pause until the traversal consumes something.
+	// (A single block larger than this is still buffered if the buffer is otherwise empty.)
+	MaxBufferBytes int64
+
+	// MaxQueue is the number of discovered links that may wait for a free worker.
+	// Links discovered while the queue is full are not prefetched;
+	// the traversal simply loads them itself when it gets to them.  Defaults to 1024.
+	MaxQueue int
+}
+
+const (
+	defaultPrefetchWorkers        = 8
+	defaultPrefetchMaxBufferBytes = 16 << 20
+	defaultPrefetchMaxQueue       = 1024
+)
+
+// Prefetcher is a ready-made implementation of Loader,
+// which fetches the links discovered by a traversal's preload pass in the background,
+// so that the traversal-proper finds the blocks it needs already in memory.
+//
+// A Prefetcher sits in front of a LinkSystem's StorageReadOpener:
+// NewPrefetcher uses the original StorageReadOpener to fetch blocks,
+// and replaces it with one that serves blocks out of the Prefetcher's buffer
+// (falling back to the original if a block wasn't prefetched).
+// The LinkSystem still verifies hashes as usual, since the Prefetcher only deals in raw block data.
+//
+// Typical use with the traversal package looks like:
+//
+//	pf := preload.NewPrefetcher(ctx, &lsys, preload.PrefetcherConfig{})
+//	defer pf.Close()
+//	prog := traversal.Progress{Cfg: &traversal.Config{
+//		Ctx:        ctx,
+//		LinkSystem: lsys,
+//		Preloader:  pf.Load,
+//	}}
+//
+// Each link is fetched at most once, and each fetched block is handed to the traversal once,
+// after which it's dropped from the buffer.
+// If the traversal has a byte budget (PreloadContext.MaxBytes), the Prefetcher doesn't buffer more than that,
+// and doesn't fetch any block bigger than that in full.
+// If the traversal's context (PreloadContext.Ctx) ends, pending fetches for it are abandoned.
+//
+// A Prefetcher is safe for concurrent use.
+type Prefetcher struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	cfg     PrefetcherConfig
+	fetch   linking.BlockReadOpener
+	maxSize int64
+	queue   chan *prefetchEntry
+	wg      sync.WaitGroup
+
+	mu       sync.Mutex
+	space    *sync.Cond // signalled when buffer space is freed, when a block is wanted, or on cancellation.
+	entries  map[string]*prefetchEntry
+	seen     map[string]struct{}
+	buffered int64
+	stats    PrefetcherStats
+}
+
+// PrefetcherStats reports how effective a Prefetcher has been.
+type PrefetcherStats struct {
+	Hits    int // loads served from the buffer (or from a fetch already in flight).
+	Misses  int // loads that had to go to storage directly.
+	Fetched int // blocks fetched by the workers.
+}
+
+type prefetchState uint8
+
+const (
+	prefetchQueued prefetchState = iota
+	prefetchFetching
+	prefetchDone
+)
+
+type prefetchEntry struct {
+	lnkCtx   linking.LinkContext
+	lnk      datamodel.Link
+	maxBytes int64 // PreloadContext.MaxBytes.
+	state    prefetchState
+	wanted   bool          // the traversal is waiting for this; deliver it regardless of buffer space.
+	counted  bool          // data is counted in Prefetcher.buffered.
+	done     chan struct{} // closed when state becomes prefetchDone.
+	data     []byte
+	err      error
+}
+
+// NewPrefetcher starts a Prefetcher which fetches blocks using lsys.StorageReadOpener,
+// and then sets lsys.StorageReadOpener to serve blocks from the Prefetcher's buffer.
+//
+// The workers run until ctx is cancelled or Close is called.
+func NewPrefetcher(ctx context.Context, lsys *linking.LinkSystem, cfg PrefetcherConfig) *Prefetcher {
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultPrefetchWorkers
+	}
+	if cfg.MaxBufferBytes <= 0 {
+		cfg.MaxBufferBytes = defaultPrefetchMaxBufferBytes
+	}
+	if cfg.MaxQueue <= 0 {
+		cfg.MaxQueue = defaultPrefetchMaxQueue
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Prefetcher{
+		ctx:     ctx,
+		cancel:  cancel,
+		cfg:     cfg,
+		fetch:   lsys.StorageReadOpener,
+		maxSize: lsys.MaxBlockSize,
+		queue:   make(chan *prefetchEntry, cfg.MaxQueue),
+		entries: make(map[string]*prefetchEntry),
+		seen:    make(map[string]struct{}),
+	}
+	p.space = sync.NewCond(&p.mu)
+	context.AfterFunc(ctx, p.wake)
+	for i := 0; i < cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	lsys.StorageReadOpener = p.readOpener
+	return p
+}
+
+// Close stops the workers, waits for them to exit, and drops anything buffered.
+// Loads through the LinkSystem keep working afterwards; they just go straight to storage.
+func (p *Prefetcher) Close() {
+	p.cancel()
+	p.wg.Wait()
+	p.mu.Lock()
+	p.entries = make(map[string]*prefetchEntry)
+	p.buffered = 0
+	p.mu.Unlock()
+}
+
+// Stats returns counts of how the Prefetcher has been used so far.
+func (p *Prefetcher) Stats() PrefetcherStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// Load queues a link for fetching.  It matches the Loader function type,
+// so it can be used as traversal.Config.Preloader.
+//
+// Load never blocks: links that have been seen before, or that arrive while the queue is full, are ignored.
+func (p *Prefetcher) Load(pctx PreloadContext, l Link) {
+	if p.ctx.Err() != nil || (pctx.Ctx != nil && pctx.Ctx.Err() != nil) {
+		return
+	}
+	key := l.Link.Binary()
+	p.mu.Lock()
+	if _, seen := p.seen[key]; seen {
+		p.mu.Unlock()
+		return
+	}
+	ent := &prefetchEntry{
+		lnkCtx: linking.LinkContext{
+			Ctx:        pctx.Ctx,
+			LinkPath:   pctx.BasePath.AppendSegment(l.Segment),
+			LinkNode:   l.LinkNode,
+			ParentNode: pctx.ParentNode,
+		},
+		lnk:      l.Link,
+		maxBytes: pctx.MaxBytes,
+		done:     make(chan struct{}),
+	}
+	if ent.lnkCtx.Ctx == nil {
+		ent.lnkCtx.Ctx = p.ctx
+	}
+	select {
+	case p.queue <- ent:
+		p.seen[key] = struct{}{}
+		p.entries[key] = ent
+	default:
+		// Queue's full; the traversal will load this itself.
+	}
+	p.mu.Unlock()
+}
+
+// wake rouses any workers waiting for buffer space, so they can notice a cancellation.
+func (p *Prefetcher) wake() {
+	p.mu.Lock()
+	p.space.Broadcast()
+	p.mu.Unlock()
+}
+
+func (p *Prefetcher) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case ent := <-p.queue:
+			p.process(ent)
+		}
+	}
+}
+
+func (p *Prefetcher) process(ent *prefetchEntry) {
+	key := ent.lnk.Binary()
+	p.mu.Lock()
+	if ent.state != prefetchQueued || p.entries[key] != ent {
+		// The traversal got here first and loaded it itself.
+		p.mu.Unlock()
+		return
+	}
+	ent.state = prefetchFetching
+	p.mu.Unlock()
+
+	var data []byte
+	var err error
+	if err = ent.lnkCtx.Ctx.Err(); err == nil {
+		data, err = p.fetchBytes(ent.lnkCtx, ent.lnk, ent.maxBytes)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		p.stats.Fetched++
+		size := int64(len(data))
+		limit := p.cfg.MaxBufferBytes
+		if ent.maxBytes > 0 && ent.maxBytes < limit {
+			limit = ent.maxBytes
+		}
+		stop := context.AfterFunc(ent.lnkCtx.Ctx, p.wake)
+		for !ent.wanted && p.buffered > 0 && p.buffered+size > limit {
+			if err = p.ctx.Err(); err != nil {
+				break
+			}
+			if err = ent.lnkCtx.Ctx.Err(); err != nil {
+				break
+			}
+			p.space.Wait()
+		}
+		stop()
+		if err == nil && !ent.wanted {
+			ent.counted = true
+			p.buffered += size
+		}
+	}
+	if err != nil {
+		data = nil
+	}
+	ent.data, ent.err = data, err
+	ent.state = prefetchDone
+	close(ent.done)
+	if err != nil && !ent.wanted {
+		delete(p.entries, key)
+	}
+}
+
+// open uses the original StorageReadOpener.
+func (p *Prefetcher) open(lnkCtx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+	if p.fetch == nil {
+		return nil, linking.ErrLinkingSetup{Detail: "no storage configured for reading", Cause: io.ErrClosedPipe}
+	}
+	return p.fetch(lnkCtx, lnk)
+}
+
+// errOverBudget is used for blocks that are bigger than the traversal's byte budget.
+// The traversal will find that out for itself when it tries to load them.
+var errOverBudget = errors.New("block is bigger than the traversal's byte budget")
+
+// fetchBytes reads a whole block from the original StorageReadOpener,
+// unless it's bigger than maxBytes (if that's more than zero).
+func (p *Prefetcher) fetchBytes(lnkCtx linking.LinkContext, lnk datamodel.Link, maxBytes int64) ([]byte, error) {
+	reader, err := p.open(lnkCtx, lnk)
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if p.maxSize > 0 {
+		// Don't buffer more than the LinkSystem would accept;
+		// it'll report the block as too large itself when the traversal gets to it.
+		reader = io.LimitReader(reader, p.maxSize+1)
+	}
+	if maxBytes > 0 {
+		reader = io.LimitReader(reader, maxBytes+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if p.maxSize > 0 && int64(len(data)) > p.maxSize {
+		return nil, linking.ErrBlockTooLarge{Link: lnk, Limit: p.maxSize}
+	}
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		return nil, errOverBudget
+	}
+	return data, nil
+}
+
+// readOpener is installed as the LinkSystem's StorageReadOpener.
+func (p *Prefetcher) readOpener(lnkCtx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+	key := lnk.Binary()
+	p.mu.Lock()
+	ent, ok := p.entries[key]
+	if !ok || ent.state == prefetchQueued {
+		// Not prefetched, or not started yet: go straight to storage.
+		// (Removing a queued entry tells the worker not to bother with it.)
+		delete(p.entries, key)
+		p.stats.Misses++
+		p.mu.Unlock()
+		return p.open(lnkCtx, lnk)
+	}
+	if ent.state == prefetchFetching {
+		ent.wanted = true
+		p.space.Broadcast()
+	}
+	p.mu.Unlock()
+
+	ctx := lnkCtx.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case <-ent.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	if p.entries[key] == ent {
+		delete(p.entries, key)
+	}
+	if ent.counted {
+		ent.counted = false
+		p.buffered -= int64(len(ent.data))
+		p.space.Broadcast()
+	}
+	if ent.err != nil {
+		p.stats.Misses++
+		p.mu.Unlock()
+		return p.open(lnkCtx, lnk)
+	}
+	p.stats.Hits++
+	p.mu.Unlock()
+	return bytes.NewReader(ent.data), nil
+}
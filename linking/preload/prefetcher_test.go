@@ -0,0 +1,168 @@
+package preload_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipfs/go-cid"
+
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/linking/preload"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+)
+
+var lp = cidlink.LinkPrototype{Prefix: cid.Prefix{
+	Version:  1,
+	Codec:    0x71,
+	MhType:   0x12,
+	MhLength: 32,
+}}
+
+// buildDAG stores a root with `width` children, each of which has `width` leaves.
+func buildDAG(t *testing.T, lsys linking.LinkSystem, width int) datamodel.Link {
+	children := make([]datamodel.Link, width)
+	for i := range children {
+		leaves := make([]datamodel.Link, width)
+		for j := range leaves {
+			leaves[j] = lsys.MustStore(linking.LinkContext{}, lp, basicnode.NewString(fmt.Sprintf("leaf %d/%d", i, j)))
+		}
+		children[i] = lsys.MustStore(linking.LinkContext{}, lp, fluent.MustBuildList(basicnode.Prototype.List, int64(width), func(la fluent.ListAssembler) {
+			for _, l := range leaves {
+				la.AssembleValue().AssignLink(l)
+			}
+		}))
+	}
+	return lsys.MustStore(linking.LinkContext{}, lp, fluent.MustBuildList(basicnode.Prototype.List, int64(width), func(la fluent.ListAssembler) {
+		for _, l := range children {
+			la.AssembleValue().AssignLink(l)
+		}
+	}))
+}
+
+func walk(t *testing.T, ctx context.Context, lsys linking.LinkSystem, root datamodel.Link, preloader preload.Loader) ([]string, error) {
+	var visited []string
+	prog := traversal.Progress{Cfg: &traversal.Config{
+		Ctx:        ctx,
+		LinkSystem: lsys,
+		LinkTargetNodePrototypeChooser: func(datamodel.Link, linking.LinkContext) (datamodel.NodePrototype, error) {
+			return basicnode.Prototype.Any, nil
+		},
+		Preloader: preloader,
+	}}
+	rootNode, err := lsys.Load(linking.LinkContext{Ctx: ctx}, root, basicnode.Prototype.Any)
+	qt.Assert(t, err, qt.IsNil)
+	sel, err := selector.CompileSelector(selectorparse.CommonSelector_ExploreAllRecursively)
+	qt.Assert(t, err, qt.IsNil)
+	err = prog.WalkAdv(rootNode, sel, func(p traversal.Progress, n datamodel.Node, _ traversal.VisitReason) error {
+		visited = append(visited, p.Path.String())
+		return nil
+	})
+	return visited, err
+}
+
+// slowStore adds latency to reads, so that prefetching has something to win.
+type slowStore struct {
+	memstore.Store
+}
+
+func (s *slowStore) Get(ctx context.Context, key string) ([]byte, error) {
+	time.Sleep(time.Millisecond)
+	return s.Store.Get(ctx, key)
+}
+
+func (s *slowStore) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	time.Sleep(time.Millisecond)
+	return s.Store.GetStream(ctx, key)
+}
+
+func TestPrefetcher(t *testing.T) {
+	ctx := context.Background()
+	store := &slowStore{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	root := buildDAG(t, lsys, 6)
+
+	want, err := walk(t, ctx, lsys, root, nil)
+	qt.Assert(t, err, qt.IsNil)
+
+	for _, cfg := range []preload.PrefetcherConfig{
+		{},
+		{Workers: 1, MaxBufferBytes: 1, MaxQueue: 1},
+		{Workers: 3, MaxBufferBytes: 40},
+	} {
+		t.Run(fmt.Sprintf("%+v", cfg), func(t *testing.T) {
+			lsys := lsys
+			pf := preload.NewPrefetcher(ctx, &lsys, cfg)
+			defer pf.Close()
+			got, err := walk(t, ctx, lsys, root, pf.Load)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, got, qt.DeepEquals, want)
+			stats := pf.Stats()
+			qt.Check(t, stats.Hits+stats.Misses, qt.Equals, 1+6+6*6) // root, children, leaves.
+			qt.Check(t, stats.Fetched <= stats.Hits+stats.Misses, qt.IsTrue)
+			if cfg.MaxQueue == 0 {
+				qt.Check(t, stats.Hits > 0, qt.IsTrue)
+			}
+		})
+	}
+}
+
+func TestPrefetcherCancel(t *testing.T) {
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	root := buildDAG(t, lsys, 4)
+
+	// Links discovered by a traversal whose context has ended are not fetched,
+	// but the LinkSystem can still load everything directly.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pf := preload.NewPrefetcher(context.Background(), &lsys, preload.PrefetcherConfig{Workers: 2})
+	defer pf.Close()
+	_, err := walk(t, context.Background(), lsys, root, func(pctx preload.PreloadContext, l preload.Link) {
+		pctx.Ctx = ctx
+		pf.Load(pctx, l)
+	})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, pf.Stats(), qt.Equals, preload.PrefetcherStats{Misses: 1 + 4 + 4*4})
+
+	// After Close, loads still work.
+	pf.Close()
+	_, err = walk(t, context.Background(), lsys, root, pf.Load)
+	qt.Assert(t, err, qt.IsNil)
+}
+
+func TestPrefetcherByteBudget(t *testing.T) {
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	root := buildDAG(t, lsys, 4)
+
+	// Blocks bigger than the traversal's remaining byte budget are not fetched;
+	// the traversal loads them itself, and finds out that they're over budget.
+	pf := preload.NewPrefetcher(context.Background(), &lsys, preload.PrefetcherConfig{Workers: 2})
+	defer pf.Close()
+	_, err := walk(t, context.Background(), lsys, root, func(pctx preload.PreloadContext, l preload.Link) {
+		pctx.MaxBytes = 1
+		pf.Load(pctx, l)
+	})
+	qt.Assert(t, err, qt.IsNil)
+	stats := pf.Stats()
+	qt.Check(t, stats.Fetched, qt.Equals, 0)
+	qt.Check(t, stats.Misses, qt.Equals, 1+4+4*4)
+}
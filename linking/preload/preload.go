@@ -29,6 +29,14 @@ type PreloadContext struct {
 	//
 	// Functions in the traversal package will set this automatically.
 	ParentNode datamodel.Node
+
+	// MaxBytes, if more than zero, is how many more bytes of blocks the traversal can load before it runs out of budget.
+	// There's no point in a preloader loading more than this ahead of the traversal,
+	// and, since budgets are often used to limit the work done for a request, it shouldn't.
+	// Zero means there's no limit.
+	//
+	// Functions in the traversal package will set this automatically.
+	MaxBytes int64
 }
 
 // Link provides the link encountered during a preload pass, the node it was
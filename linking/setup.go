@@ -31,10 +31,16 @@ func (lsys *LinkSystem) SetReadStorage(store storage.ReadableStorage) {
 // If you would like to make a more complex configuration
 // (for example, perhaps using information from a LinkContext to decide which storage area to use?)
 // then you should set LinkSystem.StorageWriteOpener to a custom callback of your own creation instead.
+//
+// To write a multi-block DAG all-or-nothing, give this a storage.Batch wrapping the real store:
+// Store calls will then be buffered in the batch, and only reach the real store when the batch is committed.
 func (lsys *LinkSystem) SetWriteStorage(store storage.WritableStorage) {
 	lsys.StorageWriteOpener = func(lctx LinkContext) (io.Writer, BlockWriteCommitter, error) {
 		wr, wrcommit, err := storage.PutStream(lctx.Ctx, store)
 		return wr, func(lnk datamodel.Link) error {
+			if lnk == nil {
+				return wrcommit("")
+			}
 			return wrcommit(lnk.Binary())
 		}, err
 	}
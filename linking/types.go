@@ -29,15 +29,33 @@ import (
 // found in the storage package.  Applications are also free to write their own.
 // Custom wrapping of BlockWriteOpener and BlockReadOpener are also common,
 // and may be reasonable if one wants to build application features that are block-aware.
+//
+// Resource limits can be applied to all loading and storing done through a LinkSystem:
+// MaxBlockSize refuses any block whose serial form is too large,
+// and DecodeLimits is handed to the Decoder chosen for each Link
+// (via LimitedDecoderChooser, which must then also be set).
+// Limits which are exceeded cause the LinkSystem methods to return
+// ErrBlockTooLarge or ErrDecodeLimitExceeded, which report the offending Link.
 type LinkSystem struct {
-	EncoderChooser     func(datamodel.LinkPrototype) (codec.Encoder, error)
-	DecoderChooser     func(datamodel.Link) (codec.Decoder, error)
-	HasherChooser      func(datamodel.LinkPrototype) (hash.Hash, error)
-	StorageWriteOpener BlockWriteOpener
-	StorageReadOpener  BlockReadOpener
-	TrustedStorage     bool
-	NodeReifier        NodeReifier
-	KnownReifiers      map[string]NodeReifier
+	EncoderChooser        func(datamodel.LinkPrototype) (codec.Encoder, error)
+	DecoderChooser        func(datamodel.Link) (codec.Decoder, error)
+	LimitedDecoderChooser func(datamodel.Link, codec.DecodeLimits) (codec.Decoder, error)
+	HasherChooser         func(datamodel.LinkPrototype) (hash.Hash, error)
+	StorageWriteOpener    BlockWriteOpener
+	StorageReadOpener     BlockReadOpener
+	TrustedStorage        bool
+	NodeReifier           NodeReifier
+	KnownReifiers         map[string]NodeReifier
+
+	// MaxBlockSize, if greater than zero, is the largest number of bytes a block may have.
+	// It's enforced while reading from the StorageReadOpener (reading stops as soon as the limit is passed),
+	// and while encoding in Store (nothing is committed if the limit is passed).
+	MaxBlockSize int64
+
+	// DecodeLimits, if not zero, is applied to every decode done by Load, LoadPlusRaw, and Fill.
+	// The decoder is obtained from LimitedDecoderChooser rather than DecoderChooser when this is set;
+	// it is a setup error to set DecodeLimits without a LimitedDecoderChooser.
+	DecodeLimits codec.DecodeLimits
 }
 
 // The following three types are the key functionality we need from a "blockstore".
@@ -140,6 +158,14 @@ type (
 	// in a content-addressable fashion.
 	// See the documentation of BlockWriteOpener for more description of this
 	// and an example of how this is likely to be reduced to practice.
+	//
+	// As a special case, a nil Link means the write is being abandoned:
+	// the BlockWriteCommitter should discard what was written, and store nothing.
+	// (This is the same as giving the zero string as the key to a storage.WritableStorage's WriteCommitter,
+	// which is what the BlockWriteOpener made by LinkSystem.SetWriteStorage does.)
+	// LinkSystem only does this when a block turns out to be larger than its MaxBlockSize,
+	// or when StoreRaw fails to write a block's data,
+	// so a BlockWriteCommitter that's never used in those ways will never see it.
 	BlockWriteCommitter func(datamodel.Link) error
 
 	// NodeReifier defines the shape of a function that given a node with no schema
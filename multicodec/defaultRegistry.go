@@ -115,3 +115,23 @@ func LookupDecoder(indicator uint64) (codec.Decoder, error) {
 func ListDecoders() []uint64 {
 	return DefaultRegistry.ListDecoders()
 }
+
+// RegisterLimitedDecoder updates the global DefaultRegistry to map a multicodec indicator number to the given codec.LimitedDecoder function.
+// The functions registered can be subsequently looked up using LookupLimitedDecoder.
+// It is a shortcut to the RegisterLimitedDecoder method on the global DefaultRegistry.
+//
+// Codec packages which support resource limits are encouraged to register a LimitedDecoder
+// at package init time, alongside their call to RegisterDecoder.
+// The same last-call-wins rules apply as for RegisterDecoder.
+func RegisterLimitedDecoder(indicator uint64, limitedDecodeFunc codec.LimitedDecoder) {
+	DefaultRegistry.RegisterLimitedDecoder(indicator, limitedDecodeFunc)
+}
+
+// LookupLimitedDecoder yields a codec.LimitedDecoder function matching a multicodec indicator code number.
+// It is a shortcut to the LookupLimitedDecoder method on the global DefaultRegistry.
+//
+// To be available from this lookup function, a limited decoder must have been registered
+// for this indicator number by an earlier call to the RegisterLimitedDecoder function.
+func LookupLimitedDecoder(indicator uint64) (codec.LimitedDecoder, error) {
+	return DefaultRegistry.LookupLimitedDecoder(indicator)
+}
@@ -26,8 +26,9 @@ import (
 // You should not use indicator numbers which are not specified in that table
 // (however, there is nothing in this implementation that will attempt to stop you, either; please behave).
 type Registry struct {
-	encoders map[uint64]codec.Encoder
-	decoders map[uint64]codec.Decoder
+	encoders        map[uint64]codec.Encoder
+	decoders        map[uint64]codec.Decoder
+	limitedDecoders map[uint64]codec.LimitedDecoder
 }
 
 func (r *Registry) ensureInit() {
@@ -36,6 +37,7 @@ func (r *Registry) ensureInit() {
 	}
 	r.encoders = make(map[uint64]codec.Encoder)
 	r.decoders = make(map[uint64]codec.Decoder)
+	r.limitedDecoders = make(map[uint64]codec.LimitedDecoder)
 }
 
 // RegisterEncoder updates a simple map of multicodec indicator number to codec.Encoder function.
@@ -103,3 +105,28 @@ func (r *Registry) ListDecoders() []uint64 {
 	}
 	return decoders
 }
+
+// RegisterLimitedDecoder updates a simple map of multicodec indicator number to codec.LimitedDecoder function.
+// The functions registered can be subsequently looked up using LookupLimitedDecoder.
+//
+// Registering a LimitedDecoder is optional, and is done in addition to RegisterDecoder (not instead of it).
+// It lets a LinkSystem apply its DecodeLimits to this codec.
+func (r *Registry) RegisterLimitedDecoder(indicator uint64, limitedDecodeFunc codec.LimitedDecoder) {
+	r.ensureInit()
+	if limitedDecodeFunc == nil {
+		panic("not sensible to attempt to register a nil function")
+	}
+	r.limitedDecoders[indicator] = limitedDecodeFunc
+}
+
+// LookupLimitedDecoder yields a codec.LimitedDecoder function matching a multicodec indicator code number.
+//
+// To be available from this lookup function, a limited decoder must have been registered
+// for this indicator number by an earlier call to the RegisterLimitedDecoder function.
+func (r *Registry) LookupLimitedDecoder(indicator uint64) (codec.LimitedDecoder, error) {
+	limitedDecodeFunc, exists := r.limitedDecoders[indicator]
+	if !exists {
+		return nil, fmt.Errorf("no limited decoder registered for multicodec code %d (0x%x)", indicator, indicator)
+	}
+	return limitedDecodeFunc, nil
+}
@@ -0,0 +1,38 @@
+package adaptertests_test
+
+import (
+	"testing"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/bsadapter"
+	"github.com/ipld/go-ipld-prime/storage/bsrvadapter"
+	"github.com/ipld/go-ipld-prime/storage/dsadapter"
+	"github.com/ipld/go-ipld-prime/storage/tests"
+)
+
+func newBlockstore() blockstore.Blockstore {
+	return blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+}
+
+func TestDsadapter(t *testing.T) {
+	tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+		return &dsadapter.Adapter{Wrapped: dssync.MutexWrap(datastore.NewMapDatastore())}
+	}, tests.ConformanceOptions{LastWriteWins: true})
+}
+
+func TestBsadapter(t *testing.T) {
+	tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+		return &bsadapter.Adapter{Wrapped: newBlockstore()}
+	}, tests.ConformanceOptions{})
+}
+
+func TestBsrvadapter(t *testing.T) {
+	tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+		return &bsrvadapter.Adapter{Wrapped: blockservice.New(newBlockstore(), nil)}
+	}, tests.ConformanceOptions{})
+}
@@ -0,0 +1,8 @@
+// Package adaptertests runs the storage conformance suite (go-ipld-prime/storage/tests)
+// against the storage adapters (bsadapter, dsadapter, and bsrvadapter).
+//
+// It's a module of its own, holding nothing but tests,
+// so that it can use replace directives to test the adapters against the go-ipld-prime beside them
+// without the adapter modules themselves needing any.
+// Nothing should import it.
+package adaptertests
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"io"
+	"iter"
 )
 
 // --- basics --->
@@ -155,19 +156,59 @@ type PeekableStorage interface {
 	Peek(ctx context.Context, key string) ([]byte, io.Closer, error)
 }
 
-// the following are all hypothetical additional future interfaces (in varying degress of speculativeness):
+// BatchWritableStorage is a feature-detection interface for storage systems which can write several entries at once, atomically:
+// either every entry is present afterwards, or (if an error is returned) none of the entries that weren't already present have been added.
+// It is typically reached through Batch.Commit, or the PutBatch package function; users rarely need to call it directly.
+//
+// The keys and contents slices must have the same length, and correspond to each other by index.
+// Each io.Reader in contents is read until EOF.
+// As usual for writes, entries whose keys are already present are first-write-wins, and not an error.
+type BatchWritableStorage interface {
+	PutBatch(ctx context.Context, keys []string, contents []io.Reader) error
+}
 
-// FUTURE: an EnumerableStorage API, that lets you list all keys present?
+// EnumerableStorage is a feature-detection interface for storage systems which can list the keys they contain.
+// It is typically reached through the List package function.
+//
+// List returns an iterator over the keys in the store which begin with the given prefix
+// (an empty prefix means every key).
+// The order of the keys is up to the implementation.
+// If an error is encountered, it's yielded (with an empty key), and iteration stops.
+// If the context is cancelled, the context's error is yielded, and iteration stops.
+//
+// Keys added or removed while an iteration is in progress may or may not be seen.
+type EnumerableStorage interface {
+	List(ctx context.Context, prefix string) iter.Seq2[string, error]
+}
+
+// DeletableStorage is a feature-detection interface for storage systems which can remove entries.
+// It is typically reached through the Delete package function.
+//
+// Deleting a key that isn't present is not an error.
+//
+// There's no consistency model for deletes beyond that of the individual operation:
+// in particular, a delete that races with a write of the same key may leave the key either present or absent.
+// (For content-addressed data, either outcome is reasonable -- the content can't have changed.)
+type DeletableStorage interface {
+	Delete(ctx context.Context, key string) error
+}
+
+// SizableStorage is a feature-detection interface for storage systems which can report the size of an entry
+// without reading it.
+// It is typically reached through the Size package function.
+type SizableStorage interface {
+	Size(ctx context.Context, key string) (int64, error)
+}
+
+// the following are all hypothetical additional future interfaces (in varying degress of speculativeness):
 
 // FUTURE: a cleanup API (for getting rid of tmp files that might've been left behind on rough shutdown)?
 
 // FUTURE: a sync-forcing API?
 
-// FUTURE: a delete API?  sure.  (just document carefully what its consistency model is -- i.e. basically none.)
+// FUTURE: a delete API with a real consistency model?
 //   (hunch: if you do want some sort of consistency model -- consider offering a whole family of methods that have some sort of generation or sequencing number on them.)
 
 // FUTURE: a force-overwrite API?  (not useful for a content-address system.  but maybe a gesture towards wider reusability is acceptable to have on offer.)
 
-// FUTURE: a size estimation API?  (unclear if we need to standardize this, but we could.  an offer, anyway.)
-
 // FUTURE: a GC API?  (dubious -- doing it well probably crosses logical domains, and should not be tied down here.)
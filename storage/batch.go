@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Batch collects writes in a buffer, so that they can later either all be written into a WritableStorage (see Commit),
+// or all be discarded (see Abort).
+//
+// Batch itself implements WritableStorage and StreamingWritableStorage,
+// so it can be used anywhere a store can be.
+// Most usefully, it can be given to linking.LinkSystem.SetWriteStorage:
+// then all the blocks written by LinkSystem.Store while building a multi-block DAG are held back,
+// and if building the DAG fails midway, calling Abort leaves no orphaned blocks in the Store.
+//
+// Content is held in memory until the total buffered reaches SpillThreshold bytes;
+// after that, further content is appended to a temporary file in SpillDir.
+// A zero SpillThreshold means everything stays in memory.
+//
+// Commit uses the BatchWritableStorage feature of the Store if it's available, and is then atomic.
+// Otherwise, Commit falls back to writing each entry in turn (using PutVec or PutStream, feature-detected as usual),
+// which is not atomic: if it fails partway through, some entries will have been written.
+//
+// Has reports entries that are pending in the batch as well as those already in the Store.
+//
+// A Batch is safe for concurrent use.
+// Once Commit has succeeded, or Abort has been called, the Batch cannot be used again.
+type Batch struct {
+	// Store is where the batch's entries are written when it's committed.
+	Store WritableStorage
+
+	// SpillThreshold is the number of bytes of content to hold in memory before spilling to a temporary file.
+	// Zero means never spill.
+	SpillThreshold int64
+
+	// SpillDir is the directory the temporary file is made in, if content is spilled.
+	// If empty, os.TempDir is used.
+	SpillDir string
+
+	mu       sync.Mutex
+	entries  []batchEntry
+	seen     map[string]struct{}
+	buffered int64
+	spill    *os.File
+	spillLen int64
+	finished bool
+}
+
+// batchEntry is either held in memory in content,
+// or, if spilled, is a range of the spill file.
+type batchEntry struct {
+	key     string
+	content []byte
+	spilled bool
+	offset  int64
+	length  int64
+}
+
+// Len returns the number of entries pending in the batch.
+func (b *Batch) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+// Has implements go-ipld-prime/storage.Storage.Has.
+func (b *Batch) Has(ctx context.Context, key string) (bool, error) {
+	b.mu.Lock()
+	_, pending := b.seen[key]
+	b.mu.Unlock()
+	if pending {
+		return true, nil
+	}
+	return b.Store.Has(ctx, key)
+}
+
+// Put implements go-ipld-prime/storage.WritableStorage.Put.
+// The content is copied into the batch; nothing reaches the Store until Commit.
+func (b *Batch) Put(ctx context.Context, key string, content []byte) error {
+	cpy := make([]byte, len(content))
+	copy(cpy, content)
+	return b.add(key, cpy)
+}
+
+// PutStream implements go-ipld-prime/storage.StreamingWritableStorage.PutStream.
+// The content is gathered into the batch; nothing reaches the Store until Commit.
+func (b *Batch) PutStream(ctx context.Context) (io.Writer, func(string) error, error) {
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	var buf bytes.Buffer
+	var written bool
+	return &buf, func(key string) error {
+		if written {
+			return fmt.Errorf("WriteCommitter already used")
+		}
+		written = true
+		if key == "" {
+			return nil
+		}
+		return b.add(key, buf.Bytes())
+	}, nil
+}
+
+func (b *Batch) add(key string, content []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.finished {
+		return fmt.Errorf("storage: batch already committed or aborted")
+	}
+	if _, exists := b.seen[key]; exists {
+		return nil
+	}
+	if b.seen == nil {
+		b.seen = make(map[string]struct{})
+	}
+	size := int64(len(content))
+	if b.SpillThreshold <= 0 || b.buffered+size <= b.SpillThreshold {
+		b.entries = append(b.entries, batchEntry{key: key, content: content, length: size})
+		b.buffered += size
+		b.seen[key] = struct{}{}
+		return nil
+	}
+	if b.spill == nil {
+		f, err := os.CreateTemp(b.SpillDir, "ipld-batch-")
+		if err != nil {
+			return fmt.Errorf("storage: could not create batch spill file: %w", err)
+		}
+		b.spill = f
+	}
+	if _, err := b.spill.WriteAt(content, b.spillLen); err != nil {
+		return fmt.Errorf("storage: could not write to batch spill file: %w", err)
+	}
+	b.entries = append(b.entries, batchEntry{key: key, spilled: true, offset: b.spillLen, length: size})
+	b.spillLen += size
+	b.seen[key] = struct{}{}
+	return nil
+}
+
+// Commit writes every entry in the batch into the Store.
+//
+// If the Store implements BatchWritableStorage, this is atomic.
+// Otherwise, entries are written one at a time, and if an error is returned, some may already have been written.
+// Either way, after an error the batch is still intact:
+// Commit may be tried again, or Abort called to discard it.
+func (b *Batch) Commit(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.finished {
+		return fmt.Errorf("storage: batch already committed or aborted")
+	}
+	if batchable, ok := b.Store.(BatchWritableStorage); ok {
+		keys := make([]string, len(b.entries))
+		contents := make([]io.Reader, len(b.entries))
+		for i, ent := range b.entries {
+			keys[i] = ent.key
+			contents[i] = b.reader(ent)
+		}
+		if err := batchable.PutBatch(ctx, keys, contents); err != nil {
+			return err
+		}
+		return b.finish()
+	}
+	for _, ent := range b.entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !ent.spilled {
+			if err := PutVec(ctx, b.Store, ent.key, [][]byte{ent.content}); err != nil {
+				return err
+			}
+			continue
+		}
+		wr, wrcommit, err := PutStream(ctx, b.Store)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(wr, b.reader(ent)); err != nil {
+			wrcommit("")
+			return err
+		}
+		if err := wrcommit(ent.key); err != nil {
+			return err
+		}
+	}
+	return b.finish()
+}
+
+// Abort discards every entry in the batch, and removes any temporary file.
+// Nothing is written to the Store.
+func (b *Batch) Abort() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.finished {
+		return nil
+	}
+	return b.finish()
+}
+
+func (b *Batch) reader(ent batchEntry) io.Reader {
+	if ent.spilled {
+		return io.NewSectionReader(b.spill, ent.offset, ent.length)
+	}
+	return bytes.NewReader(ent.content)
+}
+
+// finish drops all entries and cleans up the spill file.  Must be called with the lock held.
+func (b *Batch) finish() error {
+	b.finished = true
+	b.entries = nil
+	b.seen = nil
+	if b.spill == nil {
+		return nil
+	}
+	name := b.spill.Name()
+	b.spill.Close()
+	b.spill = nil
+	return os.Remove(name)
+}
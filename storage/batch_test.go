@@ -0,0 +1,152 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/fsstore"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/storage/tests"
+)
+
+type readWriteStorage interface {
+	storage.ReadableStorage
+	storage.WritableStorage
+}
+
+// basicStore returns a memstore with every feature except the basics hidden,
+// so that we can test the fallback paths.
+func basicStore() readWriteStorage {
+	return tests.Basic(&memstore.Store{}).(readWriteStorage)
+}
+
+// refusingStore is a basic store which refuses to put one key.
+type refusingStore struct {
+	readWriteStorage
+	failKey string
+}
+
+func (rs *refusingStore) Put(ctx context.Context, key string, content []byte) error {
+	if key == rs.failKey {
+		return errors.New("refusing to put " + key)
+	}
+	return rs.readWriteStorage.Put(ctx, key, content)
+}
+
+// failingReader can be used as batch content that can't be read.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("nope") }
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+
+	stores := map[string]func(t *testing.T) storage.ReadableStorage{
+		"memstore": func(t *testing.T) storage.ReadableStorage { return &memstore.Store{} },
+		"fsstore": func(t *testing.T) storage.ReadableStorage {
+			fs := &fsstore.Store{}
+			qt.Assert(t, fs.InitDefaults(t.TempDir()), qt.IsNil)
+			return fs
+		},
+		"basic": func(t *testing.T) storage.ReadableStorage { return basicStore() },
+	}
+	for name, mkStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			for _, spill := range []int64{0, 4} {
+				t.Run(fmt.Sprintf("commit/spill=%d", spill), func(t *testing.T) {
+					store := mkStore(t)
+					batch := &storage.Batch{Store: store.(storage.WritableStorage), SpillThreshold: spill, SpillDir: t.TempDir()}
+					qt.Assert(t, batch.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+					wr, commit, err := batch.PutStream(ctx)
+					qt.Assert(t, err, qt.IsNil)
+					wr.Write([]byte("be"))
+					wr.Write([]byte("ta"))
+					qt.Assert(t, commit("b"), qt.IsNil)
+					qt.Assert(t, batch.Put(ctx, "c", []byte("gam")), qt.IsNil)
+					qt.Assert(t, batch.Put(ctx, "a", []byte("ignored")), qt.IsNil)
+					qt.Check(t, batch.Len(), qt.Equals, 3)
+
+					has, _ := batch.Has(ctx, "b")
+					qt.Check(t, has, qt.IsTrue)
+					has, _ = store.Has(ctx, "b")
+					qt.Check(t, has, qt.IsFalse)
+
+					qt.Assert(t, batch.Commit(ctx), qt.IsNil)
+					for k, v := range map[string]string{"a": "alpha", "b": "beta", "c": "gam"} {
+						got, err := store.Get(ctx, k)
+						qt.Check(t, err, qt.IsNil)
+						qt.Check(t, string(got), qt.Equals, v)
+					}
+					qt.Check(t, batch.Put(ctx, "d", nil), qt.IsNotNil)
+					qt.Check(t, batch.Commit(ctx), qt.IsNotNil)
+
+					entries, err := os.ReadDir(batch.SpillDir)
+					qt.Assert(t, err, qt.IsNil)
+					qt.Check(t, entries, qt.HasLen, 0)
+				})
+				t.Run(fmt.Sprintf("abort/spill=%d", spill), func(t *testing.T) {
+					store := mkStore(t)
+					batch := &storage.Batch{Store: store.(storage.WritableStorage), SpillThreshold: spill, SpillDir: t.TempDir()}
+					qt.Assert(t, batch.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+					qt.Assert(t, batch.Put(ctx, "b", []byte("beta")), qt.IsNil)
+					qt.Assert(t, batch.Abort(), qt.IsNil)
+					has, _ := store.Has(ctx, "a")
+					qt.Check(t, has, qt.IsFalse)
+					qt.Check(t, batch.Commit(ctx), qt.IsNotNil)
+
+					entries, err := os.ReadDir(batch.SpillDir)
+					qt.Assert(t, err, qt.IsNil)
+					qt.Check(t, entries, qt.HasLen, 0)
+				})
+			}
+		})
+	}
+}
+
+func TestPutBatchAtomic(t *testing.T) {
+	ctx := context.Background()
+	fs := &fsstore.Store{}
+	qt.Assert(t, fs.InitDefaults(t.TempDir()), qt.IsNil)
+	for name, store := range map[string]storage.ReadableStorage{
+		"memstore": &memstore.Store{},
+		"fsstore":  fs,
+	} {
+		t.Run(name, func(t *testing.T) {
+			qt.Assert(t, store.(storage.WritableStorage).Put(ctx, "pre", []byte("existing")), qt.IsNil)
+			err := storage.PutBatch(ctx, store.(storage.WritableStorage),
+				[]string{"pre", "x", "y"},
+				[]io.Reader{bytes.NewReader([]byte("other")), bytes.NewReader([]byte("ex")), failingReader{}},
+			)
+			qt.Check(t, err, qt.IsNotNil)
+			has, _ := store.Has(ctx, "x")
+			qt.Check(t, has, qt.IsFalse)
+			got, _ := store.Get(ctx, "pre")
+			qt.Check(t, string(got), qt.Equals, "existing")
+		})
+	}
+}
+
+func TestBatchFallbackNotAtomic(t *testing.T) {
+	ctx := context.Background()
+	store := &refusingStore{readWriteStorage: basicStore(), failKey: "b"}
+	batch := &storage.Batch{Store: store}
+	qt.Assert(t, batch.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+	qt.Assert(t, batch.Put(ctx, "b", []byte("beta")), qt.IsNil)
+	qt.Check(t, batch.Commit(ctx), qt.IsNotNil)
+	has, _ := store.Has(ctx, "a")
+	qt.Check(t, has, qt.IsTrue)
+
+	// The batch is still intact, so we can retry once the store stops failing.
+	store.failKey = ""
+	qt.Check(t, batch.Commit(ctx), qt.IsNil)
+	has, _ = store.Has(ctx, "b")
+	qt.Check(t, has, qt.IsTrue)
+}
@@ -0,0 +1,455 @@
+/*
+The bloomstore package offers a storage wrapper which keeps a bloom filter of the keys present in storage,
+so that Has can answer "no" without asking the wrapped storage.
+
+This is useful when the wrapped storage is slow to answer Has -- for example, fsstore does a stat syscall per key --
+and the question is mostly asked about keys that aren't present, as it is when replicating data into a store.
+When the filter says a key may be present, the wrapped storage is asked, so Has never gives a wrong answer;
+the filter only decides how often the wrapped storage has to be asked.
+
+The filter can be saved to a file, which is read back by the next Init,
+and can be rebuilt from scratch by enumerating the wrapped storage, using Rebuild.
+A saved filter is only trusted if it was written by Close:
+Init removes the file once it's read it, so if the process doesn't get as far as closing the store,
+the next Init finds no file, and rebuilds the filter if the wrapped storage can enumerate its keys.
+*/
+package bloomstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"iter"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ipld/go-ipld-prime/storage"
+)
+
+const (
+	// DefaultExpectedKeys is the number of keys a filter is sized for if Store.ExpectedKeys isn't set.
+	DefaultExpectedKeys = 1 << 20
+
+	// DefaultFalsePositiveRate is the false positive rate a filter is sized for if Store.FalsePositiveRate isn't set.
+	DefaultFalsePositiveRate = 0.01
+)
+
+var errClosed = errors.New("bloomstore: store is closed")
+
+// errInvalidFilter is wrapped by the errors for filter files that can't be loaded because they're damaged.
+var errInvalidFilter = errors.New("bloomstore: invalid filter file")
+
+// Store implements go-ipld-prime/storage.ReadableStorage and go-ipld-prime/storage.WritableStorage,
+// as well as go-ipld-prime/storage.StreamingReadableStorage, go-ipld-prime/storage.PeekableStorage,
+// go-ipld-prime/storage.StreamingWritableStorage, go-ipld-prime/storage.VectorWritableStorage,
+// go-ipld-prime/storage.BatchWritableStorage, go-ipld-prime/storage.SizableStorage,
+// go-ipld-prime/storage.EnumerableStorage, and go-ipld-prime/storage.DeletableStorage,
+// by consulting its filter in Has, adding keys to its filter as they're written,
+// and passing everything through to another storage system.
+// Writing only works if the wrapped storage is writable,
+// and the other optional features fall back or return errors as the functions in the storage package do
+// if the wrapped storage doesn't support them.
+//
+// Init must be called before a Store is used.
+// All writes to the wrapped storage must go through the Store, or else Has may wrongly report keys as absent
+// (until the filter is rebuilt).
+//
+// Keys can't be removed from a bloom filter, so deleted keys stay in the filter,
+// and Has asks the wrapped storage about them, as it does for any other false positive.
+// If many keys are deleted, Rebuild clears them out.
+type Store struct {
+	Wrapped storage.ReadableStorage
+
+	// ExpectedKeys is the number of keys the filter is sized for.
+	// If more than this are present, the false positive rate gets worse than FalsePositiveRate;
+	// Rebuild resizes the filter to fit the number of keys it finds, if that's more.
+	// Optional: DefaultExpectedKeys is used if it's zero.
+	ExpectedKeys int64
+
+	// FalsePositiveRate is the proportion of absent keys that the filter should report as possibly present,
+	// when it holds ExpectedKeys keys.
+	// Lower rates need more memory: about 10 bits per key for 1%, and 5 more bits for each further factor of ten.
+	// Optional: DefaultFalsePositiveRate is used if it's zero.
+	FalsePositiveRate float64
+
+	path    string
+	filter  atomic.Pointer[filter] // nil if the filter isn't built yet, in which case Has always asks the wrapped storage.
+	next    atomic.Pointer[filter] // set while Rebuild is building a new filter, so writes can be added to it too.
+	writes  sync.RWMutex           // held for reading during each write, and for writing by Close, and by Rebuild before it enumerates.
+	rebuild sync.Mutex             // held by Rebuild.
+	closed  bool
+}
+
+// Init prepares the Store for use.
+//
+// If path isn't empty, the filter is saved in the file at that path by Close,
+// and loaded from it when Init is next called.
+// If the file doesn't exist, or is damaged (in which case it's removed),
+// Init calls Rebuild if the wrapped storage supports enumeration.
+// If it doesn't, the store works without a filter, and Has asks the wrapped storage about every key.
+func (store *Store) Init(ctx context.Context, path string) error {
+	store.path = path
+	if path != "" {
+		f, err := loadFilter(path)
+		switch {
+		case err == nil:
+			// From here on, the file on disk goes out of date with every write; so it goes, until Close writes it again.
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("bloomstore: could not remove filter file: %w", err)
+			}
+			store.filter.Store(f)
+			return nil
+		case errors.Is(err, errInvalidFilter):
+			// It's no use, and would only get in the way next time, so carry on as if it weren't there.
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("bloomstore: could not remove filter file: %w", err)
+			}
+		case !os.IsNotExist(err):
+			return err
+		}
+	}
+	if _, ok := store.Wrapped.(storage.EnumerableStorage); ok {
+		return store.Rebuild(ctx)
+	}
+	return nil
+}
+
+// Rebuild builds a new filter by enumerating the wrapped storage.
+// The old filter (if any) keeps being used until the new one is complete; writes are added to both.
+//
+// The new filter is sized for ExpectedKeys, or for twice the number of keys the old filter held, if that's more.
+// If it turns out to hold more keys than it was sized for, Rebuild enumerates the wrapped storage a second time,
+// into a filter sized for twice the number it found.
+//
+// It returns an error wrapping errors.ErrUnsupported if the wrapped storage can't enumerate its keys.
+func (store *Store) Rebuild(ctx context.Context) error {
+	store.rebuild.Lock()
+	defer store.rebuild.Unlock()
+	defer store.next.Store(nil)
+
+	capacity := store.ExpectedKeys
+	if capacity <= 0 {
+		capacity = DefaultExpectedKeys
+	}
+	if f := store.filter.Load(); f != nil && int64(f.keys.Load())*2 > capacity {
+		capacity = int64(f.keys.Load()) * 2
+	}
+	for {
+		f := newFilter(capacity, store.falsePositiveRate())
+		// Writes in progress might only have added their keys to the old filter,
+		// so wait for them to finish before enumerating; any later ones add their keys to the new filter as well.
+		store.writes.Lock()
+		store.next.Store(f)
+		store.writes.Unlock()
+		var n int64
+		for key, err := range storage.List(ctx, store.Wrapped, "") {
+			if err != nil {
+				return fmt.Errorf("bloomstore: could not rebuild filter: %w", err)
+			}
+			f.add(key)
+			n++
+		}
+		if n > capacity {
+			capacity = n * 2
+			continue
+		}
+		// Publish the new filter and stop feeding it together, so that no write can add its key to
+		// the old filter and the rebuilt one only after the rebuilt one has already become the old one.
+		store.writes.Lock()
+		store.filter.Store(f)
+		store.next.Store(nil)
+		store.writes.Unlock()
+		return nil
+	}
+}
+
+func (store *Store) falsePositiveRate() float64 {
+	if store.FalsePositiveRate <= 0 || store.FalsePositiveRate >= 1 {
+		return DefaultFalsePositiveRate
+	}
+	return store.FalsePositiveRate
+}
+
+// Close saves the filter to the file given to Init, if there was one.
+// Writes fail once the Store is closed.
+func (store *Store) Close() error {
+	store.writes.Lock()
+	defer store.writes.Unlock()
+	store.closed = true
+	f := store.filter.Load()
+	if store.path == "" || f == nil {
+		return nil
+	}
+	return f.save(store.path)
+}
+
+// addKeys adds keys to the filter (and the one being rebuilt, if there is one).
+// It must be called with store.writes held for reading,
+// and before the keys are written to the wrapped storage, so that Has never misses them.
+func (store *Store) addKeys(keys ...string) error {
+	if store.closed {
+		return errClosed
+	}
+	for _, f := range []*filter{store.filter.Load(), store.next.Load()} {
+		if f != nil {
+			for _, key := range keys {
+				f.add(key)
+			}
+		}
+	}
+	return nil
+}
+
+func (store *Store) writable() (storage.WritableStorage, error) {
+	ws, ok := store.Wrapped.(storage.WritableStorage)
+	if !ok {
+		return nil, fmt.Errorf("bloomstore: wrapped storage %T is not writable: %w", store.Wrapped, errors.ErrUnsupported)
+	}
+	return ws, nil
+}
+
+// Has implements go-ipld-prime/storage.Storage.Has.
+func (store *Store) Has(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if f := store.filter.Load(); f != nil && !f.mayContain(key) {
+		return false, nil
+	}
+	return store.Wrapped.Has(ctx, key)
+}
+
+// Get implements go-ipld-prime/storage.ReadableStorage.Get.
+func (store *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	return store.Wrapped.Get(ctx, key)
+}
+
+// GetStream implements go-ipld-prime/storage.StreamingReadableStorage.GetStream.
+func (store *Store) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	return storage.GetStream(ctx, store.Wrapped, key)
+}
+
+// Peek implements go-ipld-prime/storage.PeekableStorage.Peek.
+func (store *Store) Peek(ctx context.Context, key string) ([]byte, io.Closer, error) {
+	return storage.Peek(ctx, store.Wrapped, key)
+}
+
+// Size implements go-ipld-prime/storage.SizableStorage.Size.
+func (store *Store) Size(ctx context.Context, key string) (int64, error) {
+	return storage.Size(ctx, store.Wrapped, key)
+}
+
+// List implements go-ipld-prime/storage.EnumerableStorage.List.
+func (store *Store) List(ctx context.Context, prefix string) iter.Seq2[string, error] {
+	return storage.List(ctx, store.Wrapped, prefix)
+}
+
+// Delete implements go-ipld-prime/storage.DeletableStorage.Delete.
+// The key stays in the filter.
+func (store *Store) Delete(ctx context.Context, key string) error {
+	return storage.Delete(ctx, store.Wrapped, key)
+}
+
+// Put implements go-ipld-prime/storage.WritableStorage.Put.
+func (store *Store) Put(ctx context.Context, key string, content []byte) error {
+	ws, err := store.writable()
+	if err != nil {
+		return err
+	}
+	store.writes.RLock()
+	defer store.writes.RUnlock()
+	if err := store.addKeys(key); err != nil {
+		return err
+	}
+	return ws.Put(ctx, key, content)
+}
+
+// PutVec implements go-ipld-prime/storage.VectorWritableStorage.PutVec.
+func (store *Store) PutVec(ctx context.Context, key string, blobVec [][]byte) error {
+	ws, err := store.writable()
+	if err != nil {
+		return err
+	}
+	store.writes.RLock()
+	defer store.writes.RUnlock()
+	if err := store.addKeys(key); err != nil {
+		return err
+	}
+	return storage.PutVec(ctx, ws, key, blobVec)
+}
+
+// PutBatch implements go-ipld-prime/storage.BatchWritableStorage.PutBatch.
+func (store *Store) PutBatch(ctx context.Context, keys []string, contents []io.Reader) error {
+	ws, err := store.writable()
+	if err != nil {
+		return err
+	}
+	store.writes.RLock()
+	defer store.writes.RUnlock()
+	if err := store.addKeys(keys...); err != nil {
+		return err
+	}
+	return storage.PutBatch(ctx, ws, keys, contents)
+}
+
+// PutStream implements go-ipld-prime/storage.StreamingWritableStorage.PutStream.
+func (store *Store) PutStream(ctx context.Context) (io.Writer, func(key string) error, error) {
+	ws, err := store.writable()
+	if err != nil {
+		return nil, nil, err
+	}
+	wr, commit, err := storage.PutStream(ctx, ws)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wr, func(key string) error {
+		if key == "" {
+			return commit("")
+		}
+		store.writes.RLock()
+		defer store.writes.RUnlock()
+		if err := store.addKeys(key); err != nil {
+			commit("")
+			return err
+		}
+		return commit(key)
+	}, nil
+}
+
+// filter is a bloom filter.
+// It's safe for concurrent use: bits are only ever set, atomically.
+type filter struct {
+	k    uint64          // the number of bits set per key.
+	bits []atomic.Uint64 // len(bits)*64 bits in total.
+	keys atomic.Uint64   // an estimate of the number of distinct keys added: the number of adds that set any new bits.
+}
+
+// newFilter makes a filter sized to hold n keys with the given false positive rate,
+// using the usual formulas: m = -n*ln(p)/ln(2)^2 bits, and k = m/n*ln(2) bits per key.
+func newFilter(n int64, p float64) *filter {
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	words := uint64(m+63) / 64
+	if words == 0 {
+		words = 1
+	}
+	k := uint64(math.Round(float64(words*64) / float64(n) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+	return &filter{k: k, bits: make([]atomic.Uint64, words)}
+}
+
+// positions calls fn with each of the k bit positions for a key.
+// The positions are derived from the two halves of the key's 128-bit FNV-1a hash, by double hashing.
+// (This must never change, or saved filters become wrong; if it ever needs to, change the magic number in the file format too.)
+func (f *filter) positions(key string, fn func(word uint64, mask uint64) bool) bool {
+	h := fnv.New128a()
+	h.Write([]byte(key))
+	var sum [16]byte
+	h.Sum(sum[:0])
+	h1 := binary.LittleEndian.Uint64(sum[0:8])
+	h2 := binary.LittleEndian.Uint64(sum[8:16]) | 1
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < f.k; i++ {
+		pos := (h1 + i*h2) % m
+		if !fn(pos/64, 1<<(pos%64)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *filter) mayContain(key string) bool {
+	return f.positions(key, func(word, mask uint64) bool {
+		return f.bits[word].Load()&mask != 0
+	})
+}
+
+func (f *filter) add(key string) {
+	var changed bool
+	f.positions(key, func(word, mask uint64) bool {
+		if f.bits[word].Or(mask)&mask == 0 {
+			changed = true
+		}
+		return true
+	})
+	if changed {
+		f.keys.Add(1)
+	}
+}
+
+// The filter file holds the magic bytes, then k, the number of keys, and the number of words, as uint64s,
+// and then the words; all little-endian.
+var magic = [8]byte{'b', 'l', 'o', 'o', 'm', 'v', '0', '1'}
+
+func (f *filter) save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("bloomstore: could not save filter: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	w.Write(magic[:])
+	var buf [8]byte
+	for _, v := range []uint64{f.k, f.keys.Load(), uint64(len(f.bits))} {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		w.Write(buf[:])
+	}
+	for i := range f.bits {
+		binary.LittleEndian.PutUint64(buf[:], f.bits[i].Load())
+		w.Write(buf[:])
+	}
+	err = w.Flush()
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("bloomstore: could not save filter: %w", err)
+	}
+	return nil
+}
+
+func loadFilter(path string) (*filter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	var header [32]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidFilter, err)
+	}
+	if [8]byte(header[0:8]) != magic {
+		return nil, fmt.Errorf("%w: wrong magic bytes", errInvalidFilter)
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	f := &filter{k: binary.LittleEndian.Uint64(header[8:16])}
+	f.keys.Store(binary.LittleEndian.Uint64(header[16:24]))
+	words := binary.LittleEndian.Uint64(header[24:32])
+	if f.k == 0 || words == 0 || uint64(fi.Size()) != 32+words*8 {
+		return nil, fmt.Errorf("%w: wrong size", errInvalidFilter)
+	}
+	f.bits = make([]atomic.Uint64, words)
+	var buf [8]byte
+	for i := range f.bits {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidFilter, err)
+		}
+		f.bits[i].Store(binary.LittleEndian.Uint64(buf[:]))
+	}
+	return f, nil
+}
@@ -0,0 +1,203 @@
+package bloomstore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/bloomstore"
+	"github.com/ipld/go-ipld-prime/storage/fsstore"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/storage/tests"
+)
+
+// countingStore counts the calls to Has that reach it.
+type countingStore struct {
+	*fsstore.Store
+	has atomic.Int64
+}
+
+func (s *countingStore) Has(ctx context.Context, key string) (bool, error) {
+	s.has.Add(1)
+	return s.Store.Has(ctx, key)
+}
+
+func newCountingStore(t *testing.T) *countingStore {
+	fs := &fsstore.Store{}
+	qt.Assert(t, fs.InitDefaults(t.TempDir()), qt.IsNil)
+	return &countingStore{Store: fs}
+}
+
+func TestHas(t *testing.T) {
+	ctx := context.Background()
+	backing := newCountingStore(t)
+	for i := 0; i < 100; i++ {
+		qt.Assert(t, backing.Put(ctx, fmt.Sprintf("old-%d", i), []byte("x")), qt.IsNil)
+	}
+
+	store := &bloomstore.Store{Wrapped: backing, ExpectedKeys: 1000, FalsePositiveRate: 0.001}
+	qt.Assert(t, store.Init(ctx, ""), qt.IsNil)
+	qt.Assert(t, store.Put(ctx, "new", []byte("x")), qt.IsNil)
+	wr, commit, err := store.PutStream(ctx)
+	qt.Assert(t, err, qt.IsNil)
+	wr.Write([]byte("x"))
+	qt.Assert(t, commit("streamed"), qt.IsNil)
+
+	// Present keys, whether they were there before the filter was built or written since, are always found.
+	for _, key := range []string{"old-0", "old-99", "new", "streamed"} {
+		has, err := store.Has(ctx, key)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, has, qt.IsTrue, qt.Commentf("key %q", key))
+	}
+
+	// Absent keys almost never get as far as the wrapped storage.
+	backing.has.Store(0)
+	for i := 0; i < 1000; i++ {
+		has, err := store.Has(ctx, fmt.Sprintf("absent-%d", i))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, has, qt.IsFalse)
+	}
+	qt.Check(t, backing.has.Load() < 10, qt.IsTrue, qt.Commentf("%d false positives", backing.has.Load()))
+}
+
+func TestPersistence(t *testing.T) {
+	ctx := context.Background()
+	backing := newCountingStore(t)
+	path := filepath.Join(t.TempDir(), "filter")
+
+	store := &bloomstore.Store{Wrapped: backing, ExpectedKeys: 100}
+	qt.Assert(t, store.Init(ctx, path), qt.IsNil)
+	qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+	qt.Assert(t, store.Close(), qt.IsNil)
+	qt.Check(t, store.Put(ctx, "b", []byte("beta")), qt.ErrorMatches, "bloomstore: store is closed")
+
+	// The saved filter is used, rather than enumerating the storage again;
+	// so a key written behind its back is missed.
+	qt.Assert(t, backing.Put(ctx, "c", []byte("gamma")), qt.IsNil)
+	store = &bloomstore.Store{Wrapped: backing, ExpectedKeys: 100}
+	qt.Assert(t, store.Init(ctx, path), qt.IsNil)
+	has, err := store.Has(ctx, "a")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, has, qt.IsTrue)
+	has, err = store.Has(ctx, "c")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, has, qt.IsFalse)
+
+	// The file is gone until the store is closed, so a crash means a rebuild next time.
+	_, err = os.Stat(path)
+	qt.Check(t, os.IsNotExist(err), qt.IsTrue)
+	store = &bloomstore.Store{Wrapped: backing, ExpectedKeys: 100}
+	qt.Assert(t, store.Init(ctx, path), qt.IsNil)
+	has, err = store.Has(ctx, "c")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, has, qt.IsTrue)
+}
+
+func TestDamagedFile(t *testing.T) {
+	ctx := context.Background()
+	backing := newCountingStore(t)
+	path := filepath.Join(t.TempDir(), "filter")
+	store := &bloomstore.Store{Wrapped: backing, ExpectedKeys: 100}
+	qt.Assert(t, store.Init(ctx, path), qt.IsNil)
+	qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+	qt.Assert(t, store.Close(), qt.IsNil)
+
+	// A truncated file is discarded, and the filter rebuilt from the storage.
+	qt.Assert(t, os.Truncate(path, 20), qt.IsNil)
+	store = &bloomstore.Store{Wrapped: backing, ExpectedKeys: 100}
+	qt.Assert(t, store.Init(ctx, path), qt.IsNil)
+	has, err := store.Has(ctx, "a")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, has, qt.IsTrue)
+	_, err = os.Stat(path)
+	qt.Check(t, os.IsNotExist(err), qt.IsTrue)
+
+	// So is one that isn't a filter at all; without enumeration to rebuild the filter, the store works without one.
+	qt.Assert(t, os.WriteFile(path, []byte("not a bloom filter, but long enough to have a header"), 0666), qt.IsNil)
+	store = &bloomstore.Store{Wrapped: tests.Basic(backing)}
+	qt.Assert(t, store.Init(ctx, path), qt.IsNil)
+	has, err = store.Has(ctx, "a")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, has, qt.IsTrue)
+}
+
+func TestRebuildGrows(t *testing.T) {
+	ctx := context.Background()
+	backing := newCountingStore(t)
+	for i := 0; i < 500; i++ {
+		qt.Assert(t, backing.Put(ctx, fmt.Sprintf("key-%d", i), []byte("x")), qt.IsNil)
+	}
+	// Sized for far fewer keys than there are; the rebuild notices, and makes a bigger filter.
+	store := &bloomstore.Store{Wrapped: backing, ExpectedKeys: 10}
+	qt.Assert(t, store.Init(ctx, ""), qt.IsNil)
+	backing.has.Store(0)
+	for i := 0; i < 1000; i++ {
+		has, err := store.Has(ctx, fmt.Sprintf("absent-%d", i))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, has, qt.IsFalse)
+	}
+	qt.Check(t, backing.has.Load() < 50, qt.IsTrue, qt.Commentf("%d false positives", backing.has.Load()))
+}
+
+func TestRebuildDuringWrites(t *testing.T) {
+	ctx := context.Background()
+	store := &bloomstore.Store{Wrapped: &memstore.Store{}, ExpectedKeys: 10}
+	qt.Assert(t, store.Init(ctx, ""), qt.IsNil)
+
+	// Keys written while the filter is being rebuilt, and while the rebuilt one is swapped in, must never be lost.
+	var wg sync.WaitGroup
+	var done atomic.Bool
+	const writers, perWriter = 4, 500
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				qt.Check(t, store.Put(ctx, fmt.Sprintf("key-%d-%d", w, i), []byte("x")), qt.IsNil)
+			}
+		}()
+	}
+	rebuilt := make(chan struct{})
+	go func() {
+		defer close(rebuilt)
+		for !done.Load() {
+			qt.Check(t, store.Rebuild(ctx), qt.IsNil)
+		}
+	}()
+	wg.Wait()
+	done.Store(true)
+	<-rebuilt
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < perWriter; i++ {
+			key := fmt.Sprintf("key-%d-%d", w, i)
+			has, err := store.Has(ctx, key)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Assert(t, has, qt.IsTrue, qt.Commentf("key %q", key))
+		}
+	}
+}
+
+func TestNotEnumerable(t *testing.T) {
+	ctx := context.Background()
+	store := &bloomstore.Store{Wrapped: tests.Basic(&memstore.Store{})}
+	qt.Assert(t, store.Init(ctx, ""), qt.IsNil)
+	err := store.Rebuild(ctx)
+	qt.Check(t, errors.Is(err, errors.ErrUnsupported), qt.IsTrue)
+}
+
+func TestConformance(t *testing.T) {
+	tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+		store := &bloomstore.Store{Wrapped: &memstore.Store{}, ExpectedKeys: 100}
+		qt.Assert(t, store.Init(context.Background(), ""), qt.IsNil)
+		return store
+	}, tests.ConformanceOptions{})
+}
@@ -7,10 +7,12 @@ import (
 	"github.com/ipfs/boxo/blockstore"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
 )
 
 // Adapter implements go-ipld-prime/storage.ReadableStorage
 // and go-ipld-prime/storage.WritableStorage
+// (as well as go-ipld-prime/storage.DeletableStorage)
 // backed by a go-ipfs-blockstore.Blockstore.
 //
 // The go-ipfs-blockstore.Blockstore may internally have other configuration.
@@ -106,6 +108,31 @@ func (a *Adapter) Put(ctx context.Context, key string, content []byte) error {
 	return a.Wrapped.Put(ctx, block)
 }
 
+// Delete implements go-ipld-prime/storage.DeletableStorage.Delete.
+func (a *Adapter) Delete(ctx context.Context, key string) error {
+	// Return early if the context is already closed.
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// Do the inverse of cid.KeyString(),
+	// which is how a valid key for this adapter must've been produced.
+	k, err := cidFromBinString(key)
+	if err != nil {
+		return err
+	}
+
+	// Delegate the Delete call.
+	// It's called "DeleteBlock" in Blockstore.
+	// Deleting something that's not present is not an error for us,
+	// but some Blockstore implementations report it as one, so we smooth that over.
+	err = a.Wrapped.DeleteBlock(ctx, k)
+	if ipld.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
 // Do the inverse of cid.KeyString().
 // (Unclear why go-cid doesn't offer a function for this itself.)
 func cidFromBinString(key string) (cid.Cid, error) {
@@ -7,10 +7,12 @@ import (
 	"github.com/ipfs/boxo/blockservice"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
 )
 
 // Adapter implements go-ipld-prime/storage.ReadableStorage
 // and go-ipld-prime/storage.WritableStorage
+// (as well as go-ipld-prime/storage.DeletableStorage)
 // backed by a go-blockservice.BlockService.
 //
 // The go-blockservice.BlockService may internally have other configuration,
@@ -61,8 +63,12 @@ func (a *Adapter) Has(ctx context.Context, key string) (bool, error) {
 
 // Get implements go-ipld-prime/storage.ReadableStorage.Get.
 func (a *Adapter) Get(ctx context.Context, key string) ([]byte, error) {
-	// No need to check the context proactively here --
-	// the BlockService API actually accepts context.
+	// Return early if the context is already closed.
+	// The BlockService API does accept context, and we pass it on,
+	// but it doesn't check it when the block is available locally.
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 
 	// Do the inverse of cid.KeyString(),
 	// which is how a valid key for this adapter must've been produced.
@@ -115,6 +121,32 @@ func (a *Adapter) Put(ctx context.Context, key string, content []byte) error {
 	return a.Wrapped.AddBlock(ctx, block)
 }
 
+// Delete implements go-ipld-prime/storage.DeletableStorage.Delete.
+//
+// Like Has, this only concerns the Blockstore that the BlockService wraps:
+// the block is removed locally, and may well still be found remotely by a subsequent Get.
+func (a *Adapter) Delete(ctx context.Context, key string) error {
+	// No need to check the context proactively here --
+	// the BlockService API actually accepts context.
+
+	// Do the inverse of cid.KeyString(),
+	// which is how a valid key for this adapter must've been produced.
+	k, err := cidFromBinString(key)
+	if err != nil {
+		return err
+	}
+
+	// Delegate the Delete call.
+	// It's called "DeleteBlock" in BlockService.
+	// Deleting something that's not present is not an error for us,
+	// but some Blockstore implementations report it as one, so we smooth that over.
+	err = a.Wrapped.DeleteBlock(ctx, k)
+	if ipld.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
 // Do the inverse of cid.KeyString().
 // (Unclear why go-cid doesn't offer a function for this itself.)
 func cidFromBinString(key string) (cid.Cid, error) {
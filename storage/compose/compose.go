@@ -0,0 +1,262 @@
+/*
+The compose package offers storage systems built out of other storage systems:
+
+  - Tiered puts a fast store in front of a slow one, as a read-through cache.
+  - Mirror writes everything to several stores, and reads from whichever has the data.
+  - Router sends each key to one of several stores, chosen by looking at the key.
+
+The values these return implement storage.ReadableStorage,
+and also storage.WritableStorage whenever the underlying stores make that possible.
+
+They also implement storage.StreamingReadableStorage, storage.PeekableStorage,
+storage.StreamingWritableStorage, and storage.VectorWritableStorage,
+but only when every one of the underlying stores they'd need to use does:
+so, feature-detecting those interfaces on a composed store
+gives the same answer as feature-detecting them on the stores it was made of.
+(Otherwise, a caller might pick a streaming or zero-copy code path
+that the composed store could only emulate -- and less efficiently than the storage package's own fallbacks would.)
+Use the functions in the storage package to work with composed stores, as with any other.
+*/
+package compose
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipld/go-ipld-prime/storage"
+)
+
+// ReadWriteStorage is a storage system that can be both read and written.
+// Stores that the wrappers in this package need to write to as well as read from must satisfy this.
+type ReadWriteStorage interface {
+	storage.ReadableStorage
+	storage.WritableStorage
+}
+
+// composite is what each wrapper implements internally.
+// The optional features are unexported methods, so that they can be exposed selectively by expose.
+type composite interface {
+	Has(ctx context.Context, key string) (bool, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+	put(ctx context.Context, key string, content []byte) error
+	getStream(ctx context.Context, key string) (io.ReadCloser, error)
+	peek(ctx context.Context, key string) ([]byte, io.Closer, error)
+	putStream(ctx context.Context) (io.Writer, func(key string) error, error)
+	putVec(ctx context.Context, key string, blobVec [][]byte) error
+}
+
+// features is the set of optional interfaces a composite should be exposed with.
+type features uint8
+
+const (
+	featWritable features = 1 << iota
+	featStreamingRead
+	featPeek
+	featStreamingWrite
+	featVectorWrite
+)
+
+// featuresOf reports which optional interfaces a store implements.
+func featuresOf(store storage.Storage) features {
+	var f features
+	if _, ok := store.(storage.WritableStorage); ok {
+		f |= featWritable
+	}
+	if _, ok := store.(storage.StreamingReadableStorage); ok {
+		f |= featStreamingRead
+	}
+	if _, ok := store.(storage.PeekableStorage); ok {
+		f |= featPeek
+	}
+	if _, ok := store.(storage.StreamingWritableStorage); ok {
+		f |= featStreamingWrite
+	}
+	if _, ok := store.(storage.VectorWritableStorage); ok {
+		f |= featVectorWrite
+	}
+	return f
+}
+
+// commonFeatures reports the optional interfaces that all of the stores implement.
+func commonFeatures[S storage.Storage](stores ...S) features {
+	f := ^features(0)
+	for _, store := range stores {
+		f &= featuresOf(store)
+	}
+	return f
+}
+
+type (
+	reader       struct{ composite }
+	writer       struct{ c composite }
+	streamReader struct{ c composite }
+	peeker       struct{ c composite }
+	streamWriter struct{ c composite }
+	vecWriter    struct{ c composite }
+)
+
+func (w writer) Put(ctx context.Context, key string, content []byte) error {
+	return w.c.put(ctx, key, content)
+}
+
+func (r streamReader) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	return r.c.getStream(ctx, key)
+}
+
+func (p peeker) Peek(ctx context.Context, key string) ([]byte, io.Closer, error) {
+	return p.c.peek(ctx, key)
+}
+
+func (w streamWriter) PutStream(ctx context.Context) (io.Writer, func(key string) error, error) {
+	return w.c.putStream(ctx)
+}
+
+func (w vecWriter) PutVec(ctx context.Context, key string, blobVec [][]byte) error {
+	return w.c.putVec(ctx, key, blobVec)
+}
+
+// expose returns a value with the methods of c that are listed in f, and no others.
+// The write features are ignored unless featWritable is present.
+func expose(c composite, f features) storage.ReadableStorage {
+	r, w, sr, pk, sw, vw := reader{c}, writer{c}, streamReader{c}, peeker{c}, streamWriter{c}, vecWriter{c}
+	if f&featWritable == 0 {
+		f &^= featStreamingWrite | featVectorWrite
+	}
+	switch f &^ featWritable {
+	case 0:
+		if f&featWritable == 0 {
+			return r
+		}
+		return struct {
+			reader
+			writer
+		}{r, w}
+	case featStreamingRead:
+		if f&featWritable == 0 {
+			return struct {
+				reader
+				streamReader
+			}{r, sr}
+		}
+		return struct {
+			reader
+			writer
+			streamReader
+		}{r, w, sr}
+	case featPeek:
+		if f&featWritable == 0 {
+			return struct {
+				reader
+				peeker
+			}{r, pk}
+		}
+		return struct {
+			reader
+			writer
+			peeker
+		}{r, w, pk}
+	case featStreamingRead | featPeek:
+		if f&featWritable == 0 {
+			return struct {
+				reader
+				streamReader
+				peeker
+			}{r, sr, pk}
+		}
+		return struct {
+			reader
+			writer
+			streamReader
+			peeker
+		}{r, w, sr, pk}
+	// From here on, writable is implied.
+	case featStreamingWrite:
+		return struct {
+			reader
+			writer
+			streamWriter
+		}{r, w, sw}
+	case featVectorWrite:
+		return struct {
+			reader
+			writer
+			vecWriter
+		}{r, w, vw}
+	case featStreamingWrite | featVectorWrite:
+		return struct {
+			reader
+			writer
+			streamWriter
+			vecWriter
+		}{r, w, sw, vw}
+	case featStreamingRead | featStreamingWrite:
+		return struct {
+			reader
+			writer
+			streamReader
+			streamWriter
+		}{r, w, sr, sw}
+	case featStreamingRead | featVectorWrite:
+		return struct {
+			reader
+			writer
+			streamReader
+			vecWriter
+		}{r, w, sr, vw}
+	case featStreamingRead | featStreamingWrite | featVectorWrite:
+		return struct {
+			reader
+			writer
+			streamReader
+			streamWriter
+			vecWriter
+		}{r, w, sr, sw, vw}
+	case featPeek | featStreamingWrite:
+		return struct {
+			reader
+			writer
+			peeker
+			streamWriter
+		}{r, w, pk, sw}
+	case featPeek | featVectorWrite:
+		return struct {
+			reader
+			writer
+			peeker
+			vecWriter
+		}{r, w, pk, vw}
+	case featPeek | featStreamingWrite | featVectorWrite:
+		return struct {
+			reader
+			writer
+			peeker
+			streamWriter
+			vecWriter
+		}{r, w, pk, sw, vw}
+	case featStreamingRead | featPeek | featStreamingWrite:
+		return struct {
+			reader
+			writer
+			streamReader
+			peeker
+			streamWriter
+		}{r, w, sr, pk, sw}
+	case featStreamingRead | featPeek | featVectorWrite:
+		return struct {
+			reader
+			writer
+			streamReader
+			peeker
+			vecWriter
+		}{r, w, sr, pk, vw}
+	default: // everything.
+		return struct {
+			reader
+			writer
+			streamReader
+			peeker
+			streamWriter
+			vecWriter
+		}{r, w, sr, pk, sw, vw}
+	}
+}
@@ -0,0 +1,307 @@
+package compose_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/compose"
+	"github.com/ipld/go-ipld-prime/storage/fsstore"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/storage/tests"
+)
+
+// basicStore returns a memstore with every feature except the basics hidden.
+func basicStore() compose.ReadWriteStorage {
+	return tests.Basic(&memstore.Store{}).(compose.ReadWriteStorage)
+}
+
+// flakyStore is a basic store which can be told to fail.
+type flakyStore struct {
+	compose.ReadWriteStorage
+	fail bool
+}
+
+var errBroken = errors.New("broken")
+
+func newFlakyStore() *flakyStore { return &flakyStore{ReadWriteStorage: basicStore()} }
+
+func (fs *flakyStore) Has(ctx context.Context, key string) (bool, error) {
+	if fs.fail {
+		return false, errBroken
+	}
+	return fs.ReadWriteStorage.Has(ctx, key)
+}
+func (fs *flakyStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if fs.fail {
+		return nil, errBroken
+	}
+	return fs.ReadWriteStorage.Get(ctx, key)
+}
+func (fs *flakyStore) Put(ctx context.Context, key string, content []byte) error {
+	if fs.fail {
+		return errBroken
+	}
+	return fs.ReadWriteStorage.Put(ctx, key, content)
+}
+
+// readOnly hides the writability of a store.
+type readOnly struct{ storage.ReadableStorage }
+
+type featureSet struct {
+	Writable, StreamingRead, Peek, StreamingWrite, VectorWrite bool
+}
+
+func featuresOf(store storage.Storage) featureSet {
+	var f featureSet
+	_, f.Writable = store.(storage.WritableStorage)
+	_, f.StreamingRead = store.(storage.StreamingReadableStorage)
+	_, f.Peek = store.(storage.PeekableStorage)
+	_, f.StreamingWrite = store.(storage.StreamingWritableStorage)
+	_, f.VectorWrite = store.(storage.VectorWritableStorage)
+	return f
+}
+
+func has(t *testing.T, store storage.Storage, key string) bool {
+	t.Helper()
+	has, err := store.Has(context.Background(), key)
+	qt.Assert(t, err, qt.IsNil)
+	return has
+}
+
+func newFsstore(t *testing.T) *fsstore.Store {
+	fs := &fsstore.Store{}
+	qt.Assert(t, fs.InitDefaults(t.TempDir()), qt.IsNil)
+	return fs
+}
+
+func TestFeatures(t *testing.T) {
+	mem := func() *memstore.Store { return &memstore.Store{} }
+	memFeatures := featureSet{Writable: true, StreamingRead: true, Peek: true}
+	fsFeatures := featureSet{Writable: true, StreamingRead: true, StreamingWrite: true}
+
+	qt.Check(t, featuresOf(compose.Tiered(mem(), mem())), qt.Equals, memFeatures)
+	qt.Check(t, featuresOf(compose.Tiered(mem(), newFsstore(t))), qt.Equals, featureSet{Writable: true, StreamingRead: true})
+	qt.Check(t, featuresOf(compose.Tiered(newFsstore(t), newFsstore(t))), qt.Equals, fsFeatures)
+	qt.Check(t, featuresOf(compose.Tiered(mem(), readOnly{mem()})), qt.Equals, featureSet{})
+	qt.Check(t, featuresOf(compose.Tiered(mem(), basicStore())), qt.Equals, featureSet{Writable: true})
+
+	qt.Check(t, featuresOf(compose.Mirror(0, mem(), mem())), qt.Equals, memFeatures)
+	qt.Check(t, featuresOf(compose.Mirror(0, mem(), newFsstore(t))), qt.Equals, featureSet{Writable: true, StreamingRead: true})
+
+	qt.Check(t, featuresOf(compose.Router(compose.Route{Store: newFsstore(t)})), qt.Equals, featureSet{Writable: true, StreamingRead: true})
+	qt.Check(t, featuresOf(compose.Router(compose.Route{Store: mem()}, compose.Route{Store: readOnly{mem()}})), qt.Equals, featureSet{})
+}
+
+func TestTiered(t *testing.T) {
+	ctx := context.Background()
+	fast, slow := &memstore.Store{}, &memstore.Store{}
+	qt.Assert(t, slow.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+	qt.Assert(t, slow.Put(ctx, "b", []byte("beta")), qt.IsNil)
+	qt.Assert(t, slow.Put(ctx, "c", []byte("gamma")), qt.IsNil)
+	store := compose.Tiered(fast, slow)
+
+	qt.Check(t, has(t, store, "a"), qt.IsTrue)
+	qt.Check(t, has(t, fast, "a"), qt.IsFalse)
+
+	// A Get populates the cache.
+	got, err := store.Get(ctx, "a")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(got), qt.Equals, "alpha")
+	qt.Check(t, has(t, fast, "a"), qt.IsTrue)
+
+	// So does a stream, but only once it's been read to the end.
+	r, err := storage.GetStream(ctx, store, "b")
+	qt.Assert(t, err, qt.IsNil)
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(r, buf)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, r.Close(), qt.IsNil)
+	qt.Check(t, has(t, fast, "b"), qt.IsFalse)
+	r, err = storage.GetStream(ctx, store, "b")
+	qt.Assert(t, err, qt.IsNil)
+	got, err = io.ReadAll(r)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(got), qt.Equals, "beta")
+	qt.Check(t, r.Close(), qt.IsNil)
+	qt.Check(t, has(t, fast, "b"), qt.IsTrue)
+
+	// And a peek.
+	got, closer, err := store.(storage.PeekableStorage).Peek(ctx, "c")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(got), qt.Equals, "gamma")
+	closer.Close()
+	qt.Check(t, has(t, fast, "c"), qt.IsTrue)
+
+	// Reads are served from the cache when possible.
+	qt.Assert(t, storage.Delete(ctx, slow, "a"), qt.IsNil)
+	got, err = store.Get(ctx, "a")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(got), qt.Equals, "alpha")
+
+	// Writes go to both.
+	qt.Assert(t, store.(storage.WritableStorage).Put(ctx, "d", []byte("delta")), qt.IsNil)
+	qt.Check(t, has(t, slow, "d"), qt.IsTrue)
+	qt.Check(t, has(t, fast, "d"), qt.IsTrue)
+
+	_, err = store.Get(ctx, "absent")
+	qt.Check(t, err, qt.IsNotNil)
+}
+
+func TestTieredBrokenCache(t *testing.T) {
+	ctx := context.Background()
+	fast, slow := &flakyStore{ReadWriteStorage: basicStore(), fail: true}, &memstore.Store{}
+	store := compose.Tiered(fast, slow).(storage.WritableStorage)
+	qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+	got, err := storage.Get(ctx, store.(storage.ReadableStorage), "a")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(got), qt.Equals, "alpha")
+}
+
+func TestTieredStreamingWrite(t *testing.T) {
+	ctx := context.Background()
+	fast, slow := newFsstore(t), newFsstore(t)
+	store := compose.Tiered(fast, slow).(storage.WritableStorage)
+
+	wr, commit, err := storage.PutStream(ctx, store)
+	qt.Assert(t, err, qt.IsNil)
+	wr.Write([]byte("alpha"))
+	qt.Assert(t, commit("a"), qt.IsNil)
+	qt.Check(t, has(t, slow, "a"), qt.IsTrue)
+	qt.Check(t, has(t, fast, "a"), qt.IsTrue)
+
+	wr, commit, err = storage.PutStream(ctx, store)
+	qt.Assert(t, err, qt.IsNil)
+	wr.Write([]byte("beta"))
+	qt.Assert(t, commit(""), qt.IsNil)
+	qt.Check(t, has(t, slow, "b"), qt.IsFalse)
+}
+
+func TestMirror(t *testing.T) {
+	ctx := context.Background()
+	a, b, c := newFlakyStore(), newFlakyStore(), newFlakyStore()
+
+	store := compose.Mirror(2, a, b, c)
+	qt.Assert(t, store.Put(ctx, "k", []byte("value")), qt.IsNil)
+	qt.Check(t, has(t, a, "k"), qt.IsTrue)
+	qt.Check(t, has(t, b, "k"), qt.IsTrue)
+	qt.Check(t, has(t, c, "k"), qt.IsTrue)
+
+	// With one store broken, there's still a quorum, and reads skip past it.
+	a.fail = true
+	qt.Assert(t, store.Put(ctx, "k2", []byte("value2")), qt.IsNil)
+	qt.Check(t, has(t, store, "k2"), qt.IsTrue)
+	got, err := store.Get(ctx, "k2")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(got), qt.Equals, "value2")
+
+	// With two broken, there isn't.
+	b.fail = true
+	err = store.Put(ctx, "k3", []byte("value3"))
+	qt.Check(t, err, qt.ErrorMatches, "(?s)compose: write succeeded on 1 stores, but 2 were needed: .*")
+	qt.Check(t, errors.Is(err, errBroken), qt.IsTrue)
+
+	// The default quorum is everything.
+	b.fail = false
+	err = compose.Mirror(0, a, b, c).Put(ctx, "k4", []byte("value4"))
+	qt.Check(t, err, qt.IsNotNil)
+
+	// A read fails only when no store can serve it.
+	_, err = store.Get(ctx, "absent")
+	qt.Check(t, err, qt.IsNotNil)
+}
+
+func TestMirrorStreamingWrite(t *testing.T) {
+	ctx := context.Background()
+	a, b := newFsstore(t), newFsstore(t)
+	store := compose.Mirror(0, a, b)
+
+	wr, commit, err := storage.PutStream(ctx, store)
+	qt.Assert(t, err, qt.IsNil)
+	wr.Write([]byte("val"))
+	wr.Write([]byte("ue"))
+	qt.Assert(t, commit("k"), qt.IsNil)
+	for _, s := range []*fsstore.Store{a, b} {
+		got, err := s.Get(ctx, "k")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, string(got), qt.Equals, "value")
+	}
+
+	wr, commit, err = storage.PutStream(ctx, store)
+	qt.Assert(t, err, qt.IsNil)
+	wr.Write([]byte("nope"))
+	qt.Assert(t, commit(""), qt.IsNil)
+	qt.Check(t, has(t, a, "nope"), qt.IsFalse)
+	qt.Check(t, has(t, b, "nope"), qt.IsFalse)
+}
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+	mkKey := func(codec uint64, data string) string {
+		mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+		qt.Assert(t, err, qt.IsNil)
+		return string(cid.NewCidV1(codec, mh).Bytes())
+	}
+	rawKey, cborKey, jsonKey := mkKey(cid.Raw, "raw"), mkKey(cid.DagCBOR, "cbor"), mkKey(cid.DagJSON, "json")
+
+	raws, others := &memstore.Store{}, &memstore.Store{}
+	store := compose.Router(
+		compose.Route{Match: compose.MatchCodec(cid.Raw), Store: raws},
+		compose.Route{Match: compose.MatchCodec(cid.DagCBOR, cid.DagJSON), Store: others},
+	).(storage.WritableStorage)
+
+	qt.Assert(t, store.Put(ctx, rawKey, []byte("raw")), qt.IsNil)
+	qt.Assert(t, store.Put(ctx, cborKey, []byte("cbor")), qt.IsNil)
+	qt.Assert(t, storage.PutVec(ctx, store, jsonKey, [][]byte{[]byte("js"), []byte("on")}), qt.IsNil)
+	qt.Check(t, has(t, raws, rawKey), qt.IsTrue)
+	qt.Check(t, has(t, raws, cborKey), qt.IsFalse)
+	qt.Check(t, has(t, others, cborKey), qt.IsTrue)
+	qt.Check(t, has(t, others, jsonKey), qt.IsTrue)
+
+	got, err := storage.Get(ctx, store.(storage.ReadableStorage), jsonKey)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(got), qt.Equals, "json")
+
+	// Streaming writes are buffered, since the route isn't known until the end.
+	wr, commit, err := storage.PutStream(ctx, store)
+	qt.Assert(t, err, qt.IsNil)
+	streamedKey := mkKey(cid.Raw, "streamed")
+	wr.Write([]byte("streamed"))
+	qt.Assert(t, commit(streamedKey), qt.IsNil)
+	qt.Check(t, has(t, raws, streamedKey), qt.IsTrue)
+
+	// Keys no route accepts aren't there, and can't be written.
+	qt.Check(t, has(t, store, "not a cid"), qt.IsFalse)
+	qt.Check(t, store.Put(ctx, "not a cid", []byte("x")), qt.ErrorMatches, "compose: no route accepts key .*")
+
+	// A route without a Match takes everything.
+	rest := &memstore.Store{}
+	store = compose.Router(compose.Route{Match: compose.MatchCodec(cid.Raw), Store: raws}, compose.Route{Store: rest}).(storage.WritableStorage)
+	qt.Assert(t, store.Put(ctx, "not a cid", []byte("x")), qt.IsNil)
+	qt.Check(t, bytes.Equal(rest.Bag["not a cid"], []byte("x")), qt.IsTrue)
+}
+
+func TestConformance(t *testing.T) {
+	t.Run("tiered", func(t *testing.T) {
+		tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+			return compose.Tiered(&memstore.Store{}, newFsstore(t))
+		}, tests.ConformanceOptions{})
+	})
+	t.Run("mirror", func(t *testing.T) {
+		tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+			return compose.Mirror(2, &memstore.Store{}, newFsstore(t), basicStore())
+		}, tests.ConformanceOptions{})
+	})
+	t.Run("router", func(t *testing.T) {
+		tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+			return compose.Router(compose.Route{Match: compose.MatchCodec(cid.Raw), Store: &memstore.Store{}})
+		}, tests.ConformanceOptions{})
+	})
+}
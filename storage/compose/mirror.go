@@ -0,0 +1,202 @@
+package compose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ipld/go-ipld-prime/storage"
+)
+
+// Mirror returns a storage system that writes to all of the stores,
+// and reads from the first of them (in the order given) that can provide the data.
+//
+// Writes go to all the stores at once, and succeed if at least quorum of them succeed.
+// A quorum of zero (or more than the number of stores) means all of them must succeed.
+// Stores that failed a write aren't repaired later; reads simply skip past them.
+func Mirror(quorum int, stores ...ReadWriteStorage) ReadWriteStorage {
+	if quorum <= 0 || quorum > len(stores) {
+		quorum = len(stores)
+	}
+	return expose(&mirror{quorum, stores}, commonFeatures(stores...)|featWritable).(ReadWriteStorage)
+}
+
+type mirror struct {
+	quorum int
+	stores []ReadWriteStorage
+}
+
+func (m *mirror) Has(ctx context.Context, key string) (bool, error) {
+	var errs []error
+	for _, store := range m.stores {
+		has, err := store.Has(ctx, key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if has {
+			return true, nil
+		}
+	}
+	if len(errs) == len(m.stores) {
+		return false, errors.Join(errs...)
+	}
+	return false, nil
+}
+
+// firstOf tries fn on each store in turn, returning the first success, or all the errors if there's none.
+func firstOf[T any](m *mirror, fn func(ReadWriteStorage) (T, error)) (T, error) {
+	var errs []error
+	for _, store := range m.stores {
+		v, err := fn(store)
+		if err == nil {
+			return v, nil
+		}
+		errs = append(errs, err)
+	}
+	var zero T
+	return zero, errors.Join(errs...)
+}
+
+func (m *mirror) Get(ctx context.Context, key string) ([]byte, error) {
+	return firstOf(m, func(store ReadWriteStorage) ([]byte, error) {
+		return store.Get(ctx, key)
+	})
+}
+
+func (m *mirror) getStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	return firstOf(m, func(store ReadWriteStorage) (io.ReadCloser, error) {
+		return store.(storage.StreamingReadableStorage).GetStream(ctx, key)
+	})
+}
+
+func (m *mirror) peek(ctx context.Context, key string) ([]byte, io.Closer, error) {
+	type peeked struct {
+		content []byte
+		closer  io.Closer
+	}
+	p, err := firstOf(m, func(store ReadWriteStorage) (peeked, error) {
+		content, closer, err := store.(storage.PeekableStorage).Peek(ctx, key)
+		return peeked{content, closer}, err
+	})
+	return p.content, p.closer, err
+}
+
+// all runs fn against every store concurrently, and checks that enough of them succeeded.
+func (m *mirror) all(fn func(int, ReadWriteStorage) error) error {
+	errs := make([]error, len(m.stores))
+	var wg sync.WaitGroup
+	for i, store := range m.stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i, store)
+		}()
+	}
+	wg.Wait()
+	return m.check(errs)
+}
+
+func (m *mirror) check(errs []error) error {
+	var ok int
+	for _, err := range errs {
+		if err == nil {
+			ok++
+		}
+	}
+	if ok < m.quorum {
+		return fmt.Errorf("compose: write succeeded on %d stores, but %d were needed: %w", ok, m.quorum, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (m *mirror) put(ctx context.Context, key string, content []byte) error {
+	return m.all(func(_ int, store ReadWriteStorage) error {
+		return store.Put(ctx, key, content)
+	})
+}
+
+func (m *mirror) putVec(ctx context.Context, key string, blobVec [][]byte) error {
+	return m.all(func(_ int, store ReadWriteStorage) error {
+		return store.(storage.VectorWritableStorage).PutVec(ctx, key, blobVec)
+	})
+}
+
+func (m *mirror) putStream(ctx context.Context) (io.Writer, func(key string) error, error) {
+	mw := &mirrorWriter{
+		writers: make([]io.Writer, len(m.stores)),
+		commits: make([]func(string) error, len(m.stores)),
+		errs:    make([]error, len(m.stores)),
+	}
+	for i, store := range m.stores {
+		mw.writers[i], mw.commits[i], mw.errs[i] = store.(storage.StreamingWritableStorage).PutStream(ctx)
+	}
+	if err := m.check(mw.errs); err != nil {
+		mw.abort()
+		return nil, nil, err
+	}
+	return mw, func(key string) error {
+		if key == "" {
+			return mw.abort()
+		}
+		if err := m.check(mw.errs); err != nil {
+			mw.abort()
+			return err
+		}
+		var wg sync.WaitGroup
+		for i, commit := range mw.commits {
+			if mw.errs[i] != nil {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mw.errs[i] = commit(key)
+			}()
+		}
+		wg.Wait()
+		return m.check(mw.errs)
+	}, nil
+}
+
+// mirrorWriter writes to several streams, dropping any that fail.
+// It only returns an error once every stream has failed;
+// the WriteCommitter is what decides whether enough of them are left.
+type mirrorWriter struct {
+	writers []io.Writer
+	commits []func(string) error
+	errs    []error
+}
+
+func (mw *mirrorWriter) Write(p []byte) (int, error) {
+	for i, w := range mw.writers {
+		if mw.errs[i] != nil {
+			continue
+		}
+		if _, err := w.Write(p); err != nil {
+			mw.errs[i] = err
+			mw.commits[i]("")
+		}
+	}
+	for _, err := range mw.errs {
+		if err == nil {
+			return len(p), nil
+		}
+	}
+	return 0, errors.Join(mw.errs...)
+}
+
+func (mw *mirrorWriter) abort() error {
+	var errs []error
+	for i, commit := range mw.commits {
+		if mw.errs[i] == nil {
+			errs = append(errs, commit(""))
+			mw.errs[i] = errAborted
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var errAborted = errors.New("compose: write aborted")
@@ -0,0 +1,119 @@
+package compose
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/ipld/go-ipld-prime/storage"
+)
+
+// Route is one of the choices for where a Router sends keys.
+type Route struct {
+	// Match reports whether a key belongs in Store.
+	// If nil, every key does.
+	Match func(key string) bool
+
+	// Store is where keys that Match are read from, and written to.
+	Store storage.ReadableStorage
+}
+
+// Router returns a storage system that sends each key to the Store of the first Route whose Match accepts it.
+// Keys that no route accepts are reported as absent when read, and are refused when written.
+//
+// The result is writable if all of the routes' stores are.
+// It never implements storage.StreamingWritableStorage, even if all of the stores do,
+// because the key isn't known until the end of a streaming write,
+// which is too late to choose which store to stream into.
+// (storage.PutStream still works on it, by buffering.)
+func Router(routes ...Route) storage.ReadableStorage {
+	stores := make([]storage.Storage, len(routes))
+	for i, route := range routes {
+		stores[i] = route.Store
+	}
+	return expose(&router{routes}, commonFeatures(stores...)&^featStreamingWrite)
+}
+
+// MatchCodec returns a Route.Match function that accepts keys which are binary CIDs with one of the given codecs
+// (as used by LinkSystem.SetReadStorage and SetWriteStorage).
+func MatchCodec(codecs ...uint64) func(key string) bool {
+	return func(key string) bool {
+		c, err := cid.Cast([]byte(key))
+		if err != nil {
+			return false
+		}
+		for _, codec := range codecs {
+			if c.Type() == codec {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type router struct {
+	routes []Route
+}
+
+func (r *router) route(key string) storage.ReadableStorage {
+	for _, route := range r.routes {
+		if route.Match == nil || route.Match(key) {
+			return route.Store
+		}
+	}
+	return nil
+}
+
+func (r *router) Has(ctx context.Context, key string) (bool, error) {
+	store := r.route(key)
+	if store == nil {
+		return false, nil
+	}
+	return store.Has(ctx, key)
+}
+
+func (r *router) Get(ctx context.Context, key string) ([]byte, error) {
+	store := r.route(key)
+	if store == nil {
+		return nil, fmt.Errorf("404") // FIXME this needs a standard error type
+	}
+	return store.Get(ctx, key)
+}
+
+func (r *router) getStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	store := r.route(key)
+	if store == nil {
+		return nil, fmt.Errorf("404") // FIXME this needs a standard error type
+	}
+	return store.(storage.StreamingReadableStorage).GetStream(ctx, key)
+}
+
+func (r *router) peek(ctx context.Context, key string) ([]byte, io.Closer, error) {
+	store := r.route(key)
+	if store == nil {
+		return nil, nil, fmt.Errorf("404") // FIXME this needs a standard error type
+	}
+	return store.(storage.PeekableStorage).Peek(ctx, key)
+}
+
+func (r *router) put(ctx context.Context, key string, content []byte) error {
+	store := r.route(key)
+	if store == nil {
+		return fmt.Errorf("compose: no route accepts key %q", key)
+	}
+	return store.(storage.WritableStorage).Put(ctx, key, content)
+}
+
+func (r *router) putStream(ctx context.Context) (io.Writer, func(key string) error, error) {
+	panic("unreachable: a router never exposes PutStream")
+}
+
+func (r *router) putVec(ctx context.Context, key string, blobVec [][]byte) error {
+	store := r.route(key)
+	if store == nil {
+		return fmt.Errorf("compose: no route accepts key %q", key)
+	}
+	return store.(storage.VectorWritableStorage).PutVec(ctx, key, blobVec)
+}
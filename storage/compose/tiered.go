@@ -0,0 +1,178 @@
+package compose
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipld/go-ipld-prime/storage"
+)
+
+// Tiered returns a storage system that reads from fast when it has the data, and otherwise from slow,
+// copying anything read from slow into fast, so that it's found there next time.
+//
+// The result is writable if slow is.
+// Writes go to slow first, then (only if that succeeded) to fast.
+//
+// Fast is treated purely as a cache:
+// errors from it are never returned, and are not fatal to an operation.
+// If fast fails to read something it claims to have, slow is asked instead;
+// if copying into fast fails, the data is still returned.
+//
+// A stream from GetStream only copies into fast if it's read to the end;
+// closing it earlier abandons the copy.
+func Tiered(fast ReadWriteStorage, slow storage.ReadableStorage) storage.ReadableStorage {
+	return expose(&tiered{fast, slow}, commonFeatures[storage.Storage](fast, slow))
+}
+
+type tiered struct {
+	fast ReadWriteStorage
+	slow storage.ReadableStorage
+}
+
+func (t *tiered) inFast(ctx context.Context, key string) bool {
+	has, err := t.fast.Has(ctx, key)
+	return err == nil && has
+}
+
+func (t *tiered) Has(ctx context.Context, key string) (bool, error) {
+	if t.inFast(ctx, key) {
+		return true, nil
+	}
+	return t.slow.Has(ctx, key)
+}
+
+func (t *tiered) Get(ctx context.Context, key string) ([]byte, error) {
+	if t.inFast(ctx, key) {
+		if content, err := t.fast.Get(ctx, key); err == nil {
+			return content, nil
+		}
+	}
+	content, err := t.slow.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	t.fast.Put(ctx, key, content)
+	return content, nil
+}
+
+func (t *tiered) getStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	if t.inFast(ctx, key) {
+		if r, err := t.fast.(storage.StreamingReadableStorage).GetStream(ctx, key); err == nil {
+			return r, nil
+		}
+	}
+	r, err := t.slow.(storage.StreamingReadableStorage).GetStream(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	wr, commit, err := storage.PutStream(ctx, t.fast)
+	if err != nil {
+		return r, nil
+	}
+	return &teeReadCloser{ReadCloser: r, wr: wr, commit: commit, key: key}, nil
+}
+
+// teeReadCloser copies everything read into a PutStream, committing it on EOF, or aborting it on error or Close.
+type teeReadCloser struct {
+	io.ReadCloser
+	wr     io.Writer
+	commit func(string) error
+	key    string
+}
+
+func (tr *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := tr.ReadCloser.Read(p)
+	if tr.commit != nil {
+		if n > 0 {
+			if _, werr := tr.wr.Write(p[:n]); werr != nil {
+				tr.finish("")
+			}
+		}
+		switch {
+		case err == io.EOF:
+			tr.finish(tr.key)
+		case err != nil:
+			tr.finish("")
+		}
+	}
+	return n, err
+}
+
+func (tr *teeReadCloser) finish(key string) {
+	if tr.commit != nil {
+		tr.commit(key)
+		tr.commit = nil
+	}
+}
+
+func (tr *teeReadCloser) Close() error {
+	tr.finish("")
+	return tr.ReadCloser.Close()
+}
+
+func (t *tiered) peek(ctx context.Context, key string) ([]byte, io.Closer, error) {
+	if t.inFast(ctx, key) {
+		if content, closer, err := t.fast.(storage.PeekableStorage).Peek(ctx, key); err == nil {
+			return content, closer, nil
+		}
+	}
+	content, closer, err := t.slow.(storage.PeekableStorage).Peek(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	t.fast.Put(ctx, key, content)
+	return content, closer, nil
+}
+
+func (t *tiered) put(ctx context.Context, key string, content []byte) error {
+	if err := t.slow.(storage.WritableStorage).Put(ctx, key, content); err != nil {
+		return err
+	}
+	t.fast.Put(ctx, key, content)
+	return nil
+}
+
+func (t *tiered) putStream(ctx context.Context) (io.Writer, func(key string) error, error) {
+	slowWr, slowCommit, err := t.slow.(storage.StreamingWritableStorage).PutStream(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	fastWr, fastCommit, err := t.fast.(storage.StreamingWritableStorage).PutStream(ctx)
+	if err != nil {
+		// Carry on without the cache.
+		return slowWr, slowCommit, nil
+	}
+	tw := &tierWriter{slow: slowWr, fast: fastWr}
+	return tw, func(key string) error {
+		if err := slowCommit(key); err != nil || tw.fast == nil {
+			fastCommit("")
+			return err
+		}
+		fastCommit(key)
+		return nil
+	}, nil
+}
+
+// tierWriter writes to both tiers, giving up on fast (but not the write as a whole) if writing to it fails.
+type tierWriter struct {
+	slow io.Writer
+	fast io.Writer // nil once it's failed.
+}
+
+func (tw *tierWriter) Write(p []byte) (int, error) {
+	n, err := tw.slow.Write(p)
+	if tw.fast != nil && n > 0 {
+		if _, ferr := tw.fast.Write(p[:n]); ferr != nil {
+			tw.fast = nil
+		}
+	}
+	return n, err
+}
+
+func (t *tiered) putVec(ctx context.Context, key string, blobVec [][]byte) error {
+	if err := t.slow.(storage.VectorWritableStorage).PutVec(ctx, key, blobVec); err != nil {
+		return err
+	}
+	t.fast.(storage.VectorWritableStorage).PutVec(ctx, key, blobVec)
+	return nil
+}
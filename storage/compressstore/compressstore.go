@@ -0,0 +1,347 @@
+/*
+The compressstore package offers a storage wrapper which compresses content on its way into storage,
+and decompresses it on its way out.
+
+Every value it stores starts with a two-byte header:
+a marker byte (0xCF), followed by the ID of the Compressor that was used (or 0, for content stored as-is).
+This lets a single store hold a mix of algorithms (for example, after changing the configured Compressor),
+as well as uncompressed content (small values aren't worth compressing, and some content doesn't compress).
+Values without the header -- i.e., anything not written through this package -- can't be read through it.
+*/
+package compressstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipld/go-ipld-prime/storage"
+)
+
+const headerMarker = 0xCF
+
+// IDRaw is the header ID for content that's stored as-is.
+const IDRaw = 0
+
+// DefaultThreshold is the size, in bytes, below which content is stored uncompressed, if Store.Threshold isn't set.
+const DefaultThreshold = 256
+
+// Compressor is a compression algorithm.
+type Compressor interface {
+	// ID identifies the algorithm in the headers of stored values.
+	// It must be unique among the compressors used with a store, and must not be IDRaw.
+	ID() byte
+
+	// Compress returns a writer which writes the compressed form of what's written to it into w.
+	// Closing it must flush everything, but must not close w.
+	Compress(w io.Writer) (io.WriteCloser, error)
+
+	// Decompress returns a reader of the decompressed form of r.
+	Decompress(r io.Reader) (io.ReadCloser, error)
+}
+
+// Gzip is a Compressor using gzip, with header ID 1.
+// Level is a compression level as used by compress/gzip; zero means gzip.DefaultCompression.
+type Gzip struct{ Level int }
+
+func (Gzip) ID() byte { return 1 }
+
+func (c Gzip) Compress(w io.Writer) (io.WriteCloser, error) {
+	if c.Level == 0 {
+		return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+	}
+	return gzip.NewWriterLevel(w, c.Level)
+}
+
+func (Gzip) Decompress(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// Flate is a Compressor using raw DEFLATE, with header ID 2.
+// It's slightly more compact than Gzip, which adds its own header and checksum.
+// Level is a compression level as used by compress/flate; zero means flate.DefaultCompression.
+type Flate struct{ Level int }
+
+func (Flate) ID() byte { return 2 }
+
+func (c Flate) Compress(w io.Writer) (io.WriteCloser, error) {
+	if c.Level == 0 {
+		return flate.NewWriter(w, flate.DefaultCompression)
+	}
+	return flate.NewWriter(w, c.Level)
+}
+
+func (Flate) Decompress(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+// Store implements go-ipld-prime/storage.ReadableStorage and go-ipld-prime/storage.WritableStorage,
+// as well as go-ipld-prime/storage.StreamingReadableStorage and go-ipld-prime/storage.StreamingWritableStorage,
+// by compressing content and storing it in another storage system.
+// Writing only works if the wrapped storage is writable.
+//
+// Keys are passed through unchanged.
+type Store struct {
+	Wrapped storage.ReadableStorage
+
+	// Compressor is used to compress new content.
+	// Optional: defaults to Gzip{}.
+	Compressor Compressor
+
+	// Others lists any further compressors that may have been used for content already in storage,
+	// so that it can be read.
+	// (Compressor, Gzip, and Flate are always recognized, and needn't be listed.)
+	Others []Compressor
+
+	// Threshold is the size, in bytes, below which content is stored uncompressed.
+	// Zero means DefaultThreshold; a negative number means everything is compressed.
+	// Content is also stored uncompressed when compressing it doesn't make it smaller.
+	Threshold int
+}
+
+func (store *Store) compressor() Compressor {
+	if store.Compressor == nil {
+		return Gzip{}
+	}
+	return store.Compressor
+}
+
+func (store *Store) threshold() int {
+	if store.Threshold == 0 {
+		return DefaultThreshold
+	}
+	return store.Threshold
+}
+
+func (store *Store) writable() (storage.WritableStorage, error) {
+	ws, ok := store.Wrapped.(storage.WritableStorage)
+	if !ok {
+		return nil, fmt.Errorf("compressstore: wrapped storage %T is not writable: %w", store.Wrapped, errors.ErrUnsupported)
+	}
+	return ws, nil
+}
+
+// decompressorFor finds the compressor for a header ID.
+func (store *Store) decompressorFor(id byte) (Compressor, error) {
+	if c := store.compressor(); c.ID() == id {
+		return c, nil
+	}
+	for _, c := range store.Others {
+		if c.ID() == id {
+			return c, nil
+		}
+	}
+	switch id {
+	case Gzip{}.ID():
+		return Gzip{}, nil
+	case Flate{}.ID():
+		return Flate{}, nil
+	}
+	return nil, fmt.Errorf("compressstore: content was compressed with unknown algorithm %d", id)
+}
+
+// Has implements go-ipld-prime/storage.Storage.Has.
+func (store *Store) Has(ctx context.Context, key string) (bool, error) {
+	return store.Wrapped.Has(ctx, key)
+}
+
+// Get implements go-ipld-prime/storage.ReadableStorage.Get.
+func (store *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	stored, err := store.Wrapped.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) < 2 || stored[0] != headerMarker {
+		return nil, errNoHeader
+	}
+	if stored[1] == IDRaw {
+		return stored[2:], nil
+	}
+	c, err := store.decompressorFor(stored[1])
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.Decompress(bytes.NewReader(stored[2:]))
+	if err != nil {
+		return nil, fmt.Errorf("compressstore: could not decompress: %w", err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("compressstore: could not decompress: %w", err)
+	}
+	return content, nil
+}
+
+// GetStream implements go-ipld-prime/storage.StreamingReadableStorage.GetStream.
+// If the wrapped storage doesn't support GetStream, Get is used, and the content is decompressed as it's read.
+func (store *Store) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	stored, err := storage.GetStream(ctx, store.Wrapped, key)
+	if err != nil {
+		return nil, err
+	}
+	var hdr [2]byte
+	if _, err := io.ReadFull(stored, hdr[:]); err != nil || hdr[0] != headerMarker {
+		stored.Close()
+		return nil, errNoHeader
+	}
+	if hdr[1] == IDRaw {
+		return stored, nil
+	}
+	c, err := store.decompressorFor(hdr[1])
+	if err != nil {
+		stored.Close()
+		return nil, err
+	}
+	r, err := c.Decompress(stored)
+	if err != nil {
+		stored.Close()
+		return nil, fmt.Errorf("compressstore: could not decompress: %w", err)
+	}
+	return &decompressingReader{r, stored}, nil
+}
+
+var errNoHeader = fmt.Errorf("compressstore: content has no compression header")
+
+type decompressingReader struct {
+	io.ReadCloser
+	stored io.Closer
+}
+
+func (dr *decompressingReader) Close() error {
+	return errors.Join(dr.ReadCloser.Close(), dr.stored.Close())
+}
+
+// Put implements go-ipld-prime/storage.WritableStorage.Put.
+func (store *Store) Put(ctx context.Context, key string, content []byte) error {
+	ws, err := store.writable()
+	if err != nil {
+		return err
+	}
+	if len(content) < store.threshold() {
+		return storage.PutVec(ctx, ws, key, [][]byte{{headerMarker, IDRaw}, content})
+	}
+	c := store.compressor()
+	var buf bytes.Buffer
+	buf.Write([]byte{headerMarker, c.ID()})
+	w, err := c.Compress(&buf)
+	if err != nil {
+		return fmt.Errorf("compressstore: could not compress: %w", err)
+	}
+	if _, err := w.Write(content); err != nil {
+		w.Close()
+		return fmt.Errorf("compressstore: could not compress: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("compressstore: could not compress: %w", err)
+	}
+	if buf.Len() >= len(content)+2 {
+		return storage.PutVec(ctx, ws, key, [][]byte{{headerMarker, IDRaw}, content})
+	}
+	return ws.Put(ctx, key, buf.Bytes())
+}
+
+// PutStream implements go-ipld-prime/storage.StreamingWritableStorage.PutStream.
+// If the wrapped storage doesn't support PutStream, the compressed content is buffered, and Put is used.
+//
+// Content is held in memory until it reaches the Threshold size,
+// so that it can be stored uncompressed if it turns out to be smaller than that.
+// (Unlike with Put, content that's streamed and over the threshold is always stored compressed,
+// even if that makes it bigger.)
+func (store *Store) PutStream(ctx context.Context) (io.Writer, func(key string) error, error) {
+	ws, err := store.writable()
+	if err != nil {
+		return nil, nil, err
+	}
+	cw := &compressingWriter{ctx: ctx, store: store, ws: ws}
+	return cw, cw.commitFn, nil
+}
+
+// compressingWriter buffers content until it's reached the threshold, and then starts streaming it through the compressor.
+type compressingWriter struct {
+	ctx   context.Context
+	store *Store
+	ws    storage.WritableStorage
+
+	buf bytes.Buffer // content, until the threshold is reached.
+
+	// Once the threshold is reached, these are set.
+	w      io.WriteCloser // compressing writer.
+	commit func(string) error
+	done   bool
+	err    error
+}
+
+func (cw *compressingWriter) Write(p []byte) (int, error) {
+	if cw.done {
+		return 0, fmt.Errorf("compressstore: write after commit")
+	}
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	if cw.w == nil {
+		cw.buf.Write(p)
+		if cw.buf.Len() < cw.store.threshold() {
+			return len(p), nil
+		}
+		if cw.err = cw.start(); cw.err != nil {
+			return 0, cw.err
+		}
+		return len(p), nil
+	}
+	if _, err := cw.w.Write(p); err != nil {
+		cw.err = err
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// start begins the compressed stream, and flushes the buffered content into it.
+func (cw *compressingWriter) start() error {
+	stored, commit, err := storage.PutStream(cw.ctx, cw.ws)
+	if err != nil {
+		return err
+	}
+	cw.commit = commit
+	c := cw.store.compressor()
+	if _, err := stored.Write([]byte{headerMarker, c.ID()}); err != nil {
+		return err
+	}
+	if cw.w, err = c.Compress(stored); err != nil {
+		return fmt.Errorf("compressstore: could not compress: %w", err)
+	}
+	_, err = cw.w.Write(cw.buf.Bytes())
+	cw.buf = bytes.Buffer{}
+	return err
+}
+
+func (cw *compressingWriter) commitFn(key string) error {
+	if cw.done {
+		return fmt.Errorf("WriteCommitter already used")
+	}
+	cw.done = true
+	if cw.err != nil {
+		if cw.commit != nil {
+			cw.commit("")
+		}
+		if key == "" {
+			return nil
+		}
+		return cw.err
+	}
+	if cw.w == nil {
+		if key == "" {
+			return nil
+		}
+		return storage.PutVec(cw.ctx, cw.ws, key, [][]byte{{headerMarker, IDRaw}, cw.buf.Bytes()})
+	}
+	if err := cw.w.Close(); err != nil && key != "" {
+		cw.commit("")
+		return fmt.Errorf("compressstore: could not compress: %w", err)
+	}
+	return cw.commit(key)
+}
@@ -0,0 +1,196 @@
+package compressstore_test
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/compressstore"
+	"github.com/ipld/go-ipld-prime/storage/fsstore"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/storage/tests"
+)
+
+// compressible is some dag-json-ish content.
+var compressible = []byte(strings.Repeat(`{"link":{"/":"bafyreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy"}},`, 20))
+
+// custom stands in for a third-party Compressor; it's just flate under a different ID.
+type custom struct{ compressstore.Flate }
+
+func (custom) ID() byte { return 100 }
+
+// broken is a Compressor whose writers always fail.
+type broken struct{ compressstore.Flate }
+
+func (broken) ID() byte { return 101 }
+
+func (broken) Compress(w io.Writer) (io.WriteCloser, error) { return brokenWriter{}, nil }
+
+type brokenWriter struct{}
+
+func (brokenWriter) Write([]byte) (int, error) { return 0, errors.New("broken") }
+func (brokenWriter) Close() error              { return nil }
+
+func stored(t *testing.T, backing *memstore.Store, key string) []byte {
+	bs, err := backing.Get(context.Background(), key)
+	qt.Assert(t, err, qt.IsNil)
+	return bs
+}
+
+func get(t *testing.T, store *compressstore.Store, key string) []byte {
+	t.Helper()
+	bs, err := store.Get(context.Background(), key)
+	qt.Assert(t, err, qt.IsNil)
+	r, err := store.GetStream(context.Background(), key)
+	qt.Assert(t, err, qt.IsNil)
+	defer r.Close()
+	streamed, err := io.ReadAll(r)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, streamed, qt.DeepEquals, bs)
+	return bs
+}
+
+func TestPut(t *testing.T) {
+	ctx := context.Background()
+	random := make([]byte, 1000)
+	rand.Read(random)
+
+	for _, c := range []compressstore.Compressor{nil, compressstore.Gzip{}, compressstore.Flate{Level: 9}} {
+		backing := &memstore.Store{}
+		store := &compressstore.Store{Wrapped: backing, Compressor: c}
+		if c == nil {
+			c = compressstore.Gzip{}
+		}
+
+		qt.Assert(t, store.Put(ctx, "big", compressible), qt.IsNil)
+		qt.Check(t, get(t, store, "big"), qt.DeepEquals, compressible)
+		qt.Check(t, stored(t, backing, "big")[:2], qt.DeepEquals, []byte{0xCF, c.ID()})
+		qt.Check(t, len(stored(t, backing, "big")) < len(compressible)/4, qt.IsTrue)
+
+		// Small content, and content that doesn't compress, is stored as-is.
+		qt.Assert(t, store.Put(ctx, "small", []byte("tiny")), qt.IsNil)
+		qt.Check(t, string(get(t, store, "small")), qt.Equals, "tiny")
+		qt.Check(t, stored(t, backing, "small"), qt.DeepEquals, []byte("\xCF\x00tiny"))
+		qt.Assert(t, store.Put(ctx, "random", random), qt.IsNil)
+		qt.Check(t, get(t, store, "random"), qt.DeepEquals, random)
+		qt.Check(t, stored(t, backing, "random")[:2], qt.DeepEquals, []byte{0xCF, 0})
+
+		qt.Assert(t, store.Put(ctx, "empty", nil), qt.IsNil)
+		qt.Check(t, get(t, store, "empty"), qt.HasLen, 0)
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	ctx := context.Background()
+	backing := &memstore.Store{}
+	content := compressible[:200]
+
+	store := &compressstore.Store{Wrapped: backing}
+	qt.Assert(t, store.Put(ctx, "default", content), qt.IsNil)
+	qt.Check(t, stored(t, backing, "default")[1], qt.Equals, byte(compressstore.IDRaw))
+
+	store = &compressstore.Store{Wrapped: backing, Threshold: -1}
+	qt.Assert(t, store.Put(ctx, "always", content), qt.IsNil)
+	qt.Check(t, stored(t, backing, "always")[1], qt.Equals, compressstore.Gzip{}.ID())
+	qt.Check(t, get(t, store, "always"), qt.DeepEquals, content)
+}
+
+func TestCompressError(t *testing.T) {
+	backing := &memstore.Store{}
+	store := &compressstore.Store{Wrapped: backing, Compressor: broken{}}
+	err := store.Put(context.Background(), "key", compressible)
+	qt.Check(t, err, qt.ErrorMatches, "compressstore: could not compress: broken")
+	qt.Check(t, backing.Bag, qt.HasLen, 0)
+}
+
+func TestMixed(t *testing.T) {
+	ctx := context.Background()
+	backing := &memstore.Store{}
+	gz := &compressstore.Store{Wrapped: backing}
+	qt.Assert(t, gz.Put(ctx, "gzip", compressible), qt.IsNil)
+	fl := &compressstore.Store{Wrapped: backing, Compressor: compressstore.Flate{}}
+	qt.Assert(t, fl.Put(ctx, "flate", compressible), qt.IsNil)
+	cus := &compressstore.Store{Wrapped: backing, Compressor: custom{}}
+	qt.Assert(t, cus.Put(ctx, "custom", []byte("0123456789")), qt.IsNil) // under the threshold, so stored raw.
+
+	// Any store can read gzip and flate; custom compressors need to be known.
+	for _, store := range []*compressstore.Store{gz, fl, cus} {
+		qt.Check(t, get(t, store, "gzip"), qt.DeepEquals, compressible)
+		qt.Check(t, get(t, store, "flate"), qt.DeepEquals, compressible)
+		qt.Check(t, string(get(t, store, "custom")), qt.Equals, "0123456789")
+	}
+	cus.Threshold = -1
+	qt.Assert(t, cus.Put(ctx, "customized", compressible), qt.IsNil)
+	qt.Check(t, stored(t, backing, "customized")[1], qt.Equals, byte(100))
+	_, err := gz.Get(ctx, "customized")
+	qt.Check(t, err, qt.ErrorMatches, "compressstore: content was compressed with unknown algorithm 100")
+	gz.Others = []compressstore.Compressor{custom{}}
+	qt.Check(t, get(t, gz, "customized"), qt.DeepEquals, compressible)
+
+	// Content not written through the wrapper can't be read through it.
+	qt.Assert(t, backing.Put(ctx, "plain", []byte("plain")), qt.IsNil)
+	_, err = gz.Get(ctx, "plain")
+	qt.Check(t, err, qt.ErrorMatches, "compressstore: content has no compression header")
+	_, err = gz.GetStream(ctx, "plain")
+	qt.Check(t, err, qt.ErrorMatches, "compressstore: content has no compression header")
+}
+
+func TestPutStream(t *testing.T) {
+	ctx := context.Background()
+	backings := map[string]func(t *testing.T) storage.ReadableStorage{
+		"memstore": func(t *testing.T) storage.ReadableStorage { return &memstore.Store{} },
+		"fsstore": func(t *testing.T) storage.ReadableStorage {
+			fs := &fsstore.Store{}
+			qt.Assert(t, fs.InitDefaults(t.TempDir()), qt.IsNil)
+			return fs
+		},
+	}
+	for name, mkBacking := range backings {
+		t.Run(name, func(t *testing.T) {
+			backing := mkBacking(t)
+			store := &compressstore.Store{Wrapped: backing}
+			put := func(key string, chunks ...[]byte) {
+				wr, commit, err := store.PutStream(ctx)
+				qt.Assert(t, err, qt.IsNil)
+				for _, chunk := range chunks {
+					_, err := wr.Write(chunk)
+					qt.Assert(t, err, qt.IsNil)
+				}
+				qt.Assert(t, commit(key), qt.IsNil)
+			}
+
+			put("big", compressible[:100], compressible[100:200], compressible[200:])
+			qt.Check(t, get(t, store, "big"), qt.DeepEquals, compressible)
+			raw, err := backing.Get(ctx, "big")
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, raw[1], qt.Equals, compressstore.Gzip{}.ID())
+
+			put("small", []byte("ti"), []byte("ny"))
+			qt.Check(t, string(get(t, store, "small")), qt.Equals, "tiny")
+			raw, err = backing.Get(ctx, "small")
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, raw[1], qt.Equals, byte(compressstore.IDRaw))
+
+			put("", compressible)
+			put("", []byte("small"))
+			keys := 0
+			for _, err := range storage.List(ctx, backing, "") {
+				qt.Assert(t, err, qt.IsNil)
+				keys++
+			}
+			qt.Check(t, keys, qt.Equals, 2)
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+		return &compressstore.Store{Wrapped: &memstore.Store{}, Threshold: -1}
+	}, tests.ConformanceOptions{})
+}
@@ -0,0 +1,203 @@
+/*
+The cryptstore package offers a storage wrapper which encrypts content at rest, using AES-GCM.
+
+Only content is encrypted: keys (typically CIDs) are passed through in the clear,
+so Has, listing, and deletion all keep working on the underlying store as usual.
+(Bear in mind that this means anyone with access to the underlying store
+can see which blocks are present, and can check guesses about their content by hashing.)
+
+Each stored value has a header naming the encryption key that was used,
+so keys can be rotated: new content is encrypted with the KeyProvider's current key,
+and older content stays readable for as long as the provider can still find the key it was written with.
+
+Every value is encrypted with a fresh random nonce,
+and is bound to its storage key (which is used as AES-GCM's "additional data"),
+so that copying a value to a different key in the underlying store makes it unreadable, rather than silently wrong.
+*/
+package cryptstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipld/go-ipld-prime/storage"
+)
+
+const headerMarker = 0xCE
+
+// KeyProvider supplies the encryption keys used by a Store.
+//
+// Keys must be 16, 24, or 32 bytes long, to select AES-128, AES-192, or AES-256.
+// Key IDs may be at most 255 bytes long, and are stored in the clear.
+type KeyProvider interface {
+	// CurrentKey returns the key that new content should be encrypted with, and its ID.
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+
+	// Key returns the key with the given ID, so that content encrypted with it can be decrypted.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider that serves keys from a map.
+type StaticKeys struct {
+	Current string            // the ID of the key used for new content.
+	Keys    map[string][]byte // all keys, by ID.
+}
+
+func (sk StaticKeys) CurrentKey(ctx context.Context) (string, []byte, error) {
+	key, err := sk.Key(ctx, sk.Current)
+	return sk.Current, key, err
+}
+
+func (sk StaticKeys) Key(ctx context.Context, id string) ([]byte, error) {
+	key, ok := sk.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("cryptstore: no key with ID %q", id)
+	}
+	return key, nil
+}
+
+// Store implements go-ipld-prime/storage.ReadableStorage and go-ipld-prime/storage.WritableStorage,
+// as well as go-ipld-prime/storage.StreamingReadableStorage, go-ipld-prime/storage.StreamingWritableStorage,
+// and go-ipld-prime/storage.VectorWritableStorage,
+// by encrypting content and storing it in another storage system.
+// Writing only works if the wrapped storage is writable.
+//
+// AES-GCM authenticates whole messages, so nothing can be returned before all of it has been checked:
+// the streaming methods are provided for convenience,
+// but buffer the whole of each value in memory, as Get and Put do.
+type Store struct {
+	Wrapped storage.ReadableStorage
+	Keys    KeyProvider
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cryptstore: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Has implements go-ipld-prime/storage.Storage.Has.
+func (store *Store) Has(ctx context.Context, key string) (bool, error) {
+	return store.Wrapped.Has(ctx, key)
+}
+
+// Get implements go-ipld-prime/storage.ReadableStorage.Get.
+func (store *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	sealed, err := store.Wrapped.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return store.open(ctx, key, sealed)
+}
+
+// open checks and decrypts a stored value.
+func (store *Store) open(ctx context.Context, key string, sealed []byte) ([]byte, error) {
+	if len(sealed) < 2 || sealed[0] != headerMarker || len(sealed) < 2+int(sealed[1]) {
+		return nil, fmt.Errorf("cryptstore: content has no encryption header")
+	}
+	idLen := int(sealed[1])
+	id := string(sealed[2 : 2+idLen])
+	sealed = sealed[2+idLen:]
+	k, err := store.Keys.Key(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(k)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("cryptstore: content is truncated")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	content, err := aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("cryptstore: could not decrypt content with key %q: %w", id, err)
+	}
+	return content, nil
+}
+
+// GetStream implements go-ipld-prime/storage.StreamingReadableStorage.GetStream.
+func (store *Store) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	content, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// Put implements go-ipld-prime/storage.WritableStorage.Put.
+func (store *Store) Put(ctx context.Context, key string, content []byte) error {
+	return store.PutVec(ctx, key, [][]byte{content})
+}
+
+// PutVec implements go-ipld-prime/storage.VectorWritableStorage.PutVec.
+func (store *Store) PutVec(ctx context.Context, key string, blobVec [][]byte) error {
+	ws, ok := store.Wrapped.(storage.WritableStorage)
+	if !ok {
+		return fmt.Errorf("cryptstore: wrapped storage %T is not writable: %w", store.Wrapped, errors.ErrUnsupported)
+	}
+	// Writes are first-write-wins, so don't bother encrypting anything that won't be written.
+	if has, err := ws.Has(ctx, key); err == nil && has {
+		return nil
+	}
+	id, k, err := store.Keys.CurrentKey(ctx)
+	if err != nil {
+		return err
+	}
+	if len(id) > 255 {
+		return fmt.Errorf("cryptstore: key ID %q is too long", id)
+	}
+	aead, err := newAEAD(k)
+	if err != nil {
+		return err
+	}
+
+	// Lay out the header, then the content, and encrypt the content in place.
+	var size int
+	for _, blob := range blobVec {
+		size += len(blob)
+	}
+	hdrLen := 2 + len(id) + aead.NonceSize()
+	buf := make([]byte, hdrLen, hdrLen+size+aead.Overhead())
+	buf[0] = headerMarker
+	buf[1] = byte(len(id))
+	copy(buf[2:], id)
+	nonce := buf[2+len(id) : hdrLen]
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("cryptstore: could not generate nonce: %w", err)
+	}
+	for _, blob := range blobVec {
+		buf = append(buf, blob...)
+	}
+	buf = aead.Seal(buf[:hdrLen], nonce, buf[hdrLen:], []byte(key))
+	return ws.Put(ctx, key, buf)
+}
+
+// PutStream implements go-ipld-prime/storage.StreamingWritableStorage.PutStream.
+// The content is buffered, and encrypted when the WriteCommitter is called.
+func (store *Store) PutStream(ctx context.Context) (io.Writer, func(key string) error, error) {
+	if _, ok := store.Wrapped.(storage.WritableStorage); !ok {
+		return nil, nil, fmt.Errorf("cryptstore: wrapped storage %T is not writable: %w", store.Wrapped, errors.ErrUnsupported)
+	}
+	var buf bytes.Buffer
+	var written bool
+	return &buf, func(key string) error {
+		if written {
+			return fmt.Errorf("WriteCommitter already used")
+		}
+		written = true
+		if key == "" {
+			return nil
+		}
+		return store.Put(ctx, key, buf.Bytes())
+	}, nil
+}
@@ -0,0 +1,145 @@
+package cryptstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/cryptstore"
+	"github.com/ipld/go-ipld-prime/storage/fsstore"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/storage/tests"
+)
+
+var keys = cryptstore.StaticKeys{
+	Current: "2024",
+	Keys: map[string][]byte{
+		"2023": bytes.Repeat([]byte{1}, 16),
+		"2024": bytes.Repeat([]byte{2}, 32),
+	},
+}
+
+func get(t *testing.T, store *cryptstore.Store, key string) string {
+	t.Helper()
+	content, err := store.Get(context.Background(), key)
+	qt.Assert(t, err, qt.IsNil)
+	r, err := store.GetStream(context.Background(), key)
+	qt.Assert(t, err, qt.IsNil)
+	defer r.Close()
+	streamed, err := io.ReadAll(r)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(streamed), qt.Equals, string(content))
+	return string(content)
+}
+
+func TestRoundtrip(t *testing.T) {
+	ctx := context.Background()
+	backings := map[string]func(t *testing.T) storage.ReadableStorage{
+		"memstore": func(t *testing.T) storage.ReadableStorage { return &memstore.Store{} },
+		"fsstore": func(t *testing.T) storage.ReadableStorage {
+			fs := &fsstore.Store{}
+			qt.Assert(t, fs.InitDefaults(t.TempDir()), qt.IsNil)
+			return fs
+		},
+	}
+	for name, mkBacking := range backings {
+		t.Run(name, func(t *testing.T) {
+			backing := mkBacking(t)
+			store := &cryptstore.Store{Wrapped: backing, Keys: keys}
+
+			qt.Assert(t, store.Put(ctx, "a", []byte("secret alpha")), qt.IsNil)
+			qt.Assert(t, store.PutVec(ctx, "b", [][]byte{[]byte("secret "), []byte("beta")}), qt.IsNil)
+			wr, commit, err := store.PutStream(ctx)
+			qt.Assert(t, err, qt.IsNil)
+			wr.Write([]byte("secret gamma"))
+			qt.Assert(t, commit("c"), qt.IsNil)
+			wr, commit, err = store.PutStream(ctx)
+			qt.Assert(t, err, qt.IsNil)
+			wr.Write([]byte("secret delta"))
+			qt.Assert(t, commit(""), qt.IsNil)
+			qt.Assert(t, store.Put(ctx, "empty", nil), qt.IsNil)
+
+			qt.Check(t, get(t, store, "a"), qt.Equals, "secret alpha")
+			qt.Check(t, get(t, store, "b"), qt.Equals, "secret beta")
+			qt.Check(t, get(t, store, "c"), qt.Equals, "secret gamma")
+			qt.Check(t, get(t, store, "empty"), qt.Equals, "")
+
+			// Keys are in the clear; content isn't.
+			for _, key := range []string{"a", "b", "c"} {
+				has, err := backing.Has(ctx, key)
+				qt.Assert(t, err, qt.IsNil)
+				qt.Check(t, has, qt.IsTrue)
+				raw, err := backing.Get(ctx, key)
+				qt.Assert(t, err, qt.IsNil)
+				qt.Check(t, bytes.Contains(raw, []byte("secret")), qt.IsFalse)
+			}
+			has, err := store.Has(ctx, "d")
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, has, qt.IsFalse)
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	ctx := context.Background()
+	backing := &memstore.Store{}
+	old := &cryptstore.Store{Wrapped: backing, Keys: cryptstore.StaticKeys{Current: "2023", Keys: keys.Keys}}
+	qt.Assert(t, old.Put(ctx, "old", []byte("old content")), qt.IsNil)
+	store := &cryptstore.Store{Wrapped: backing, Keys: keys}
+	qt.Assert(t, store.Put(ctx, "new", []byte("new content")), qt.IsNil)
+
+	qt.Check(t, get(t, store, "old"), qt.Equals, "old content")
+	qt.Check(t, get(t, store, "new"), qt.Equals, "new content")
+	qt.Check(t, string(backing.Bag["old"][2:6]), qt.Equals, "2023")
+	qt.Check(t, string(backing.Bag["new"][2:6]), qt.Equals, "2024")
+
+	// Once the old key is gone, so is the old content.
+	retired := &cryptstore.Store{Wrapped: backing, Keys: cryptstore.StaticKeys{Current: "2024", Keys: map[string][]byte{"2024": keys.Keys["2024"]}}}
+	qt.Check(t, get(t, retired, "new"), qt.Equals, "new content")
+	_, err := retired.Get(ctx, "old")
+	qt.Check(t, err, qt.ErrorMatches, `cryptstore: no key with ID "2023"`)
+}
+
+func TestTampering(t *testing.T) {
+	ctx := context.Background()
+	backing := &memstore.Store{}
+	store := &cryptstore.Store{Wrapped: backing, Keys: keys}
+	qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+	qt.Assert(t, store.Put(ctx, "b", []byte("beta")), qt.IsNil)
+
+	// A flipped bit is detected.
+	backing.Bag["a"][len(backing.Bag["a"])-1] ^= 1
+	_, err := store.Get(ctx, "a")
+	qt.Check(t, err, qt.ErrorMatches, `cryptstore: could not decrypt content with key "2024": .*`)
+
+	// So is content moved to a different key.
+	backing.Bag["c"] = backing.Bag["b"]
+	_, err = store.Get(ctx, "c")
+	qt.Check(t, err, qt.ErrorMatches, `cryptstore: could not decrypt content with key "2024": .*`)
+
+	// And content that was never encrypted.
+	backing.Bag["plain"] = []byte("plain")
+	_, err = store.Get(ctx, "plain")
+	qt.Check(t, err, qt.ErrorMatches, "cryptstore: content has no encryption header")
+}
+
+func TestUnwritable(t *testing.T) {
+	store := &cryptstore.Store{Wrapped: readOnly{&memstore.Store{}}, Keys: keys}
+	err := store.Put(context.Background(), "a", nil)
+	qt.Check(t, errors.Is(err, errors.ErrUnsupported), qt.IsTrue)
+	_, _, err = store.PutStream(context.Background())
+	qt.Check(t, errors.Is(err, errors.ErrUnsupported), qt.IsTrue)
+}
+
+type readOnly struct{ storage.ReadableStorage }
+
+func TestConformance(t *testing.T) {
+	tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+		return &cryptstore.Store{Wrapped: &memstore.Store{}, Keys: keys}
+	}, tests.ConformanceOptions{})
+}
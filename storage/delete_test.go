@@ -0,0 +1,68 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/fsstore"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+)
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+
+	stores := map[string]func(t *testing.T) storage.WritableStorage{
+		"memstore": func(t *testing.T) storage.WritableStorage { return &memstore.Store{} },
+		"fsstore": func(t *testing.T) storage.WritableStorage {
+			fs := &fsstore.Store{}
+			qt.Assert(t, fs.InitDefaults(t.TempDir()), qt.IsNil)
+			return fs
+		},
+	}
+	for name, mkStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := mkStore(t)
+			qt.Check(t, storage.Delete(ctx, store, "absent"), qt.IsNil)
+
+			qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+			qt.Assert(t, store.Put(ctx, "b", []byte("beta")), qt.IsNil)
+			qt.Assert(t, storage.Delete(ctx, store, "a"), qt.IsNil)
+			has, err := store.Has(ctx, "a")
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, has, qt.IsFalse)
+			has, _ = store.Has(ctx, "b")
+			qt.Check(t, has, qt.IsTrue)
+			qt.Check(t, storage.Delete(ctx, store, "a"), qt.IsNil)
+
+			// Deleted keys can be written again.
+			qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+			got, err := storage.Get(ctx, store.(storage.ReadableStorage), "a")
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(got), qt.Equals, "alpha")
+
+			// A write that's in progress isn't disturbed by a delete of the same key.
+			wr, commit, err := storage.PutStream(ctx, store)
+			qt.Assert(t, err, qt.IsNil)
+			wr.Write([]byte("gam"))
+			qt.Assert(t, storage.Delete(ctx, store, "c"), qt.IsNil)
+			wr.Write([]byte("ma"))
+			qt.Assert(t, commit("c"), qt.IsNil)
+			got, err = storage.Get(ctx, store.(storage.ReadableStorage), "c")
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(got), qt.Equals, "gamma")
+
+			keys, err := listAll(t, ctx, store, "")
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, keys, qt.DeepEquals, []string{"a", "b", "c"})
+		})
+	}
+}
+
+func TestDeleteUnsupported(t *testing.T) {
+	err := storage.Delete(context.Background(), basicStore(), "a")
+	qt.Check(t, errors.Is(err, errors.ErrUnsupported), qt.IsTrue)
+}
@@ -8,6 +8,7 @@ import (
 
 // Adapter implements go-ipld-prime/storage.ReadableStorage
 // and go-ipld-prime/storage.WritableStorage
+// (as well as go-ipld-prime/storage.DeletableStorage)
 // backed by a go-datastore.Datastore.
 //
 // Optionally, an EscapingFunc may also be set,
@@ -112,3 +113,26 @@ func (a *Adapter) Put(ctx context.Context, key string, content []byte) error {
 	// validation on the key, and may return errors from that.
 	return a.Wrapped.Put(ctx, k, content)
 }
+
+// Delete implements go-ipld-prime/storage.DeletableStorage.Delete.
+func (a *Adapter) Delete(ctx context.Context, key string) error {
+	// Return early if the context is already closed.
+	// This is also the last time we'll check the context,
+	// since go-datastore doesn't take them.
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// If we have an EscapingFunc, apply it.
+	if a.EscapingFunc != nil {
+		key = a.EscapingFunc(key)
+	}
+
+	// Wrap the key into go-datastore's concrete type that it requires.
+	// (See the comments in Get about the costs of this.)
+	k := datastore.NewKey(key)
+
+	// Delegate the delete call.
+	// The Datastore contract already says deleting a missing key is not an error.
+	return a.Wrapped.Delete(ctx, k)
+}
@@ -7,10 +7,13 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/fs"
+	"iter"
 	"os"
 	"path/filepath"
-
-	"github.com/ipld/go-ipld-prime/storage/sharding"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Store is implements storage.ReadableStorage and storage.WritableStorage,
@@ -25,20 +28,51 @@ import (
 // and a sharding function that returns two shards of two characters each.
 // The escaping and sharding functions should be chosen with regard to each other --
 // the sharding function is applied to the escaped form.
+// The last hunk the sharding function returns is used as the filename,
+// and should be the whole escaped key (as it is for all the functions in the sharding package).
+//
+// Store also implements storage.EnumerableStorage, which requires reversing the escaping function.
+// InitDefaults sets this up; if using Init with a custom escaping function, see SetUnescaping.
+//
+// When a store is set up with InitDefaults or InitLayout, the escaping and sharding functions are recorded by name
+// in a layout file in the basepath, so that the same ones are used when it's opened again.
+// Migrate can move the contents to a different layout, while the store stays in use.
 type Store struct {
-	basepath     string
-	escapingFunc func(string) string
-	shardingFunc func(key string, shards *[]string)
+	basepath  string
+	state     atomic.Pointer[layoutState]
+	migrating sync.Mutex   // held by Migrate.
+	writing   sync.RWMutex // held for reading while a write or delete picks paths and uses them; held by Migrate while it changes the layout, and by PutBatch while it moves entries into place.
+}
+
+// keyFuncs are the functions which turn keys into paths, and back.
+type keyFuncs struct {
+	escapingFunc   func(string) string
+	unescapingFunc func(string) (string, error)
+	shardingFunc   func(key string, shards *[]string)
+}
+
+// layoutState is everything about how keys are mapped onto paths.
+// It's replaced as a whole (never modified), so that a migration can change it while other operations are in progress.
+type layoutState struct {
+	keyFuncs           // the functions writes use, and which reads look at last.
+	layout   Layout    // the names of those functions, if the store was set up from a Layout.
+	previous *keyFuncs // during a migration, the functions of the layout being migrated away from.
 }
 
+// InitDefaults sets up the store, using the given directory.
+//
+// If the directory has a layout file, the layout it records is used (including resuming a migration that was in progress);
+// otherwise, DefaultLayout is used, and recorded.
 func (store *Store) InitDefaults(basepath string) error {
-	return store.Init(
-		basepath,
-		b32enc,             // The same function as go-ipfs uses: see https://github.com/ipfs/go-ipfs-ds-help/blob/48b9cc210923d23b39582b5fa6670ed0d08dc2af/key.go#L20-L22 .
-		sharding.Shard_r12, // Equivalent to what go-ipfs uses by default with flatfs: see https://github.com/ipfs/go-ipfs/blob/52a747763f6c4e85b33ca051cda9cc4b75c815f9/docs/config.md#datastorespec and grep for "shard/v1/next-to-last/2".
-	)
+	return store.initLayout(basepath, DefaultLayout, true)
 }
 
+// Init sets up the store, using the given directory,
+// and the given escaping and sharding functions.
+// A nil escaping function means keys are used in filenames as they are.
+//
+// Init doesn't read or write the layout file, so a store set up with it can't be migrated;
+// it's up to the caller to always use the same functions with the same directory.
 func (store *Store) Init(
 	basepath string,
 	escapingFunc func(string) string,
@@ -51,14 +85,16 @@ func (store *Store) Init(
 	if store.basepath != "" {
 		return fmt.Errorf("fsstore: cannot init: is already initialized")
 	}
-	store.basepath = basepath
-	store.escapingFunc = escapingFunc
-	store.shardingFunc = shardingFunc
 
 	// Make sure basepath is a dir, and make sure the staging and content dirs exist.
 	if err := CheckAndMakeBasepath(basepath); err != nil {
 		return err
 	}
+	store.basepath = basepath
+	store.state.Store(&layoutState{keyFuncs: keyFuncs{
+		escapingFunc: escapingFunc,
+		shardingFunc: shardingFunc,
+	}})
 
 	// That's it for setup on this one.
 	return nil
@@ -70,19 +106,79 @@ func b32enc(in string) string {
 	return b32encoder.EncodeToString([]byte(in))
 }
 
-// pathForKey applies sharding funcs as well as adds the basepath prefix,
+func b32dec(in string) (string, error) {
+	bs, err := b32encoder.DecodeString(in)
+	return string(bs), err
+}
+
+// SetUnescaping gives the store the inverse of the escaping function it was initialized with.
+// This is needed for List to turn filenames back into keys.
+// It's only necessary when using Init with a custom escaping function:
+// InitDefaults sets it already, and a store with no escaping function needs no unescaping either.
+func (store *Store) SetUnescaping(unescapingFunc func(string) (string, error)) {
+	st := *store.state.Load()
+	st.unescapingFunc = unescapingFunc
+	store.state.Store(&st)
+}
+
+// pathForKey applies the escaping and sharding funcs as well as adds the basepath prefix,
 // returning a string ready to use as a filesystem path.
-func (store *Store) pathForKey(key string) string {
+func (kf *keyFuncs) pathForKey(basepath, key string) string {
+	if kf.escapingFunc != nil {
+		key = kf.escapingFunc(key)
+	}
 	shards := make([]string, 1, 4) // future work: would be nice if we could reuse this rather than fresh allocating.
-	shards[0] = store.basepath     // not part of the path shard, but will be a param to Join, so, practical to put here.
+	shards[0] = basepath           // not part of the path shard, but will be a param to Join, so, practical to put here.
 	//shards[1] = storageDir       // not part of the path shard, but will be a param to Join, so, practical to put here.
-	store.shardingFunc(key, &shards)
+	kf.shardingFunc(key, &shards)
 	return filepath.Join(shards...)
 }
 
+// unescape turns a filename back into a key.
+func (kf *keyFuncs) unescape(name string) (string, error) {
+	if kf.unescapingFunc != nil {
+		return kf.unescapingFunc(name)
+	}
+	if kf.escapingFunc != nil {
+		return "", fmt.Errorf("no unescaping function configured")
+	}
+	return name, nil
+}
+
+// pathsForKey returns the paths a key's file could be at:
+// during a migration, the path in the old layout, and then the path in the new layout;
+// otherwise, just the one path.
+//
+// Reads should look at them in that order.
+// Since Migrate moves files with rename, which is atomic,
+// a file that isn't at the old path by the time a read looks there is certain to be at the new path if it's anywhere.
+func (store *Store) pathsForKey(key string) []string {
+	st := store.state.Load()
+	if st.previous == nil {
+		return []string{st.pathForKey(store.basepath, key)}
+	}
+	return []string{st.previous.pathForKey(store.basepath, key), st.pathForKey(store.basepath, key)}
+}
+
+// stat calls os.Stat on each of a key's possible paths, until it finds the file.
+func (store *Store) stat(key string) (fs.FileInfo, error) {
+	var fi fs.FileInfo
+	var err error
+	for _, pth := range store.pathsForKey(key) {
+		fi, err = os.Stat(pth)
+		if !os.IsNotExist(err) {
+			break
+		}
+	}
+	return fi, err
+}
+
 // Has implements go-ipld-prime/storage.Storage.Has.
 func (store *Store) Has(ctx context.Context, key string) (bool, error) {
-	_, err := os.Stat(store.pathForKey(key))
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	_, err := store.stat(key)
 	if err == nil {
 		return true, nil
 	}
@@ -128,21 +224,25 @@ func (store *Store) GetStream(ctx context.Context, key string) (io.ReadCloser, e
 		return nil, ctx.Err()
 	}
 
-	// Figure out where we expect it to be.
-	destpath := store.pathForKey(key)
-
-	// Open and return.
+	// Open it from wherever we expect it to be, and return.
 	// TODO: we should normalize things like "not exists" errors before hurling them up the stack.
-	return os.OpenFile(destpath, os.O_RDONLY, 0)
+	var f *os.File
+	var err error
+	for _, pth := range store.pathsForKey(key) {
+		f, err = os.OpenFile(pth, os.O_RDONLY, 0)
+		if !os.IsNotExist(err) {
+			break
+		}
+	}
+	return f, err
 }
 
-// PutStream implements go-ipld-prime/storage.StreamingWritableStorage.PutStream.
-func (store *Store) PutStream(ctx context.Context) (io.Writer, func(string) error, error) {
+// openStagingFile opens a new file in the staging area, with a random name.
+func (store *Store) openStagingFile(ctx context.Context) (string, *os.File, error) {
 	for {
 		if ctx.Err() != nil {
-			return nil, nil, ctx.Err()
+			return "", nil, ctx.Err()
 		}
-		// Open a new file in the staging area, with a random name.
 		var bs [8]byte
 		rand.Read(bs[:])
 		stagepath := filepath.Join(store.basepath, stagingDir, hex.EncodeToString(bs[:]))
@@ -151,38 +251,245 @@ func (store *Store) PutStream(ctx context.Context) (io.Writer, func(string) erro
 			continue
 		}
 		if err != nil {
-			return nil, nil, fmt.Errorf("fsstore.BeginWrite: could not create a staging file: %w", err)
+			return "", nil, fmt.Errorf("fsstore.BeginWrite: could not create a staging file: %w", err)
+		}
+		return stagepath, f, nil
+	}
+}
+
+// PutStream implements go-ipld-prime/storage.StreamingWritableStorage.PutStream.
+func (store *Store) PutStream(ctx context.Context) (io.Writer, func(string) error, error) {
+	stagepath, f, err := store.openStagingFile(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Okay, got a handle.  Return it... and its commit closure.
+	return f, func(key string) error {
+		// Close the staging file.
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if key == "" {
+			return os.Remove(stagepath)
+		}
+		// n.b. there is a lack of fsync here.  I am going to choose to believe that a sane filesystem will not let me do a 'move' without flushing somewhere in between.
+		// Fun little note: there are some times in history where this belief is not backed -- but, mostly, the evolution of kernel and filesystem development seems to have considered that a mistake,
+		// and things do again typically take 'move' as a strong cue to flush, unless you've actively configured your system oddly.
+		// See https://en.wikipedia.org/wiki/Ext4#Delayed_allocation_and_potential_data_loss for some fun history regarding Ext4;
+		// but ultimately, note that the kernel decided to again make 'move' cause flush, and has done so since 2.6.30, which came out sometime in 2009.
+		// Accordingly, our lack of fsync here seems justified.
+		// However, if you *really* find a system in the wild where this is problematic,
+		// *and* you cannot make your application recover gracefully (which should be relatively easy, because... content addressing; you can't have inconsistency, at least!),
+		// *and* you cannot configure your filesystem to have the level of durability and sanity that you want, so you must fix it in application land...
+		// then... patches welcome.  :)
+		//
+		// History also seems to indicate that if we add fsyncs hereabouts, people will usually just turn around and seek to disable them for performance reasons;
+		// so by default, it seems best to just not do the dance of having a default that people hate.
+
+		// Get it where we want it to go.
+		return store.moveInto(stagepath, key)
+	}, nil
+}
+
+// moveInto moves the file at stagepath into place for key.
+// During a migration, it goes to the new layout, unless the key is present in the old layout already.
+func (store *Store) moveInto(stagepath, key string) error {
+	store.writing.RLock()
+	defer store.writing.RUnlock()
+	paths := store.pathsForKey(key)
+	for _, pth := range paths[:len(paths)-1] {
+		if _, err := os.Lstat(pth); err == nil {
+			return os.Remove(stagepath)
+		}
+	}
+	return move(stagepath, paths[len(paths)-1])
+}
+
+// PutBatch implements go-ipld-prime/storage.BatchWritableStorage.PutBatch.
+//
+// Every entry is first written into the staging area,
+// and only once all of them have been written successfully are any moved into place.
+// If moving any of them fails, the ones this call had already moved are removed again,
+// so the store is left as it was.
+// (Entries which were already present before the call are never touched.)
+// Other writes and deletes wait while a batch is being moved into place, so they can't see entries that might yet be removed again.
+//
+// This is as close to atomic as we can get with plain filesystem operations:
+// a crash partway through moving entries into place can still leave some of them present.
+func (store *Store) PutBatch(ctx context.Context, keys []string, contents []io.Reader) error {
+	if len(keys) != len(contents) {
+		return fmt.Errorf("fsstore: PutBatch given %d keys but %d contents", len(keys), len(contents))
+	}
+
+	// Stage everything.
+	staged := make([]string, 0, len(keys))
+	removeStaged := func(from int) {
+		for _, stagepath := range staged[from:] {
+			os.Remove(stagepath)
+		}
+	}
+	for i := range keys {
+		stagepath, f, err := store.openStagingFile(ctx)
+		if err != nil {
+			removeStaged(0)
+			return err
+		}
+		staged = append(staged, stagepath)
+		_, err = io.Copy(f, contents[i])
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			removeStaged(0)
+			return err
+		}
+	}
+
+	// Move everything into place, remembering what we added in case we have to back out.
+	// This holds off all other writes until we're done:
+	//  otherwise, a concurrent write of a key we'd moved into place would find it present and report success,
+	//  and then lose it if we backed out.
+	store.writing.Lock()
+	defer store.writing.Unlock()
+	moved := make([]string, 0, len(keys))
+	for i, key := range keys {
+		if _, err := store.stat(key); err == nil {
+			// Already present (perhaps even from earlier in this same batch).  First write wins.
+			os.Remove(staged[i])
+			continue
+		}
+		paths := store.pathsForKey(key)
+		destpath := paths[len(paths)-1]
+		if err := move(staged[i], destpath); err != nil {
+			for _, p := range moved {
+				os.Remove(p)
+			}
+			removeStaged(i)
+			return err
+		}
+		moved = append(moved, destpath)
+	}
+	return nil
+}
+
+// Size implements go-ipld-prime/storage.SizableStorage.Size.
+func (store *Store) Size(ctx context.Context, key string) (int64, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	fi, err := store.stat(key)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Delete implements go-ipld-prime/storage.DeletableStorage.Delete.
+//
+// Only the file holding the entry is removed.
+// Files in the staging area are never touched, so writes in progress (and their WriteCommitters) are unaffected;
+// and shard directories are left in place even if they become empty,
+// so that a concurrent write moving a file into the same shard can't have its directory removed out from under it.
+// If a delete races with a write of the same key, the key may end up either present or absent.
+func (store *Store) Delete(ctx context.Context, key string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	store.writing.RLock()
+	defer store.writing.RUnlock()
+	for _, pth := range store.pathsForKey(key) {
+		err := os.Remove(pth)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// List implements go-ipld-prime/storage.EnumerableStorage.List.
+//
+// It walks the whole directory tree (skipping the staging area, and anything else whose name starts with a dot),
+// and turns each filename back into a key using the unescaping function.
+// Since sharding is usually based on the end of the escaped key, a prefix doesn't let us skip any directories;
+// it only filters the results.
+// Keys are yielded in the lexical order of their paths on disk.
+//
+// During a migration, each file is checked against both layouts to find out which it's in.
+// Since files are being moved around while the walk is in progress,
+// a key may be yielded twice, or not at all if it's moved from a directory the walk hasn't reached yet to one it's already passed.
+func (store *Store) List(ctx context.Context, prefix string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		st := store.state.Load()
+		if st.unescapingFunc == nil && st.escapingFunc != nil {
+			yield("", fmt.Errorf("fsstore: cannot list: no unescaping function configured"))
+			return
 		}
-		// Okay, got a handle.  Return it... and its commit closure.
-		return f, func(key string) error {
-			// Close the staging file.
-			if err := f.Close(); err != nil {
-				return err
+		err := st.walk(ctx, store.basepath, func(pth string, d fs.DirEntry) error {
+			key, _, err := st.classify(store.basepath, pth, d.Name())
+			if err != nil {
+				return fmt.Errorf("fsstore: cannot list: %w", err)
 			}
-			if key == "" {
-				return os.Remove(stagepath)
+			if !strings.HasPrefix(key, prefix) {
+				return nil
+			}
+			if !yield(key, nil) {
+				return filepath.SkipAll
+			}
+			return nil
+		})
+		if err != nil {
+			yield("", err)
+		}
+	}
+}
+
+// walk calls fn for every file in the directory tree,
+// skipping the staging area and anything else whose name starts with a dot.
+func (st *layoutState) walk(ctx context.Context, basepath string, fn func(pth string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(basepath, func(pth string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if pth == basepath {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
 			}
-			// n.b. there is a lack of fsync here.  I am going to choose to believe that a sane filesystem will not let me do a 'move' without flushing somewhere in between.
-			// Fun little note: there are some times in history where this belief is not backed -- but, mostly, the evolution of kernel and filesystem development seems to have considered that a mistake,
-			// and things do again typically take 'move' as a strong cue to flush, unless you've actively configured your system oddly.
-			// See https://en.wikipedia.org/wiki/Ext4#Delayed_allocation_and_potential_data_loss for some fun history regarding Ext4;
-			// but ultimately, note that the kernel decided to again make 'move' cause flush, and has done so since 2.6.30, which came out sometime in 2009.
-			// Accordingly, our lack of fsync here seems justified.
-			// However, if you *really* find a system in the wild where this is problematic,
-			// *and* you cannot make your application recover gracefully (which should be relatively easy, because... content addressing; you can't have inconsistency, at least!),
-			// *and* you cannot configure your filesystem to have the level of durability and sanity that you want, so you must fix it in application land...
-			// then... patches welcome.  :)
-			//
-			// History also seems to indicate that if we add fsyncs hereabouts, people will usually just turn around and seek to disable them for performance reasons;
-			// so by default, it seems best to just not do the dance of having a default that people hate.
-
-			// Figure out where we want it to go.
-			destpath := store.pathForKey(key)
-
-			// Get it there.
-			return move(stagepath, destpath)
-		}, nil
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		return fn(pth, d)
+	})
+}
+
+// classify turns the path of a file back into a key.
+//
+// Outside of a migration, this only needs to unescape the filename.
+// During one, the key is checked by mapping it back onto a path in each layout, to find out which one the file is in;
+// the key is returned along with whether that's the old layout.
+func (st *layoutState) classify(basepath, pth, name string) (key string, old bool, err error) {
+	key, err = st.unescape(name)
+	if st.previous == nil {
+		if err != nil {
+			return "", false, fmt.Errorf("could not unescape filename %q: %w", pth, err)
+		}
+		return key, false, nil
+	}
+	if err == nil && st.pathForKey(basepath, key) == pth {
+		return key, false, nil
 	}
+	key, err = st.previous.unescape(name)
+	if err == nil && st.previous.pathForKey(basepath, key) == pth {
+		return key, true, nil
+	}
+	return "", false, fmt.Errorf("file %q doesn't belong to either layout", pth)
 }
 
 const stagingDir = ".temp" // same as flatfs uses.
@@ -230,6 +537,13 @@ func CheckAndMakeBasepath(basepath string) error {
 // (An alternative approach would be to blindly mkdir the parent segments every time,
 // rather than do this backwards stepping.  Have not benchmarked these against each other.)
 func move(stagepath, destpath string) error {
+	// We're a write-once (presumed-to-be-)content-addressable blob store -- that means *we keep what already exists*.
+	//  Rename would happily replace it, so look first.
+	//   Someone could still race us in between the look and the rename; but if they do, they were writing the same key,
+	//   which in a content-addressable store means the same content, so it doesn't much matter who wins.
+	if _, err := os.Lstat(destpath); err == nil {
+		return os.Remove(stagepath)
+	}
 	err := os.Rename(stagepath, destpath)
 	if os.IsNotExist(err) {
 		// This probably means parent of destpath doesn't exist yet, so we'll make it.
@@ -246,8 +560,7 @@ func move(stagepath, destpath string) error {
 	}
 	if os.IsExist(err) {
 		// Oh!  Some content is already there?
-		//  We're a write-once (presumed-to-be-)content-addressable blob store -- that means *we keep what already exists*.
-		//  FIXME: no, I wish this is how the Rename function worked, but it is not, actually.
+		//  (Rename doesn't usually report this, but on some platforms it can.)
 		return os.Remove(stagepath)
 	}
 	return err
@@ -257,13 +570,18 @@ func move(stagepath, destpath string) error {
 // If this sounds a lot like os.MkdirAll: yes,
 // except this function is going to assume if it exists, it's a dir,
 // and that saves us some stat syscalls.
+// Someone else making the same directory at the same time is fine, too:
+// concurrent writes into a new shard all race to make it.
 func haveDir(pth string) error {
 	err := os.Mkdir(pth, 0777)
 	if os.IsNotExist(err) {
 		if err := haveDir(filepath.Dir(pth)); err != nil {
 			return err
 		}
-		return os.Mkdir(pth, 0777)
+		err = os.Mkdir(pth, 0777)
+	}
+	if os.IsExist(err) {
+		return nil
 	}
 	return err
 }
@@ -0,0 +1,142 @@
+package fsstore_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/fsstore"
+	"github.com/ipld/go-ipld-prime/storage/tests"
+)
+
+func TestConformance(t *testing.T) {
+	tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+		store := &fsstore.Store{}
+		if err := store.InitDefaults(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}, tests.ConformanceOptions{})
+}
+
+func TestConformanceDuringMigration(t *testing.T) {
+	tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+		store := &fsstore.Store{}
+		qt.Assert(t, store.InitDefaults(t.TempDir()), qt.IsNil)
+		qt.Assert(t, store.Put(context.Background(), "already here", []byte("x")), qt.IsNil)
+		qt.Assert(t, store.Put(context.Background(), "also here", []byte("y")), qt.IsNil)
+		done, err := store.Migrate(context.Background(), fsstore.Layout{Escaping: "base32", Sharding: "r133"}, 1)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, done, qt.IsFalse)
+		qt.Assert(t, store.Delete(context.Background(), "already here"), qt.IsNil)
+		qt.Assert(t, store.Delete(context.Background(), "also here"), qt.IsNil)
+		return store
+	}, tests.ConformanceOptions{})
+}
+
+func TestLayout(t *testing.T) {
+	dir := t.TempDir()
+	store := &fsstore.Store{}
+	qt.Assert(t, store.InitDefaults(dir), qt.IsNil)
+	qt.Check(t, store.Layout(), qt.Equals, fsstore.DefaultLayout)
+	qt.Assert(t, store.Put(context.Background(), "key", []byte("value")), qt.IsNil)
+
+	// Opening it again with the same layout is fine; with a different one, it's refused, rather than losing everything.
+	qt.Assert(t, (&fsstore.Store{}).InitLayout(dir, fsstore.DefaultLayout), qt.IsNil)
+	err := (&fsstore.Store{}).InitLayout(dir, fsstore.Layout{Escaping: "base32", Sharding: "r133"})
+	qt.Check(t, err, qt.ErrorMatches, `fsstore: cannot init: .* has layout base32/r12, not base32/r133`)
+
+	// A new directory gets the layout it's asked for, and InitDefaults detects it.
+	dir = t.TempDir()
+	qt.Assert(t, (&fsstore.Store{}).InitLayout(dir, fsstore.Layout{Escaping: "none", Sharding: "r122"}), qt.IsNil)
+	store = &fsstore.Store{}
+	qt.Assert(t, store.InitDefaults(dir), qt.IsNil)
+	qt.Check(t, store.Layout(), qt.Equals, fsstore.Layout{Escaping: "none", Sharding: "r122"})
+	qt.Assert(t, store.Put(context.Background(), "abcdef", []byte("value")), qt.IsNil)
+	_, err = os.Stat(filepath.Join(dir, "bc", "de", "abcdef"))
+	qt.Check(t, err, qt.IsNil)
+
+	err = (&fsstore.Store{}).InitLayout(t.TempDir(), fsstore.Layout{Escaping: "rot13", Sharding: "r12"})
+	qt.Check(t, err, qt.ErrorMatches, `fsstore: cannot init: no escaping function registered as "rot13"`)
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	to := fsstore.Layout{Escaping: "none", Sharding: "r133"}
+	store := &fsstore.Store{}
+	qt.Assert(t, store.InitDefaults(dir), qt.IsNil)
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		keys = append(keys, key)
+		qt.Assert(t, store.Put(ctx, key, []byte("value of "+key)), qt.IsNil)
+	}
+	check := func(store *fsstore.Store) {
+		t.Helper()
+		for _, key := range keys {
+			r, err := store.GetStream(ctx, key)
+			qt.Assert(t, err, qt.IsNil)
+			content, err := io.ReadAll(r)
+			r.Close()
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, string(content), qt.Equals, "value of "+key)
+		}
+		var listed []string
+		for key, err := range store.List(ctx, "") {
+			qt.Assert(t, err, qt.IsNil)
+			listed = append(listed, key)
+		}
+		sort.Strings(listed)
+		qt.Check(t, listed, qt.DeepEquals, keys)
+	}
+
+	// Move some of the entries, and check everything's still readable.
+	done, err := store.Migrate(ctx, to, 5)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, done, qt.IsFalse)
+	qt.Check(t, store.Layout(), qt.Equals, to)
+	check(store)
+
+	// New writes go to the new layout.
+	keys = append(keys, "key-new")
+	qt.Assert(t, store.Put(ctx, "key-new", []byte("value of key-new")), qt.IsNil)
+	_, err = os.Stat(filepath.Join(dir, "key", "-ne", "key-new"))
+	qt.Check(t, err, qt.IsNil)
+
+	// Only one migration at a time.
+	_, err = store.Migrate(ctx, fsstore.DefaultLayout, 0)
+	qt.Check(t, err, qt.ErrorMatches, "fsstore: cannot migrate to base32/r12: a migration to none/r133 is already in progress")
+
+	// Pick the migration back up in a new Store (as if the process restarted), and finish it.
+	store = &fsstore.Store{}
+	qt.Assert(t, store.InitLayout(dir, to), qt.IsNil)
+	check(store)
+	done, err = store.Migrate(ctx, to, 5)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, done, qt.IsFalse)
+	done, err = store.Migrate(ctx, to, 0)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, done, qt.IsTrue)
+	check(store)
+
+	store = &fsstore.Store{}
+	qt.Assert(t, store.InitDefaults(dir), qt.IsNil)
+	qt.Check(t, store.Layout(), qt.Equals, to)
+	check(store)
+	_, err = os.Stat(filepath.Join(dir, "000", "y-0", "key-05"))
+	qt.Check(t, err, qt.IsNil)
+
+	// Stores set up without a layout can't be migrated.
+	store = &fsstore.Store{}
+	qt.Assert(t, store.Init(t.TempDir(), nil, func(key string, shards *[]string) { *shards = append(*shards, key) }), qt.IsNil)
+	_, err = store.Migrate(ctx, to, 0)
+	qt.Check(t, err, qt.ErrorMatches, "fsstore: cannot migrate: the store has no recorded layout")
+}
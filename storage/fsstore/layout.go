@@ -0,0 +1,281 @@
+package fsstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/ipld/go-ipld-prime/storage/sharding"
+)
+
+// Layout names the escaping and sharding functions a Store uses to turn keys into paths.
+// The names are looked up among those registered with RegisterEscaping and RegisterSharding.
+//
+// The escaping functions registered by default are "none" (keys are used as filenames as they are)
+// and "base32" (unpadded standard base32, as go-ipfs uses).
+// The sharding functions registered by default are "r12", "r122", and "r133",
+// which are the functions of the same names in the sharding package.
+type Layout struct {
+	Escaping string `json:"escaping"`
+	Sharding string `json:"sharding"`
+}
+
+func (l Layout) String() string {
+	return l.Escaping + "/" + l.Sharding
+}
+
+// DefaultLayout is the layout InitDefaults uses for a directory that doesn't have one recorded already.
+var DefaultLayout = Layout{
+	Escaping: "base32", // The same function as go-ipfs uses: see https://github.com/ipfs/go-ipfs-ds-help/blob/48b9cc210923d23b39582b5fa6670ed0d08dc2af/key.go#L20-L22 .
+	Sharding: "r12",    // Equivalent to what go-ipfs uses by default with flatfs: see https://github.com/ipfs/go-ipfs/blob/52a747763f6c4e85b33ca051cda9cc4b75c815f9/docs/config.md#datastorespec and grep for "shard/v1/next-to-last/2".
+}
+
+type escaping struct {
+	escapingFunc   func(string) string
+	unescapingFunc func(string) (string, error)
+}
+
+var escapings = map[string]escaping{
+	"none":   {},
+	"base32": {b32enc, b32dec},
+}
+
+var shardings = map[string]func(key string, shards *[]string){
+	"r12":  sharding.Shard_r12,
+	"r122": sharding.Shard_r122,
+	"r133": sharding.Shard_r133,
+}
+
+// RegisterEscaping makes an escaping function, and its inverse, available to use in a Layout under the given name.
+// Registering a name that's already registered replaces it.
+//
+// Since the name is what's recorded in the layout file, a name must always refer to the same functions,
+// or stores using it will not find their contents.
+//
+// This function is not safe to call concurrently with the use of any Store;
+// typically it's called from an init function.
+func RegisterEscaping(name string, escapingFunc func(string) string, unescapingFunc func(string) (string, error)) {
+	escapings[name] = escaping{escapingFunc, unescapingFunc}
+}
+
+// RegisterSharding makes a sharding function available to use in a Layout under the given name.
+// The same caveats apply as for RegisterEscaping.
+func RegisterSharding(name string, shardingFunc func(key string, shards *[]string)) {
+	shardings[name] = shardingFunc
+}
+
+func (l Layout) keyFuncs() (keyFuncs, error) {
+	esc, ok := escapings[l.Escaping]
+	if !ok {
+		return keyFuncs{}, fmt.Errorf("no escaping function registered as %q", l.Escaping)
+	}
+	shardingFunc, ok := shardings[l.Sharding]
+	if !ok {
+		return keyFuncs{}, fmt.Errorf("no sharding function registered as %q", l.Sharding)
+	}
+	return keyFuncs{esc.escapingFunc, esc.unescapingFunc, shardingFunc}, nil
+}
+
+// layoutFileName is the name of the file recording the layout in the basepath.
+// (It starts with a dot, so List doesn't mistake it for an entry.)
+const layoutFileName = ".layout"
+
+// layoutFile is the content of the layout file.
+type layoutFile struct {
+	Layout
+	MigratingTo *Layout `json:"migratingTo,omitempty"`
+}
+
+func (lf *layoutFile) state() (*layoutState, error) {
+	kf, err := lf.Layout.keyFuncs()
+	if err != nil {
+		return nil, err
+	}
+	if lf.MigratingTo == nil {
+		return &layoutState{keyFuncs: kf, layout: lf.Layout}, nil
+	}
+	to, err := lf.MigratingTo.keyFuncs()
+	if err != nil {
+		return nil, err
+	}
+	return &layoutState{keyFuncs: to, layout: *lf.MigratingTo, previous: &kf}, nil
+}
+
+// readLayoutFile returns nil if there's no layout file.
+func readLayoutFile(basepath string) (*layoutFile, error) {
+	bs, err := os.ReadFile(filepath.Join(basepath, layoutFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lf layoutFile
+	if err := json.Unmarshal(bs, &lf); err != nil {
+		return nil, fmt.Errorf("invalid layout file: %w", err)
+	}
+	return &lf, nil
+}
+
+// writeLayoutFile replaces the layout file, atomically.
+func writeLayoutFile(basepath string, lf layoutFile) error {
+	bs, err := json.Marshal(lf)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Join(basepath, stagingDir), "layout")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(bs)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(basepath, layoutFileName))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// InitLayout sets up the store, using the given directory and layout.
+//
+// If the directory doesn't have a layout file, the layout is recorded in one.
+// If it does, and it records a different layout, InitLayout returns an error rather than orphaning the existing contents:
+// either use InitDefaults, which uses whatever layout is recorded, or Migrate to change it.
+// (If a migration is in progress, the layout being migrated to is the one that has to match.)
+func (store *Store) InitLayout(basepath string, layout Layout) error {
+	return store.initLayout(basepath, layout, false)
+}
+
+func (store *Store) initLayout(basepath string, want Layout, detect bool) error {
+	if basepath == "" {
+		return fmt.Errorf("fsstore: invalid setup args: need a path")
+	}
+	if store.basepath != "" {
+		return fmt.Errorf("fsstore: cannot init: is already initialized")
+	}
+	if err := CheckAndMakeBasepath(basepath); err != nil {
+		return err
+	}
+	lf, err := readLayoutFile(basepath)
+	if err != nil {
+		return fmt.Errorf("fsstore: cannot init: %w", err)
+	}
+	switch {
+	case lf == nil:
+		lf = &layoutFile{Layout: want}
+		if _, err := want.keyFuncs(); err != nil {
+			return fmt.Errorf("fsstore: cannot init: %w", err)
+		}
+		if err := writeLayoutFile(basepath, *lf); err != nil {
+			return fmt.Errorf("fsstore: cannot init: could not write layout file: %w", err)
+		}
+	case !detect:
+		current := lf.Layout
+		if lf.MigratingTo != nil {
+			current = *lf.MigratingTo
+		}
+		if current != want {
+			return fmt.Errorf("fsstore: cannot init: %s has layout %s, not %s", basepath, current, want)
+		}
+	}
+	st, err := lf.state()
+	if err != nil {
+		return fmt.Errorf("fsstore: cannot init: %w", err)
+	}
+	store.basepath = basepath
+	store.state.Store(st)
+	return nil
+}
+
+// Layout returns the layout the store writes new entries in:
+// during a migration, that's the layout being migrated to.
+// It returns the zero Layout if the store was set up with Init.
+func (store *Store) Layout() Layout {
+	return store.state.Load().layout
+}
+
+var errMigrationLimit = errors.New("migration limit reached")
+
+// Migrate moves the store's contents from its current layout to another one.
+//
+// The migration is recorded in the layout file before anything is moved.
+// From then on, new entries are written in the new layout,
+// reads look in both layouts, and entries are moved across one at a time by renaming their files.
+// The store can be used as usual throughout (although List has some caveats; see its documentation).
+//
+// If limit is more than zero, Migrate moves at most that many entries;
+// if there are more left, it returns with done set to false, and should be called again (with the same layout) to carry on.
+// A migration is also resumable if it's interrupted in any other way, including by the process exiting:
+// InitDefaults, or InitLayout with the new layout, picks it up from the layout file,
+// and the next call to Migrate carries on from there.
+// Once every entry has been moved, the layout file is updated, and Migrate returns with done set to true.
+//
+// Directories that become empty are left in place.
+// Only one migration can be in progress at a time; Migrate returns an error if asked for a different layout than the one in progress.
+func (store *Store) Migrate(ctx context.Context, to Layout, limit int) (done bool, err error) {
+	store.migrating.Lock()
+	defer store.migrating.Unlock()
+
+	st := store.state.Load()
+	switch {
+	case st.layout == Layout{}:
+		return false, fmt.Errorf("fsstore: cannot migrate: the store has no recorded layout")
+	case st.previous != nil && st.layout != to:
+		return false, fmt.Errorf("fsstore: cannot migrate to %s: a migration to %s is already in progress", to, st.layout)
+	case st.previous == nil && st.layout == to:
+		return true, nil
+	case st.previous == nil:
+		kf, err := to.keyFuncs()
+		if err != nil {
+			return false, fmt.Errorf("fsstore: cannot migrate: %w", err)
+		}
+		if err := writeLayoutFile(store.basepath, layoutFile{Layout: st.layout, MigratingTo: &to}); err != nil {
+			return false, fmt.Errorf("fsstore: cannot migrate: could not write layout file: %w", err)
+		}
+		st = &layoutState{keyFuncs: kf, layout: to, previous: &st.keyFuncs}
+		// Writes already in progress finish with the old layout before we switch;
+		// from here on, nothing new can appear in the old layout, so one full pass over the tree is enough.
+		store.writing.Lock()
+		store.state.Store(st)
+		store.writing.Unlock()
+	}
+
+	var moved int
+	err = st.walk(ctx, store.basepath, func(pth string, d fs.DirEntry) error {
+		key, old, err := st.classify(store.basepath, pth, d.Name())
+		if err != nil || !old {
+			return err
+		}
+		if limit > 0 && moved >= limit {
+			return errMigrationLimit
+		}
+		if err := move(pth, st.pathForKey(store.basepath, key)); err != nil {
+			if _, err2 := os.Lstat(pth); os.IsNotExist(err2) {
+				return nil // Deleted while we were looking at it.
+			}
+			return err
+		}
+		moved++
+		return nil
+	})
+	if err == errMigrationLimit {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("fsstore: migration: %w", err)
+	}
+
+	if err := writeLayoutFile(store.basepath, layoutFile{Layout: st.layout}); err != nil {
+		return false, fmt.Errorf("fsstore: migration: could not write layout file: %w", err)
+	}
+	store.state.Store(&layoutState{keyFuncs: st.keyFuncs, layout: st.layout})
+	return true, nil
+}
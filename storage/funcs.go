@@ -3,8 +3,10 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 )
 
 /*
@@ -64,6 +66,9 @@ func PutStream(ctx context.Context, store WritableStorage) (io.Writer, func(key
 			return fmt.Errorf("WriteCommitter already used")
 		}
 		written = true
+		if key == "" {
+			return nil
+		}
 		return store.Put(ctx, key, buf.Bytes())
 	}, nil
 }
@@ -95,6 +100,40 @@ func PutVec(ctx context.Context, store WritableStorage, key string, blobVec [][]
 	return wrcommit(key)
 }
 
+// PutBatch writes several entries into storage.
+// The keys and contents slices must have the same length, and correspond to each other by index.
+// This function will feature-detect the BatchWritableStorage interface, and use that if possible,
+// in which case the write is atomic.
+// Otherwise it falls back to writing each entry in turn with PutStream,
+// which is not atomic: if an error is returned, some of the entries may have been written.
+func PutBatch(ctx context.Context, store WritableStorage, keys []string, contents []io.Reader) error {
+	if len(keys) != len(contents) {
+		return fmt.Errorf("PutBatch: %d keys but %d contents", len(keys), len(contents))
+	}
+	// Prefer the feature itself, first.
+	if batchable, ok := store.(BatchWritableStorage); ok {
+		return batchable.PutBatch(ctx, keys, contents)
+	}
+	// Fallback to streaming mode (which will further fall back to basic, if necessary).
+	for i, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		wr, wrcommit, err := PutStream(ctx, store)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(wr, contents[i]); err != nil {
+			wrcommit("")
+			return err
+		}
+		if err := wrcommit(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Peek accessess the same data as Get, but indicates that the caller promises not to mutate the returned byte slice.
 // (By contrast, Get is expected to return a safe copy.)
 // This function will feature-detect the PeekableStorage interface, and use that if possible;
@@ -115,6 +154,61 @@ func Peek(ctx context.Context, store ReadableStorage, key string) ([]byte, io.Cl
 	return bs, noopCloser{nil}, err
 }
 
+// List returns an iterator over the keys present in the store which begin with the given prefix.
+// (An empty prefix means every key.)
+// This function will feature-detect the EnumerableStorage interface;
+// there's no way to synthesize this behavior from the basic interfaces,
+// so if the store doesn't support it, the iterator yields a single error wrapping errors.ErrUnsupported.
+//
+// Iteration stops after the first error.  Typical usage looks like:
+//
+//	for key, err := range storage.List(ctx, store, "") {
+//		if err != nil {
+//			return err
+//		}
+//		// ... use key ...
+//	}
+func List(ctx context.Context, store Storage, prefix string) iter.Seq2[string, error] {
+	// Prefer the feature itself, first.
+	if enumerable, ok := store.(EnumerableStorage); ok {
+		return enumerable.List(ctx, prefix)
+	}
+	// No fallback possible.
+	return func(yield func(string, error) bool) {
+		yield("", fmt.Errorf("storage: %T cannot list its keys: %w", store, errors.ErrUnsupported))
+	}
+}
+
+// Size returns the length of the content stored under a key.
+// This function will feature-detect the SizableStorage interface, and use that if possible;
+// otherwise it will fall back to using Peek, and counting the bytes it returns.
+func Size(ctx context.Context, store ReadableStorage, key string) (int64, error) {
+	// Prefer the feature itself, first.
+	if sizable, ok := store.(SizableStorage); ok {
+		return sizable.Size(ctx, key)
+	}
+	// Fallback to peek (which may further fall back to basic).
+	bs, closer, err := Peek(ctx, store, key)
+	if err != nil {
+		return 0, err
+	}
+	defer closer.Close()
+	return int64(len(bs)), nil
+}
+
+// Delete removes an entry from storage.  Deleting a key that isn't present is not an error.
+// This function will feature-detect the DeletableStorage interface;
+// there's no way to synthesize this behavior from the basic interfaces,
+// so if the store doesn't support it, an error wrapping errors.ErrUnsupported is returned.
+func Delete(ctx context.Context, store Storage, key string) error {
+	// Prefer the feature itself, first.
+	if deletable, ok := store.(DeletableStorage); ok {
+		return deletable.Delete(ctx, key)
+	}
+	// No fallback possible.
+	return fmt.Errorf("storage: %T cannot delete: %w", store, errors.ErrUnsupported)
+}
+
 type noopCloser struct {
 	io.Reader
 }
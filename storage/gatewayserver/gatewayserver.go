@@ -0,0 +1,424 @@
+/*
+The gatewayserver package offers an http.Handler which serves data from a LinkSystem
+in the style of an IPFS "trustless gateway" (https://specs.ipfs.tech/http-gateways/trustless-gateway/):
+clients receive blocks, rather than interpreted data, and can verify every one of them themselves.
+
+Requests take the form "/ipfs/{cid}", or "/ipfs/{cid}/{path}" to address data within the DAG, with the path
+resolved as a datamodel.Path, crossing links as needed.
+Two kinds of response are available,
+chosen by the "format" query parameter, or else by the Accept header:
+
+  - "raw" (application/vnd.ipld.raw): the single block that contains the data at the end of the path.
+  - "car" (application/vnd.ipld.car): a CAR (version 1) of the blocks along the path,
+    followed by the blocks that a traversal from the end of the path reaches.
+    How far that traversal goes is set by the "dag-scope" query parameter,
+    or (if the Handler allows it) by a selector given in the "selector" query parameter.
+
+The gatewaystore package contains a client for these responses.
+*/
+package gatewayserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+)
+
+const (
+	contentTypeRaw = "application/vnd.ipld.raw"
+	contentTypeCAR = "application/vnd.ipld.car"
+)
+
+var exploreAll = func() selector.Selector {
+	sel, err := selector.CompileSelector(selectorparse.CommonSelector_ExploreAllRecursively)
+	if err != nil {
+		panic(err)
+	}
+	return sel
+}()
+
+// Handler is an http.Handler serving blocks, and CARs of DAGs, from a LinkSystem.
+//
+// Only requests for paths beginning "/ipfs/" are served;
+// use http.StripPrefix if the Handler is mounted somewhere other than at the root.
+//
+// Responses are immutable (the same URL always yields the same content, or an error),
+// and so they're marked as cacheable forever, and have an ETag derived from the CID;
+// requests with a matching If-None-Match header get a 304 Not Modified response.
+//
+// Errors found before a response has begun are reported with an HTTP status:
+// 404 if the data can't be found (including when it isn't present in the LinkSystem's storage),
+// and 400 for malformed requests.
+// Once a CAR response has begun, its status can't be changed,
+// so an error (including exceeding the Budget) aborts the response instead,
+// which clients see as a connection that was closed early.
+type Handler struct {
+	// LinkSystem is where blocks are loaded from.
+	// Its codecs are needed to resolve paths and make CARs of more than a single block;
+	// a CAR with dag-scope=block, or a raw block, can be served for any CID,
+	// as long as there's no path.
+	LinkSystem linking.LinkSystem
+
+	// Budget, if set, limits the work done for each request:
+	// path resolution and CAR traversals each draw on a fresh copy of it.
+	// Path resolution is done by traversal.Progress.Focus, which only applies NodeBudget and LinkBudget,
+	// so ByteBudget and Deadline limit only the traversal.
+	Budget *traversal.Budget
+
+	// AllowSelectors, if true, allows requests for CARs to give any selector in a "selector" query parameter,
+	// in DAG-JSON, instead of a dag-scope.
+	// Setting a Budget is strongly recommended when this is enabled.
+	AllowSelectors bool
+
+	// LinkTargetNodePrototypeChooser chooses the Node implementation each block is loaded into,
+	// for the purpose of resolving paths and evaluating selectors.
+	// Optional; by default, basicnode.Prototype.Any is used.
+	LinkTargetNodePrototypeChooser traversal.LinkTargetNodePrototypeChooser
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, "/ipfs/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	cidStr, pathStr, _ := strings.Cut(rest, "/")
+	root, err := cid.Decode(cidStr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid CID: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := h.checkDigest(root); err != nil {
+		http.Error(w, fmt.Sprintf("invalid CID: %s", err), http.StatusBadRequest)
+		return
+	}
+	req := request{
+		root: cidlink.Link{Cid: root},
+		path: datamodel.ParsePath(pathStr),
+	}
+	if req.format, err = negotiate(r); err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	if req.format == "car" {
+		if req.sel, req.selectorKey, err = h.chooseSelector(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	h.serve(w, r, req)
+}
+
+// checkDigest makes sure that a CID's digest is as long as its hash function's output,
+// since no content could ever match it otherwise (identity digests, being the content itself, are the exception).
+// Hash functions that can't be found are left for loading to report.
+func (h *Handler) checkDigest(c cid.Cid) error {
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return err
+	}
+	if dmh.Code == multihash.IDENTITY || h.LinkSystem.HasherChooser == nil {
+		return nil
+	}
+	hasher, err := h.LinkSystem.HasherChooser(cidlink.Link{Cid: c}.Prototype())
+	if err != nil {
+		return nil
+	}
+	if dmh.Length != hasher.Size() {
+		return fmt.Errorf("%s has a %d-byte digest, but its hash function produces %d bytes", c, dmh.Length, hasher.Size())
+	}
+	return nil
+}
+
+// request is a parsed request.
+type request struct {
+	root        cidlink.Link
+	path        datamodel.Path
+	format      string            // "raw" or "car".
+	sel         selector.Selector // for CARs: nil means no traversal beyond the end of the path.
+	selectorKey string            // for CARs: a description of the selector, which is used in the ETag.
+}
+
+// negotiate picks the response format, from the format parameter if there is one, or else from the Accept header.
+func negotiate(r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "raw", "car":
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported format %q", format)
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediatype, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediatype {
+		case contentTypeRaw:
+			return "raw", nil
+		case contentTypeCAR:
+			if v, ok := params["version"]; ok && v != "1" {
+				continue
+			}
+			if o, ok := params["order"]; ok && o != "dfs" && o != "unk" {
+				continue
+			}
+			return "car", nil
+		}
+	}
+	return "", fmt.Errorf("request must accept %s or %s; or give a format parameter", contentTypeRaw, contentTypeCAR)
+}
+
+// chooseSelector works out what the traversal for a CAR should be from the dag-scope and selector parameters.
+func (h *Handler) chooseSelector(r *http.Request) (selector.Selector, string, error) {
+	q := r.URL.Query()
+	if s := q.Get("selector"); s != "" {
+		if !h.AllowSelectors {
+			return nil, "", fmt.Errorf("selectors are not allowed")
+		}
+		if q.Has("dag-scope") {
+			return nil, "", fmt.Errorf("dag-scope and selector cannot both be given")
+		}
+		sel, err := selectorparse.ParseAndCompileJSONSelector(s)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid selector: %w", err)
+		}
+		return sel, "selector=" + s, nil
+	}
+	switch scope := q.Get("dag-scope"); scope {
+	case "", "all":
+		return exploreAll, "all", nil
+	case "block", "entity":
+		// Outside of UnixFS, there's no general notion of an "entity" larger than a block.
+		return nil, scope, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported dag-scope %q", scope)
+	}
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, req request) {
+	ctx := r.Context()
+	chooser := h.LinkTargetNodePrototypeChooser
+	if chooser == nil {
+		chooser = func(datamodel.Link, linking.LinkContext) (datamodel.NodePrototype, error) {
+			return basicnode.Prototype.Any, nil
+		}
+	}
+
+	// Every block loaded is sent in a CAR response.
+	// Those loaded while resolving the path are held until we know the path resolves,
+	// and the ones loaded after that are written as they're loaded.
+	cw := &carWriter{seen: make(map[string]struct{})}
+	lsys := h.LinkSystem
+	lsys.StorageReadOpener = func(lnkCtx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		data, err := h.LinkSystem.LoadRaw(lnkCtx, lnk)
+		if err != nil {
+			return nil, err
+		}
+		if err := cw.add(lnk, data); err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	}
+	// The blocks have been verified by LoadRaw already.
+	lsys.TrustedStorage = true
+
+	prog := traversal.Progress{
+		Cfg: &traversal.Config{
+			Ctx:                            ctx,
+			LinkSystem:                     lsys,
+			LinkTargetNodePrototypeChooser: chooser,
+		},
+		Budget: h.Budget.Clone(),
+	}
+	lnkCtx := linking.LinkContext{Ctx: ctx}
+	var (
+		n        datamodel.Node
+		terminal datamodel.Link = req.root
+	)
+	if req.path.Len() == 0 && req.sel == nil {
+		// There's no need to decode anything, so don't: this way, blocks in codecs we don't know can still be served.
+		data, err := lsys.LoadRaw(lnkCtx, req.root)
+		if err == nil {
+			err = cw.add(req.root, data)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not load %s: %s", req.root, err), http.StatusNotFound)
+			return
+		}
+	} else {
+		np, err := chooser(req.root, lnkCtx)
+		if err == nil {
+			n, err = lsys.Load(lnkCtx, req.root, np)
+		}
+		if err == nil {
+			err = prog.Focus(n, req.path, func(p traversal.Progress, reached datamodel.Node) error {
+				prog, n = p, reached
+				if p.LastBlock.Link != nil {
+					terminal = p.LastBlock.Link
+				}
+				return nil
+			})
+		}
+		if err != nil {
+			var budgetErr *traversal.ErrBudgetExceeded
+			if errors.As(err, &budgetErr) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, fmt.Sprintf("could not resolve %s: %s", r.URL.Path, err), http.StatusNotFound)
+			}
+			return
+		}
+	}
+
+	// Now we know what we're sending, and can check whether the client already has it.
+	var etag string
+	if req.format == "raw" {
+		etag = fmt.Sprintf(`"%s.raw"`, terminal)
+	} else {
+		hasher := fnv.New64a()
+		fmt.Fprintf(hasher, "%s\x00%s", req.path, req.selectorKey)
+		etag = fmt.Sprintf(`"%s.car.%x"`, req.root, hasher.Sum64())
+	}
+	header := w.Header()
+	header.Set("Etag", etag)
+	header.Set("Cache-Control", "public, max-age=29030400, immutable")
+	header.Set("Vary", "Accept")
+	header.Set("X-Content-Type-Options", "nosniff")
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if req.format == "raw" {
+		data := cw.pending[terminal.Binary()]
+		header.Set("Content-Type", contentTypeRaw)
+		header.Set("Content-Length", fmt.Sprint(len(data)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			w.Write(data)
+		}
+		return
+	}
+
+	header.Set("Content-Type", contentTypeCAR+"; version=1; order=dfs; dups=n")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := cw.start(w, req.root); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if req.sel != nil {
+		// The traversal gets a budget of its own, rather than whatever path resolution left of it.
+		prog.Budget = h.Budget.Clone()
+		if err := prog.WalkAdv(n, req.sel, func(traversal.Progress, datamodel.Node, traversal.VisitReason) error { return nil }); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// matchesETag checks an If-None-Match header against an ETag.
+func matchesETag(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// carWriter accumulates blocks until the response starts, and then writes each new one as it arrives.
+type carWriter struct {
+	w       io.Writer
+	seen    map[string]struct{}
+	order   []datamodel.Link
+	pending map[string][]byte
+}
+
+func (cw *carWriter) add(lnk datamodel.Link, data []byte) error {
+	key := lnk.Binary()
+	if _, ok := cw.seen[key]; ok {
+		return nil
+	}
+	cw.seen[key] = struct{}{}
+	if cw.w == nil {
+		if cw.pending == nil {
+			cw.pending = make(map[string][]byte)
+		}
+		cw.order = append(cw.order, lnk)
+		cw.pending[key] = data
+		return nil
+	}
+	return writeSection(cw.w, []byte(key), data)
+}
+
+// start writes the CAR header, and the blocks accumulated so far.
+func (cw *carWriter) start(w io.Writer, root datamodel.Link) error {
+	header, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "roots", qp.List(1, func(la datamodel.ListAssembler) {
+			qp.ListEntry(la, qp.Link(root))
+		}))
+		qp.MapEntry(ma, "version", qp.Int(1))
+	})
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := dagcbor.Encode(header, &buf); err != nil {
+		return err
+	}
+	if err := writeSection(w, buf.Bytes()); err != nil {
+		return err
+	}
+	for _, lnk := range cw.order {
+		if err := writeSection(w, []byte(lnk.Binary()), cw.pending[lnk.Binary()]); err != nil {
+			return err
+		}
+	}
+	cw.w = w
+	cw.order, cw.pending = nil, nil
+	return nil
+}
+
+// writeSection writes a CAR section: a uvarint length prefix, and then the parts.
+func writeSection(w io.Writer, parts ...[]byte) error {
+	var length int
+	for _, part := range parts {
+		length += len(part)
+	}
+	if _, err := w.Write(binary.AppendUvarint(nil, uint64(length))); err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package gatewayserver_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage/gatewayserver"
+	"github.com/ipld/go-ipld-prime/storage/gatewaystore"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/traversal"
+)
+
+// fixture stores a small DAG:
+// a root {"a": &mid, "b": "value"}, a mid {"leaf": &leaf, "list": [1, 2]}, and a raw leaf.
+func fixture(t *testing.T) (*memstore.Store, cid.Cid, cid.Cid, cid.Cid) {
+	blocks := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetWriteStorage(blocks)
+	store := func(codec uint64, n datamodel.Node) cid.Cid {
+		lp := cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: codec, MhType: multihash.SHA2_256, MhLength: -1}}
+		lnk, err := lsys.Store(linking.LinkContext{}, lp, n)
+		qt.Assert(t, err, qt.IsNil)
+		return lnk.(cidlink.Link).Cid
+	}
+	build := func(fn func(ma datamodel.MapAssembler)) datamodel.Node {
+		n, err := qp.BuildMap(basicnode.Prototype.Any, -1, fn)
+		qt.Assert(t, err, qt.IsNil)
+		return n
+	}
+	leaf := store(cid.Raw, basicnode.NewBytes([]byte("leaf")))
+	mid := store(cid.DagCBOR, build(func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "leaf", qp.Link(cidlink.Link{Cid: leaf}))
+		qp.MapEntry(ma, "list", qp.List(2, func(la datamodel.ListAssembler) {
+			qp.ListEntry(la, qp.Int(1))
+			qp.ListEntry(la, qp.Int(2))
+		}))
+	}))
+	root := store(cid.DagCBOR, build(func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "a", qp.Link(cidlink.Link{Cid: mid}))
+		qp.MapEntry(ma, "b", qp.String("value"))
+	}))
+	return blocks, root, mid, leaf
+}
+
+func serve(t *testing.T, blocks *memstore.Store, h *gatewayserver.Handler) string {
+	h.LinkSystem = cidlink.DefaultLinkSystem()
+	h.LinkSystem.SetReadStorage(blocks)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func get(t *testing.T, method, url string, header ...string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, nil)
+	qt.Assert(t, err, qt.IsNil)
+	for i := 0; i < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	qt.Assert(t, err, qt.IsNil)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	qt.Assert(t, err, qt.IsNil)
+	return resp, body
+}
+
+// readCAR returns the CIDs of the blocks in a CAR, in order.
+func readCAR(t *testing.T, body io.Reader) []string {
+	br := bufio.NewReader(body)
+	var cids []string
+	for i := 0; ; i++ {
+		length, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return cids
+		}
+		qt.Assert(t, err, qt.IsNil)
+		section := make([]byte, length)
+		_, err = io.ReadFull(br, section)
+		qt.Assert(t, err, qt.IsNil)
+		if i == 0 {
+			continue // the header.
+		}
+		_, c, err := cid.CidFromBytes(section)
+		qt.Assert(t, err, qt.IsNil)
+		cids = append(cids, c.String())
+	}
+}
+
+func strs(cids ...cid.Cid) []string {
+	var ss []string
+	for _, c := range cids {
+		ss = append(ss, c.String())
+	}
+	return ss
+}
+
+func TestRaw(t *testing.T) {
+	blocks, root, mid, leaf := fixture(t)
+	base := serve(t, blocks, &gatewayserver.Handler{})
+	rootData, _ := blocks.Get(context.Background(), root.KeyString())
+
+	resp, body := get(t, "GET", base+"/ipfs/"+root.String()+"?format=raw")
+	qt.Assert(t, resp.StatusCode, qt.Equals, http.StatusOK)
+	qt.Check(t, body, qt.DeepEquals, rootData)
+	qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, "application/vnd.ipld.raw")
+	qt.Check(t, resp.Header.Get("Etag"), qt.Equals, `"`+root.String()+`.raw"`)
+
+	// Negotiated by Accept header too.
+	resp, body = get(t, "GET", base+"/ipfs/"+root.String(), "Accept", "text/html, application/vnd.ipld.raw")
+	qt.Assert(t, resp.StatusCode, qt.Equals, http.StatusOK)
+	qt.Check(t, body, qt.DeepEquals, rootData)
+
+	// Clients that have it already needn't get it again.
+	resp, _ = get(t, "GET", base+"/ipfs/"+root.String()+"?format=raw", "If-None-Match", `"`+root.String()+`.raw"`)
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusNotModified)
+
+	resp, body = get(t, "HEAD", base+"/ipfs/"+root.String()+"?format=raw")
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusOK)
+	qt.Check(t, resp.ContentLength, qt.Equals, int64(len(rootData)))
+	qt.Check(t, body, qt.HasLen, 0)
+
+	// Paths lead to the block containing what they reach.
+	for path, want := range map[string]cid.Cid{
+		"/a/leaf":   leaf,
+		"/a":        mid,
+		"/a/list/1": mid,
+		"/b":        root,
+	} {
+		resp, body = get(t, "GET", base+"/ipfs/"+root.String()+path+"?format=raw")
+		qt.Assert(t, resp.StatusCode, qt.Equals, http.StatusOK, qt.Commentf("path %s", path))
+		wantData, _ := blocks.Get(context.Background(), want.KeyString())
+		qt.Check(t, body, qt.DeepEquals, wantData, qt.Commentf("path %s", path))
+		qt.Check(t, resp.Header.Get("Etag"), qt.Equals, `"`+want.String()+`.raw"`)
+	}
+
+	// And they can be fetched with the gatewaystore client.
+	client := &gatewaystore.Store{Endpoints: []string{base}}
+	data, err := client.Get(context.Background(), leaf.KeyString())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(data), qt.Equals, "leaf")
+}
+
+func TestRawUnknownCodec(t *testing.T) {
+	// Blocks can be served without being decoded, as long as there's no path.
+	blocks := &memstore.Store{}
+	mh, _ := multihash.Sum([]byte("opaque"), multihash.SHA2_256, -1)
+	c := cid.NewCidV1(0x300000, mh)
+	blocks.Put(context.Background(), c.KeyString(), []byte("opaque"))
+	base := serve(t, blocks, &gatewayserver.Handler{})
+
+	resp, body := get(t, "GET", base+"/ipfs/"+c.String()+"?format=raw")
+	qt.Assert(t, resp.StatusCode, qt.Equals, http.StatusOK)
+	qt.Check(t, string(body), qt.Equals, "opaque")
+	resp, _ = get(t, "GET", base+"/ipfs/"+c.String()+"?format=car&dag-scope=block")
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusOK)
+	resp, _ = get(t, "GET", base+"/ipfs/"+c.String()+"/x?format=raw")
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusNotFound)
+}
+
+func TestErrors(t *testing.T) {
+	blocks, root, _, _ := fixture(t)
+	base := serve(t, blocks, &gatewayserver.Handler{})
+	mh, _ := multihash.Sum([]byte("absent"), multihash.SHA2_256, -1)
+	absent := cid.NewCidV1(cid.Raw, mh)
+	// A sha2-256 digest can only be 32 bytes long; this CID claims 40.
+	long := cid.NewCidV1(cid.Raw, append([]byte{multihash.SHA2_256, 40}, make([]byte, 40)...))
+
+	for _, tc := range []struct {
+		method, url string
+		header      []string
+		status      int
+	}{
+		{"GET", "/ipfs/" + absent.String() + "?format=raw", nil, http.StatusNotFound},
+		{"GET", "/ipfs/" + root.String() + "/nope?format=raw", nil, http.StatusNotFound},
+		{"GET", "/ipfs/" + root.String() + "/b/deeper?format=car", nil, http.StatusNotFound},
+		{"GET", "/ipfs/notacid?format=raw", nil, http.StatusBadRequest},
+		{"GET", "/ipfs/" + long.String() + "?format=raw", nil, http.StatusBadRequest},
+		{"GET", "/ipfs/" + root.String(), nil, http.StatusNotAcceptable},
+		{"GET", "/ipfs/" + root.String(), []string{"Accept", "application/vnd.ipld.car; version=2"}, http.StatusNotAcceptable},
+		{"GET", "/ipfs/" + root.String() + "?format=html", nil, http.StatusNotAcceptable},
+		{"GET", "/ipfs/" + root.String() + "?format=car&dag-scope=most", nil, http.StatusBadRequest},
+		{"GET", "/ipfs/" + root.String() + "?format=car&selector=" + url.QueryEscape(`{".":{}}`), nil, http.StatusBadRequest},
+		{"POST", "/ipfs/" + root.String() + "?format=raw", nil, http.StatusMethodNotAllowed},
+		{"GET", "/ipns/" + root.String() + "?format=raw", nil, http.StatusNotFound},
+	} {
+		resp, _ := get(t, tc.method, base+tc.url, tc.header...)
+		qt.Check(t, resp.StatusCode, qt.Equals, tc.status, qt.Commentf("%s %s", tc.method, tc.url))
+	}
+}
+
+func TestCAR(t *testing.T) {
+	ctx := context.Background()
+	blocks, root, mid, leaf := fixture(t)
+	base := serve(t, blocks, &gatewayserver.Handler{AllowSelectors: true})
+
+	car := func(path, query string) (*http.Response, []string) {
+		resp, err := http.Get(base + "/ipfs/" + root.String() + path + "?format=car&" + query)
+		qt.Assert(t, err, qt.IsNil)
+		defer resp.Body.Close()
+		qt.Assert(t, resp.StatusCode, qt.Equals, http.StatusOK)
+		return resp, readCAR(t, resp.Body)
+	}
+
+	resp, cids := car("", "")
+	qt.Check(t, resp.Header.Get("Content-Type"), qt.Equals, "application/vnd.ipld.car; version=1; order=dfs; dups=n")
+	qt.Check(t, cids, qt.DeepEquals, strs(root, mid, leaf))
+	allETag := resp.Header.Get("Etag")
+
+	resp, cids = car("", "dag-scope=block")
+	qt.Check(t, cids, qt.DeepEquals, strs(root))
+	qt.Check(t, resp.Header.Get("Etag"), qt.Not(qt.Equals), allETag)
+
+	// The blocks along the path are included.
+	_, cids = car("/a/leaf", "dag-scope=all")
+	qt.Check(t, cids, qt.DeepEquals, strs(root, mid, leaf))
+	_, cids = car("/a", "dag-scope=block")
+	qt.Check(t, cids, qt.DeepEquals, strs(root, mid))
+	_, cids = car("/a/list", "dag-scope=all")
+	qt.Check(t, cids, qt.DeepEquals, strs(root, mid))
+
+	// Selectors can choose what's included.
+	_, cids = car("", "selector="+url.QueryEscape(`{"f":{"f>":{"a":{".":{}}}}}`))
+	qt.Check(t, cids, qt.DeepEquals, strs(root, mid))
+
+	// The gatewaystore client can fetch the lot.
+	into := &memstore.Store{}
+	client := &gatewaystore.Store{Endpoints: []string{base}}
+	qt.Assert(t, client.FetchDAG(ctx, root.KeyString(), gatewaystore.ScopeAll, into), qt.IsNil)
+	qt.Check(t, into.Bag, qt.HasLen, 3)
+}
+
+func TestBudget(t *testing.T) {
+	ctx := context.Background()
+	blocks, root, mid, leaf := fixture(t)
+	base := serve(t, blocks, &gatewayserver.Handler{Budget: &traversal.Budget{NodeBudget: 100, LinkBudget: 1}})
+
+	// Within budget.
+	resp, _ := get(t, "GET", base+"/ipfs/"+root.String()+"/a?format=raw")
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusOK)
+
+	// Paths that need more are refused.
+	resp, _ = get(t, "GET", base+"/ipfs/"+root.String()+"/a/leaf?format=raw")
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusBadRequest)
+
+	// The traversal for a CAR gets a budget of its own, after the one used to resolve the path.
+	resp, body := get(t, "GET", base+"/ipfs/"+root.String()+"/a?format=car&dag-scope=all")
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusOK)
+	qt.Check(t, readCAR(t, bytes.NewReader(body)), qt.DeepEquals, strs(root, mid, leaf))
+
+	// CARs that need more are cut off.
+	client := &gatewaystore.Store{Endpoints: []string{base}}
+	err := client.FetchDAG(ctx, root.KeyString(), gatewaystore.ScopeAll, &memstore.Store{})
+	qt.Check(t, err, qt.ErrorMatches, "gatewaystore: could not fetch .*")
+
+	// Each request gets the whole budget.
+	resp, _ = get(t, "GET", base+"/ipfs/"+root.String()+"/a?format=raw")
+	qt.Check(t, resp.StatusCode, qt.Equals, http.StatusOK)
+}
@@ -0,0 +1,145 @@
+package gatewaystore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage"
+)
+
+// DagScope selects how much of a DAG FetchDAG asks for.
+// These are the values of the trustless gateway protocol's "dag-scope" parameter.
+type DagScope string
+
+const (
+	// ScopeAll asks for the whole DAG.
+	ScopeAll DagScope = "all"
+
+	// ScopeEntity asks for the blocks needed to read the root "entity":
+	// for example, the whole of a file, or the root block of a directory (including its sharding, if any), but not its children.
+	ScopeEntity DagScope = "entity"
+
+	// ScopeBlock asks for the root block alone.
+	ScopeBlock DagScope = "block"
+)
+
+// FetchDAG requests a DAG from a gateway in CAR format, and writes every block in it into the given storage.
+//
+// Each block is verified against its CID before it's written.
+// If a request fails partway through, the whole DAG is requested again from the next endpoint
+// (so the storage may receive some blocks more than once),
+// and the blocks that had been written already stay in the storage even if all the attempts fail.
+//
+// FetchDAG doesn't check that the blocks it receives are all part of the requested DAG,
+// nor that none are missing:
+// a traversal of the DAG, using a LinkSystem reading from the storage, is the way to be sure of that.
+func (store *Store) FetchDAG(ctx context.Context, key string, scope DagScope, into storage.WritableStorage) error {
+	c, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	query := "format=car&dag-scope=" + string(scope)
+	return store.try(ctx, c, func(ctx context.Context, cancel context.CancelFunc, endpoint string) error {
+		defer cancel()
+		resp, err := store.request(ctx, http.MethodGet, endpoint, c, query, "application/vnd.ipld.car;version=1;order=dfs;dups=n")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := store.readCAR(ctx, c, resp.Body, into); err != nil {
+			return fmt.Errorf("%s: %w", endpoint, err)
+		}
+		return nil
+	})
+}
+
+// readCAR reads a CARv1 stream, verifying each block and writing it into storage.
+// The root is expected to be the first block, as it is in every order a gateway may respond with.
+func (store *Store) readCAR(ctx context.Context, root cid.Cid, r io.Reader, into storage.WritableStorage) error {
+	br := bufio.NewReader(r)
+
+	// The header is a length-prefixed dag-cbor map, which we only need the version from.
+	header, err := readSection(br, store.maxBlockSize())
+	if err != nil {
+		return fmt.Errorf("could not read CAR header: %w", err)
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := dagcbor.Decode(nb, bytes.NewReader(header)); err != nil {
+		return fmt.Errorf("could not decode CAR header: %w", err)
+	}
+	version, err := nb.Build().LookupByString("version")
+	if err != nil {
+		return fmt.Errorf("invalid CAR header: %w", err)
+	}
+	if v, err := version.AsInt(); err != nil || v != 1 {
+		return fmt.Errorf("invalid CAR header: unsupported version")
+	}
+
+	for first := true; ; first = false {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		section, err := readSection(br, store.maxBlockSize()+cidMaxLen)
+		if err == io.EOF {
+			if first {
+				return fmt.Errorf("CAR contains no blocks")
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read CAR section: %w", err)
+		}
+		n, c, err := cid.CidFromBytes(section)
+		if err != nil {
+			return fmt.Errorf("invalid CID in CAR section: %w", err)
+		}
+		if first && !c.Equals(root) {
+			return fmt.Errorf("CAR starts with %s, not the requested root", c)
+		}
+		content := section[n:]
+		lnk, hasher, err := store.hasherFor(c)
+		if err != nil {
+			return err
+		}
+		hasher.Write(content)
+		if err := check(lnk, hasher); err != nil {
+			return err
+		}
+		if err := into.Put(ctx, c.KeyString(), content); err != nil {
+			return err
+		}
+	}
+}
+
+// cidMaxLen is a generous upper bound on how long the CID at the start of a CAR section can be.
+const cidMaxLen = 256
+
+// readSection reads a uvarint length prefix, and then that much data.
+// It returns io.EOF only if the stream ends cleanly before the length.
+func readSection(br *bufio.Reader, limit int64) ([]byte, error) {
+	length, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(limit) {
+		return nil, fmt.Errorf("section is %d bytes, which is more than the limit of %d", length, limit)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
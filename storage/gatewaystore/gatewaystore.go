@@ -0,0 +1,359 @@
+/*
+The gatewaystore package offers a read-only storage system which fetches blocks over HTTP
+from IPFS "trustless gateways" (https://specs.ipfs.tech/http-gateways/trustless-gateway/).
+
+Nothing a gateway returns is trusted: every block is hashed and checked against the CID it was requested by,
+and a gateway that returns the wrong content is treated the same as one that's unreachable.
+
+A Store can be given to LinkSystem.SetReadStorage like any other storage system,
+which makes it usable as the LinkSystem's BlockReadOpener.
+For fetching whole DAGs at once, rather than a request per block, see Store.FetchDAG.
+*/
+package gatewaystore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// DefaultMaxBlockSize is the largest block a Store will accept if its MaxBlockSize isn't set.
+// It matches the limit that IPFS implementations generally apply to blocks they exchange.
+const DefaultMaxBlockSize = 2 << 20
+
+// Store implements go-ipld-prime/storage.ReadableStorage and go-ipld-prime/storage.StreamingReadableStorage
+// by requesting blocks from one or more trustless gateways.
+//
+// Keys must be binary CIDs, as they are when using LinkSystem.SetReadStorage.
+//
+// Each operation tries the endpoints in order, moving on to the next whenever one fails
+// (including by returning content that doesn't match the CID),
+// and goes round them all again, up to Retries more times, if none succeeded.
+// A key that every endpoint reports as not found is not retried.
+type Store struct {
+	// Endpoints are the base URLs of the gateways, such as "https://trustless-gateway.link".
+	// They're tried in order.
+	Endpoints []string
+
+	// Client is used to make requests.
+	// Optional: by default, http.DefaultClient is used.
+	Client *http.Client
+
+	// Timeout, if set, limits how long each request may take, including reading the response body.
+	Timeout time.Duration
+
+	// Retries is how many more times to try the endpoints, after the first round of attempts has failed.
+	Retries int
+
+	// RetryDelay is how long to wait before the first retry; it doubles before each one after that.
+	RetryDelay time.Duration
+
+	// MaxBlockSize is the largest block that will be accepted.
+	// Optional: by default, DefaultMaxBlockSize is used.
+	MaxBlockSize int64
+
+	// HasherChooser picks the hash function used to verify each block.
+	// Optional: by default, the one from cidlink.DefaultLinkSystem is used.
+	HasherChooser func(datamodel.LinkPrototype) (hash.Hash, error)
+}
+
+var defaultHasherChooser = cidlink.DefaultLinkSystem().HasherChooser
+
+// errNotFound is returned when no gateway has the content.
+// Errors from individual requests which found nothing wrap it.
+var errNotFound = errors.New("404") // FIXME this needs a standard error type
+
+func (store *Store) client() *http.Client {
+	if store.Client != nil {
+		return store.Client
+	}
+	return http.DefaultClient
+}
+
+func (store *Store) maxBlockSize() int64 {
+	if store.MaxBlockSize > 0 {
+		return store.MaxBlockSize
+	}
+	return DefaultMaxBlockSize
+}
+
+// hasherFor sets up a hasher for verifying the content of a CID.
+func (store *Store) hasherFor(c cid.Cid) (cidlink.Link, hash.Hash, error) {
+	lnk := cidlink.Link{Cid: c}
+	chooser := store.HasherChooser
+	if chooser == nil {
+		chooser = defaultHasherChooser
+	}
+	hasher, err := chooser(lnk.Prototype())
+	if err != nil {
+		return cidlink.Link{}, nil, fmt.Errorf("gatewaystore: %w", err)
+	}
+	// A digest that isn't as long as the hash function's output could never be matched
+	// (identity digests, being the content itself, are the exception).
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return cidlink.Link{}, nil, fmt.Errorf("gatewaystore: invalid multihash in %s: %w", c, err)
+	}
+	if dmh.Code != multihash.IDENTITY && dmh.Length != hasher.Size() {
+		return cidlink.Link{}, nil, fmt.Errorf("gatewaystore: %s has a %d-byte digest, but its hash function produces %d bytes", c, dmh.Length, hasher.Size())
+	}
+	return lnk, hasher, nil
+}
+
+// check compares what was hashed against the CID, which must have come from hasherFor.
+func check(lnk cidlink.Link, hasher hash.Hash) error {
+	sum := hasher.Sum(nil)
+	if dmh, err := multihash.Decode(lnk.Hash()); err == nil && bytes.Equal(dmh.Digest, sum) {
+		return nil
+	}
+	prefix := lnk.Prefix()
+	prefix.MhLength = -1
+	return linking.ErrHashMismatch{Actual: cidlink.LinkPrototype{Prefix: prefix}.BuildLink(sum), Expected: lnk}
+}
+
+func parseKey(key string) (cid.Cid, error) {
+	c, err := cid.Cast([]byte(key))
+	if err != nil {
+		return cid.Undef, fmt.Errorf("gatewaystore: key is not a CID: %w", err)
+	}
+	return c, nil
+}
+
+// identityContent returns the content of a CID that uses the identity multihash,
+// which is inside the CID itself, so there's no need to ask a gateway for it.
+func identityContent(c cid.Cid) ([]byte, bool) {
+	if c.Prefix().MhType != multihash.IDENTITY {
+		return nil, false
+	}
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return nil, false
+	}
+	return dmh.Digest, true
+}
+
+// try calls attempt for each endpoint in turn, and then again for each retry, until one succeeds.
+// attempt is given a context that's limited by the Store's Timeout;
+// if it succeeds, it takes responsibility for calling the cancel function.
+func (store *Store) try(ctx context.Context, c cid.Cid, attempt func(ctx context.Context, cancel context.CancelFunc, endpoint string) error) error {
+	if len(store.Endpoints) == 0 {
+		return fmt.Errorf("gatewaystore: no endpoints configured")
+	}
+	delay := store.RetryDelay
+	var errs []error
+	for round := 0; round <= store.Retries; round++ {
+		if round > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			delay *= 2
+		}
+		errs = errs[:0]
+		notFound := 0
+		for _, endpoint := range store.Endpoints {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			actx, cancel := ctx, context.CancelFunc(func() {})
+			if store.Timeout > 0 {
+				actx, cancel = context.WithTimeout(ctx, store.Timeout)
+			}
+			err := attempt(actx, cancel, strings.TrimSuffix(endpoint, "/"))
+			if err == nil {
+				return nil
+			}
+			cancel()
+			if errors.Is(err, errNotFound) {
+				notFound++
+			}
+			errs = append(errs, err)
+		}
+		if notFound == len(store.Endpoints) {
+			return errNotFound
+		}
+	}
+	return fmt.Errorf("gatewaystore: could not fetch %s: %w", c, errors.Join(errs...))
+}
+
+// request makes a request to one endpoint, and returns the response if its status is 200.
+func (store *Store) request(ctx context.Context, method, endpoint string, c cid.Cid, query, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+"/ipfs/"+c.String()+"?"+query, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := store.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound, http.StatusGone:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", endpoint, errNotFound)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", endpoint, resp.Status)
+	}
+}
+
+// Has implements go-ipld-prime/storage.Storage.Has.
+//
+// It makes a HEAD request, so nothing is downloaded or verified;
+// a gateway that claims to have content that it doesn't actually have can mislead it.
+func (store *Store) Has(ctx context.Context, key string) (bool, error) {
+	c, err := parseKey(key)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := identityContent(c); ok {
+		return true, nil
+	}
+	err = store.try(ctx, c, func(ctx context.Context, cancel context.CancelFunc, endpoint string) error {
+		defer cancel()
+		resp, err := store.request(ctx, http.MethodHead, endpoint, c, "format=raw", "application/vnd.ipld.raw")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	})
+	if errors.Is(err, errNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Get implements go-ipld-prime/storage.ReadableStorage.Get.
+func (store *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	c, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if content, ok := identityContent(c); ok {
+		return content, nil
+	}
+	var content []byte
+	err = store.try(ctx, c, func(ctx context.Context, cancel context.CancelFunc, endpoint string) error {
+		defer cancel()
+		r, err := store.open(ctx, cancel, endpoint, c)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		content, err = io.ReadAll(r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// GetStream implements go-ipld-prime/storage.StreamingReadableStorage.GetStream.
+//
+// Failing over to another endpoint is only possible until the response starts arriving:
+// after that, any problem (including a timeout) is returned from Read.
+// The content can only be verified once all of it has been read,
+// so a mismatch is reported by the final Read returning a linking.ErrHashMismatch, rather than io.EOF.
+// Callers must not trust anything they've read from the stream until they've seen io.EOF.
+func (store *Store) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	c, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if content, ok := identityContent(c); ok {
+		return io.NopCloser(strings.NewReader(string(content))), nil
+	}
+	var r io.ReadCloser
+	err = store.try(ctx, c, func(ctx context.Context, cancel context.CancelFunc, endpoint string) error {
+		r, err = store.open(ctx, cancel, endpoint, c)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open requests a raw block from one endpoint,
+// and returns a reader that verifies it, and calls cancel when closed.
+func (store *Store) open(ctx context.Context, cancel context.CancelFunc, endpoint string, c cid.Cid) (io.ReadCloser, error) {
+	lnk, hasher, err := store.hasherFor(c)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := store.request(ctx, http.MethodGet, endpoint, c, "format=raw", "application/vnd.ipld.raw")
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength > store.maxBlockSize() {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: block is %d bytes, which is more than the limit of %d", endpoint, resp.ContentLength, store.maxBlockSize())
+	}
+	return &verifyingReader{
+		body:      resp.Body,
+		cancel:    cancel,
+		endpoint:  endpoint,
+		lnk:       lnk,
+		hasher:    hasher,
+		remaining: store.maxBlockSize(),
+	}, nil
+}
+
+type verifyingReader struct {
+	body      io.ReadCloser
+	cancel    context.CancelFunc
+	endpoint  string
+	lnk       cidlink.Link
+	hasher    hash.Hash
+	remaining int64 // how much more may be read before the block is too large.
+	err       error // sticky result of the check, once done.
+}
+
+func (vr *verifyingReader) Read(p []byte) (int, error) {
+	if vr.err != nil {
+		return 0, vr.err
+	}
+	if int64(len(p)) > vr.remaining+1 {
+		p = p[:vr.remaining+1]
+	}
+	n, err := vr.body.Read(p)
+	vr.remaining -= int64(n)
+	if vr.remaining < 0 {
+		vr.err = fmt.Errorf("%s: block is larger than the limit", vr.endpoint)
+		return 0, vr.err
+	}
+	vr.hasher.Write(p[:n])
+	if err == io.EOF {
+		if err := check(vr.lnk, vr.hasher); err != nil {
+			vr.err = fmt.Errorf("%s: %w", vr.endpoint, err)
+		} else {
+			vr.err = io.EOF
+		}
+		return n, vr.err
+	}
+	return n, err
+}
+
+func (vr *verifyingReader) Close() error {
+	err := vr.body.Close()
+	vr.cancel()
+	return err
+}
@@ -0,0 +1,298 @@
+package gatewaystore_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage/gatewaystore"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+)
+
+// gateway is a stand-in for a trustless gateway, serving blocks from a memstore.
+type gateway struct {
+	blocks   *memstore.Store
+	order    []cid.Cid     // the order blocks are sent in CARs.
+	failures int32         // how many requests to fail before serving any.
+	lie      bool          // whether to serve the wrong content.
+	delay    time.Duration // how long to wait before responding.
+	requests atomic.Int32
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if g.requests.Add(1) <= g.failures {
+		http.Error(w, "try again later", http.StatusServiceUnavailable)
+		return
+	}
+	select {
+	case <-time.After(g.delay):
+	case <-r.Context().Done():
+		return
+	}
+	c, err := cid.Decode(strings.TrimPrefix(r.URL.Path, "/ipfs/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	content, err := g.blocks.Get(r.Context(), c.KeyString())
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if g.lie {
+		content = append([]byte("not "), content...)
+	}
+	switch r.URL.Query().Get("format") {
+	case "raw":
+		w.Header().Set("Content-Type", "application/vnd.ipld.raw")
+		w.Write(content)
+	case "car":
+		w.Header().Set("Content-Type", "application/vnd.ipld.car")
+		header, _ := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "roots", qp.List(1, func(la datamodel.ListAssembler) {
+				qp.ListEntry(la, qp.Link(cidlink.Link{Cid: c}))
+			}))
+			qp.MapEntry(ma, "version", qp.Int(1))
+		})
+		var buf bytes.Buffer
+		dagcbor.Encode(header, &buf)
+		writeSection(w, buf.Bytes())
+		for _, bc := range g.order {
+			content, _ := g.blocks.Get(r.Context(), bc.KeyString())
+			if g.lie {
+				content = append([]byte("not "), content...)
+			}
+			writeSection(w, append(bc.Bytes(), content...))
+		}
+	default:
+		http.Error(w, "unsupported format", http.StatusBadRequest)
+	}
+}
+
+func writeSection(w io.Writer, section []byte) {
+	w.Write(binary.AppendUvarint(nil, uint64(len(section))))
+	w.Write(section)
+}
+
+// fixture stores a small DAG: a dag-cbor root linking to two raw leaves.
+func fixture(t *testing.T) (*memstore.Store, []cid.Cid) {
+	blocks := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetWriteStorage(blocks)
+	store := func(lp cidlink.LinkPrototype, n datamodel.Node) cid.Cid {
+		lnk, err := lsys.Store(linking.LinkContext{}, lp, n)
+		qt.Assert(t, err, qt.IsNil)
+		return lnk.(cidlink.Link).Cid
+	}
+	raw := cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: cid.Raw, MhType: multihash.SHA2_256, MhLength: -1}}
+	cbor := cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: multihash.SHA2_256, MhLength: -1}}
+	leaf1 := store(raw, basicnode.NewBytes([]byte("leaf one")))
+	leaf2 := store(raw, basicnode.NewBytes([]byte("leaf two")))
+	n, err := qp.BuildMap(basicnode.Prototype.Any, 2, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "one", qp.Link(cidlink.Link{Cid: leaf1}))
+		qp.MapEntry(ma, "two", qp.Link(cidlink.Link{Cid: leaf2}))
+	})
+	qt.Assert(t, err, qt.IsNil)
+	root := store(cbor, n)
+	return blocks, []cid.Cid{root, leaf1, leaf2}
+}
+
+func serve(t *testing.T, g *gateway) string {
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestGet(t *testing.T) {
+	ctx := context.Background()
+	blocks, cids := fixture(t)
+	g := &gateway{blocks: blocks}
+	store := &gatewaystore.Store{Endpoints: []string{serve(t, g) + "/"}}
+	leaf := cids[1].KeyString()
+
+	has, err := store.Has(ctx, leaf)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, has, qt.IsTrue)
+	content, err := store.Get(ctx, leaf)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(content), qt.Equals, "leaf one")
+	r, err := store.GetStream(ctx, leaf)
+	qt.Assert(t, err, qt.IsNil)
+	content, err = io.ReadAll(r)
+	qt.Check(t, err, qt.IsNil)
+	qt.Check(t, string(content), qt.Equals, "leaf one")
+	qt.Check(t, r.Close(), qt.IsNil)
+
+	// Works through a LinkSystem too.
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	n, err := lsys.Load(linking.LinkContext{Ctx: ctx}, cidlink.Link{Cid: cids[0]}, basicnode.Prototype.Any)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, n.Length(), qt.Equals, int64(2))
+
+	// Absent content.
+	mh, _ := multihash.Sum([]byte("absent"), multihash.SHA2_256, -1)
+	absent := cid.NewCidV1(cid.Raw, mh).KeyString()
+	has, err = store.Has(ctx, absent)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, has, qt.IsFalse)
+	_, err = store.Get(ctx, absent)
+	qt.Check(t, err, qt.ErrorMatches, "404")
+
+	// Identity CIDs don't need a request.
+	before := g.requests.Load()
+	mh, _ = multihash.Sum([]byte("inline"), multihash.IDENTITY, -1)
+	content, err = store.Get(ctx, cid.NewCidV1(cid.Raw, mh).KeyString())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(content), qt.Equals, "inline")
+	qt.Check(t, g.requests.Load(), qt.Equals, before)
+
+	_, err = store.Get(ctx, "not a cid")
+	qt.Check(t, err, qt.ErrorMatches, "gatewaystore: key is not a CID: .*")
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	blocks, cids := fixture(t)
+	down := &gateway{blocks: blocks, failures: 1000}
+	liar := &gateway{blocks: blocks, lie: true}
+	empty := &gateway{blocks: &memstore.Store{}}
+	good := &gateway{blocks: blocks}
+	store := &gatewaystore.Store{Endpoints: []string{serve(t, down), serve(t, liar), serve(t, empty), serve(t, good)}}
+
+	content, err := store.Get(ctx, cids[1].KeyString())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(content), qt.Equals, "leaf one")
+	for _, g := range []*gateway{down, liar, empty, good} {
+		qt.Check(t, g.requests.Load(), qt.Equals, int32(1))
+	}
+
+	// If they all fail, all the reasons are reported.
+	store.Endpoints = store.Endpoints[:3]
+	_, err = store.Get(ctx, cids[1].KeyString())
+	qt.Check(t, err, qt.ErrorMatches, `(?s)gatewaystore: could not fetch .*: 503 Service Unavailable\n.*hash mismatch.*\n.*404`)
+	var mismatch linking.ErrHashMismatch
+	qt.Check(t, errors.As(err, &mismatch), qt.IsTrue)
+
+	// Streams are verified as they're read, and can't fail over once they've started.
+	store.Endpoints = store.Endpoints[1:2]
+	r, err := store.GetStream(ctx, cids[1].KeyString())
+	qt.Assert(t, err, qt.IsNil)
+	_, err = io.ReadAll(r)
+	qt.Check(t, errors.As(err, &mismatch), qt.IsTrue)
+	r.Close()
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+	blocks, cids := fixture(t)
+
+	g := &gateway{blocks: blocks, failures: 2}
+	store := &gatewaystore.Store{Endpoints: []string{serve(t, g)}, Retries: 1, RetryDelay: time.Millisecond}
+	_, err := store.Get(ctx, cids[1].KeyString())
+	qt.Check(t, err, qt.ErrorMatches, ".*503 Service Unavailable")
+	qt.Check(t, g.requests.Load(), qt.Equals, int32(2))
+
+	g = &gateway{blocks: blocks, failures: 2}
+	store = &gatewaystore.Store{Endpoints: []string{serve(t, g)}, Retries: 2, RetryDelay: time.Millisecond}
+	content, err := store.Get(ctx, cids[1].KeyString())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(content), qt.Equals, "leaf one")
+	qt.Check(t, g.requests.Load(), qt.Equals, int32(3))
+
+	// Content that isn't anywhere isn't retried.
+	g = &gateway{blocks: &memstore.Store{}}
+	store = &gatewaystore.Store{Endpoints: []string{serve(t, g)}, Retries: 5}
+	_, err = store.Get(ctx, cids[1].KeyString())
+	qt.Check(t, err, qt.ErrorMatches, "404")
+	qt.Check(t, g.requests.Load(), qt.Equals, int32(1))
+}
+
+func TestTimeout(t *testing.T) {
+	ctx := context.Background()
+	blocks, cids := fixture(t)
+	slow := &gateway{blocks: blocks, delay: time.Second}
+	store := &gatewaystore.Store{Endpoints: []string{serve(t, slow)}, Timeout: 20 * time.Millisecond}
+	_, err := store.Get(ctx, cids[1].KeyString())
+	qt.Check(t, errors.Is(err, context.DeadlineExceeded), qt.IsTrue)
+
+	// A slow endpoint is passed over for a faster one.
+	store.Endpoints = append(store.Endpoints, serve(t, &gateway{blocks: blocks}))
+	content, err := store.Get(ctx, cids[1].KeyString())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(content), qt.Equals, "leaf one")
+
+	// The caller's context is respected too.
+	store = &gatewaystore.Store{Endpoints: []string{serve(t, slow)}, Retries: 10}
+	cctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = store.Get(cctx, cids[1].KeyString())
+	qt.Check(t, errors.Is(err, context.DeadlineExceeded), qt.IsTrue)
+}
+
+func TestMaxBlockSize(t *testing.T) {
+	blocks, cids := fixture(t)
+	store := &gatewaystore.Store{Endpoints: []string{serve(t, &gateway{blocks: blocks})}, MaxBlockSize: 4}
+	_, err := store.Get(context.Background(), cids[1].KeyString())
+	qt.Check(t, err, qt.ErrorMatches, ".*block is 8 bytes, which is more than the limit of 4")
+}
+
+func TestFetchDAG(t *testing.T) {
+	ctx := context.Background()
+	blocks, cids := fixture(t)
+	liar := &gateway{blocks: blocks, order: cids, lie: true}
+	good := &gateway{blocks: blocks, order: cids}
+	store := &gatewaystore.Store{Endpoints: []string{serve(t, liar), serve(t, good)}}
+
+	into := &memstore.Store{}
+	qt.Assert(t, store.FetchDAG(ctx, cids[0].KeyString(), gatewaystore.ScopeAll, into), qt.IsNil)
+	qt.Check(t, into.Bag, qt.HasLen, 3)
+	for _, c := range cids {
+		want, _ := blocks.Get(ctx, c.KeyString())
+		got, err := into.Get(ctx, c.KeyString())
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, got, qt.DeepEquals, want)
+	}
+	qt.Check(t, liar.requests.Load(), qt.Equals, int32(1))
+
+	// A CAR that doesn't start with the root is rejected.
+	wrong := &gateway{blocks: blocks, order: cids[1:]}
+	store = &gatewaystore.Store{Endpoints: []string{serve(t, wrong)}}
+	err := store.FetchDAG(ctx, cids[0].KeyString(), gatewaystore.ScopeAll, &memstore.Store{})
+	qt.Check(t, err, qt.ErrorMatches, ".*CAR starts with .*, not the requested root")
+}
+
+func TestOversizedDigest(t *testing.T) {
+	ctx := context.Background()
+	blocks, cids := fixture(t)
+	// A sha2-256 digest can only be 32 bytes long; this CID claims 40.
+	long := cid.NewCidV1(cid.Raw, append([]byte{multihash.SHA2_256, 40}, make([]byte, 40)...))
+	qt.Assert(t, blocks.Put(ctx, long.KeyString(), []byte("leaf one")), qt.IsNil)
+	store := &gatewaystore.Store{Endpoints: []string{serve(t, &gateway{blocks: blocks, order: []cid.Cid{cids[0], long}})}}
+
+	_, err := store.Get(ctx, long.KeyString())
+	qt.Check(t, err, qt.ErrorMatches, ".* has a 40-byte digest, but its hash function produces 32 bytes")
+
+	// It's refused in a CAR, too, rather than crashing the client.
+	err = store.FetchDAG(ctx, cids[0].KeyString(), gatewaystore.ScopeAll, &memstore.Store{})
+	qt.Check(t, err, qt.ErrorMatches, ".* has a 40-byte digest, but its hash function produces 32 bytes")
+}
@@ -0,0 +1,242 @@
+/*
+The gc package offers reachability-based garbage collection for storage systems.
+
+Given a set of root links, Collect loads everything reachable from them (the "mark" phase),
+then lists every key in the store and deletes the ones that weren't reached (the "sweep" phase).
+
+The store must support enumeration (storage.EnumerableStorage) and, unless doing a dry run,
+deletion (storage.DeletableStorage); both memstore and fsstore do.
+
+Collect must not run while anything else is writing to the store!
+Blocks written after the mark phase has looked at the part of the DAG they belong to
+(or blocks belonging to a DAG whose root isn't in the root set yet) are indistinguishable from garbage,
+and will be deleted.
+Callers are responsible for pausing writes, or otherwise making sure
+that any new blocks are reachable from the roots they give to Collect.
+*/
+package gc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+)
+
+// Root is a link that should be kept, along with (optionally) a selector describing how much of the DAG under it should be kept.
+type Root struct {
+	Link datamodel.Link
+
+	// Selector, if set, limits what's kept to the blocks this selector loads when applied from Link.
+	// If nil, everything reachable from Link is kept.
+	Selector selector.Selector
+}
+
+// Config is the set of options for Collect.
+type Config struct {
+	// Store is the storage system to collect garbage from.
+	// It must implement storage.EnumerableStorage, and, unless DryRun is set, storage.DeletableStorage.
+	Store storage.ReadableStorage
+
+	// Roots are the links that are kept, along with everything reachable from them.
+	Roots []Root
+
+	// LinkSystem is used to load blocks during the mark phase.
+	// Optional: by default, cidlink.DefaultLinkSystem is used.
+	// If its StorageReadOpener isn't set, it's set to read from Store.
+	// Keys in Store are expected to be the Binary form of links, as they are when using LinkSystem.SetReadStorage.
+	LinkSystem linking.LinkSystem
+
+	// LinkTargetNodePrototypeChooser chooses the Node implementation each block is loaded into.
+	// Optional; by default, basicnode.Prototype.Any is used.
+	// (Set this if any Root's Selector relies on schema types or ADLs.)
+	LinkTargetNodePrototypeChooser traversal.LinkTargetNodePrototypeChooser
+
+	// DryRun, if set, causes Collect to report what it would delete, without deleting anything.
+	DryRun bool
+
+	// Concurrency is the number of deletes done at the same time.
+	// Zero means one.  When it's more than one, Store must be safe for concurrent use.
+	Concurrency int
+
+	// OnSweep, if set, is called for every key that's deleted (or that would be, in a DryRun), along with its size.
+	// Calls are never concurrent with each other.
+	OnSweep func(key string, size int64)
+}
+
+// Report summarizes what Collect did.
+type Report struct {
+	Marked         int   // distinct blocks reached from the roots.
+	Scanned        int   // keys listed from the store.
+	Swept          int   // keys deleted (or, in a DryRun, that would have been).
+	BytesReclaimed int64 // total size of the swept entries.
+}
+
+// Collect deletes every entry in the store that isn't reachable from the roots.
+// See the package docs for the caveats regarding concurrent writes.
+//
+// If any block that should be reachable can't be loaded, Collect returns an error before deleting anything,
+// since it can't know what that block would have linked to.
+// If an error occurs during the sweep, the Report describes what had been deleted before the error.
+func Collect(ctx context.Context, cfg Config) (Report, error) {
+	var report Report
+	if cfg.Store == nil {
+		return report, fmt.Errorf("gc: no store configured")
+	}
+	if _, ok := cfg.Store.(storage.EnumerableStorage); !ok {
+		return report, fmt.Errorf("gc: store %T cannot list its keys", cfg.Store)
+	}
+	if _, ok := cfg.Store.(storage.DeletableStorage); !ok && !cfg.DryRun {
+		return report, fmt.Errorf("gc: store %T cannot delete", cfg.Store)
+	}
+	if cfg.LinkSystem.DecoderChooser == nil {
+		cfg.LinkSystem = cidlink.DefaultLinkSystem()
+	}
+	if cfg.LinkSystem.StorageReadOpener == nil {
+		cfg.LinkSystem.SetReadStorage(cfg.Store)
+	}
+	if cfg.LinkTargetNodePrototypeChooser == nil {
+		cfg.LinkTargetNodePrototypeChooser = func(datamodel.Link, linking.LinkContext) (datamodel.NodePrototype, error) {
+			return basicnode.Prototype.Any, nil
+		}
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+
+	marked, err := mark(ctx, &cfg)
+	if err != nil {
+		return report, err
+	}
+	report.Marked = len(marked)
+
+	// Find the garbage first, and only then start deleting,
+	// so we're not altering the store underneath the listing.
+	var garbage []string
+	for key, err := range storage.List(ctx, cfg.Store, "") {
+		if err != nil {
+			return report, fmt.Errorf("gc: could not list keys: %w", err)
+		}
+		report.Scanned++
+		if _, keep := marked[key]; !keep {
+			garbage = append(garbage, key)
+		}
+	}
+
+	return report, sweep(ctx, &cfg, garbage, &report)
+}
+
+// mark returns the set of keys of every block reachable from the roots.
+func mark(ctx context.Context, cfg *Config) (map[string]struct{}, error) {
+	marked := make(map[string]struct{})
+	lsys := cfg.LinkSystem
+	readOpener := lsys.StorageReadOpener
+	lsys.StorageReadOpener = func(lnkCtx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		marked[lnk.Binary()] = struct{}{}
+		return readOpener(lnkCtx, lnk)
+	}
+
+	var all selector.Selector
+	// When exploring everything, visiting a link a second time can't reach anything new,
+	// so links seen under any root can be skipped under every other root too.
+	seen := make(map[datamodel.Link]struct{})
+	for _, root := range cfg.Roots {
+		sel := root.Selector
+		prog := traversal.Progress{Cfg: &traversal.Config{
+			Ctx:                            ctx,
+			LinkSystem:                     lsys,
+			LinkTargetNodePrototypeChooser: cfg.LinkTargetNodePrototypeChooser,
+		}}
+		if sel == nil {
+			if _, done := seen[root.Link]; done {
+				continue
+			}
+			if all == nil {
+				var err error
+				all, err = selector.CompileSelector(selectorparse.CommonSelector_ExploreAllRecursively)
+				if err != nil {
+					return nil, err
+				}
+			}
+			sel = all
+			prog.Cfg.LinkVisitOnlyOnce = true
+			prog.Cfg.KeepSeenLinks = true
+			prog.SeenLinks = seen
+			seen[root.Link] = struct{}{}
+		}
+		lnkCtx := linking.LinkContext{Ctx: ctx}
+		np, err := cfg.LinkTargetNodePrototypeChooser(root.Link, lnkCtx)
+		if err != nil {
+			return nil, fmt.Errorf("gc: could not load root %q: %w", root.Link, err)
+		}
+		n, err := lsys.Load(lnkCtx, root.Link, np)
+		if err != nil {
+			return nil, fmt.Errorf("gc: could not load root %q: %w", root.Link, err)
+		}
+		if err := prog.WalkAdv(n, sel, func(traversal.Progress, datamodel.Node, traversal.VisitReason) error { return nil }); err != nil {
+			return nil, fmt.Errorf("gc: could not walk from root %q: %w", root.Link, err)
+		}
+	}
+	return marked, nil
+}
+
+// sweep deletes the garbage (or, in a dry run, just measures it), updating the report as it goes.
+func sweep(ctx context.Context, cfg *Config, garbage []string, report *Report) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var firstErr error
+	keys := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				size, err := storage.Size(ctx, cfg.Store, key)
+				if err == nil && !cfg.DryRun {
+					err = storage.Delete(ctx, cfg.Store, key)
+				}
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("gc: could not sweep key %q: %w", key, err)
+						cancel()
+					}
+					mu.Unlock()
+					continue
+				}
+				report.Swept++
+				report.BytesReclaimed += size
+				if cfg.OnSweep != nil {
+					cfg.OnSweep(key, size)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, key := range garbage {
+		select {
+		case keys <- key:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(keys)
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
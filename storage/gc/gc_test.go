@@ -0,0 +1,183 @@
+package gc_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipfs/go-cid"
+
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/fsstore"
+	"github.com/ipld/go-ipld-prime/storage/gc"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+)
+
+var lp = cidlink.LinkPrototype{Prefix: cid.Prefix{
+	Version:  1,
+	Codec:    0x71,
+	MhType:   0x12,
+	MhLength: 32,
+}}
+
+type fixture struct {
+	store   storage.ReadableStorage
+	lsys    linking.LinkSystem
+	shared  datamodel.Link // a leaf reachable from both roots.
+	rootA   datamodel.Link // {"shared": shared, "own": leafA}
+	leafA   datamodel.Link
+	rootB   datamodel.Link // [shared, leafB]
+	leafB   datamodel.Link
+	garbage []datamodel.Link
+}
+
+func (f *fixture) put(t *testing.T, n datamodel.Node) datamodel.Link {
+	lnk, err := f.lsys.Store(linking.LinkContext{}, lp, n)
+	qt.Assert(t, err, qt.IsNil)
+	return lnk
+}
+
+func (f *fixture) size(t *testing.T, lnks ...datamodel.Link) int64 {
+	var total int64
+	for _, lnk := range lnks {
+		size, err := storage.Size(context.Background(), f.store, lnk.Binary())
+		qt.Assert(t, err, qt.IsNil)
+		total += size
+	}
+	return total
+}
+
+func (f *fixture) has(t *testing.T, lnk datamodel.Link) bool {
+	has, err := f.store.Has(context.Background(), lnk.Binary())
+	qt.Assert(t, err, qt.IsNil)
+	return has
+}
+
+func newFixture(t *testing.T, store storage.ReadableStorage) *fixture {
+	f := &fixture{store: store, lsys: cidlink.DefaultLinkSystem()}
+	f.lsys.SetReadStorage(store)
+	f.lsys.SetWriteStorage(store.(storage.WritableStorage))
+	f.shared = f.put(t, basicnode.NewString("shared"))
+	f.leafA = f.put(t, basicnode.NewString("a"))
+	f.leafB = f.put(t, basicnode.NewString("b"))
+	f.rootA = f.put(t, fluent.MustBuildMap(basicnode.Prototype.Map, 2, func(ma fluent.MapAssembler) {
+		ma.AssembleEntry("shared").AssignLink(f.shared)
+		ma.AssembleEntry("own").AssignLink(f.leafA)
+	}))
+	f.rootB = f.put(t, fluent.MustBuildList(basicnode.Prototype.List, 2, func(la fluent.ListAssembler) {
+		la.AssembleValue().AssignLink(f.shared)
+		la.AssembleValue().AssignLink(f.leafB)
+	}))
+	for _, s := range []string{"old 1", "old 2", "old 3"} {
+		f.garbage = append(f.garbage, f.put(t, basicnode.NewString(s)))
+	}
+	return f
+}
+
+func TestCollect(t *testing.T) {
+	ctx := context.Background()
+	stores := map[string]func(t *testing.T) storage.ReadableStorage{
+		"memstore": func(t *testing.T) storage.ReadableStorage { return &memstore.Store{} },
+		"fsstore": func(t *testing.T) storage.ReadableStorage {
+			fs := &fsstore.Store{}
+			qt.Assert(t, fs.InitDefaults(t.TempDir()), qt.IsNil)
+			return fs
+		},
+	}
+	for name, mkStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Run("dry run", func(t *testing.T) {
+				f := newFixture(t, mkStore(t))
+				var swept []string
+				report, err := gc.Collect(ctx, gc.Config{
+					Store:   f.store,
+					Roots:   []gc.Root{{Link: f.rootA}, {Link: f.rootB}},
+					DryRun:  true,
+					OnSweep: func(key string, size int64) { swept = append(swept, key) },
+				})
+				qt.Assert(t, err, qt.IsNil)
+				qt.Check(t, report, qt.Equals, gc.Report{
+					Marked:         5,
+					Scanned:        8,
+					Swept:          3,
+					BytesReclaimed: f.size(t, f.garbage...),
+				})
+				qt.Check(t, swept, qt.HasLen, 3)
+				for _, lnk := range f.garbage {
+					qt.Check(t, f.has(t, lnk), qt.IsTrue)
+				}
+			})
+
+			t.Run("sweep", func(t *testing.T) {
+				f := newFixture(t, mkStore(t))
+				wantBytes := f.size(t, f.rootB, f.leafB)
+				wantBytes += f.size(t, f.garbage...)
+				report, err := gc.Collect(ctx, gc.Config{
+					Store:       f.store,
+					Roots:       []gc.Root{{Link: f.rootA}},
+					Concurrency: 4,
+				})
+				qt.Assert(t, err, qt.IsNil)
+				qt.Check(t, report, qt.Equals, gc.Report{
+					Marked:         3,
+					Scanned:        8,
+					Swept:          5,
+					BytesReclaimed: wantBytes,
+				})
+				for _, lnk := range []datamodel.Link{f.rootA, f.shared, f.leafA} {
+					qt.Check(t, f.has(t, lnk), qt.IsTrue)
+				}
+				for _, lnk := range append([]datamodel.Link{f.rootB, f.leafB}, f.garbage...) {
+					qt.Check(t, f.has(t, lnk), qt.IsFalse)
+				}
+
+				// Running again finds nothing more to do.
+				report, err = gc.Collect(ctx, gc.Config{
+					Store: f.store,
+					Roots: []gc.Root{{Link: f.rootA}},
+				})
+				qt.Assert(t, err, qt.IsNil)
+				qt.Check(t, report, qt.Equals, gc.Report{Marked: 3, Scanned: 3})
+			})
+
+			t.Run("selector", func(t *testing.T) {
+				f := newFixture(t, mkStore(t))
+				ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+				sel, err := ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+					efsb.Insert("own", ssb.Matcher())
+				}).Selector()
+				qt.Assert(t, err, qt.IsNil)
+				report, err := gc.Collect(ctx, gc.Config{
+					Store: f.store,
+					Roots: []gc.Root{{Link: f.rootA, Selector: sel}},
+				})
+				qt.Assert(t, err, qt.IsNil)
+				qt.Check(t, report.Marked, qt.Equals, 2)
+				qt.Check(t, report.Swept, qt.Equals, 6)
+				qt.Check(t, f.has(t, f.leafA), qt.IsTrue)
+				qt.Check(t, f.has(t, f.shared), qt.IsFalse)
+			})
+
+			t.Run("missing block", func(t *testing.T) {
+				f := newFixture(t, mkStore(t))
+				qt.Assert(t, storage.Delete(ctx, f.store, f.leafA.Binary()), qt.IsNil)
+				report, err := gc.Collect(ctx, gc.Config{
+					Store: f.store,
+					Roots: []gc.Root{{Link: f.rootA}},
+				})
+				qt.Check(t, err, qt.IsNotNil)
+				qt.Check(t, report.Swept, qt.Equals, 0)
+				for _, lnk := range f.garbage {
+					qt.Check(t, f.has(t, lnk), qt.IsTrue)
+				}
+			})
+		})
+	}
+}
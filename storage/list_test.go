@@ -0,0 +1,113 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/fsstore"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/storage/sharding"
+)
+
+func listAll(t *testing.T, ctx context.Context, store storage.Storage, prefix string) ([]string, error) {
+	var keys []string
+	for key, err := range storage.List(ctx, store, prefix) {
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	keys := []string{"apple", "apricot", "banana", "a/b\x00c", "\xff\x01"}
+
+	stores := map[string]func(t *testing.T) storage.WritableStorage{
+		"memstore": func(t *testing.T) storage.WritableStorage { return &memstore.Store{} },
+		"fsstore": func(t *testing.T) storage.WritableStorage {
+			fs := &fsstore.Store{}
+			qt.Assert(t, fs.InitDefaults(t.TempDir()), qt.IsNil)
+			return fs
+		},
+	}
+	for name, mkStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := mkStore(t)
+			got, err := listAll(t, ctx, store, "")
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, got, qt.HasLen, 0)
+
+			for _, key := range keys {
+				qt.Assert(t, store.Put(ctx, key, []byte(key)), qt.IsNil)
+			}
+			got, err = listAll(t, ctx, store, "")
+			qt.Assert(t, err, qt.IsNil)
+			want := append([]string(nil), keys...)
+			sort.Strings(want)
+			qt.Check(t, got, qt.DeepEquals, want)
+
+			got, err = listAll(t, ctx, store, "ap")
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, got, qt.DeepEquals, []string{"apple", "apricot"})
+
+			// Stopping early is fine.
+			n := 0
+			for range storage.List(ctx, store, "") {
+				n++
+				break
+			}
+			qt.Check(t, n, qt.Equals, 1)
+
+			// Cancellation is reported.
+			cctx, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err = listAll(t, cctx, store, "")
+			qt.Check(t, errors.Is(err, context.Canceled), qt.IsTrue)
+		})
+	}
+}
+
+func TestListFsstoreEscaping(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("no escaping", func(t *testing.T) {
+		store := &fsstore.Store{}
+		qt.Assert(t, store.Init(t.TempDir(), nil, sharding.Shard_r12), qt.IsNil)
+		qt.Assert(t, store.Put(ctx, "plainkey", []byte("x")), qt.IsNil)
+		got, err := listAll(t, ctx, store, "")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, got, qt.DeepEquals, []string{"plainkey"})
+	})
+
+	t.Run("custom escaping", func(t *testing.T) {
+		escape := func(s string) string { return "k" + s }
+		store := &fsstore.Store{}
+		qt.Assert(t, store.Init(t.TempDir(), escape, sharding.Shard_r12), qt.IsNil)
+		qt.Assert(t, store.Put(ctx, "key", []byte("x")), qt.IsNil)
+		_, err := listAll(t, ctx, store, "")
+		qt.Check(t, err, qt.ErrorMatches, ".*no unescaping function configured")
+
+		store.SetUnescaping(func(s string) (string, error) {
+			if len(s) == 0 || s[0] != 'k' {
+				return "", errors.New("not escaped")
+			}
+			return s[1:], nil
+		})
+		got, err := listAll(t, ctx, store, "")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, got, qt.DeepEquals, []string{"key"})
+	})
+}
+
+func TestListUnsupported(t *testing.T) {
+	_, err := listAll(t, context.Background(), basicStore(), "")
+	qt.Check(t, errors.Is(err, errors.ErrUnsupported), qt.IsTrue)
+}
@@ -2,9 +2,14 @@ package memstore
 
 import (
 	"bytes"
+	"container/list"
 	"context"
 	"fmt"
 	"io"
+	"iter"
+	"sort"
+	"strings"
+	"sync"
 )
 
 // Store is a simple in-memory storage.
@@ -13,7 +18,9 @@ import (
 //
 // Store conforms to the storage.ReadableStorage and storage.WritableStorage APIs.
 // Additionally, it supports storage.PeekableStorage and storage.StreamingReadableStorage,
-// because it can do so while provoking fewer copies.
+// because it can do so while provoking fewer copies,
+// and storage.BatchWritableStorage, because it's easy for it to make batches atomic,
+// and storage.EnumerableStorage, storage.DeletableStorage, and storage.SizableStorage.
 //
 // If you want to use this store with streaming APIs,
 // you can still do so by using the functions in the storage package,
@@ -25,11 +32,32 @@ import (
 // There are no construction parameters for sharding functions nor escaping functions.
 // Any keys are acceptable.
 //
+// The methods of Store are safe for concurrent use.
+// (Poking the Bag directly is not, of course; if you do that, it's up to you to make sure nothing else is using the Store at the same time.)
+// Because of this, a Store contains a mutex, and must not be copied once it's been used;
+// go vet's copylocks check reports code that copies a Store by value, which should use a *Store instead.
+//
+// If MaxBytes is set, Store acts as a bounded cache:
+// when a write takes it over the limit, the least recently used entries are evicted until it's back under.
+// Reads (Get, GetStream, and Peek) count as use; Has does not.
+// Entries put directly into the Bag are neither counted towards the limit nor ever evicted.
+// If MaxBytes isn't set, nothing is kept for each entry beyond the Bag itself.
+//
 // This storage is mostly expected to be used for testing and demos,
 // and as an example of how you can implement and integrate your own storage systems.
 // It does not provide persistence beyond memory.
 type Store struct {
 	Bag map[string][]byte
+
+	// MaxBytes, if nonzero, is the most content the Store will hold before evicting the least recently used entries.
+	// It must be set before the Store is first used, and not changed afterwards.
+	// The most recently written entry is never evicted, even if it alone is bigger than MaxBytes.
+	MaxBytes int64
+
+	mu    sync.RWMutex
+	used  int64                    // the size of the entries in elems.  (Only kept if MaxBytes is set, as are lru and elems.)
+	lru   *list.List               // of keys, most recently used at the front.
+	elems map[string]*list.Element // index into lru; also tells us which entries are counted in used.
 }
 
 func (store *Store) beInitialized() {
@@ -39,11 +67,83 @@ func (store *Store) beInitialized() {
 	store.Bag = make(map[string][]byte)
 }
 
+// lockForRead takes the lock needed to read an entry, and returns the function that releases it.
+// Reading is only a truly read-only operation if we're not keeping track of use for LRU eviction.
+func (store *Store) lockForRead() func() {
+	if store.MaxBytes > 0 {
+		store.mu.Lock()
+		return store.mu.Unlock
+	}
+	store.mu.RLock()
+	return store.mu.RUnlock
+}
+
+// touch marks the key as recently used.  Must be called with the write lock held.
+func (store *Store) touch(key string) {
+	if store.MaxBytes <= 0 {
+		return
+	}
+	if elem, ok := store.elems[key]; ok {
+		store.lru.MoveToFront(elem)
+	}
+}
+
+// insert adds an entry, and then evicts others if necessary.  Must be called with the write lock held.
+// The caller is expected to have already checked the key isn't present.
+func (store *Store) insert(key string, content []byte) {
+	store.beInitialized()
+	store.Bag[key] = content
+	if store.MaxBytes <= 0 {
+		return
+	}
+	store.used += int64(len(content))
+	if store.lru == nil {
+		store.lru = list.New()
+		store.elems = make(map[string]*list.Element)
+	}
+	store.elems[key] = store.lru.PushFront(key)
+	for store.used > store.MaxBytes && store.lru.Len() > 1 {
+		store.remove(store.lru.Back().Value.(string))
+	}
+}
+
+// remove drops an entry.  Must be called with the write lock held.
+func (store *Store) remove(key string) {
+	content, exists := store.Bag[key]
+	if !exists {
+		return
+	}
+	delete(store.Bag, key)
+	if elem, ok := store.elems[key]; ok {
+		store.lru.Remove(elem)
+		delete(store.elems, key)
+		store.used -= int64(len(content))
+	}
+}
+
+// UsedBytes returns the total size of the content held by the Store.
+// If MaxBytes is set, that's what counts towards the limit, which doesn't include any entries that were put directly into the Bag;
+// otherwise, it's the size of everything in the Bag, which has to be added up on each call.
+func (store *Store) UsedBytes() int64 {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if store.MaxBytes > 0 {
+		return store.used
+	}
+	var used int64
+	for _, content := range store.Bag {
+		used += int64(len(content))
+	}
+	return used
+}
+
 // Has implements go-ipld-prime/storage.Storage.Has.
 func (store *Store) Has(ctx context.Context, key string) (bool, error) {
-	if store.Bag == nil {
-		return false, nil
+	if ctx.Err() != nil {
+		return false, ctx.Err()
 	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
 	_, exists := store.Bag[key]
 	return exists, nil
 }
@@ -53,11 +153,15 @@ func (store *Store) Has(ctx context.Context, key string) (bool, error) {
 // Note that this internally performs a defensive copy;
 // use Peek for higher performance if you are certain you won't mutate the returned slice.
 func (store *Store) Get(ctx context.Context, key string) ([]byte, error) {
-	store.beInitialized()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	defer store.lockForRead()()
 	content, exists := store.Bag[key]
 	if !exists {
 		return nil, fmt.Errorf("404") // FIXME this needs a standard error type
 	}
+	store.touch(key)
 	cpy := make([]byte, len(content))
 	copy(cpy, content)
 	return cpy, nil
@@ -65,13 +169,48 @@ func (store *Store) Get(ctx context.Context, key string) ([]byte, error) {
 
 // Put implements go-ipld-prime/storage.WritableStorage.Put.
 func (store *Store) Put(ctx context.Context, key string, content []byte) error {
-	store.beInitialized()
-	if _, exists := store.Bag[key]; exists {
-		return nil
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 	cpy := make([]byte, len(content))
 	copy(cpy, content)
-	store.Bag[key] = cpy
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, exists := store.Bag[key]; exists {
+		return nil
+	}
+	store.insert(key, cpy)
+	return nil
+}
+
+// PutBatch implements go-ipld-prime/storage.BatchWritableStorage.PutBatch.
+//
+// All of the content is read before any of it is added to the Bag,
+// so if reading any of it fails, nothing is added.
+// (If MaxBytes is set, entries from the batch may of course still be evicted by later entries from the same batch.)
+func (store *Store) PutBatch(ctx context.Context, keys []string, contents []io.Reader) error {
+	if len(keys) != len(contents) {
+		return fmt.Errorf("memstore: PutBatch given %d keys but %d contents", len(keys), len(contents))
+	}
+	cpys := make([][]byte, len(contents))
+	for i, r := range contents {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		cpy, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		cpys[i] = cpy
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for i, key := range keys {
+		if _, exists := store.Bag[key]; exists {
+			continue
+		}
+		store.insert(key, cpys[i])
+	}
 	return nil
 }
 
@@ -80,22 +219,85 @@ func (store *Store) Put(ctx context.Context, key string, content []byte) error {
 // It's useful for this storage implementation to explicitly support this,
 // because returning a reader gives us room to avoid needing a defensive copy.
 func (store *Store) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	defer store.lockForRead()()
 	content, exists := store.Bag[key]
 	if !exists {
 		return nil, fmt.Errorf("404") // FIXME this needs a standard error type
 	}
+	store.touch(key)
 	return noopCloser{bytes.NewReader(content)}, nil
 }
 
 // Peek implements go-ipld-prime/storage.PeekableStorage.Peek.
 func (store *Store) Peek(ctx context.Context, key string) ([]byte, io.Closer, error) {
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	defer store.lockForRead()()
 	content, exists := store.Bag[key]
 	if !exists {
 		return nil, nil, fmt.Errorf("404") // FIXME this needs a standard error type
 	}
+	store.touch(key)
 	return content, noopCloser{nil}, nil
 }
 
+// Size implements go-ipld-prime/storage.SizableStorage.Size.
+func (store *Store) Size(ctx context.Context, key string) (int64, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	content, exists := store.Bag[key]
+	if !exists {
+		return 0, fmt.Errorf("404") // FIXME this needs a standard error type
+	}
+	return int64(len(content)), nil
+}
+
+// Delete implements go-ipld-prime/storage.DeletableStorage.Delete.
+func (store *Store) Delete(ctx context.Context, key string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.remove(key)
+	return nil
+}
+
+// List implements go-ipld-prime/storage.EnumerableStorage.List.
+//
+// Keys are yielded in sorted order.
+// The set of keys is gathered when iteration begins,
+// so changes made while iterating (including by the loop body) are not seen.
+func (store *Store) List(ctx context.Context, prefix string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		store.mu.RLock()
+		keys := make([]string, 0, len(store.Bag))
+		for key := range store.Bag {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		store.mu.RUnlock()
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := ctx.Err(); err != nil {
+				yield("", err)
+				return
+			}
+			if !yield(key, nil) {
+				return
+			}
+		}
+	}
+}
+
 type noopCloser struct {
 	io.Reader
 }
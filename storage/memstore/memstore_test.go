@@ -0,0 +1,135 @@
+package memstore_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/storage/tests"
+)
+
+func TestConformance(t *testing.T) {
+	tests.Conformance(t, func(t *testing.T) storage.ReadableStorage { return &memstore.Store{} }, tests.ConformanceOptions{})
+}
+
+// TestConcurrent is mostly interesting when run with the race detector.
+func TestConcurrent(t *testing.T) {
+	for _, maxBytes := range []int64{0, 64} {
+		t.Run(fmt.Sprintf("maxBytes=%d", maxBytes), func(t *testing.T) {
+			ctx := context.Background()
+			store := &memstore.Store{MaxBytes: maxBytes}
+			var wg sync.WaitGroup
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < 100; i++ {
+						key := fmt.Sprintf("key%d", (w*7+i)%20)
+						content := []byte(key)
+						switch i % 6 {
+						case 0:
+							qt.Check(t, store.Put(ctx, key, content), qt.IsNil)
+						case 1:
+							_, err := store.Has(ctx, key)
+							qt.Check(t, err, qt.IsNil)
+						case 2:
+							if got, err := store.Get(ctx, key); err == nil {
+								qt.Check(t, got, qt.DeepEquals, content)
+							}
+						case 3:
+							if r, err := store.GetStream(ctx, key); err == nil {
+								got, _ := io.ReadAll(r)
+								qt.Check(t, got, qt.DeepEquals, content)
+								r.Close()
+							}
+						case 4:
+							if got, closer, err := store.Peek(ctx, key); err == nil {
+								qt.Check(t, bytes.Equal(got, content), qt.IsTrue)
+								closer.Close()
+							}
+						case 5:
+							qt.Check(t, store.PutBatch(ctx, []string{key}, []io.Reader{bytes.NewReader(content)}), qt.IsNil)
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			if maxBytes > 0 {
+				qt.Check(t, store.UsedBytes() <= maxBytes, qt.IsTrue)
+			}
+		})
+	}
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	store := &memstore.Store{MaxBytes: 10}
+	has := func(key string) bool {
+		has, err := store.Has(ctx, key)
+		qt.Assert(t, err, qt.IsNil)
+		return has
+	}
+
+	qt.Assert(t, store.Put(ctx, "a", []byte("aaaa")), qt.IsNil)
+	qt.Assert(t, store.Put(ctx, "b", []byte("bbbb")), qt.IsNil)
+	qt.Check(t, store.UsedBytes(), qt.Equals, int64(8))
+
+	// Reading "a" makes "b" the least recently used.
+	_, err := store.Get(ctx, "a")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, store.Put(ctx, "c", []byte("cccc")), qt.IsNil)
+	qt.Check(t, has("a"), qt.IsTrue)
+	qt.Check(t, has("b"), qt.IsFalse)
+	qt.Check(t, has("c"), qt.IsTrue)
+	qt.Check(t, store.UsedBytes(), qt.Equals, int64(8))
+
+	// Has doesn't count as use; the other reads do.
+	has("a")
+	r, err := storage.GetStream(ctx, store, "c")
+	qt.Assert(t, err, qt.IsNil)
+	r.Close()
+	qt.Assert(t, store.Put(ctx, "d", []byte("dd")), qt.IsNil)
+	qt.Check(t, store.UsedBytes(), qt.Equals, int64(10))
+	_, closer, err := store.Peek(ctx, "a")
+	qt.Assert(t, err, qt.IsNil)
+	closer.Close()
+	qt.Assert(t, store.Put(ctx, "e", []byte("e")), qt.IsNil)
+	qt.Check(t, has("c"), qt.IsFalse)
+	qt.Check(t, has("a"), qt.IsTrue)
+	qt.Check(t, has("d"), qt.IsTrue)
+	qt.Check(t, has("e"), qt.IsTrue)
+	qt.Check(t, store.UsedBytes(), qt.Equals, int64(7))
+
+	// Deleting gives the space back.
+	qt.Assert(t, store.Delete(ctx, "a"), qt.IsNil)
+	qt.Check(t, store.UsedBytes(), qt.Equals, int64(3))
+
+	// An entry bigger than the whole limit pushes everything else out, but stays itself.
+	qt.Assert(t, store.Put(ctx, "big", bytes.Repeat([]byte("x"), 20)), qt.IsNil)
+	qt.Check(t, store.Bag, qt.HasLen, 1)
+	qt.Check(t, has("big"), qt.IsTrue)
+	qt.Check(t, store.UsedBytes(), qt.Equals, int64(20))
+}
+
+func TestUsedBytesUnbounded(t *testing.T) {
+	ctx := context.Background()
+	store := &memstore.Store{}
+	qt.Assert(t, store.Put(ctx, "a", []byte("aaaa")), qt.IsNil)
+	qt.Assert(t, store.Put(ctx, "a", []byte("again")), qt.IsNil)
+	qt.Assert(t, store.Put(ctx, "b", []byte("bb")), qt.IsNil)
+	qt.Check(t, store.UsedBytes(), qt.Equals, int64(6))
+
+	// Without a limit, entries poked into the Bag directly are counted too.
+	store.Bag["poked"] = []byte("pppp")
+	qt.Check(t, store.UsedBytes(), qt.Equals, int64(10))
+	qt.Assert(t, store.Delete(ctx, "poked"), qt.IsNil)
+	qt.Assert(t, store.Delete(ctx, "a"), qt.IsNil)
+	qt.Check(t, store.UsedBytes(), qt.Equals, int64(2))
+}
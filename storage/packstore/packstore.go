@@ -0,0 +1,821 @@
+/*
+The packstore package is a storage backend which appends blocks to a small number of large "segment" files,
+rather than writing a file per block as fsstore does.
+This scales much better when there are very many small blocks.
+
+A packstore directory contains numbered segment files (e.g. "00000001.pack"),
+and an "index" file which maps each key to the segment, offset, and length of its content.
+
+Segments are only ever appended to.
+Each record in a segment carries a checksum, so after a crash,
+the records written since the index was last saved can be replayed from the segment tails,
+and any torn record at the very end is discarded.
+
+Deleting an entry appends a small "tombstone" record, and leaves the old content in place as garbage;
+Compact rewrites the live entries into fresh segments and removes the old ones.
+*/
+package packstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	segmentSuffix = ".pack"
+	indexFilename = "index"
+	indexMagic    = "ipld-packstore-index-v1\n"
+
+	defaultMaxSegmentSize = 256 << 20
+
+	recordPut    byte = 1
+	recordDelete byte = 2
+)
+
+// Store implements storage.ReadableStorage and storage.WritableStorage,
+// as well as storage.StreamingReadableStorage, storage.PeekableStorage, storage.VectorWritableStorage,
+// storage.EnumerableStorage, storage.DeletableStorage, and storage.SizableStorage,
+// backed by append-only segment files in a directory.
+//
+// Set any options, then call Init before use, and Close when done.
+// Close saves the index; if the process stops without calling Close,
+// the next Init recovers by replaying whatever was written since the index was last saved.
+// (The index is also saved whenever a new segment is started, and by Sync and Compact.)
+//
+// Writes are not fsync'd individually.  Call Sync to make sure everything written so far is durable.
+//
+// A Store is safe for concurrent use, but only by one process at a time.
+type Store struct {
+	// MaxSegmentSize is the size at which a segment is closed, and a new one started.
+	// (A single record bigger than this still goes into one segment.)
+	// Defaults to 256 MiB.
+	MaxSegmentSize int64
+
+	dir string
+
+	mu       sync.RWMutex
+	index    map[string]location
+	segments map[uint32]*segment
+	active   *segment
+	garbage  int64 // bytes in segments that are no longer needed.
+	closed   bool
+}
+
+type location struct {
+	seg    uint32
+	offset int64 // of the content itself, not the start of the record.
+	length int64
+}
+
+type segment struct {
+	id      uint32
+	file    *os.File
+	size    int64
+	refs    int  // streams currently reading from this segment.
+	retired bool // compacted away; remove once refs reaches zero.
+}
+
+func (store *Store) segmentPath(id uint32) string {
+	return filepath.Join(store.dir, fmt.Sprintf("%08d%s", id, segmentSuffix))
+}
+
+// Init opens the store in the given directory, which must already exist,
+// loading the index and replaying any segment data written after it was saved.
+func (store *Store) Init(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("packstore: invalid setup args: need a path")
+	}
+	if store.dir != "" {
+		return fmt.Errorf("packstore: cannot init: is already initialized")
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("packstore: cannot init: path must be a directory: %w", err)
+	} else if !fi.IsDir() {
+		return fmt.Errorf("packstore: cannot init: path must be a directory")
+	}
+	if store.MaxSegmentSize <= 0 {
+		store.MaxSegmentSize = defaultMaxSegmentSize
+	}
+	store.dir = dir
+	store.index = make(map[string]location)
+	store.segments = make(map[uint32]*segment)
+
+	// Find the segments.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("packstore: cannot init: %w", err)
+	}
+	var ids []uint32
+	for _, ent := range entries {
+		name := ent.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// Load the index, if there's a good one; otherwise, we'll replay everything.
+	idx, err := readIndex(filepath.Join(dir, indexFilename))
+	if err != nil {
+		idx = nil
+	}
+	replayFrom := location{}
+	if idx != nil {
+		store.index = idx.entries
+		store.garbage = idx.garbage
+		replayFrom = idx.watermark
+		live := make(map[uint32]bool, len(idx.segments))
+		for _, id := range idx.segments {
+			live[id] = true
+		}
+		// Segments older than the watermark that the index doesn't know about were compacted away,
+		// but not yet removed when we stopped.
+		kept := ids[:0]
+		for _, id := range ids {
+			if id < replayFrom.seg && !live[id] {
+				os.Remove(store.segmentPath(id))
+				continue
+			}
+			kept = append(kept, id)
+		}
+		ids = kept
+	}
+
+	// Open the segments, and replay the tails.
+	for i, id := range ids {
+		f, err := os.OpenFile(store.segmentPath(id), os.O_RDWR, 0)
+		if err != nil {
+			store.closeFiles()
+			return fmt.Errorf("packstore: cannot init: %w", err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			store.closeFiles()
+			return fmt.Errorf("packstore: cannot init: %w", err)
+		}
+		seg := &segment{id: id, file: f, size: fi.Size()}
+		store.segments[id] = seg
+		if id < replayFrom.seg {
+			continue
+		}
+		start := int64(0)
+		if id == replayFrom.seg {
+			start = replayFrom.offset
+		}
+		if err := store.replay(seg, start, i == len(ids)-1); err != nil {
+			store.closeFiles()
+			return err
+		}
+	}
+	if len(ids) > 0 {
+		store.active = store.segments[ids[len(ids)-1]]
+	} else if err := store.startSegment(1); err != nil {
+		return err
+	}
+	return nil
+}
+
+// replay reads the records in a segment from the given offset, applying them to the index.
+// If the segment is the last one, a damaged record is assumed to be the result of a crash part way through writing it,
+// and the segment is truncated to drop it; otherwise, damage is an error.
+func (store *Store) replay(seg *segment, start int64, last bool) error {
+	if start > seg.size {
+		return fmt.Errorf("packstore: segment %d is shorter than the index says it should be", seg.id)
+	}
+	r := bufio.NewReader(io.NewSectionReader(seg.file, start, seg.size-start))
+	pos := start
+	for pos < seg.size {
+		kind, key, contentOffset, length, n, err := readRecord(r, pos, seg.size)
+		if err != nil {
+			if !last {
+				return fmt.Errorf("packstore: segment %d is damaged at offset %d: %w", seg.id, pos, err)
+			}
+			if err := seg.file.Truncate(pos); err != nil {
+				return fmt.Errorf("packstore: could not truncate damaged segment %d: %w", seg.id, err)
+			}
+			seg.size = pos
+			return nil
+		}
+		switch kind {
+		case recordPut:
+			if _, exists := store.index[key]; exists {
+				store.garbage += n
+			} else {
+				store.index[key] = location{seg.id, contentOffset, length}
+			}
+		case recordDelete:
+			if loc, exists := store.index[key]; exists {
+				store.garbage += loc.length
+				delete(store.index, key)
+			}
+			store.garbage += n
+		}
+		pos += n
+	}
+	return nil
+}
+
+// readRecord reads one record, starting at pos, in a segment which ends at end.
+// It returns the record's kind and key, the offset and length of its content, and the size of the whole record.
+// Lengths in the record which would run past the end of the segment are an error, like any other damage,
+// rather than something to try allocating.
+func readRecord(r *bufio.Reader, pos, end int64) (kind byte, key string, contentOffset, length, n int64, err error) {
+	crc := crc32.NewIEEE()
+	cr := &countingReader{r: io.TeeReader(r, crc)}
+	var b [1]byte
+	if _, err := io.ReadFull(cr, b[:]); err != nil {
+		return 0, "", 0, 0, 0, err
+	}
+	kind = b[0]
+	if kind != recordPut && kind != recordDelete {
+		return 0, "", 0, 0, 0, fmt.Errorf("unknown record kind %d", kind)
+	}
+	keyLen, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return 0, "", 0, 0, 0, err
+	}
+	if left := end - pos - cr.n; keyLen > uint64(left) {
+		return 0, "", 0, 0, 0, fmt.Errorf("record claims a %d-byte key, but only %d bytes are left", keyLen, left)
+	}
+	keyBytes := make([]byte, keyLen)
+	if _, err := io.ReadFull(cr, keyBytes); err != nil {
+		return 0, "", 0, 0, 0, err
+	}
+	if kind == recordPut {
+		l, err := binary.ReadUvarint(cr)
+		if err != nil {
+			return 0, "", 0, 0, 0, err
+		}
+		if left := end - pos - cr.n; l > uint64(left) {
+			return 0, "", 0, 0, 0, fmt.Errorf("record claims %d bytes of content, but only %d bytes are left", l, left)
+		}
+		length = int64(l)
+		contentOffset = pos + cr.n
+		if _, err := io.CopyN(io.Discard, cr, length); err != nil {
+			return 0, "", 0, 0, 0, err
+		}
+	}
+	want := crc.Sum32()
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return 0, "", 0, 0, 0, err
+	}
+	if binary.BigEndian.Uint32(sum[:]) != want {
+		return 0, "", 0, 0, 0, fmt.Errorf("checksum mismatch")
+	}
+	return kind, string(keyBytes), contentOffset, length, cr.n + 4, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(cr, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// startSegment creates a new, empty segment, and makes it the active one.  Must be called with the lock held.
+func (store *Store) startSegment(id uint32) error {
+	f, err := os.OpenFile(store.segmentPath(id), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return fmt.Errorf("packstore: could not create segment: %w", err)
+	}
+	seg := &segment{id: id, file: f}
+	store.segments[id] = seg
+	store.active = seg
+	return nil
+}
+
+// appendRecord writes a record to the active segment, starting a new segment first if this one is full.
+// It returns where the content landed, and the size of the whole record.  Must be called with the lock held.
+func (store *Store) appendRecord(kind byte, key string, blobs [][]byte) (location, int64, error) {
+	var length int64
+	for _, blob := range blobs {
+		length += int64(len(blob))
+	}
+	hdr := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(key))
+	hdr = append(hdr, kind)
+	hdr = binary.AppendUvarint(hdr, uint64(len(key)))
+	hdr = append(hdr, key...)
+	if kind == recordPut {
+		hdr = binary.AppendUvarint(hdr, uint64(length))
+	}
+	size := int64(len(hdr)) + length + 4
+
+	if store.active.size > 0 && store.active.size+size > store.MaxSegmentSize {
+		if err := store.startSegment(store.active.id + 1); err != nil {
+			return location{}, 0, err
+		}
+		// Save the index now, so that recovery never needs to replay more than the active segment.
+		if err := store.writeIndex(); err != nil {
+			return location{}, 0, err
+		}
+	}
+
+	seg := store.active
+	start := seg.size
+	crc := crc32.NewIEEE()
+	pos := start
+	write := func(bs []byte) error {
+		crc.Write(bs)
+		_, err := seg.file.WriteAt(bs, pos)
+		pos += int64(len(bs))
+		return err
+	}
+	err := write(hdr)
+	for _, blob := range blobs {
+		if err != nil {
+			break
+		}
+		err = write(blob)
+	}
+	if err == nil {
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+		_, err = seg.file.WriteAt(sum[:], pos)
+	}
+	if err != nil {
+		seg.file.Truncate(start)
+		return location{}, 0, fmt.Errorf("packstore: could not write to segment %d: %w", seg.id, err)
+	}
+	seg.size = start + size
+	return location{seg.id, start + int64(len(hdr)), length}, size, nil
+}
+
+// Has implements go-ipld-prime/storage.Storage.Has.
+func (store *Store) Has(ctx context.Context, key string) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if store.closed {
+		return false, errClosed
+	}
+	_, exists := store.index[key]
+	return exists, nil
+}
+
+// Get implements go-ipld-prime/storage.ReadableStorage.Get.
+func (store *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if store.closed {
+		return nil, errClosed
+	}
+	loc, exists := store.index[key]
+	if !exists {
+		return nil, fmt.Errorf("404") // FIXME this needs a standard error type
+	}
+	buf := make([]byte, loc.length)
+	if _, err := store.segments[loc.seg].file.ReadAt(buf, loc.offset); err != nil {
+		return nil, fmt.Errorf("packstore: could not read segment %d: %w", loc.seg, err)
+	}
+	return buf, nil
+}
+
+// Peek implements go-ipld-prime/storage.PeekableStorage.Peek.
+//
+// This reads the content with ReadAt, the same as Get does;
+// segments are not memory-mapped, so there's no sharing to be had.
+func (store *Store) Peek(ctx context.Context, key string) ([]byte, io.Closer, error) {
+	bs, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bs, io.NopCloser(nil), nil
+}
+
+// GetStream implements go-ipld-prime/storage.StreamingReadableStorage.GetStream.
+//
+// The stream reads directly from the segment file, and must be closed.
+// (A segment that's compacted away is only removed once every stream reading from it has been closed.)
+func (store *Store) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.closed {
+		return nil, errClosed
+	}
+	loc, exists := store.index[key]
+	if !exists {
+		return nil, fmt.Errorf("404") // FIXME this needs a standard error type
+	}
+	seg := store.segments[loc.seg]
+	seg.refs++
+	return &segmentReader{
+		SectionReader: io.NewSectionReader(seg.file, loc.offset, loc.length),
+		store:         store,
+		seg:           seg,
+	}, nil
+}
+
+type segmentReader struct {
+	*io.SectionReader
+	store *Store
+	seg   *segment
+	once  sync.Once
+}
+
+func (sr *segmentReader) Close() error {
+	var err error
+	sr.once.Do(func() {
+		sr.store.mu.Lock()
+		defer sr.store.mu.Unlock()
+		sr.seg.refs--
+		if sr.seg.retired && sr.seg.refs == 0 {
+			err = sr.store.removeSegment(sr.seg)
+		}
+	})
+	return err
+}
+
+// Size implements go-ipld-prime/storage.SizableStorage.Size.
+func (store *Store) Size(ctx context.Context, key string) (int64, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if store.closed {
+		return 0, errClosed
+	}
+	loc, exists := store.index[key]
+	if !exists {
+		return 0, fmt.Errorf("404") // FIXME this needs a standard error type
+	}
+	return loc.length, nil
+}
+
+// Put implements go-ipld-prime/storage.WritableStorage.Put.
+func (store *Store) Put(ctx context.Context, key string, content []byte) error {
+	return store.PutVec(ctx, key, [][]byte{content})
+}
+
+// PutVec implements go-ipld-prime/storage.VectorWritableStorage.PutVec.
+// The slices are written straight into the segment, without being joined first.
+func (store *Store) PutVec(ctx context.Context, key string, blobVec [][]byte) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.closed {
+		return errClosed
+	}
+	if _, exists := store.index[key]; exists {
+		return nil
+	}
+	loc, _, err := store.appendRecord(recordPut, key, blobVec)
+	if err != nil {
+		return err
+	}
+	store.index[key] = loc
+	return nil
+}
+
+// Delete implements go-ipld-prime/storage.DeletableStorage.Delete.
+//
+// The content isn't removed from disk until the next Compact.
+func (store *Store) Delete(ctx context.Context, key string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.closed {
+		return errClosed
+	}
+	loc, exists := store.index[key]
+	if !exists {
+		return nil
+	}
+	_, size, err := store.appendRecord(recordDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	delete(store.index, key)
+	store.garbage += loc.length + size
+	return nil
+}
+
+// List implements go-ipld-prime/storage.EnumerableStorage.List.
+//
+// Keys are yielded in sorted order.
+// The set of keys is gathered when iteration begins,
+// so changes made while iterating (including by the loop body) are not seen.
+func (store *Store) List(ctx context.Context, prefix string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		store.mu.RLock()
+		if store.closed {
+			store.mu.RUnlock()
+			yield("", errClosed)
+			return
+		}
+		keys := make([]string, 0, len(store.index))
+		for key := range store.index {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		store.mu.RUnlock()
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := ctx.Err(); err != nil {
+				yield("", err)
+				return
+			}
+			if !yield(key, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Garbage returns the number of bytes in the segments that Compact would reclaim.
+func (store *Store) Garbage() int64 {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.garbage
+}
+
+// Compact rewrites every live entry into new segments, and removes all the old segments,
+// reclaiming the space used by deleted entries.
+// If there's no garbage, it does nothing.
+//
+// Other operations on the store wait while Compact runs.
+func (store *Store) Compact(ctx context.Context) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.closed {
+		return errClosed
+	}
+	if store.garbage == 0 {
+		return nil
+	}
+
+	// Everything currently on disk is going away, including the active segment, so start a fresh one.
+	old := make([]*segment, 0, len(store.segments))
+	for _, seg := range store.segments {
+		old = append(old, seg)
+	}
+	if err := store.startSegment(store.active.id + 1); err != nil {
+		return err
+	}
+
+	// Copy the live entries, in the order they're already in on disk.
+	keys := make([]string, 0, len(store.index))
+	for key := range store.index {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := store.index[keys[i]], store.index[keys[j]]
+		if a.seg != b.seg {
+			return a.seg < b.seg
+		}
+		return a.offset < b.offset
+	})
+	newIndex := make(map[string]location, len(keys))
+	var copied int64 // the size of the records copied so far.
+	err := func() error {
+		for _, key := range keys {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			loc := store.index[key]
+			buf := make([]byte, loc.length)
+			if _, err := store.segments[loc.seg].file.ReadAt(buf, loc.offset); err != nil {
+				return fmt.Errorf("packstore: could not read segment %d: %w", loc.seg, err)
+			}
+			newLoc, n, err := store.appendRecord(recordPut, key, [][]byte{buf})
+			if err != nil {
+				return err
+			}
+			copied += n
+			newIndex[key] = newLoc
+		}
+		return nil
+	}()
+	if err != nil {
+		// The index still points at the old records, so the copies are garbage,
+		// just as they'd be found to be if the segments were replayed.
+		store.garbage += copied
+		return err
+	}
+
+	// Switch over, and save the index, before anything old is removed.
+	store.index = newIndex
+	store.garbage = 0
+	for _, seg := range old {
+		seg.retired = true
+	}
+	if err := store.writeIndex(); err != nil {
+		return err
+	}
+	var errs []error
+	for _, seg := range old {
+		if seg.refs == 0 {
+			errs = append(errs, store.removeSegment(seg))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// removeSegment closes and deletes a retired segment.  Must be called with the lock held.
+func (store *Store) removeSegment(seg *segment) error {
+	delete(store.segments, seg.id)
+	if store.closed {
+		// Close already closed the file; the next Init will clean up.
+		return nil
+	}
+	seg.file.Close()
+	return os.Remove(store.segmentPath(seg.id))
+}
+
+// Sync makes sure everything written so far is on disk, and saves the index.
+func (store *Store) Sync() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.closed {
+		return errClosed
+	}
+	return store.writeIndex()
+}
+
+// Close saves the index and closes all the files.
+// Streams returned by GetStream stop working once the store is closed.
+func (store *Store) Close() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.closed {
+		return nil
+	}
+	err := store.writeIndex()
+	store.closeFiles()
+	store.closed = true
+	return err
+}
+
+func (store *Store) closeFiles() {
+	for _, seg := range store.segments {
+		seg.file.Close()
+	}
+}
+
+var errClosed = fmt.Errorf("packstore: store is closed")
+
+// indexSnapshot is the content of the index file.
+type indexSnapshot struct {
+	watermark location // the index reflects every record before this segment and offset.
+	garbage   int64
+	segments  []uint32 // the segments the index refers to.
+	entries   map[string]location
+}
+
+// writeIndex saves the index, replacing the index file atomically.  Must be called with the lock held.
+func (store *Store) writeIndex() error {
+	if err := store.active.file.Sync(); err != nil {
+		return fmt.Errorf("packstore: could not sync segment %d: %w", store.active.id, err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(indexMagic)
+	putUvarint := func(v uint64) { buf.Write(binary.AppendUvarint(nil, v)) }
+	putUvarint(uint64(store.active.id))
+	putUvarint(uint64(store.active.size))
+	putUvarint(uint64(store.garbage))
+	var ids []uint32
+	for id, seg := range store.segments {
+		if !seg.retired {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	putUvarint(uint64(len(ids)))
+	for _, id := range ids {
+		putUvarint(uint64(id))
+	}
+	putUvarint(uint64(len(store.index)))
+	for key, loc := range store.index {
+		putUvarint(uint64(len(key)))
+		buf.WriteString(key)
+		putUvarint(uint64(loc.seg))
+		putUvarint(uint64(loc.offset))
+		putUvarint(uint64(loc.length))
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(buf.Bytes()))
+	buf.Write(sum[:])
+
+	tmp := filepath.Join(store.dir, indexFilename+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("packstore: could not write index: %w", err)
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(store.dir, indexFilename))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("packstore: could not write index: %w", err)
+	}
+	return nil
+}
+
+// readIndex loads an index file, checking it's intact.
+func readIndex(path string) (*indexSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(indexMagic)+4 || string(data[:len(indexMagic)]) != indexMagic {
+		return nil, fmt.Errorf("packstore: index file is not recognized")
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return nil, fmt.Errorf("packstore: index file is damaged")
+	}
+	r := bytes.NewReader(body[len(indexMagic):])
+	var readErr error
+	next := func() uint64 {
+		if readErr != nil {
+			return 0
+		}
+		v, err := binary.ReadUvarint(r)
+		readErr = err
+		return v
+	}
+	// nextLen reads a count of things which each take at least size bytes, and checks there's room left for that many.
+	nextLen := func(size int) uint64 {
+		v := next()
+		if readErr == nil && v > uint64(r.Len()/size) {
+			readErr = fmt.Errorf("count of %d is too many for the %d bytes left", v, r.Len())
+			return 0
+		}
+		return v
+	}
+	idx := &indexSnapshot{}
+	idx.watermark.seg = uint32(next())
+	idx.watermark.offset = int64(next())
+	idx.garbage = int64(next())
+	nSegs := nextLen(1)
+	for i := uint64(0); i < nSegs && readErr == nil; i++ {
+		idx.segments = append(idx.segments, uint32(next()))
+	}
+	nEntries := nextLen(4) // a key length, and three numbers for the location.
+	idx.entries = make(map[string]location, nEntries)
+	for i := uint64(0); i < nEntries && readErr == nil; i++ {
+		key := make([]byte, nextLen(1))
+		if readErr == nil {
+			_, readErr = io.ReadFull(r, key)
+		}
+		loc := location{seg: uint32(next()), offset: int64(next()), length: int64(next())}
+		idx.entries[string(key)] = loc
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("packstore: index file is damaged: %w", readErr)
+	}
+	return idx, nil
+}
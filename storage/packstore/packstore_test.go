@@ -0,0 +1,376 @@
+package packstore_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/packstore"
+	"github.com/ipld/go-ipld-prime/storage/tests"
+)
+
+func TestConformance(t *testing.T) {
+	tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+		store := open(t, t.TempDir(), 0)
+		t.Cleanup(func() { store.Close() })
+		return store
+	}, tests.ConformanceOptions{})
+}
+
+func open(t *testing.T, dir string, maxSegmentSize int64) *packstore.Store {
+	store := &packstore.Store{MaxSegmentSize: maxSegmentSize}
+	qt.Assert(t, store.Init(dir), qt.IsNil)
+	return store
+}
+
+func get(t *testing.T, store *packstore.Store, key string) string {
+	t.Helper()
+	bs, err := store.Get(context.Background(), key)
+	qt.Assert(t, err, qt.IsNil)
+	return string(bs)
+}
+
+func segments(t *testing.T, dir string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.pack"))
+	qt.Assert(t, err, qt.IsNil)
+	return matches
+}
+
+func TestBasics(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := open(t, dir, 0)
+
+	has, err := store.Has(ctx, "a")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, has, qt.IsFalse)
+	_, err = store.Get(ctx, "a")
+	qt.Check(t, err, qt.IsNotNil)
+
+	qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+	qt.Assert(t, store.Put(ctx, "a", []byte("ignored")), qt.IsNil)
+	qt.Assert(t, store.PutVec(ctx, "b", [][]byte{[]byte("be"), nil, []byte("ta")}), qt.IsNil)
+	qt.Assert(t, store.Put(ctx, "empty", nil), qt.IsNil)
+	qt.Check(t, get(t, store, "a"), qt.Equals, "alpha")
+	qt.Check(t, get(t, store, "b"), qt.Equals, "beta")
+	qt.Check(t, get(t, store, "empty"), qt.Equals, "")
+
+	r, err := store.GetStream(ctx, "b")
+	qt.Assert(t, err, qt.IsNil)
+	bs, err := io.ReadAll(r)
+	qt.Check(t, err, qt.IsNil)
+	qt.Check(t, string(bs), qt.Equals, "beta")
+	qt.Check(t, r.Close(), qt.IsNil)
+	qt.Check(t, r.Close(), qt.IsNil)
+
+	bs, closer, err := store.Peek(ctx, "a")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, string(bs), qt.Equals, "alpha")
+	qt.Check(t, closer.Close(), qt.IsNil)
+
+	size, err := storage.Size(ctx, store, "b")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, size, qt.Equals, int64(4))
+
+	var keys []string
+	for key, err := range storage.List(ctx, store, "") {
+		qt.Assert(t, err, qt.IsNil)
+		keys = append(keys, key)
+	}
+	qt.Check(t, keys, qt.DeepEquals, []string{"a", "b", "empty"})
+
+	// Everything's still there after reopening.
+	qt.Assert(t, store.Close(), qt.IsNil)
+	_, err = store.Get(ctx, "a")
+	qt.Check(t, err, qt.ErrorMatches, ".*closed.*")
+	store = open(t, dir, 0)
+	defer store.Close()
+	qt.Check(t, get(t, store, "a"), qt.Equals, "alpha")
+	qt.Check(t, get(t, store, "b"), qt.Equals, "beta")
+	qt.Check(t, get(t, store, "empty"), qt.Equals, "")
+}
+
+func TestSegmentRollover(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := open(t, dir, 64)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		qt.Assert(t, store.Put(ctx, key, bytes.Repeat([]byte{byte(i)}, 20)), qt.IsNil)
+	}
+	// A record bigger than a whole segment still gets written.
+	qt.Assert(t, store.Put(ctx, "big", bytes.Repeat([]byte("x"), 200)), qt.IsNil)
+	qt.Check(t, len(segments(t, dir)) > 5, qt.IsTrue)
+	qt.Assert(t, store.Close(), qt.IsNil)
+
+	store = open(t, dir, 64)
+	defer store.Close()
+	for i := 0; i < 20; i++ {
+		qt.Check(t, get(t, store, fmt.Sprintf("key%02d", i)), qt.Equals, string(bytes.Repeat([]byte{byte(i)}, 20)))
+	}
+	qt.Check(t, len(get(t, store, "big")), qt.Equals, 200)
+}
+
+func TestRecovery(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unindexed tail", func(t *testing.T) {
+		dir := t.TempDir()
+		store := open(t, dir, 0)
+		qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+		qt.Assert(t, store.Sync(), qt.IsNil)
+		qt.Assert(t, store.Put(ctx, "b", []byte("beta")), qt.IsNil)
+		qt.Assert(t, store.Delete(ctx, "a"), qt.IsNil)
+		qt.Assert(t, store.Put(ctx, "c", []byte("gamma")), qt.IsNil)
+		// No Close: as if the process had stopped here.
+
+		store = open(t, dir, 0)
+		defer store.Close()
+		has, _ := store.Has(ctx, "a")
+		qt.Check(t, has, qt.IsFalse)
+		qt.Check(t, get(t, store, "b"), qt.Equals, "beta")
+		qt.Check(t, get(t, store, "c"), qt.Equals, "gamma")
+		qt.Check(t, store.Garbage() > 0, qt.IsTrue)
+	})
+
+	t.Run("torn write", func(t *testing.T) {
+		dir := t.TempDir()
+		store := open(t, dir, 0)
+		qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+		qt.Assert(t, store.Put(ctx, "b", []byte("beta")), qt.IsNil)
+
+		// Chop the last record in half.
+		segs := segments(t, dir)
+		qt.Assert(t, segs, qt.HasLen, 1)
+		fi, err := os.Stat(segs[0])
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, os.Truncate(segs[0], fi.Size()-3), qt.IsNil)
+
+		store = open(t, dir, 0)
+		qt.Check(t, get(t, store, "a"), qt.Equals, "alpha")
+		has, _ := store.Has(ctx, "b")
+		qt.Check(t, has, qt.IsFalse)
+
+		// Writing carries on from where the good data ended.
+		qt.Assert(t, store.Put(ctx, "b", []byte("beta")), qt.IsNil)
+		qt.Assert(t, store.Close(), qt.IsNil)
+		store = open(t, dir, 0)
+		defer store.Close()
+		qt.Check(t, get(t, store, "b"), qt.Equals, "beta")
+	})
+
+	t.Run("garbage tail", func(t *testing.T) {
+		dir := t.TempDir()
+		store := open(t, dir, 0)
+		qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+		f, err := os.OpenFile(segments(t, dir)[0], os.O_WRONLY|os.O_APPEND, 0)
+		qt.Assert(t, err, qt.IsNil)
+		f.Write([]byte{1, 3, 'z', 'z', 'z', 2, 'z', 'z', 0, 0, 0, 0})
+		f.Close()
+
+		store = open(t, dir, 0)
+		defer store.Close()
+		qt.Check(t, get(t, store, "a"), qt.Equals, "alpha")
+		has, _ := store.Has(ctx, "zzz")
+		qt.Check(t, has, qt.IsFalse)
+	})
+
+	t.Run("garbage lengths", func(t *testing.T) {
+		for _, tail := range [][]byte{
+			binary.AppendUvarint([]byte{1}, 1<<62),                    // a key that couldn't possibly fit.
+			binary.AppendUvarint([]byte{1, 1, 'z'}, math.MaxUint64-1), // content that couldn't possibly fit.
+		} {
+			dir := t.TempDir()
+			store := open(t, dir, 0)
+			qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+			f, err := os.OpenFile(segments(t, dir)[0], os.O_WRONLY|os.O_APPEND, 0)
+			qt.Assert(t, err, qt.IsNil)
+			f.Write(tail)
+			f.Close()
+
+			store = open(t, dir, 0)
+			qt.Check(t, get(t, store, "a"), qt.Equals, "alpha")
+			qt.Check(t, store.Close(), qt.IsNil)
+		}
+	})
+
+	t.Run("garbage index lengths", func(t *testing.T) {
+		dir := t.TempDir()
+		store := open(t, dir, 0)
+		qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+		qt.Assert(t, store.Close(), qt.IsNil)
+
+		// Indexes with good checksums, but which claim far more than they hold...
+		// (After the magic: the watermark's segment and offset, the garbage count, and then the segments and entries.)
+		for _, fields := range [][]byte{
+			binary.AppendUvarint([]byte{0, 0, 0}, 1<<60),       // segments.
+			binary.AppendUvarint([]byte{0, 0, 0, 0}, 1<<60),    // entries.
+			binary.AppendUvarint([]byte{0, 0, 0, 0, 1}, 1<<40), // key bytes.
+		} {
+			body := append([]byte("ipld-packstore-index-v1\n"), fields...)
+			body = binary.BigEndian.AppendUint32(body, crc32.ChecksumIEEE(body))
+			qt.Assert(t, os.WriteFile(filepath.Join(dir, "index"), body, 0666), qt.IsNil)
+
+			// ... are ignored, and everything is replayed instead.
+			store = open(t, dir, 0)
+			qt.Check(t, get(t, store, "a"), qt.Equals, "alpha")
+			qt.Assert(t, store.Close(), qt.IsNil)
+		}
+	})
+
+	t.Run("lost index", func(t *testing.T) {
+		dir := t.TempDir()
+		store := open(t, dir, 32)
+		qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+		qt.Assert(t, store.Put(ctx, "b", []byte("beta")), qt.IsNil)
+		qt.Assert(t, store.Delete(ctx, "a"), qt.IsNil)
+		qt.Assert(t, store.Put(ctx, "c", []byte("gamma")), qt.IsNil)
+		qt.Assert(t, store.Close(), qt.IsNil)
+		qt.Assert(t, os.Remove(filepath.Join(dir, "index")), qt.IsNil)
+
+		store = open(t, dir, 32)
+		defer store.Close()
+		has, _ := store.Has(ctx, "a")
+		qt.Check(t, has, qt.IsFalse)
+		qt.Check(t, get(t, store, "b"), qt.Equals, "beta")
+		qt.Check(t, get(t, store, "c"), qt.Equals, "gamma")
+	})
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := open(t, dir, 64)
+	for i := 0; i < 20; i++ {
+		qt.Assert(t, store.Put(ctx, fmt.Sprintf("key%02d", i), bytes.Repeat([]byte{byte(i)}, 20)), qt.IsNil)
+	}
+	qt.Check(t, store.Garbage(), qt.Equals, int64(0))
+	before := len(segments(t, dir))
+
+	// A stream that's open during compaction keeps working.
+	r, err := store.GetStream(ctx, "key01")
+	qt.Assert(t, err, qt.IsNil)
+
+	for i := 0; i < 20; i += 2 {
+		qt.Assert(t, store.Delete(ctx, fmt.Sprintf("key%02d", i)), qt.IsNil)
+	}
+	qt.Check(t, store.Garbage() > 200, qt.IsTrue)
+	qt.Assert(t, store.Compact(ctx), qt.IsNil)
+	qt.Check(t, store.Garbage(), qt.Equals, int64(0))
+
+	bs, err := io.ReadAll(r)
+	qt.Check(t, err, qt.IsNil)
+	qt.Check(t, bs, qt.DeepEquals, bytes.Repeat([]byte{1}, 20))
+	qt.Check(t, r.Close(), qt.IsNil)
+	qt.Check(t, len(segments(t, dir)) < before, qt.IsTrue)
+
+	check := func() {
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("key%02d", i)
+			has, err := store.Has(ctx, key)
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, has, qt.Equals, i%2 == 1)
+			if has {
+				qt.Check(t, get(t, store, key), qt.Equals, string(bytes.Repeat([]byte{byte(i)}, 20)))
+			}
+		}
+	}
+	check()
+
+	// Compacting with nothing to reclaim does nothing.
+	after := segments(t, dir)
+	qt.Assert(t, store.Compact(ctx), qt.IsNil)
+	qt.Check(t, segments(t, dir), qt.DeepEquals, after)
+
+	qt.Assert(t, store.Close(), qt.IsNil)
+	store = open(t, dir, 64)
+	defer store.Close()
+	check()
+}
+
+// cancelAfter is a context which is cancelled once its Err method has been called n times.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (ctx *cancelAfter) Err() error {
+	if ctx.n == 0 {
+		return context.Canceled
+	}
+	ctx.n--
+	return nil
+}
+
+func TestCompactInterrupted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := open(t, dir, 64)
+	defer store.Close()
+	for i := 0; i < 10; i++ {
+		qt.Assert(t, store.Put(ctx, fmt.Sprintf("key%02d", i), bytes.Repeat([]byte{byte(i)}, 20)), qt.IsNil)
+	}
+	qt.Assert(t, store.Delete(ctx, "key00"), qt.IsNil)
+	garbage := store.Garbage()
+
+	// The entries copied before the interruption are garbage:
+	// each is a 32-byte record (kind, key length, 5-byte key, content length, 20 bytes of content, and checksum).
+	err := store.Compact(&cancelAfter{Context: ctx, n: 3})
+	qt.Assert(t, err, qt.Equals, context.Canceled)
+	qt.Check(t, store.Garbage(), qt.Equals, garbage+3*32)
+	for i := 1; i < 10; i++ {
+		qt.Check(t, get(t, store, fmt.Sprintf("key%02d", i)), qt.Equals, string(bytes.Repeat([]byte{byte(i)}, 20)))
+	}
+
+	// Finishing the job later reclaims them.
+	qt.Assert(t, store.Compact(ctx), qt.IsNil)
+	qt.Check(t, store.Garbage(), qt.Equals, int64(0))
+}
+
+// TestConcurrent is mostly interesting when run with the race detector.
+func TestConcurrent(t *testing.T) {
+	ctx := context.Background()
+	store := open(t, t.TempDir(), 256)
+	defer store.Close()
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key%d", (w*7+i)%20)
+				content := []byte(key)
+				switch i % 5 {
+				case 0:
+					qt.Check(t, store.Put(ctx, key, content), qt.IsNil)
+				case 1:
+					if got, err := store.Get(ctx, key); err == nil {
+						qt.Check(t, got, qt.DeepEquals, content)
+					}
+				case 2:
+					if r, err := store.GetStream(ctx, key); err == nil {
+						got, _ := io.ReadAll(r)
+						qt.Check(t, got, qt.DeepEquals, content)
+						r.Close()
+					}
+				case 3:
+					qt.Check(t, store.Delete(ctx, key), qt.IsNil)
+				case 4:
+					qt.Check(t, store.Compact(ctx), qt.IsNil)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,419 @@
+/*
+The quotastore package offers a storage wrapper which keeps count of how much is stored,
+and can refuse writes that would take that over a quota.
+
+Usage is counted in bytes of content, and in number of entries;
+both are counted once per key, no matter how many times a key is written.
+(This relies on the wrapped storage being content-addressed, as it is when used by a LinkSystem:
+the content for a key is assumed never to change, so writes of keys that are already present are skipped entirely.)
+
+The counters can be saved to a file, which is read back by the next Init,
+and can be recalculated from scratch by enumerating the wrapped storage, using Reconcile.
+If the file wasn't written by a clean Close (for example, if the process crashed),
+the counters in it can't be trusted, and Init reconciles automatically if the wrapped storage can enumerate its keys.
+*/
+package quotastore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ipld/go-ipld-prime/storage"
+)
+
+// Usage is an amount of storage: a number of bytes, and a number of entries.
+type Usage struct {
+	Bytes  int64 `json:"bytes"`
+	Blocks int64 `json:"blocks"`
+}
+
+// ErrQuotaExceeded is returned when a write is refused because it would take usage over the quota.
+type ErrQuotaExceeded struct {
+	Kind  string // "bytes" or "blocks": which part of the quota would be exceeded.
+	Key   string // the key being written.  (May be empty, if a stream was refused before it was committed.)
+	Size  int64  // the size of the content being written.  (For a stream, how much had been written when it was refused.)
+	Usage Usage  // the usage at the time, including writes still in progress.
+	Quota Usage
+}
+
+func (e ErrQuotaExceeded) Error() string {
+	if e.Kind == "blocks" {
+		return fmt.Sprintf("quotastore: quota exceeded: %d blocks are stored, and the quota is %d", e.Usage.Blocks, e.Quota.Blocks)
+	}
+	return fmt.Sprintf("quotastore: quota exceeded: storing %d more bytes would take usage over the quota of %d bytes (%d bytes are stored)", e.Size, e.Quota.Bytes, e.Usage.Bytes)
+}
+
+var errClosed = errors.New("quotastore: store is closed")
+
+// Store implements go-ipld-prime/storage.ReadableStorage and go-ipld-prime/storage.WritableStorage,
+// as well as go-ipld-prime/storage.StreamingReadableStorage, go-ipld-prime/storage.PeekableStorage,
+// go-ipld-prime/storage.StreamingWritableStorage, go-ipld-prime/storage.VectorWritableStorage,
+// go-ipld-prime/storage.SizableStorage, go-ipld-prime/storage.EnumerableStorage,
+// and go-ipld-prime/storage.DeletableStorage,
+// by counting usage, enforcing the quota on writes, and passing everything else through to another storage system.
+// Writing only works if the wrapped storage is writable,
+// and the other optional features fall back or return errors as the functions in the storage package do
+// if the wrapped storage doesn't support them.
+//
+// Init must be called before a Store is used.
+// All usage of the wrapped storage must go through the Store, or else the counters will be wrong
+// (until corrected by Reconcile).
+type Store struct {
+	Wrapped storage.ReadableStorage
+
+	// Quota is the most that may be stored.  A zero field means that part is unlimited.
+	Quota Usage
+
+	path     string
+	writes   sync.RWMutex // held for reading during each write, and for writing by Reconcile.
+	mu       sync.Mutex   // protects everything below.
+	usage    Usage
+	reserved Usage                    // the usage of writes in progress.
+	inflight map[string]chan struct{} // keys being written or deleted; closed when done.
+	closed   bool
+}
+
+// usageFile is the content of the file counters are saved in.
+type usageFile struct {
+	Usage
+	Clean bool `json:"clean"`
+}
+
+// Init prepares the Store for use.
+//
+// If path isn't empty, the counters are saved in the file at that path
+// (by Sync and Close, as well as by Init itself), and restored from it when Init is next called.
+// If the file doesn't exist, or wasn't saved by Close,
+// Init calls Reconcile if the wrapped storage supports enumeration.
+// (If it doesn't, a file that wasn't cleanly closed is trusted anyway, and a missing file means starting from zero.)
+func (store *Store) Init(ctx context.Context, path string) error {
+	store.path = path
+	store.inflight = make(map[string]chan struct{})
+	var saved *usageFile
+	if path != "" {
+		bs, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("quotastore: could not read usage file: %w", err)
+		}
+		if err == nil {
+			saved = &usageFile{}
+			if err := json.Unmarshal(bs, saved); err != nil {
+				saved = nil
+			}
+		}
+	}
+	switch _, enumerable := store.Wrapped.(storage.EnumerableStorage); {
+	case saved != nil && (saved.Clean || !enumerable):
+		store.usage = saved.Usage
+	case enumerable:
+		return store.Reconcile(ctx)
+	}
+	// Until Close, the file on disk could be out of date at any moment, so it's marked as such.
+	return store.save(false)
+}
+
+// save writes the counters to the file, if there is one.
+func (store *Store) save(clean bool) error {
+	if store.path == "" {
+		return nil
+	}
+	store.mu.Lock()
+	f := usageFile{Usage: store.usage, Clean: clean}
+	store.mu.Unlock()
+	bs, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("quotastore: could not save usage: %w", err)
+	}
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("quotastore: could not save usage: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("quotastore: could not save usage: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), store.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("quotastore: could not save usage: %w", err)
+	}
+	return nil
+}
+
+// Usage returns the current usage.
+// Writes that are still in progress aren't included.
+func (store *Store) Usage() Usage {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.usage
+}
+
+// Reconcile recalculates the usage by enumerating the wrapped storage, and getting the size of every entry.
+// Writes wait until it's finished.
+//
+// It returns an error wrapping errors.ErrUnsupported if the wrapped storage can't enumerate its keys.
+func (store *Store) Reconcile(ctx context.Context) error {
+	store.writes.Lock()
+	defer store.writes.Unlock()
+	var usage Usage
+	for key, err := range storage.List(ctx, store.Wrapped, "") {
+		if err != nil {
+			return fmt.Errorf("quotastore: could not reconcile: %w", err)
+		}
+		size, err := storage.Size(ctx, store.Wrapped, key)
+		if err != nil {
+			return fmt.Errorf("quotastore: could not reconcile: %w", err)
+		}
+		usage.Bytes += size
+		usage.Blocks++
+	}
+	store.mu.Lock()
+	store.usage = usage
+	store.mu.Unlock()
+	return store.save(false)
+}
+
+// Sync saves the counters to the file given to Init, if there was one.
+func (store *Store) Sync() error {
+	return store.save(false)
+}
+
+// Close saves the counters to the file given to Init, if there was one,
+// marking them as trustworthy for the next Init.
+// Writes fail once the Store is closed.
+func (store *Store) Close() error {
+	store.writes.Lock()
+	defer store.writes.Unlock()
+	store.mu.Lock()
+	store.closed = true
+	store.mu.Unlock()
+	return store.save(true)
+}
+
+// lockKey waits until no other write or delete of the key is in progress,
+// and then marks one as in progress; unlockKey must be called when it's done.
+func (store *Store) lockKey(ctx context.Context, key string) error {
+	for {
+		store.mu.Lock()
+		if store.closed {
+			store.mu.Unlock()
+			return errClosed
+		}
+		ch, busy := store.inflight[key]
+		if !busy {
+			store.inflight[key] = make(chan struct{})
+			store.mu.Unlock()
+			return nil
+		}
+		store.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (store *Store) unlockKey(key string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	close(store.inflight[key])
+	delete(store.inflight, key)
+}
+
+// reserve is called before writing a key: it checks whether the key is already present,
+// and if not, whether there's enough quota left for it.
+// If the write should go ahead, it returns a function to call when it's done;
+// if the key is already present, that function is nil.
+func (store *Store) reserve(ctx context.Context, key string, size int64) (func(ok bool), error) {
+	if err := store.lockKey(ctx, key); err != nil {
+		return nil, err
+	}
+	has, err := store.Wrapped.Has(ctx, key)
+	if err != nil || has {
+		store.unlockKey(key)
+		return nil, err
+	}
+	store.mu.Lock()
+	total := Usage{store.usage.Bytes + store.reserved.Bytes, store.usage.Blocks + store.reserved.Blocks}
+	var kind string
+	switch {
+	case store.Quota.Blocks > 0 && total.Blocks+1 > store.Quota.Blocks:
+		kind = "blocks"
+	case store.Quota.Bytes > 0 && total.Bytes+size > store.Quota.Bytes:
+		kind = "bytes"
+	}
+	if kind != "" {
+		store.mu.Unlock()
+		store.unlockKey(key)
+		return nil, ErrQuotaExceeded{Kind: kind, Key: key, Size: size, Usage: total, Quota: store.Quota}
+	}
+	store.reserved.Bytes += size
+	store.reserved.Blocks++
+	store.mu.Unlock()
+	return func(ok bool) {
+		store.mu.Lock()
+		store.reserved.Bytes -= size
+		store.reserved.Blocks--
+		if ok {
+			store.usage.Bytes += size
+			store.usage.Blocks++
+		}
+		store.mu.Unlock()
+		store.unlockKey(key)
+	}, nil
+}
+
+func (store *Store) writable() (storage.WritableStorage, error) {
+	ws, ok := store.Wrapped.(storage.WritableStorage)
+	if !ok {
+		return nil, fmt.Errorf("quotastore: wrapped storage %T is not writable: %w", store.Wrapped, errors.ErrUnsupported)
+	}
+	return ws, nil
+}
+
+// Has implements go-ipld-prime/storage.Storage.Has.
+func (store *Store) Has(ctx context.Context, key string) (bool, error) {
+	return store.Wrapped.Has(ctx, key)
+}
+
+// Get implements go-ipld-prime/storage.ReadableStorage.Get.
+func (store *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	return store.Wrapped.Get(ctx, key)
+}
+
+// GetStream implements go-ipld-prime/storage.StreamingReadableStorage.GetStream.
+func (store *Store) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	return storage.GetStream(ctx, store.Wrapped, key)
+}
+
+// Peek implements go-ipld-prime/storage.PeekableStorage.Peek.
+func (store *Store) Peek(ctx context.Context, key string) ([]byte, io.Closer, error) {
+	return storage.Peek(ctx, store.Wrapped, key)
+}
+
+// Size implements go-ipld-prime/storage.SizableStorage.Size.
+func (store *Store) Size(ctx context.Context, key string) (int64, error) {
+	return storage.Size(ctx, store.Wrapped, key)
+}
+
+// List implements go-ipld-prime/storage.EnumerableStorage.List.
+func (store *Store) List(ctx context.Context, prefix string) iter.Seq2[string, error] {
+	return storage.List(ctx, store.Wrapped, prefix)
+}
+
+// Put implements go-ipld-prime/storage.WritableStorage.Put.
+func (store *Store) Put(ctx context.Context, key string, content []byte) error {
+	return store.PutVec(ctx, key, [][]byte{content})
+}
+
+// PutVec implements go-ipld-prime/storage.VectorWritableStorage.PutVec.
+func (store *Store) PutVec(ctx context.Context, key string, blobVec [][]byte) error {
+	ws, err := store.writable()
+	if err != nil {
+		return err
+	}
+	store.writes.RLock()
+	defer store.writes.RUnlock()
+	var size int64
+	for _, blob := range blobVec {
+		size += int64(len(blob))
+	}
+	done, err := store.reserve(ctx, key, size)
+	if err != nil || done == nil {
+		return err
+	}
+	err = storage.PutVec(ctx, ws, key, blobVec)
+	done(err == nil)
+	return err
+}
+
+// PutStream implements go-ipld-prime/storage.StreamingWritableStorage.PutStream.
+//
+// The size of the content isn't known until the stream is finished,
+// so the quota is checked both as it's written (where writing fails as soon as the quota could no longer fit it),
+// and again when the WriteCommitter is called (which is when it's actually counted).
+func (store *Store) PutStream(ctx context.Context) (io.Writer, func(key string) error, error) {
+	ws, err := store.writable()
+	if err != nil {
+		return nil, nil, err
+	}
+	wr, commit, err := storage.PutStream(ctx, ws)
+	if err != nil {
+		return nil, nil, err
+	}
+	qw := &quotaWriter{store: store, wr: wr}
+	var used bool
+	return qw, func(key string) error {
+		if used {
+			return fmt.Errorf("WriteCommitter already used")
+		}
+		used = true
+		if key == "" {
+			return commit("")
+		}
+		store.writes.RLock()
+		defer store.writes.RUnlock()
+		done, err := store.reserve(ctx, key, qw.n)
+		if err != nil || done == nil {
+			commit("")
+			return err
+		}
+		err = commit(key)
+		done(err == nil)
+		return err
+	}, nil
+}
+
+type quotaWriter struct {
+	store *Store
+	wr    io.Writer
+	n     int64
+}
+
+func (qw *quotaWriter) Write(p []byte) (int, error) {
+	store := qw.store
+	if store.Quota.Bytes > 0 {
+		store.mu.Lock()
+		used := store.usage.Bytes + store.reserved.Bytes
+		store.mu.Unlock()
+		if used+qw.n+int64(len(p)) > store.Quota.Bytes {
+			return 0, ErrQuotaExceeded{Kind: "bytes", Size: qw.n + int64(len(p)), Usage: Usage{Bytes: used}, Quota: store.Quota}
+		}
+	}
+	n, err := qw.wr.Write(p)
+	qw.n += int64(n)
+	return n, err
+}
+
+// Delete implements go-ipld-prime/storage.DeletableStorage.Delete.
+func (store *Store) Delete(ctx context.Context, key string) error {
+	store.writes.RLock()
+	defer store.writes.RUnlock()
+	if err := store.lockKey(ctx, key); err != nil {
+		return err
+	}
+	defer store.unlockKey(key)
+	size, sizeErr := storage.Size(ctx, store.Wrapped, key)
+	if err := storage.Delete(ctx, store.Wrapped, key); err != nil {
+		return err
+	}
+	if sizeErr == nil {
+		store.mu.Lock()
+		store.usage.Bytes -= size
+		store.usage.Blocks--
+		store.mu.Unlock()
+	}
+	return nil
+}
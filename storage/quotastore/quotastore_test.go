@@ -0,0 +1,133 @@
+package quotastore_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/fsstore"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/storage/quotastore"
+	"github.com/ipld/go-ipld-prime/storage/tests"
+)
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+	store := &quotastore.Store{Wrapped: &memstore.Store{}, Quota: quotastore.Usage{Bytes: 10, Blocks: 3}}
+	qt.Assert(t, store.Init(ctx, ""), qt.IsNil)
+
+	qt.Assert(t, store.Put(ctx, "a", []byte("aaaa")), qt.IsNil)
+	qt.Assert(t, store.PutVec(ctx, "b", [][]byte{[]byte("bb"), []byte("b")}), qt.IsNil)
+	qt.Check(t, store.Usage(), qt.Equals, quotastore.Usage{Bytes: 7, Blocks: 2})
+
+	// Writing a key that's already present doesn't count again.
+	qt.Assert(t, store.Put(ctx, "a", []byte("aaaa")), qt.IsNil)
+	qt.Check(t, store.Usage(), qt.Equals, quotastore.Usage{Bytes: 7, Blocks: 2})
+
+	// Too many bytes.
+	err := store.Put(ctx, "c", []byte("cccc"))
+	var qe quotastore.ErrQuotaExceeded
+	qt.Assert(t, errors.As(err, &qe), qt.IsTrue)
+	qt.Check(t, qe.Kind, qt.Equals, "bytes")
+	qt.Check(t, qe.Key, qt.Equals, "c")
+	qt.Check(t, qe.Size, qt.Equals, int64(4))
+	has, err := store.Has(ctx, "c")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Check(t, has, qt.IsFalse)
+
+	// Streams are refused as soon as they're too large.
+	wr, commit, err := store.PutStream(ctx)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = wr.Write([]byte("cc"))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = wr.Write([]byte("cc"))
+	qt.Assert(t, errors.As(err, &qe), qt.IsTrue)
+	qt.Assert(t, commit(""), qt.IsNil)
+
+	wr, commit, err = store.PutStream(ctx)
+	qt.Assert(t, err, qt.IsNil)
+	wr.Write([]byte("ccc"))
+	qt.Assert(t, commit("c"), qt.IsNil)
+	qt.Check(t, store.Usage(), qt.Equals, quotastore.Usage{Bytes: 10, Blocks: 3})
+
+	// Too many blocks.
+	err = store.Put(ctx, "d", nil)
+	qt.Assert(t, errors.As(err, &qe), qt.IsTrue)
+	qt.Check(t, qe.Kind, qt.Equals, "blocks")
+	qt.Check(t, err, qt.ErrorMatches, "quotastore: quota exceeded: 3 blocks are stored, and the quota is 3")
+
+	// Deleting frees space.
+	qt.Assert(t, store.Delete(ctx, "a"), qt.IsNil)
+	qt.Check(t, store.Usage(), qt.Equals, quotastore.Usage{Bytes: 6, Blocks: 2})
+	qt.Assert(t, store.Put(ctx, "d", []byte("dddd")), qt.IsNil)
+	qt.Check(t, store.Usage(), qt.Equals, quotastore.Usage{Bytes: 10, Blocks: 3})
+}
+
+func TestConcurrentDuplicates(t *testing.T) {
+	ctx := context.Background()
+	store := &quotastore.Store{Wrapped: &memstore.Store{}, Quota: quotastore.Usage{Bytes: 100}}
+	qt.Assert(t, store.Init(ctx, ""), qt.IsNil)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.Put(ctx, "k", []byte("content")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	qt.Check(t, store.Usage(), qt.Equals, quotastore.Usage{Bytes: 7, Blocks: 1})
+}
+
+func TestPersistence(t *testing.T) {
+	ctx := context.Background()
+	backing := &fsstore.Store{}
+	qt.Assert(t, backing.InitDefaults(t.TempDir()), qt.IsNil)
+	usagePath := filepath.Join(t.TempDir(), "usage")
+
+	store := &quotastore.Store{Wrapped: backing}
+	qt.Assert(t, store.Init(ctx, usagePath), qt.IsNil)
+	qt.Assert(t, store.Put(ctx, "a", []byte("alpha")), qt.IsNil)
+	qt.Assert(t, store.Put(ctx, "b", []byte("beta")), qt.IsNil)
+	qt.Assert(t, store.Close(), qt.IsNil)
+	qt.Check(t, store.Put(ctx, "c", []byte("gamma")), qt.ErrorMatches, "quotastore: store is closed")
+
+	// Change the storage behind the quotastore's back.  After a clean close, the saved counters are trusted.
+	qt.Assert(t, backing.Put(ctx, "c", []byte("gamma")), qt.IsNil)
+	store = &quotastore.Store{Wrapped: backing}
+	qt.Assert(t, store.Init(ctx, usagePath), qt.IsNil)
+	qt.Check(t, store.Usage(), qt.Equals, quotastore.Usage{Bytes: 9, Blocks: 2})
+
+	// ... until reconciled.
+	qt.Assert(t, store.Reconcile(ctx), qt.IsNil)
+	qt.Check(t, store.Usage(), qt.Equals, quotastore.Usage{Bytes: 14, Blocks: 3})
+
+	// Without a clean close, Init reconciles.
+	qt.Assert(t, store.Sync(), qt.IsNil)
+	qt.Assert(t, backing.Put(ctx, "d", []byte("delta")), qt.IsNil)
+	store = &quotastore.Store{Wrapped: backing}
+	qt.Assert(t, store.Init(ctx, usagePath), qt.IsNil)
+	qt.Check(t, store.Usage(), qt.Equals, quotastore.Usage{Bytes: 19, Blocks: 4})
+}
+
+func TestReconcileUnsupported(t *testing.T) {
+	store := &quotastore.Store{Wrapped: tests.Basic(&memstore.Store{})}
+	qt.Assert(t, store.Init(context.Background(), ""), qt.IsNil)
+	err := store.Reconcile(context.Background())
+	qt.Check(t, errors.Is(err, errors.ErrUnsupported), qt.IsTrue)
+}
+
+func TestConformance(t *testing.T) {
+	tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+		store := &quotastore.Store{Wrapped: &memstore.Store{}}
+		qt.Assert(t, store.Init(context.Background(), ""), qt.IsNil)
+		return store
+	}, tests.ConformanceOptions{})
+}
@@ -0,0 +1,39 @@
+package tests
+
+import (
+	"context"
+
+	"github.com/ipld/go-ipld-prime/storage"
+)
+
+// Basic returns a store which offers only the basic features of the given one:
+// Has and Get, and Put if it's writable.
+// All of the optional interfaces the given store may implement are hidden,
+// so that the fallbacks used for stores without them can be tested.
+func Basic(store storage.ReadableStorage) storage.ReadableStorage {
+	if ws, ok := store.(storage.WritableStorage); ok {
+		return basicWritable{basicReadable{store}, ws}
+	}
+	return basicReadable{store}
+}
+
+type basicReadable struct {
+	store storage.ReadableStorage
+}
+
+func (bs basicReadable) Has(ctx context.Context, key string) (bool, error) {
+	return bs.store.Has(ctx, key)
+}
+
+func (bs basicReadable) Get(ctx context.Context, key string) ([]byte, error) {
+	return bs.store.Get(ctx, key)
+}
+
+type basicWritable struct {
+	basicReadable
+	ws storage.WritableStorage
+}
+
+func (bs basicWritable) Put(ctx context.Context, key string, content []byte) error {
+	return bs.ws.Put(ctx, key, content)
+}
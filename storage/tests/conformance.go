@@ -0,0 +1,350 @@
+/*
+Package tests offers a conformance suite for implementations of the go-ipld-prime/storage interfaces.
+Call Conformance from a test in the implementation's own package.
+
+The conformance suite checks the contract that the interfaces in the storage package describe,
+plus a few rules that they don't spell out, but that users of storage rely on anyway:
+
+  - Has reports false, and Get, GetStream, and Peek return errors, for keys that aren't present.
+  - Put (and PutStream, and PutVec) of a key that's already present isn't an error,
+    and leaves the first content in place (unless the store declares otherwise; see ConformanceOptions.LastWriteWins).
+  - Content written with PutStream isn't visible until the WriteCommitter is called,
+    and isn't stored at all if it's called with the empty key.
+  - Operations given a context that's already been cancelled return an error, and have no effect.
+
+Every test is run through the feature-detecting functions in the storage package,
+so that the optional interfaces are tested where the store implements them,
+and the fallbacks are tested where it doesn't.
+*/
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/ipld/go-ipld-prime/storage"
+)
+
+// ConformanceOptions describes the allowable quirks of a storage system tested by Conformance.
+type ConformanceOptions struct {
+	// LastWriteWins should be set if the store replaces existing content when a key is written again
+	// (as go-datastore does), rather than keeping the first write.
+	// Both are permitted by the storage package's contract.
+	LastWriteWins bool
+
+	// NotConcurrent should be set if the store isn't safe for concurrent use,
+	// to skip the tests that use it from several goroutines at once.
+	NotConcurrent bool
+}
+
+// Conformance runs a suite of tests checking that a storage system behaves as the storage package specifies.
+//
+// newStore is called at the start of each subtest, and must return a new, empty store,
+// which implements both storage.ReadableStorage and storage.WritableStorage.
+//
+// The keys used are all binary CIDs (as used by LinkSystem.SetReadStorage and SetWriteStorage),
+// with the content hashed correctly,
+// so that storage systems which are restricted to CIDs can be tested too.
+// (The exception is the check of what happens when a key is rewritten, which needs two different contents for one key.)
+func Conformance(t *testing.T, newStore func(t *testing.T) storage.ReadableStorage, opts ConformanceOptions) {
+	ctx := context.Background()
+	setup := func(t *testing.T) (storage.ReadableStorage, storage.WritableStorage) {
+		store := newStore(t)
+		ws, ok := store.(storage.WritableStorage)
+		if !ok {
+			t.Fatalf("store %T is not writable", store)
+		}
+		return store, ws
+	}
+
+	t.Run("has get put", func(t *testing.T) {
+		store, ws := setup(t)
+		content := []byte("hello")
+		k := Key(content)
+		checkAbsent(t, store, k)
+		if err := ws.Put(ctx, k, content); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		checkContent(t, store, k, content)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		store, ws := setup(t)
+		if err := ws.Put(ctx, Key([]byte("other")), []byte("other")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		checkAbsent(t, store, Key([]byte("absent")))
+	})
+
+	t.Run("empty content", func(t *testing.T) {
+		store, ws := setup(t)
+		k := Key(nil)
+		if err := ws.Put(ctx, k, nil); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		checkContent(t, store, k, []byte{})
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		store, ws := setup(t)
+		first, second := []byte("first"), []byte("second")
+		k := Key(first)
+		if err := ws.Put(ctx, k, first); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		if err := ws.Put(ctx, k, first); err != nil {
+			t.Fatalf("Put of identical content under the same key failed: %v", err)
+		}
+		checkContent(t, store, k, first)
+		if err := ws.Put(ctx, k, second); err != nil {
+			t.Fatalf("Put of different content under the same key failed: %v", err)
+		}
+		if opts.LastWriteWins {
+			checkContent(t, store, k, second)
+		} else {
+			checkContent(t, store, k, first)
+		}
+	})
+
+	t.Run("put stream", func(t *testing.T) {
+		store, ws := setup(t)
+		content := []byte("streamed content")
+		k := Key(content)
+		wr, commit, err := storage.PutStream(ctx, ws)
+		if err != nil {
+			t.Fatalf("PutStream failed: %v", err)
+		}
+		for _, chunk := range [][]byte{content[:4], content[4:9], content[9:]} {
+			if _, err := wr.Write(chunk); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+		if has, _ := store.Has(ctx, k); has {
+			t.Errorf("content is visible before commit")
+		}
+		if err := commit(k); err != nil {
+			t.Fatalf("commit failed: %v", err)
+		}
+		checkContent(t, store, k, content)
+		if err := commit(k); err == nil {
+			t.Errorf("committing a second time should be an error")
+		}
+
+		// Aborting stores nothing.
+		aborted := []byte("aborted content")
+		wr, commit, err = storage.PutStream(ctx, ws)
+		if err != nil {
+			t.Fatalf("PutStream failed: %v", err)
+		}
+		wr.Write(aborted)
+		if err := commit(""); err != nil {
+			t.Fatalf("abort failed: %v", err)
+		}
+		checkAbsent(t, store, Key(aborted))
+
+		// Streams that are never written to are fine too.
+		wr, commit, err = storage.PutStream(ctx, ws)
+		if err != nil {
+			t.Fatalf("PutStream failed: %v", err)
+		}
+		if err := commit(Key(nil)); err != nil {
+			t.Fatalf("commit failed: %v", err)
+		}
+		checkContent(t, store, Key(nil), []byte{})
+	})
+
+	t.Run("put vec", func(t *testing.T) {
+		store, ws := setup(t)
+		for _, vec := range [][][]byte{
+			{[]byte("one"), []byte(" two"), []byte(" three")},
+			{[]byte("single")},
+			{nil, []byte("with"), {}, []byte(" empties"), nil},
+			{},
+		} {
+			content := bytes.Join(vec, nil)
+			k := Key(content)
+			if err := storage.PutVec(ctx, ws, k, vec); err != nil {
+				t.Fatalf("PutVec failed: %v", err)
+			}
+			checkContent(t, store, k, content)
+		}
+	})
+
+	t.Run("context cancellation", func(t *testing.T) {
+		store, ws := setup(t)
+		present := []byte("present")
+		if err := ws.Put(ctx, Key(present), present); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		if _, err := store.Has(cctx, Key(present)); err == nil {
+			t.Errorf("Has with a cancelled context should fail")
+		}
+		if _, err := store.Get(cctx, Key(present)); err == nil {
+			t.Errorf("Get with a cancelled context should fail")
+		}
+		if r, err := storage.GetStream(cctx, store, Key(present)); err == nil {
+			r.Close()
+			t.Errorf("GetStream with a cancelled context should fail")
+		}
+		if _, closer, err := storage.Peek(cctx, store, Key(present)); err == nil {
+			closer.Close()
+			t.Errorf("Peek with a cancelled context should fail")
+		}
+
+		put, vec, streamed := []byte("put"), []byte("vec"), []byte("streamed")
+		if err := ws.Put(cctx, Key(put), put); err == nil {
+			t.Errorf("Put with a cancelled context should fail")
+		}
+		if err := storage.PutVec(cctx, ws, Key(vec), [][]byte{vec}); err == nil {
+			t.Errorf("PutVec with a cancelled context should fail")
+		}
+		if wr, commit, err := storage.PutStream(cctx, ws); err == nil {
+			wr.Write(streamed)
+			if err := commit(Key(streamed)); err == nil {
+				t.Errorf("PutStream with a cancelled context should fail")
+			}
+		}
+		for _, content := range [][]byte{put, vec, streamed} {
+			checkAbsent(t, store, Key(content))
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		if opts.NotConcurrent {
+			t.Skip("store is not safe for concurrent use")
+		}
+		store, ws := setup(t)
+		const workers, entries = 8, 32
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				// Every worker writes every entry (in a different order), and reads them all back.
+				for i := 0; i < entries; i++ {
+					content := []byte(fmt.Sprintf("entry %d", (i+w*5)%entries))
+					var err error
+					switch (i + w) % 3 {
+					case 0:
+						err = ws.Put(ctx, Key(content), content)
+					case 1:
+						err = storage.PutVec(ctx, ws, Key(content), [][]byte{content[:3], content[3:]})
+					case 2:
+						var wr io.Writer
+						var commit func(string) error
+						wr, commit, err = storage.PutStream(ctx, ws)
+						if err == nil {
+							wr.Write(content)
+							err = commit(Key(content))
+						}
+					}
+					if err != nil {
+						errs <- fmt.Errorf("write of %q failed: %w", content, err)
+						return
+					}
+					got, err := store.Get(ctx, Key(content))
+					if err != nil {
+						errs <- fmt.Errorf("read of %q failed: %w", content, err)
+						return
+					}
+					if !bytes.Equal(got, content) {
+						errs <- fmt.Errorf("read of %q got %q", content, got)
+						return
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+		for i := 0; i < entries; i++ {
+			content := []byte(fmt.Sprintf("entry %d", i))
+			checkContent(t, store, Key(content), content)
+		}
+	})
+}
+
+// Key returns the binary form of a CID (v1, raw codec, sha2-256) for the content,
+// which is the kind of key the conformance suite uses.
+func Key(content []byte) string {
+	mh, err := multihash.Sum(content, multihash.SHA2_256, -1)
+	if err != nil {
+		panic(err)
+	}
+	return string(cid.NewCidV1(cid.Raw, mh).Bytes())
+}
+
+// checkContent checks the key is present with the given content, by every means of reading.
+func checkContent(t *testing.T, store storage.ReadableStorage, key string, content []byte) {
+	t.Helper()
+	ctx := context.Background()
+	has, err := store.Has(ctx, key)
+	if err != nil || !has {
+		t.Errorf("Has = %v, %v; expected true", has, err)
+	}
+	got, err := store.Get(ctx, key)
+	if err != nil {
+		t.Errorf("Get failed: %v", err)
+	} else if !bytes.Equal(got, content) {
+		t.Errorf("Get returned %q; expected %q", got, content)
+	}
+	r, err := storage.GetStream(ctx, store, key)
+	if err != nil {
+		t.Errorf("GetStream failed: %v", err)
+	} else {
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("reading from GetStream failed: %v", err)
+		} else if !bytes.Equal(got, content) {
+			t.Errorf("GetStream returned %q; expected %q", got, content)
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("closing the GetStream reader failed: %v", err)
+		}
+	}
+	got, closer, err := storage.Peek(ctx, store, key)
+	if err != nil {
+		t.Errorf("Peek failed: %v", err)
+	} else {
+		if !bytes.Equal(got, content) {
+			t.Errorf("Peek returned %q; expected %q", got, content)
+		}
+		if err := closer.Close(); err != nil {
+			t.Errorf("closing the Peek closer failed: %v", err)
+		}
+	}
+}
+
+// checkAbsent checks the key is absent, by every means of reading.
+func checkAbsent(t *testing.T, store storage.ReadableStorage, key string) {
+	t.Helper()
+	ctx := context.Background()
+	has, err := store.Has(ctx, key)
+	if err != nil || has {
+		t.Errorf("Has = %v, %v; expected false", has, err)
+	}
+	if got, err := store.Get(ctx, key); err == nil {
+		t.Errorf("Get of an absent key returned %q, and no error", got)
+	}
+	if r, err := storage.GetStream(ctx, store, key); err == nil {
+		r.Close()
+		t.Errorf("GetStream of an absent key returned no error")
+	}
+	if _, closer, err := storage.Peek(ctx, store, key); err == nil {
+		closer.Close()
+		t.Errorf("Peek of an absent key returned no error")
+	}
+}
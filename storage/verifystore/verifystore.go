@@ -0,0 +1,190 @@
+/*
+The verifystore package offers a storage wrapper which checks that content read from storage
+matches the hash in its key.
+
+linking.LinkSystem already verifies hashes when it loads blocks (unless TrustedStorage is set),
+but code that reads storage directly -- replicating blocks, exporting CARs, serving them over the network -- doesn't get that for free.
+Putting a verifystore.Store around the storage system it reads from restores that safety.
+*/
+package verifystore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage"
+)
+
+// Store implements go-ipld-prime/storage.ReadableStorage,
+// go-ipld-prime/storage.StreamingReadableStorage, and go-ipld-prime/storage.PeekableStorage
+// (as well as go-ipld-prime/storage.WritableStorage, which works if the wrapped storage is writable),
+// by reading from another storage system and verifying everything it returns.
+//
+// Keys are expected to be binary CIDs, as they are when using LinkSystem.SetReadStorage.
+// Content read is re-hashed according to the CID's multihash,
+// and if the result doesn't match, a linking.ErrHashMismatch is returned instead.
+// Reading a key that isn't a CID is an error.
+//
+// Writes are passed through to the wrapped storage unchecked.
+type Store struct {
+	Wrapped storage.ReadableStorage
+
+	// HasherChooser picks the hash function for each CID.
+	// Optional: by default, the one from cidlink.DefaultLinkSystem is used.
+	HasherChooser func(datamodel.LinkPrototype) (hash.Hash, error)
+
+	// Quarantine, if set, is called whenever content is found not to match its key,
+	// before the error is returned.
+	// It might, for example, delete the entry (with storage.Delete), or move it somewhere for inspection.
+	Quarantine func(ctx context.Context, key string, err linking.ErrHashMismatch)
+}
+
+var defaultHasherChooser = cidlink.DefaultLinkSystem().HasherChooser
+
+// hasherFor parses the key and sets up a hasher for it.
+func (store *Store) hasherFor(key string) (cidlink.Link, hash.Hash, error) {
+	c, err := cid.Cast([]byte(key))
+	if err != nil {
+		return cidlink.Link{}, nil, fmt.Errorf("verifystore: key is not a CID: %w", err)
+	}
+	lnk := cidlink.Link{Cid: c}
+	chooser := store.HasherChooser
+	if chooser == nil {
+		chooser = defaultHasherChooser
+	}
+	hasher, err := chooser(lnk.Prototype())
+	if err != nil {
+		return cidlink.Link{}, nil, fmt.Errorf("verifystore: %w", err)
+	}
+	// A digest that isn't as long as the hash function's output could never be matched
+	// (identity digests, being the content itself, are the exception).
+	dmh, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return cidlink.Link{}, nil, fmt.Errorf("verifystore: invalid multihash in %s: %w", c, err)
+	}
+	if dmh.Code != multihash.IDENTITY && dmh.Length != hasher.Size() {
+		return cidlink.Link{}, nil, fmt.Errorf("verifystore: %s has a %d-byte digest, but its hash function produces %d bytes", c, dmh.Length, hasher.Size())
+	}
+	return lnk, hasher, nil
+}
+
+// check compares what was hashed against the key, which must have come from hasherFor,
+// calling Quarantine if they differ.
+func (store *Store) check(ctx context.Context, key string, lnk cidlink.Link, hasher hash.Hash) error {
+	sum := hasher.Sum(nil)
+	if dmh, err := multihash.Decode(lnk.Hash()); err == nil && bytes.Equal(dmh.Digest, sum) {
+		return nil
+	}
+	prefix := lnk.Prefix()
+	prefix.MhLength = -1
+	err := linking.ErrHashMismatch{Actual: cidlink.LinkPrototype{Prefix: prefix}.BuildLink(sum), Expected: lnk}
+	if store.Quarantine != nil {
+		store.Quarantine(ctx, key, err)
+	}
+	return err
+}
+
+// Has implements go-ipld-prime/storage.Storage.Has.
+// It doesn't read the content, so nothing is verified.
+func (store *Store) Has(ctx context.Context, key string) (bool, error) {
+	return store.Wrapped.Has(ctx, key)
+}
+
+// Get implements go-ipld-prime/storage.ReadableStorage.Get.
+func (store *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	lnk, hasher, err := store.hasherFor(key)
+	if err != nil {
+		return nil, err
+	}
+	content, err := store.Wrapped.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	hasher.Write(content)
+	if err := store.check(ctx, key, lnk, hasher); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// Peek implements go-ipld-prime/storage.PeekableStorage.Peek.
+// If the wrapped storage doesn't support Peek, Get is used.
+func (store *Store) Peek(ctx context.Context, key string) ([]byte, io.Closer, error) {
+	lnk, hasher, err := store.hasherFor(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, closer, err := storage.Peek(ctx, store.Wrapped, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	hasher.Write(content)
+	if err := store.check(ctx, key, lnk, hasher); err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+	return content, closer, nil
+}
+
+// GetStream implements go-ipld-prime/storage.StreamingReadableStorage.GetStream.
+// If the wrapped storage doesn't support GetStream, Get is used.
+//
+// The content can only be verified once all of it has been read,
+// so a mismatch is reported by the final Read returning a linking.ErrHashMismatch, rather than io.EOF.
+// Callers must not trust anything they've read from the stream until they've seen io.EOF.
+func (store *Store) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	lnk, hasher, err := store.hasherFor(key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := storage.GetStream(ctx, store.Wrapped, key)
+	if err != nil {
+		return nil, err
+	}
+	return &verifyingReader{ReadCloser: r, ctx: ctx, store: store, key: key, lnk: lnk, hasher: hasher}, nil
+}
+
+type verifyingReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	store  *Store
+	key    string
+	lnk    cidlink.Link
+	hasher hash.Hash
+	err    error // sticky result of the check, once done.
+}
+
+func (vr *verifyingReader) Read(p []byte) (int, error) {
+	if vr.err != nil {
+		return 0, vr.err
+	}
+	n, err := vr.ReadCloser.Read(p)
+	vr.hasher.Write(p[:n])
+	if err == io.EOF {
+		if vr.err = vr.store.check(vr.ctx, vr.key, vr.lnk, vr.hasher); vr.err == nil {
+			vr.err = io.EOF
+		}
+		return n, vr.err
+	}
+	return n, err
+}
+
+// Put implements go-ipld-prime/storage.WritableStorage.Put.
+// It returns an error wrapping errors.ErrUnsupported if the wrapped storage isn't writable.
+func (store *Store) Put(ctx context.Context, key string, content []byte) error {
+	ws, ok := store.Wrapped.(storage.WritableStorage)
+	if !ok {
+		return fmt.Errorf("verifystore: wrapped storage %T is not writable: %w", store.Wrapped, errors.ErrUnsupported)
+	}
+	return ws.Put(ctx, key, content)
+}
@@ -0,0 +1,145 @@
+package verifystore_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipfs/go-cid"
+
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/storage/tests"
+	"github.com/ipld/go-ipld-prime/storage/verifystore"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	backing := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetWriteStorage(backing)
+	lp := cidlink.LinkPrototype{Prefix: cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: 32}}
+	good, err := lsys.Store(linking.LinkContext{}, lp, basicnode.NewBytes([]byte("good")))
+	qt.Assert(t, err, qt.IsNil)
+	bad, err := lsys.Store(linking.LinkContext{}, lp, basicnode.NewBytes([]byte("bad")))
+	qt.Assert(t, err, qt.IsNil)
+	backing.Bag[bad.Binary()] = []byte("corrupted")
+
+	var quarantined []string
+	store := &verifystore.Store{
+		Wrapped: backing,
+		Quarantine: func(ctx context.Context, key string, err linking.ErrHashMismatch) {
+			quarantined = append(quarantined, key)
+		},
+	}
+	checkMismatch := func(err error) {
+		t.Helper()
+		var mismatch linking.ErrHashMismatch
+		qt.Assert(t, errors.As(err, &mismatch), qt.IsTrue)
+		qt.Check(t, mismatch.Expected, qt.Equals, bad)
+	}
+
+	t.Run("get", func(t *testing.T) {
+		quarantined = nil
+		content, err := store.Get(ctx, good.Binary())
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, string(content), qt.Equals, "good")
+
+		_, err = store.Get(ctx, bad.Binary())
+		checkMismatch(err)
+		qt.Check(t, quarantined, qt.DeepEquals, []string{bad.Binary()})
+	})
+
+	t.Run("peek", func(t *testing.T) {
+		quarantined = nil
+		content, closer, err := store.Peek(ctx, good.Binary())
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, string(content), qt.Equals, "good")
+		closer.Close()
+
+		_, _, err = store.Peek(ctx, bad.Binary())
+		checkMismatch(err)
+		qt.Check(t, quarantined, qt.DeepEquals, []string{bad.Binary()})
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		quarantined = nil
+		r, err := store.GetStream(ctx, good.Binary())
+		qt.Assert(t, err, qt.IsNil)
+		content, err := io.ReadAll(r)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, string(content), qt.Equals, "good")
+		r.Close()
+
+		r, err = store.GetStream(ctx, bad.Binary())
+		qt.Assert(t, err, qt.IsNil)
+		_, err = io.ReadAll(r)
+		checkMismatch(err)
+		r.Close()
+		qt.Check(t, quarantined, qt.DeepEquals, []string{bad.Binary()})
+	})
+
+	t.Run("quarantine by deleting", func(t *testing.T) {
+		store := &verifystore.Store{
+			Wrapped: backing,
+			Quarantine: func(ctx context.Context, key string, err linking.ErrHashMismatch) {
+				qt.Check(t, storage.Delete(ctx, backing, key), qt.IsNil)
+			},
+		}
+		_, err := store.Get(ctx, bad.Binary())
+		checkMismatch(err)
+		has, err := store.Has(ctx, bad.Binary())
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, has, qt.IsFalse)
+	})
+
+	t.Run("not a cid", func(t *testing.T) {
+		qt.Assert(t, backing.Put(ctx, "plain", []byte("x")), qt.IsNil)
+		_, err := store.Get(ctx, "plain")
+		qt.Check(t, err, qt.ErrorMatches, "verifystore: key is not a CID: .*")
+	})
+
+	t.Run("as link system storage", func(t *testing.T) {
+		lsys := cidlink.DefaultLinkSystem()
+		lsys.SetReadStorage(store)
+		lsys.SetWriteStorage(store)
+		lsys.TrustedStorage = true
+		lnk, err := lsys.Store(linking.LinkContext{}, lp, basicnode.NewBytes([]byte("new")))
+		qt.Assert(t, err, qt.IsNil)
+		n, err := lsys.Load(linking.LinkContext{}, lnk, basicnode.Prototype.Any)
+		qt.Assert(t, err, qt.IsNil)
+		bs, _ := n.AsBytes()
+		qt.Check(t, string(bs), qt.Equals, "new")
+	})
+}
+
+func TestPutUnsupported(t *testing.T) {
+	store := &verifystore.Store{Wrapped: readOnly{&memstore.Store{}}}
+	err := store.Put(context.Background(), "k", nil)
+	qt.Check(t, errors.Is(err, errors.ErrUnsupported), qt.IsTrue)
+}
+
+type readOnly struct{ storage.ReadableStorage }
+
+func TestOversizedDigest(t *testing.T) {
+	ctx := context.Background()
+	// A sha2-256 digest can only be 32 bytes long; this CID claims 40.
+	long := cid.NewCidV1(cid.Raw, append([]byte{0x12, 40}, make([]byte, 40)...))
+	backing := &memstore.Store{}
+	qt.Assert(t, backing.Put(ctx, long.KeyString(), []byte("content")), qt.IsNil)
+	store := &verifystore.Store{Wrapped: backing}
+	_, err := store.Get(ctx, long.KeyString())
+	qt.Check(t, err, qt.ErrorMatches, "verifystore: .* has a 40-byte digest, but its hash function produces 32 bytes")
+}
+
+func TestConformance(t *testing.T) {
+	tests.Conformance(t, func(t *testing.T) storage.ReadableStorage {
+		return &verifystore.Store{Wrapped: &memstore.Store{}}
+	}, tests.ConformanceOptions{})
+}
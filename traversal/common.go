@@ -34,7 +34,7 @@ func (prog *Progress) init() {
 		prog.Cfg = &Config{}
 	}
 	prog.Cfg.init()
-	if prog.Cfg.LinkVisitOnlyOnce {
+	if prog.Cfg.LinkVisitOnlyOnce && (prog.SeenLinks == nil || !prog.Cfg.KeepSeenLinks) {
 		prog.SeenLinks = make(map[datamodel.Link]struct{})
 	}
 }
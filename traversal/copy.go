@@ -0,0 +1,266 @@
+package traversal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	"github.com/ipld/go-ipld-prime/linking/preload"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+)
+
+// CopyConfig is the set of options for Copy.
+type CopyConfig struct {
+	// Ctx is the context carried through the copy.
+	// Optional; use it if you need cancellation.
+	Ctx context.Context
+
+	// Source is the LinkSystem that blocks are loaded from.
+	Source linking.LinkSystem
+
+	// Destination is the LinkSystem that blocks are stored into.
+	// If its StorageReadOpener is set, it's used to check whether the destination already has a block,
+	// and such blocks are not transferred again.
+	Destination linking.LinkSystem
+
+	// DestinationStorage, if set, is the storage behind the Destination.
+	// Its Has method is then used to check whether the destination already has a block,
+	// rather than trying to read the block, and it's where blocks the destination has are read from.
+	DestinationStorage storage.ReadableStorage
+
+	// Concurrency is the number of blocks that may be fetched from the Source,
+	// and stored into the Destination, at the same time.
+	// Zero means one, which is to say, no concurrency at all.
+	// When it's more than one, both the Source and Destination storage must be safe for concurrent use.
+	Concurrency int
+
+	// Budget, if set, limits the traversal just like Progress.Budget does.
+	Budget *Budget
+
+	// LinkTargetNodePrototypeChooser chooses the Node implementation each block is loaded into,
+	// for the purpose of evaluating the selector.
+	// Optional; by default, basicnode.Prototype.Any is used.
+	LinkTargetNodePrototypeChooser LinkTargetNodePrototypeChooser
+
+	// Progress, if set, is called each time a block has been dealt with,
+	// with the totals so far.
+	// Calls are never concurrent with each other, but may come from a different goroutine than the one that called Copy.
+	Progress func(CopyStats)
+}
+
+// CopyStats summarizes the work done by Copy.
+type CopyStats struct {
+	Blocks        int   // blocks reached by the selector, whether copied or skipped.
+	BlocksCopied  int   // blocks stored into the Destination.
+	BlocksSkipped int   // blocks the Destination already had.
+	BytesCopied   int64 // total size of the blocks stored into the Destination.
+}
+
+// Copy copies every block that a selector reaches, starting from the root link, from one LinkSystem to another.
+//
+// Blocks are copied as their raw, serialized bytes, just as stored in the Source;
+// they are never re-encoded, and so their links are unchanged.
+// (Blocks do still need to be decoded in order to evaluate the selector and find further links.)
+// Every block's hash is checked before it's stored.
+// Blocks are only copied once, even if the selector reaches them more than once.
+//
+// If the Destination can read (or a DestinationStorage is given), blocks it already has are not transferred,
+// and are read from the Destination instead of the Source if the selector needs to look into them.
+//
+// When Concurrency is more than one, blocks are prefetched from the Source using a preload.Prefetcher,
+// and stored into the Destination in the background, while the traversal continues.
+//
+// The returned CopyStats are accurate even if an error is returned,
+// and reflect what had been done before the copy stopped.
+func Copy(cfg CopyConfig, root datamodel.Link, sel selector.Selector) (CopyStats, error) {
+	if cfg.Ctx == nil {
+		cfg.Ctx = context.Background()
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.LinkTargetNodePrototypeChooser == nil {
+		cfg.LinkTargetNodePrototypeChooser = func(datamodel.Link, linking.LinkContext) (datamodel.NodePrototype, error) {
+			return basicnode.Prototype.Any, nil
+		}
+	}
+	ctx, cancel := context.WithCancel(cfg.Ctx)
+	defer cancel()
+
+	c := &copier{
+		cfg:    &cfg,
+		cancel: cancel,
+		src:    cfg.Source,
+		dst:    cfg.Destination,
+		seen:   make(map[string]struct{}),
+	}
+	if cfg.DestinationStorage != nil {
+		c.dst.SetReadStorage(cfg.DestinationStorage)
+	}
+	var preloader preload.Loader
+	if cfg.Concurrency > 1 {
+		pf := preload.NewPrefetcher(ctx, &c.src, preload.PrefetcherConfig{Workers: cfg.Concurrency})
+		defer pf.Close()
+		preloader = pf.Load
+		c.jobs = make(chan copyJob, cfg.Concurrency)
+		for i := 0; i < cfg.Concurrency; i++ {
+			c.wg.Add(1)
+			go c.work()
+		}
+	}
+
+	walkLsys := cfg.Source
+	walkLsys.StorageReadOpener = c.readOpener
+	// The blocks have been verified by LoadRaw already.
+	walkLsys.TrustedStorage = true
+	prog := Progress{
+		Cfg: &Config{
+			Ctx:                            ctx,
+			LinkSystem:                     walkLsys,
+			LinkTargetNodePrototypeChooser: cfg.LinkTargetNodePrototypeChooser,
+			Preloader:                      preloader,
+		},
+		Budget: cfg.Budget,
+	}
+	err := func() error {
+		lnkCtx := linking.LinkContext{Ctx: ctx}
+		np, err := cfg.LinkTargetNodePrototypeChooser(root, lnkCtx)
+		if err != nil {
+			return fmt.Errorf("error copying: could not load root %q: %w", root, err)
+		}
+		rootNode, err := walkLsys.Load(lnkCtx, root, np)
+		if err != nil {
+			return fmt.Errorf("error copying: could not load root %q: %w", root, err)
+		}
+		return prog.WalkAdv(rootNode, sel, func(Progress, datamodel.Node, VisitReason) error { return nil })
+	}()
+
+	if c.jobs != nil {
+		close(c.jobs)
+		c.wg.Wait()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		// An error from storing a block is the root cause of anything the traversal saw afterwards.
+		err = c.err
+	}
+	return c.stats, err
+}
+
+type copier struct {
+	cfg    *CopyConfig
+	cancel context.CancelFunc
+	src    linking.LinkSystem // like cfg.Source, but possibly with a Prefetcher in front of its storage.
+	dst    linking.LinkSystem // like cfg.Destination, but reading from cfg.DestinationStorage if that's set.
+	jobs   chan copyJob       // nil if not concurrent.
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	seen  map[string]struct{}
+	stats CopyStats
+	err   error
+}
+
+type copyJob struct {
+	lnkCtx linking.LinkContext
+	lnk    datamodel.Link
+	data   []byte
+}
+
+// readOpener is the StorageReadOpener used by the traversal.
+// It serves blocks from the Destination when it has them,
+// and otherwise loads them from the Source and sends them to be stored.
+// Either way, what it serves has been verified.
+func (c *copier) readOpener(lnkCtx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+	if err := lnkCtx.Ctx.Err(); err != nil {
+		return nil, err
+	}
+	key := lnk.Binary()
+	c.mu.Lock()
+	_, seen := c.seen[key]
+	c.seen[key] = struct{}{}
+	c.mu.Unlock()
+
+	if !seen && c.dst.StorageReadOpener != nil && c.destinationHas(lnkCtx, key) {
+		if data, err := c.dst.LoadRaw(lnkCtx, lnk); err == nil {
+			c.record(func(s *CopyStats) {
+				s.Blocks++
+				s.BlocksSkipped++
+			})
+			return bytes.NewReader(data), nil
+		}
+	}
+
+	data, err := c.src.LoadRaw(lnkCtx, lnk)
+	if err != nil {
+		return nil, err
+	}
+	if !seen {
+		job := copyJob{lnkCtx, lnk, data}
+		if c.jobs == nil {
+			if err := c.store(job); err != nil {
+				return nil, err
+			}
+		} else {
+			select {
+			case c.jobs <- job:
+			case <-lnkCtx.Ctx.Done():
+				return nil, lnkCtx.Ctx.Err()
+			}
+		}
+	}
+	return bytes.NewReader(data), nil
+}
+
+// destinationHas checks whether the Destination has a block, if there's a DestinationStorage to ask.
+// Otherwise, it's assumed that it might, and reading the block will tell.
+func (c *copier) destinationHas(lnkCtx linking.LinkContext, key string) bool {
+	if c.cfg.DestinationStorage == nil {
+		return true
+	}
+	has, err := storage.Has(lnkCtx.Ctx, c.cfg.DestinationStorage, key)
+	return err == nil && has
+}
+
+func (c *copier) work() {
+	defer c.wg.Done()
+	for job := range c.jobs {
+		if err := c.store(job); err != nil {
+			c.mu.Lock()
+			if c.err == nil {
+				c.err = err
+			}
+			c.mu.Unlock()
+			c.cancel()
+		}
+	}
+}
+
+func (c *copier) store(job copyJob) error {
+	if _, err := c.cfg.Destination.StoreRaw(job.lnkCtx, job.lnk.Prototype(), job.data); err != nil {
+		return fmt.Errorf("error copying node at %q: could not store block %q: %w", job.lnkCtx.LinkPath, job.lnk, err)
+	}
+	c.record(func(s *CopyStats) {
+		s.Blocks++
+		s.BlocksCopied++
+		s.BytesCopied += int64(len(job.data))
+	})
+	return nil
+}
+
+// record updates the stats, and reports progress.
+func (c *copier) record(update func(*CopyStats)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	update(&c.stats)
+	if c.cfg.Progress != nil {
+		c.cfg.Progress(c.stats)
+	}
+}
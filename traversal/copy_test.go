@@ -0,0 +1,177 @@
+package traversal_test
+
+import (
+	"context"
+	"hash"
+	"io"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+)
+
+func TestCopy(t *testing.T) {
+	src := cidlink.DefaultLinkSystem()
+	src.SetReadStorage(&store)
+	all, err := selector.CompileSelector(selectorparse.CommonSelector_ExploreAllRecursively)
+	qt.Assert(t, err, qt.IsNil)
+
+	keys := []string{
+		rootNodeLnk.Binary(), middleMapNodeLnk.Binary(), middleListNodeLnk.Binary(), leafAlphaLnk.Binary(), leafBetaLnk.Binary(),
+	}
+	var totalBytes int64
+	for _, key := range keys {
+		totalBytes += int64(len(store.Bag[key]))
+	}
+
+	for _, concurrency := range []int{0, 4} {
+		dstStore := &memstore.Store{}
+		dst := cidlink.DefaultLinkSystem()
+		dst.SetReadStorage(dstStore)
+		dst.SetWriteStorage(dstStore)
+
+		var progress []traversal.CopyStats
+		stats, err := traversal.Copy(traversal.CopyConfig{
+			Source:      src,
+			Destination: dst,
+			Concurrency: concurrency,
+			Progress:    func(s traversal.CopyStats) { progress = append(progress, s) },
+		}, rootNodeLnk, all)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, stats, qt.Equals, traversal.CopyStats{Blocks: 5, BlocksCopied: 5, BytesCopied: totalBytes})
+		qt.Check(t, progress, qt.HasLen, 5)
+		qt.Check(t, progress[4], qt.Equals, stats)
+		for _, key := range keys {
+			qt.Check(t, dstStore.Bag[key], qt.DeepEquals, store.Bag[key])
+		}
+
+		// Doing it again transfers nothing.
+		stats, err = traversal.Copy(traversal.CopyConfig{
+			Source:      src,
+			Destination: dst,
+			Concurrency: concurrency,
+		}, rootNodeLnk, all)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, stats, qt.Equals, traversal.CopyStats{Blocks: 5, BlocksSkipped: 5})
+	}
+
+	t.Run("destination storage", func(t *testing.T) {
+		// Count how much is read and hashed, to make sure each block is only hashed once per read.
+		var read, hashed int64
+		counting := src
+		counting.StorageReadOpener = func(lnkCtx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+			read += int64(len(store.Bag[lnk.Binary()]))
+			return src.StorageReadOpener(lnkCtx, lnk)
+		}
+		counting.HasherChooser = func(lp datamodel.LinkPrototype) (hash.Hash, error) {
+			h, err := src.HasherChooser(lp)
+			return countingHasher{h, &hashed}, err
+		}
+		dstStore := &watchedStore{Store: &memstore.Store{}}
+		dst := cidlink.DefaultLinkSystem()
+		dst.SetWriteStorage(dstStore)
+
+		stats, err := traversal.Copy(traversal.CopyConfig{
+			Source:             counting,
+			Destination:        dst,
+			DestinationStorage: dstStore,
+		}, rootNodeLnk, all)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, stats, qt.Equals, traversal.CopyStats{Blocks: 5, BlocksCopied: 5, BytesCopied: totalBytes})
+		qt.Check(t, hashed, qt.Equals, read)
+		qt.Check(t, dstStore.has, qt.Equals, 5)
+		qt.Check(t, dstStore.gets, qt.Equals, 0)
+
+		// Doing it again reads the blocks from the destination, which has them all.
+		stats, err = traversal.Copy(traversal.CopyConfig{
+			Source:             counting,
+			Destination:        dst,
+			DestinationStorage: dstStore,
+		}, rootNodeLnk, all)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, stats, qt.Equals, traversal.CopyStats{Blocks: 5, BlocksSkipped: 5})
+		qt.Check(t, dstStore.gets, qt.Equals, 5)
+	})
+
+	t.Run("selected subgraph", func(t *testing.T) {
+		dstStore := &memstore.Store{}
+		dst := cidlink.DefaultLinkSystem()
+		dst.SetWriteStorage(dstStore)
+
+		ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+		sel, err := ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert("linkedList", ssb.ExploreAll(ssb.Matcher()))
+		}).Selector()
+		qt.Assert(t, err, qt.IsNil)
+		stats, err := traversal.Copy(traversal.CopyConfig{
+			Source:      src,
+			Destination: dst,
+		}, rootNodeLnk, sel)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, stats.Blocks, qt.Equals, 4) // root, the list, and both leaves; not the map.
+		qt.Check(t, stats.BlocksCopied, qt.Equals, 4)
+		qt.Check(t, dstStore.Bag, qt.HasLen, 4)
+		_, has := dstStore.Bag[middleMapNodeLnk.Binary()]
+		qt.Check(t, has, qt.IsFalse)
+	})
+
+	t.Run("missing block", func(t *testing.T) {
+		partial := &memstore.Store{}
+		for _, key := range keys {
+			if key != leafBetaLnk.Binary() {
+				partial.Put(context.Background(), key, store.Bag[key])
+			}
+		}
+		src := cidlink.DefaultLinkSystem()
+		src.SetReadStorage(partial)
+		dst := cidlink.DefaultLinkSystem()
+		dst.SetWriteStorage(&memstore.Store{})
+		stats, err := traversal.Copy(traversal.CopyConfig{
+			Source:      src,
+			Destination: dst,
+		}, rootNodeLnk, all)
+		qt.Check(t, err, qt.IsNotNil)
+		qt.Check(t, stats.BlocksCopied < 5, qt.IsTrue)
+	})
+}
+
+// watchedStore counts the calls made to a memstore.
+type watchedStore struct {
+	*memstore.Store
+	has, gets int
+}
+
+func (s *watchedStore) Has(ctx context.Context, key string) (bool, error) {
+	s.has++
+	return s.Store.Has(ctx, key)
+}
+
+func (s *watchedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.gets++
+	return s.Store.Get(ctx, key)
+}
+
+func (s *watchedStore) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.gets++
+	return s.Store.GetStream(ctx, key)
+}
+
+// countingHasher counts the bytes written to a hash.
+type countingHasher struct {
+	hash.Hash
+	n *int64
+}
+
+func (h countingHasher) Write(p []byte) (int, error) {
+	*h.n += int64(len(p))
+	return h.Hash.Write(p)
+}
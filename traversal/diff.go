@@ -0,0 +1,206 @@
+package traversal
+
+import (
+	"fmt"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+)
+
+// ChangeKind says what sort of Change a Diff found.
+type ChangeKind byte
+
+const (
+	// ChangeKind_Added means there's a value at the Change's path in the new version, but not in the old one.
+	ChangeKind_Added ChangeKind = '+'
+	// ChangeKind_Removed means there's a value at the Change's path in the old version, but not in the new one.
+	ChangeKind_Removed ChangeKind = '-'
+	// ChangeKind_Modified means the values at the Change's path in the old and new versions differ, and Diff isn't looking any deeper:
+	// either they're scalars, or they're of different kinds.
+	ChangeKind_Modified ChangeKind = '~'
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeKind_Added:
+		return "added"
+	case ChangeKind_Removed:
+		return "removed"
+	case ChangeKind_Modified:
+		return "modified"
+	default:
+		return fmt.Sprintf("ChangeKind(%q)", byte(k))
+	}
+}
+
+// Change is a difference found by Diff.
+type Change struct {
+	Kind ChangeKind
+	Path datamodel.Path
+
+	// Old is the value at Path in the old version, or nil if the change is an addition.
+	// New is the value at Path in the new version, or nil if the change is a removal.
+	//
+	// Added and removed values are given as they are, without loading any links in them:
+	// if a whole block was added, New is the link to it.
+	// Modified values, on the other hand, have been loaded if they were links (unless the diff can't load links).
+	Old, New datamodel.Node
+}
+
+// DiffFn is called by Diff for each change it finds.
+// Returning an error stops the diff, and Diff returns the error.
+type DiffFn func(Change) error
+
+// Diff compares two versions of a tree of Nodes, and calls the given DiffFn for each difference between them;
+// it does not load any links, and compares them just like any other value.
+// Use the equivalent Diff function on the Progress structure to compare graphs that span several blocks.
+func Diff(oldNode, newNode datamodel.Node, fn DiffFn) error {
+	return Progress{}.Diff(oldNode, newNode, fn)
+}
+
+// Diff compares two versions of a graph of Nodes, and calls the given DiffFn for each difference between them,
+// in the order the old version's maps and lists iterate in (with additions to a map after everything else in it).
+//
+// Where the two versions have the same link at the same path, the subgraph it links to is the same, and Diff skips it without loading it,
+// so the cost of a diff depends on how much has changed, rather than on the size of the graph.
+// Otherwise, links are loaded using Cfg.LinkSystem, and compared by what they link to:
+// a link and the value it links to are equivalent.
+// (If the LinkSystem can't load anything, links are compared like any other value instead.)
+// oldNode and newNode can be links themselves, such as basicnode.NewLink(root), so that two versions of a whole DAG can be compared by their roots.
+//
+// Maps are compared by key, and lists by index;
+// Diff doesn't look for insertions into the middle of a list, so one shows up as modifications to everything after it, and an addition at the end.
+//
+// The Budget, if set, applies as it does to a walk, with each pair of nodes compared counting as one node,
+// and each load counting as one link.
+func (prog Progress) Diff(oldNode, newNode datamodel.Node, fn DiffFn) error {
+	prog.init()
+	return prog.diff(oldNode, nil, newNode, nil, fn)
+}
+
+func (prog Progress) diff(a, aParent, b, bParent datamodel.Node, fn DiffFn) error {
+	if err := prog.checkNodeBudget(); err != nil {
+		return err
+	}
+
+	// The same link means the same data, so there's nothing more to look at.
+	if a.Kind() == datamodel.Kind_Link && b.Kind() == datamodel.Kind_Link {
+		la, _ := a.AsLink()
+		lb, _ := b.AsLink()
+		if la.Binary() == lb.Binary() {
+			return nil
+		}
+	}
+	if prog.Cfg.LinkSystem.StorageReadOpener != nil {
+		var err error
+		if a, err = prog.diffLoad(a, aParent); err != nil {
+			if _, ok := err.(SkipMe); ok {
+				return nil
+			}
+			return err
+		}
+		if b, err = prog.diffLoad(b, bParent); err != nil {
+			if _, ok := err.(SkipMe); ok {
+				return nil
+			}
+			return err
+		}
+	}
+
+	switch {
+	case a.Kind() != b.Kind():
+		return fn(Change{Kind: ChangeKind_Modified, Path: prog.Path, Old: a, New: b})
+	case a.Kind() == datamodel.Kind_Map:
+		return prog.diffMap(a, b, fn)
+	case a.Kind() == datamodel.Kind_List:
+		return prog.diffList(a, b, fn)
+	case !datamodel.DeepEqual(a, b):
+		return fn(Change{Kind: ChangeKind_Modified, Path: prog.Path, Old: a, New: b})
+	default:
+		return nil
+	}
+}
+
+// diffLoad returns n, or, if n is a link, what it links to.
+func (prog Progress) diffLoad(n, parent datamodel.Node) (datamodel.Node, error) {
+	for n.Kind() == datamodel.Kind_Link {
+		lnk, _ := n.AsLink()
+		next, err := prog.loadLink(lnk, n, parent)
+		if err != nil {
+			return nil, err
+		}
+		parent, n = n, next
+	}
+	return n, nil
+}
+
+func (prog Progress) diffMap(a, b datamodel.Node, fn DiffFn) error {
+	for itr := a.MapIterator(); !itr.Done(); {
+		k, va, err := itr.Next()
+		if err != nil {
+			return err
+		}
+		ps := asPathSegment(k)
+		progNext := prog
+		progNext.Path = prog.Path.AppendSegment(ps)
+		vb, err := b.LookupBySegment(ps)
+		if _, ok := err.(datamodel.ErrNotExists); ok {
+			if err := fn(Change{Kind: ChangeKind_Removed, Path: progNext.Path, Old: va}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := progNext.diff(va, a, vb, b, fn); err != nil {
+			return err
+		}
+	}
+	for itr := b.MapIterator(); !itr.Done(); {
+		k, vb, err := itr.Next()
+		if err != nil {
+			return err
+		}
+		ps := asPathSegment(k)
+		if _, err := a.LookupBySegment(ps); err == nil {
+			continue
+		} else if _, ok := err.(datamodel.ErrNotExists); !ok {
+			return err
+		}
+		if err := fn(Change{Kind: ChangeKind_Added, Path: prog.Path.AppendSegment(ps), New: vb}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (prog Progress) diffList(a, b datamodel.Node, fn DiffFn) error {
+	for i := int64(0); i < a.Length() || i < b.Length(); i++ {
+		progNext := prog
+		progNext.Path = prog.Path.AppendSegmentInt(i)
+		var va, vb datamodel.Node
+		var err error
+		if i < a.Length() {
+			if va, err = a.LookupByIndex(i); err != nil {
+				return err
+			}
+		}
+		if i < b.Length() {
+			if vb, err = b.LookupByIndex(i); err != nil {
+				return err
+			}
+		}
+		switch {
+		case vb == nil:
+			err = fn(Change{Kind: ChangeKind_Removed, Path: progNext.Path, Old: va})
+		case va == nil:
+			err = fn(Change{Kind: ChangeKind_Added, Path: progNext.Path, New: vb})
+		default:
+			err = progNext.diff(va, a, vb, b, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package traversal_test
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/printer"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/traversal"
+)
+
+func recordChanges(changes *[]string) traversal.DiffFn {
+	return func(c traversal.Change) error {
+		s := fmt.Sprintf("%c %s:", c.Kind, c.Path)
+		if c.Old != nil {
+			s += " " + printer.Sprint(c.Old)
+		}
+		if c.New != nil {
+			s += " -> " + printer.Sprint(c.New)
+		}
+		*changes = append(*changes, s)
+		return nil
+	}
+}
+
+func TestDiff(t *testing.T) {
+	t.Run("single block", func(t *testing.T) {
+		build := func(fn func(ma datamodel.MapAssembler)) datamodel.Node {
+			n, err := qp.BuildMap(basicnode.Prototype.Any, -1, fn)
+			qt.Assert(t, err, qt.IsNil)
+			return n
+		}
+		oldNode := build(func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "k", qp.String("same"))
+			qp.MapEntry(ma, "x", qp.List(-1, func(la datamodel.ListAssembler) {
+				qp.ListEntry(la, qp.Int(1))
+				qp.ListEntry(la, qp.Int(2))
+				qp.ListEntry(la, qp.Int(3))
+			}))
+			qp.MapEntry(ma, "y", qp.Map(-1, func(ma datamodel.MapAssembler) {
+				qp.MapEntry(ma, "z", qp.Int(1))
+			}))
+			qp.MapEntry(ma, "gone", qp.Bool(true))
+		})
+		newNode := build(func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "k", qp.String("same"))
+			qp.MapEntry(ma, "x", qp.List(-1, func(la datamodel.ListAssembler) {
+				qp.ListEntry(la, qp.Int(1))
+				qp.ListEntry(la, qp.Int(5))
+			}))
+			qp.MapEntry(ma, "y", qp.String("z"))
+			qp.MapEntry(ma, "new", qp.Int(7))
+		})
+
+		var changes []string
+		qt.Assert(t, traversal.Diff(oldNode, newNode, recordChanges(&changes)), qt.IsNil)
+		qt.Check(t, changes, qt.DeepEquals, []string{
+			"~ x/1: int{2} -> int{5}",
+			"- x/2: int{3}",
+			"~ y: map{\n\tstring{\"z\"}: int{1}\n} -> string{\"z\"}",
+			"- gone: bool{true}",
+			"+ new: -> int{7}",
+		})
+
+		changes = nil
+		qt.Assert(t, traversal.Diff(oldNode, oldNode, recordChanges(&changes)), qt.IsNil)
+		qt.Check(t, changes, qt.HasLen, 0)
+	})
+
+	t.Run("DAG", func(t *testing.T) {
+		store := &memstore.Store{}
+		lsys := cidlink.DefaultLinkSystem()
+		lsys.SetReadStorage(store)
+		lsys.SetWriteStorage(store)
+		lp := cidlink.LinkPrototype{Prefix: rootNodeLnk.(cidlink.Link).Prefix()}
+		put := func(fn func(ma datamodel.MapAssembler)) datamodel.Link {
+			n, err := qp.BuildMap(basicnode.Prototype.Any, -1, fn)
+			qt.Assert(t, err, qt.IsNil)
+			lnk, err := lsys.Store(linking.LinkContext{}, lp, n)
+			qt.Assert(t, err, qt.IsNil)
+			return lnk
+		}
+
+		// Both versions share the block at "a"; "b" is changed two blocks down.
+		shared := put(func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "n", qp.Int(1))
+		})
+		oldRoot := put(func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "a", qp.Link(shared))
+			qp.MapEntry(ma, "b", qp.Link(put(func(ma datamodel.MapAssembler) {
+				qp.MapEntry(ma, "deep", qp.Link(put(func(ma datamodel.MapAssembler) {
+					qp.MapEntry(ma, "v", qp.String("old"))
+				})))
+			})))
+			qp.MapEntry(ma, "c", qp.String("x"))
+		})
+		added := put(func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "n", qp.Int(2))
+		})
+		newRoot := put(func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "a", qp.Link(shared))
+			qp.MapEntry(ma, "b", qp.Link(put(func(ma datamodel.MapAssembler) {
+				qp.MapEntry(ma, "deep", qp.Link(put(func(ma datamodel.MapAssembler) {
+					qp.MapEntry(ma, "v", qp.String("new"))
+					qp.MapEntry(ma, "w", qp.Bool(true))
+				})))
+			})))
+			qp.MapEntry(ma, "d", qp.Link(added))
+		})
+
+		var loaded []string
+		read := lsys.StorageReadOpener
+		lsys.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+			loaded = append(loaded, lctx.LinkPath.String())
+			return read(lctx, lnk)
+		}
+		diff := func(budget *traversal.Budget) ([]string, error) {
+			loaded = nil
+			var changes []string
+			err := traversal.Progress{
+				Cfg: &traversal.Config{
+					LinkSystem:                     lsys,
+					LinkTargetNodePrototypeChooser: basicnode.Chooser,
+				},
+				Budget: budget,
+			}.Diff(basicnode.NewLink(oldRoot), basicnode.NewLink(newRoot), recordChanges(&changes))
+			return changes, err
+		}
+
+		changes, err := diff(nil)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, changes, qt.DeepEquals, []string{
+			`~ b/deep/v: string{"old"} -> string{"new"}`,
+			`+ b/deep/w: -> bool{true}`,
+			`- c: string{"x"}`,
+			`+ d: -> link{` + added.String() + `}`,
+		})
+		// The shared block, and the added one, are never loaded.
+		qt.Check(t, loaded, qt.DeepEquals, []string{"", "", "b", "b", "b/deep", "b/deep"})
+
+		// Nothing at all is loaded to compare a DAG with itself.
+		changes, loaded = nil, nil
+		err = traversal.Progress{Cfg: &traversal.Config{LinkSystem: lsys}}.Diff(basicnode.NewLink(oldRoot), basicnode.NewLink(oldRoot), recordChanges(&changes))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, changes, qt.HasLen, 0)
+		qt.Check(t, loaded, qt.HasLen, 0)
+
+		// Without a LinkSystem, links are just values.
+		changes = nil
+		qt.Assert(t, traversal.Diff(basicnode.NewLink(oldRoot), basicnode.NewLink(newRoot), recordChanges(&changes)), qt.IsNil)
+		qt.Check(t, changes, qt.DeepEquals, []string{`~ : link{` + oldRoot.String() + `} -> link{` + newRoot.String() + `}`})
+
+		// Loads count against the budget.
+		_, err = diff(&traversal.Budget{NodeBudget: 1000, LinkBudget: 3})
+		qt.Check(t, err, qt.ErrorMatches, `traversal budget exceeded: budget for links reached zero while on path "b" .*`)
+		qt.Check(t, loaded, qt.HasLen, 3)
+	})
+}
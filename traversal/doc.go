@@ -72,8 +72,9 @@
 // the same block may be essential for traversal or visit callbacks.
 //
 // A Budget can be set at the beginning of a traversal to limit the number of
-// Nodes and/or Links encountered before failing the traversal (with the
-// ErrBudgetExceeded error).
+// Nodes and/or Links encountered, the number of bytes of blocks loaded, or the
+// time taken, before failing the traversal (with the ErrBudgetExceeded error,
+// which says which budget ran out, and where).
 //
 // The "Preloader" option provides a way to parallelize block loading in
 // environments where block loading is a high-latency operation (such as
@@ -86,6 +87,26 @@
 // A Preloader and a Budget option can be used on the same traversal, BUT the
 // Preloader may not receive the same links that the traversal wants to load
 // from the LinkSystem. Use with care. See notes below.
+//
+// The "Parallelism" option goes further: WalkAdv and WalkMatching then load
+// links concurrently, looking ahead through as many blocks as they can, while
+// still calling visit functions in the usual order (or, with
+// ParallelCompletionOrder, in whatever order blocks finish loading).
+// Budgets and LinkVisitOnlyOnce are applied exactly as they would be without it.
+//
+// Walks are depth-first by default. The "NewFrontier" option changes the order
+// in which blocks are walked: NewBreadthFirstFrontier walks the shallowest
+// blocks first, and NewPriorityFrontier, or a custom Frontier, can follow links
+// in any order. Selectors apply just the same either way.
+//
+// A walk can be stopped and carried on later, even in another process:
+// Progress.Snapshot records how far it has got as a node, which can be encoded and stored,
+// and ResumeWalkAdv carries on from there, just as the original walk would have.
+//
+// Diff compares two versions of a graph, reporting what was added, removed or
+// modified at each path. Subgraphs behind the same link in both versions are
+// skipped without being loaded, so diffing large DAGs costs only as much as
+// the parts that changed.
 package traversal
 
 // Why only "point-mutation"?  This use-case gets core library support because
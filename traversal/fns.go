@@ -3,6 +3,7 @@ package traversal
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/linking"
@@ -69,7 +70,13 @@ type Progress struct {
 	PastStartAtPath bool
 
 	// SeenLinks is a set used to remember which links have been visited before, if Cfg.LinkVisitOnlyOnce is true.
+	// It's created afresh when the traversal begins, unless Cfg.KeepSeenLinks is set.
 	SeenLinks map[datamodel.Link]struct{}
+
+	par      *parallelWalk // set during a walk with Cfg.Parallelism.
+	blk      *blockState   // set during a walk with Cfg.Parallelism: the position of the block being walked.
+	resume   *resumeState  // set during ResumeWalkAdv, until the walk gets back to where it left off.
+	frontier *frontierWalk // set during a walk with Cfg.NewFrontier.
 }
 
 // Config is a set of options for a traversal. Set a Config on a Progress to customize the traversal.
@@ -90,6 +97,12 @@ type Config struct {
 	// Note that sufficiently complex selectors may require valid revisiting of some links, so setting this to true can change behavior noticably and should be done with care.
 	LinkVisitOnlyOnce bool
 
+	// KeepSeenLinks, if set along with LinkVisitOnlyOnce, makes a traversal use the Progress.SeenLinks it's given, if any,
+	// rather than starting with an empty set.
+	// Setting the same SeenLinks on several traversals then lets them share it, so that no link is visited by more than one of them.
+	// (Without this, a traversal started from within another's visit function starts afresh, and doesn't touch the outer one's SeenLinks.)
+	KeepSeenLinks bool
+
 	// StartAtPath, if set, causes a traversal to skip forward until passing this path, and only then begins calling visit functions.
 	// Block loads will also be skipped wherever possible.
 	StartAtPath datamodel.Path
@@ -97,8 +110,50 @@ type Config struct {
 	// Preloader receives links within each block prior to traversal-proper by performing a lateral scan of a block without descending into links themselves before backing up and doing a traversal-proper.
 	// This can be used to asynchronously load blocks that will be required at a later phase of the retrieval, or even to load blocks in a different order than the traversal would otherwise do.
 	// Preload calls are not de-duplicated, it is up to the receiver to do so if desired.
+	// The linking/preload package offers a ready-made Preloader, preload.Prefetcher, which fetches blocks concurrently and does de-duplicate.
 	// Beware of using both Budget and Preloader!  See the documentation on Progress for more information on this usage and the likely surprising effects.
 	Preloader preload.Loader
+
+	// Parallelism, if more than one, makes WalkAdv and WalkMatching load links concurrently, using this many goroutines.
+	// (The other traversal functions ignore it.)
+	//
+	// By default, the walk itself still proceeds one node at a time, in the same order as it would without Parallelism,
+	// and visit functions are called in exactly the same order, with the same Progress, as they would be otherwise:
+	// loading happens ahead of the walk, and the walk picks up blocks that have been loaded already as it reaches them.
+	// Up to four times Parallelism blocks may be held in memory, loaded but not yet reached.
+	// Loading ahead is speculative: some blocks may be loaded which the walk then doesn't use
+	// (for example, because of LinkVisitOnlyOnce, a budget running out, or a visit function returning an error).
+	// Budgets and LinkVisitOnlyOnce are applied by the walk itself, exactly as they would be otherwise.
+	//
+	// Visit functions are always called from the goroutine that started the walk, never concurrently;
+	// but the LinkSystem, the LinkTargetNodePrototypeChooser, and any ADL reifiers are called from several goroutines at once,
+	// so they must be safe for concurrent use.
+	Parallelism int
+
+	// ParallelCompletionOrder, when used with Parallelism,
+	// makes the walk handle each block as soon as it's loaded, rather than in the order it would otherwise.
+	// Within a block, nodes are still visited in order; but blocks are visited in whatever order their loads finish,
+	// which is not deterministic.
+	// Progress.Path, LastBlock, budgets, and LinkVisitOnlyOnce all still work,
+	// but which links LinkVisitOnlyOnce skips, and where a budget runs out, depend on the order.
+	// This can be faster than the default, because the walk never waits for one particular block while others are ready.
+	ParallelCompletionOrder bool
+
+	// NewFrontier, if set, changes the order in which WalkAdv and WalkMatching follow links.
+	// (The other traversal functions ignore it.)
+	// It's called at the start of each walk, to make a Frontier to hold the links the walk finds;
+	// the walk finishes each block before it follows any of the links in it, and then follows whichever link the Frontier gives it next.
+	// NewBreadthFirstFrontier makes the walk breadth-first, and NewPriorityFrontier follows links in whatever order a function decides.
+	//
+	// Nodes within each block are still visited in the usual order.
+	// Selectors apply exactly as they would otherwise, and the same nodes are visited, with the same Progress;
+	// only the order in which blocks are visited is different.
+	// Budgets are applied in the order the walk goes, so they may run out at a different point.
+	// Likewise, LinkVisitOnlyOnce follows a link from whichever place the walk finds it first,
+	// which may not be the place it would be followed from otherwise.
+	//
+	// Parallelism is ignored when NewFrontier is set, and a walk with a Frontier can't be snapshotted or resumed.
+	NewFrontier func() Frontier
 }
 
 // Budget is a set of monotonically-decrementing "budgets" for how many more steps we're willing to take before we should halt.
@@ -106,7 +161,9 @@ type Config struct {
 // The fields of Budget are described as "monotonically-decrementing", because that's what the traversal library will do with them,
 // but they are user-accessable and can be reset to higher numbers again by code in the visitor callbacks.  This is not recommended (why?), but possible.
 
-// If you set any budgets (by having a non-nil Progress.Budget field), you must set some value for all of them.
+// If you set any budgets (by having a non-nil Progress.Budget field), you must set some value for NodeBudget and LinkBudget;
+// ByteBudget and Deadline are only applied if you set them (see their documentation),
+// and only by the Walk functions; the Focus functions ignore them.
 // Traversal halts when _any_ of the budgets reaches zero.
 // The max value of an int (math.MaxInt64) is acceptable for any budget you don't care about.
 //
@@ -117,6 +174,30 @@ type Budget struct {
 	// LinkBudget is a monotonically-decrementing "budget" for how many more links we're willing to load before halting.
 	// (This is not aware of any caching; it's purely in terms of links encountered and traversed.)
 	LinkBudget int64
+	// ByteBudget is a monotonically-decrementing "budget" for how many more bytes of blocks we're willing to load before halting.
+	// Blocks are counted as they're read, so a block that's bigger than what's left fails the traversal without being read in full.
+	// (Like LinkBudget, this is not aware of any caching.)
+	// Since ByteBudget was added after the other budgets, it only applies once it's been set by LimitBytes;
+	// otherwise it's ignored, and there's no limit on bytes.
+	ByteBudget int64
+	// Deadline, if not zero, is the time by which the traversal must be done: it halts if it's still going then.
+	// Loads that are in progress at the deadline are cancelled (via the LinkContext's Ctx).
+	// To limit how long a traversal takes, set it to time.Now().Add(limit) before starting.
+	Deadline time.Time
+
+	limitBytes bool // whether ByteBudget applies; see LimitBytes.
+}
+
+// LimitBytes sets the ByteBudget, and turns on the limit on bytes,
+// which is off in a Budget that's been made without calling LimitBytes,
+// so that code written before there was a ByteBudget keeps working as it did.
+// It returns the Budget, so that it can be called as the Budget is made:
+//
+//	budget := (&traversal.Budget{NodeBudget: 1000, LinkBudget: 100}).LimitBytes(1 << 20)
+func (b *Budget) LimitBytes(n int64) *Budget {
+	b.ByteBudget = n
+	b.limitBytes = true
+	return b
 }
 
 // Clone returns a copy of the budget.
@@ -127,6 +208,9 @@ func (b *Budget) Clone() *Budget {
 	return &Budget{
 		NodeBudget: b.NodeBudget,
 		LinkBudget: b.LinkBudget,
+		ByteBudget: b.ByteBudget,
+		Deadline:   b.Deadline,
+		limitBytes: b.limitBytes,
 	}
 }
 
@@ -154,14 +238,23 @@ func (SkipMe) Error() string {
 	return "skip"
 }
 
+// ErrBudgetExceeded is returned when a traversal halts because one of its budgets ran out.
 type ErrBudgetExceeded struct {
-	BudgetKind string // "node"|"link"
+	BudgetKind string // "node"|"link"|"byte"|"time"
 	Path       datamodel.Path
-	Link       datamodel.Link // only present if BudgetKind=="link"
+	Link       datamodel.Link // present if BudgetKind=="link" or "byte", and if BudgetKind=="time" when a link was being loaded.
 }
 
 func (e *ErrBudgetExceeded) Error() string {
-	msg := fmt.Sprintf("traversal budget exceeded: budget for %ss reached zero while on path %q", e.BudgetKind, e.Path)
+	var msg string
+	switch e.BudgetKind {
+	case "byte":
+		msg = fmt.Sprintf("traversal budget exceeded: budget for bytes ran out while on path %q", e.Path)
+	case "time":
+		msg = fmt.Sprintf("traversal budget exceeded: deadline passed while on path %q", e.Path)
+	default:
+		msg = fmt.Sprintf("traversal budget exceeded: budget for %ss reached zero while on path %q", e.BudgetKind, e.Path)
+	}
 	if e.Link != nil {
 		msg += fmt.Sprintf(" (link: %q)", e.Link)
 	}
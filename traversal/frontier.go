@@ -0,0 +1,182 @@
+package traversal
+
+import (
+	"container/heap"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+)
+
+// This file implements Config.NewFrontier.
+//
+// A walk with a frontier walks each block as usual, but when it reaches a link, rather than loading it and walking that block straight away,
+// it pushes it onto the frontier and carries on with the block it's in.
+// Once it's done with that block, it pops the next link off the frontier, loads it, and walks that block in the same way;
+// and so on, until the frontier is empty.
+
+// Frontier holds the links a walk has found but not followed yet, and decides which of them the walk follows next.
+// Set Config.NewFrontier to use one.
+//
+// NewBreadthFirstFrontier and NewPriorityFrontier return ready-made Frontiers;
+// other implementations can order links however they like.
+// A Frontier is only used by one walk, and isn't called concurrently.
+type Frontier interface {
+	// Push adds a link the walk has found to the frontier.
+	Push(*PendingLink)
+
+	// Pop removes a link from the frontier, and returns it for the walk to follow next.
+	// It returns nil if the frontier is empty, which ends the walk.
+	Pop() *PendingLink
+}
+
+// PendingLink is a link that a walk has found but not followed yet.
+// The exported fields describe it, for a Frontier to decide when it should be followed;
+// the rest of what the walk needs to carry on from it is kept in unexported fields.
+type PendingLink struct {
+	// Path is the path of the link, which is also the path of the root of the block it links to.
+	Path datamodel.Path
+
+	// Link is the link itself.
+	Link datamodel.Link
+
+	// Depth is the number of links on the path from the root to the block the link links to, including itself.
+	// It's 1 for links in the root block, 2 for links in blocks that those link to, and so on.
+	Depth int
+
+	// Seq is the number of links the walk found before this one.
+	Seq int64
+
+	prog    Progress
+	lnkNode datamodel.Node
+	parent  datamodel.Node
+	s       selector.Selector
+	visitFn AdvVisitFn
+}
+
+// frontierWalk is the state of a walk with a frontier.
+type frontierWalk struct {
+	frontier Frontier
+	depth    int   // the depth of the block being walked.
+	seq      int64 // the number of links pushed so far.
+}
+
+// walkFrontier runs a whole walk with a frontier, starting from the root node.
+func (prog Progress) walkFrontier(n datamodel.Node, s selector.Selector, visitFn AdvVisitFn) error {
+	fw := &frontierWalk{frontier: prog.Cfg.NewFrontier()}
+	prog.frontier = fw
+	if err := prog.walkBlock(n, s, visitFn); err != nil {
+		return err
+	}
+	for {
+		pl := fw.frontier.Pop()
+		if pl == nil {
+			return nil
+		}
+		fw.depth = pl.Depth
+		n, err := pl.prog.loadLink(pl.Link, pl.lnkNode, pl.parent)
+		if err != nil {
+			if _, ok := err.(SkipMe); ok {
+				continue
+			}
+			return err
+		}
+		if err := pl.prog.walkBlock(n, pl.s, pl.visitFn); err != nil {
+			return err
+		}
+	}
+}
+
+// push is called by the walk when it reaches a link (in place of loading it, and walking the block).
+func (fw *frontierWalk) push(prog Progress, lnk datamodel.Link, lnkNode, parent datamodel.Node, s selector.Selector, visitFn AdvVisitFn) {
+	fw.frontier.Push(&PendingLink{
+		Path:    prog.Path,
+		Link:    lnk,
+		Depth:   fw.depth + 1,
+		Seq:     fw.seq,
+		prog:    prog,
+		lnkNode: lnkNode,
+		parent:  parent,
+		s:       s,
+		visitFn: visitFn,
+	})
+	fw.seq++
+}
+
+// NewBreadthFirstFrontier returns a Frontier that follows links in the order they're found.
+// That makes a walk breadth-first, as far as blocks go:
+// it walks the root block, then all the blocks it links to, then all the blocks those link to, and so on.
+// (Within each block, nodes are still walked depth-first.)
+//
+// It's meant to be used as Config.NewFrontier.
+func NewBreadthFirstFrontier() Frontier {
+	return &fifoFrontier{}
+}
+
+type fifoFrontier struct {
+	queue []*PendingLink
+}
+
+func (f *fifoFrontier) Push(pl *PendingLink) {
+	f.queue = append(f.queue, pl)
+}
+
+func (f *fifoFrontier) Pop() *PendingLink {
+	if len(f.queue) == 0 {
+		return nil
+	}
+	pl := f.queue[0]
+	f.queue[0] = nil
+	f.queue = f.queue[1:]
+	return pl
+}
+
+// NewPriorityFrontier returns a Frontier that follows whichever link it holds that comes first according to less;
+// links that are equal according to less are followed in the order they're found.
+//
+// For example, this would make a walk follow shorter paths first:
+//
+//	cfg.NewFrontier = func() traversal.Frontier {
+//		return traversal.NewPriorityFrontier(func(a, b *traversal.PendingLink) bool {
+//			return a.Path.Len() < b.Path.Len()
+//		})
+//	}
+func NewPriorityFrontier(less func(a, b *PendingLink) bool) Frontier {
+	return &priorityFrontier{less: less}
+}
+
+type priorityFrontier struct {
+	less  func(a, b *PendingLink) bool
+	links []*PendingLink
+}
+
+func (f *priorityFrontier) Push(pl *PendingLink) { heap.Push((*priorityHeap)(f), pl) }
+
+func (f *priorityFrontier) Pop() *PendingLink {
+	if len(f.links) == 0 {
+		return nil
+	}
+	return heap.Pop((*priorityHeap)(f)).(*PendingLink)
+}
+
+// priorityHeap implements heap.Interface for priorityFrontier.
+type priorityHeap priorityFrontier
+
+func (h *priorityHeap) Len() int { return len(h.links) }
+func (h *priorityHeap) Less(i, j int) bool {
+	a, b := h.links[i], h.links[j]
+	if h.less(a, b) {
+		return true
+	}
+	if h.less(b, a) {
+		return false
+	}
+	return a.Seq < b.Seq
+}
+func (h *priorityHeap) Swap(i, j int) { h.links[i], h.links[j] = h.links[j], h.links[i] }
+func (h *priorityHeap) Push(x any)    { h.links = append(h.links, x.(*PendingLink)) }
+func (h *priorityHeap) Pop() any {
+	pl := h.links[len(h.links)-1]
+	h.links[len(h.links)-1] = nil
+	h.links = h.links[:len(h.links)-1]
+	return pl
+}
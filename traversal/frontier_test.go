@@ -0,0 +1,121 @@
+package traversal_test
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+)
+
+func TestWalkFrontier(t *testing.T) {
+	store, root := parallelFixture(t, 3, 3)
+	exploreAll, err := selector.CompileSelector(selectorparse.CommonSelector_ExploreAllRecursively)
+	qt.Assert(t, err, qt.IsNil)
+
+	var loaded []string
+	walk := func(cfg traversal.Config, budget *traversal.Budget, s selector.Selector) ([]visit, error) {
+		cfg.LinkSystem = cidlink.DefaultLinkSystem()
+		cfg.LinkSystem.SetReadStorage(store)
+		read := cfg.LinkSystem.StorageReadOpener
+		cfg.LinkSystem.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+			loaded = append(loaded, lctx.LinkPath.String())
+			return read(lctx, lnk)
+		}
+		cfg.LinkTargetNodePrototypeChooser = basicnode.Chooser
+		n, err := cfg.LinkSystem.Load(linking.LinkContext{}, root, basicnode.Prototype.Any)
+		qt.Assert(t, err, qt.IsNil)
+		loaded = nil
+		var visits []visit
+		err = traversal.Progress{Cfg: &cfg, Budget: budget}.WalkAdv(n, s, recordVisits(&visits))
+		return visits, err
+	}
+	sorted := func(visits []visit) []visit {
+		visits = append([]visit(nil), visits...)
+		sort.Slice(visits, func(i, j int) bool { return visits[i].Path < visits[j].Path })
+		return visits
+	}
+
+	t.Run("breadth-first", func(t *testing.T) {
+		expected, err := walk(traversal.Config{}, nil, exploreAll)
+		qt.Assert(t, err, qt.IsNil)
+
+		actual, err := walk(traversal.Config{NewFrontier: traversal.NewBreadthFirstFrontier}, nil, exploreAll)
+		qt.Assert(t, err, qt.IsNil)
+		// The same visits, with the same Progress...
+		qt.Check(t, sorted(actual), qt.DeepEquals, sorted(expected))
+		// ... but each level of blocks is loaded before any of the next.
+		qt.Assert(t, loaded, qt.HasLen, 3+9+27)
+		for i := 1; i < len(loaded); i++ {
+			qt.Check(t, len(loaded[i-1]) <= len(loaded[i]), qt.IsTrue, qt.Commentf("%q loaded before %q", loaded[i-1], loaded[i]))
+		}
+		qt.Check(t, loaded[:3], qt.DeepEquals, []string{"children/0", "children/1", "children/2"})
+		qt.Check(t, loaded[3:6], qt.DeepEquals, []string{"children/0/children/0", "children/0/children/1", "children/0/children/2"})
+	})
+
+	t.Run("priority", func(t *testing.T) {
+		// Follow the last child first, at every level.
+		last := func(a, b *traversal.PendingLink) bool {
+			return a.Path.String() > b.Path.String()
+		}
+		_, err := walk(traversal.Config{NewFrontier: func() traversal.Frontier { return traversal.NewPriorityFrontier(last) }}, nil, exploreAll)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, loaded[:4], qt.DeepEquals, []string{"children/2", "children/2/children/2", "children/2/children/2/children/2", "children/2/children/2/children/1"})
+	})
+
+	t.Run("selector", func(t *testing.T) {
+		// Only the first child at each level, and no further than two levels down.
+		ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+		s, err := ssb.ExploreRecursive(selector.RecursionLimitDepth(3), ssb.ExploreUnion(
+			ssb.Matcher(),
+			ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+				efsb.Insert("children", ssb.ExploreIndex(0, ssb.ExploreRecursiveEdge()))
+			}),
+		)).Selector()
+		qt.Assert(t, err, qt.IsNil)
+		expected, err := walk(traversal.Config{}, nil, s)
+		qt.Assert(t, err, qt.IsNil)
+		actual, err := walk(traversal.Config{NewFrontier: traversal.NewBreadthFirstFrontier}, nil, s)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, sorted(actual), qt.DeepEquals, sorted(expected))
+		qt.Check(t, loaded, qt.DeepEquals, []string{"children/0", "children/0/children/0"})
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		_, err := walk(traversal.Config{NewFrontier: traversal.NewBreadthFirstFrontier}, &traversal.Budget{NodeBudget: 1000, LinkBudget: 5}, exploreAll)
+		qt.Check(t, err, qt.ErrorMatches, `traversal budget exceeded: budget for links reached zero while on path "children/0/children/2" .*`)
+		qt.Check(t, loaded, qt.HasLen, 5)
+
+		_, err = walk(traversal.Config{NewFrontier: traversal.NewBreadthFirstFrontier}, &traversal.Budget{NodeBudget: 20, LinkBudget: 1000}, exploreAll)
+		qt.Check(t, err, qt.ErrorMatches, `traversal budget exceeded: budget for nodes reached zero while on path "children/0/children/2/level"`)
+	})
+
+	t.Run("LinkVisitOnlyOnce", func(t *testing.T) {
+		expected, err := walk(traversal.Config{LinkVisitOnlyOnce: true}, nil, exploreAll)
+		qt.Assert(t, err, qt.IsNil)
+		expectedLoads := len(loaded)
+		actual, err := walk(traversal.Config{LinkVisitOnlyOnce: true, NewFrontier: traversal.NewBreadthFirstFrontier}, nil, exploreAll)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, len(loaded), qt.Equals, expectedLoads)
+		qt.Check(t, len(actual), qt.Equals, len(expected))
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		cfg := traversal.Config{NewFrontier: traversal.NewBreadthFirstFrontier}
+		err := traversal.Progress{Cfg: &cfg}.WalkAdv(basicnode.NewString("x"), exploreAll, func(prog traversal.Progress, n datamodel.Node, reason traversal.VisitReason) error {
+			_, err := prog.Snapshot(nil)
+			return err
+		})
+		qt.Check(t, fmt.Sprint(err), qt.Equals, "cannot snapshot a walk with a Frontier")
+	})
+}
@@ -0,0 +1,237 @@
+package traversal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+)
+
+// MigrateConfig is the set of options for Migrate.
+type MigrateConfig struct {
+	// Ctx is the context carried through the migration.
+	// Optional; use it if you need cancellation.
+	Ctx context.Context
+
+	// Source is the LinkSystem that the existing DAG is loaded from.
+	Source linking.LinkSystem
+
+	// Destination is the LinkSystem that the migrated DAG is stored into.
+	// It may be the same as Source.
+	Destination linking.LinkSystem
+
+	// LinkPrototype is used to store every migrated block,
+	// and so determines the codec and hash used for the new DAG.
+	LinkPrototype datamodel.LinkPrototype
+
+	// LinkPrototypeChooser, if set, is used instead of LinkPrototype, and can choose per block.
+	// It's given the block's old Link.
+	// If it returns a nil LinkPrototype, the block is copied to the Destination unchanged --
+	// this is only sensible for blocks that contain no links (for example, raw leaves),
+	// and is an error otherwise, since links inside it could not be rewritten.
+	LinkPrototypeChooser func(datamodel.Link, linking.LinkContext) (datamodel.LinkPrototype, error)
+
+	// LinkTargetNodePrototypeChooser chooses the Node implementation each block is loaded into.
+	// Optional; by default, basicnode.Prototype.Any is used.
+	LinkTargetNodePrototypeChooser LinkTargetNodePrototypeChooser
+}
+
+// MigrateResult is returned by Migrate.
+type MigrateResult struct {
+	// Root is the link to the root of the migrated DAG.
+	Root datamodel.Link
+
+	// Links maps each old link that was migrated to its new link.
+	Links map[datamodel.Link]datamodel.Link
+}
+
+// Migrate rewrites a whole DAG, which may be useful for moving it to a different codec or hash function.
+//
+// Starting from the root link, every block reachable by links is loaded from the Source LinkSystem,
+// and stored into the Destination LinkSystem re-encoded under the configured LinkPrototype.
+// Since a block's links must be updated to point at the new versions of the blocks they refer to,
+// this proceeds bottom-up: a block is stored only after everything it links to has been.
+// Blocks linked to more than once are migrated only once.
+//
+// Blocks are loaded without applying any NodeReifier, so the migration operates on the data model as stored.
+func Migrate(cfg MigrateConfig, root datamodel.Link) (MigrateResult, error) {
+	if cfg.Ctx == nil {
+		cfg.Ctx = context.Background()
+	}
+	if cfg.LinkTargetNodePrototypeChooser == nil {
+		cfg.LinkTargetNodePrototypeChooser = func(datamodel.Link, linking.LinkContext) (datamodel.NodePrototype, error) {
+			return basicnode.Prototype.Any, nil
+		}
+	}
+	if cfg.LinkPrototype == nil && cfg.LinkPrototypeChooser == nil {
+		return MigrateResult{}, fmt.Errorf("migrate: no LinkPrototype configured")
+	}
+	m := migration{cfg: &cfg, links: make(map[datamodel.Link]datamodel.Link)}
+	newRoot, err := m.migrateLink(datamodel.Path{}, root, nil, nil)
+	if err != nil {
+		return MigrateResult{}, err
+	}
+	return MigrateResult{Root: newRoot, Links: m.links}, nil
+}
+
+type migration struct {
+	cfg   *MigrateConfig
+	links map[datamodel.Link]datamodel.Link
+}
+
+func (m *migration) migrateLink(path datamodel.Path, lnk datamodel.Link, lnkNode, parent datamodel.Node) (datamodel.Link, error) {
+	if newLnk, done := m.links[lnk]; done {
+		return newLnk, nil
+	}
+	if err := m.cfg.Ctx.Err(); err != nil {
+		return nil, err
+	}
+	lnkCtx := linking.LinkContext{
+		Ctx:        m.cfg.Ctx,
+		LinkPath:   path,
+		LinkNode:   lnkNode,
+		ParentNode: parent,
+	}
+
+	lp := m.cfg.LinkPrototype
+	if m.cfg.LinkPrototypeChooser != nil {
+		var err error
+		lp, err = m.cfg.LinkPrototypeChooser(lnk, lnkCtx)
+		if err != nil {
+			return nil, fmt.Errorf("error migrating node at %q: could not choose a link prototype for %q: %w", path, lnk, err)
+		}
+	}
+
+	np, err := m.cfg.LinkTargetNodePrototypeChooser(lnk, lnkCtx)
+	if err != nil {
+		return nil, fmt.Errorf("error migrating node at %q: could not load link %q: %w", path, lnk, err)
+	}
+	// The raw data is kept, in case the block can be copied unchanged.
+	n, raw, err := m.cfg.Source.LoadPlusRaw(lnkCtx, lnk, np)
+	if err != nil {
+		return nil, fmt.Errorf("error migrating node at %q: could not load link %q: %w", path, lnk, err)
+	}
+
+	var newLnk datamodel.Link
+	if lp == nil {
+		if hasLinks(n) {
+			return nil, fmt.Errorf("error migrating node at %q: block %q contains links, so it cannot be copied unchanged", path, lnk)
+		}
+		newLnk, err = m.cfg.Destination.StoreRaw(lnkCtx, lnk.Prototype(), raw)
+		if err != nil {
+			return nil, fmt.Errorf("error migrating node at %q: could not store block for %q: %w", path, lnk, err)
+		}
+	} else {
+		n, err = m.rewrite(path, n, nil)
+		if err != nil {
+			return nil, err
+		}
+		newLnk, err = m.cfg.Destination.Store(lnkCtx, lp, n)
+		if err != nil {
+			return nil, fmt.Errorf("error migrating node at %q: could not store block for %q: %w", path, lnk, err)
+		}
+	}
+	m.links[lnk] = newLnk
+	return newLnk, nil
+}
+
+// rewrite returns a copy of the node with every link in it replaced by its migrated equivalent,
+// migrating the linked blocks first if that hasn't been done yet.
+// The copy is built using the same prototypes as the original nodes.
+func (m *migration) rewrite(path datamodel.Path, n, parent datamodel.Node) (datamodel.Node, error) {
+	switch n.Kind() {
+	case datamodel.Kind_Link:
+		lnk, _ := n.AsLink()
+		newLnk, err := m.migrateLink(path, lnk, n, parent)
+		if err != nil {
+			return nil, err
+		}
+		nb := n.Prototype().NewBuilder()
+		if err := nb.AssignLink(newLnk); err != nil {
+			return nil, err
+		}
+		return nb.Build(), nil
+	case datamodel.Kind_Map:
+		nb := n.Prototype().NewBuilder()
+		ma, err := nb.BeginMap(n.Length())
+		if err != nil {
+			return nil, err
+		}
+		for itr := n.MapIterator(); !itr.Done(); {
+			k, v, err := itr.Next()
+			if err != nil {
+				return nil, err
+			}
+			if err := ma.AssembleKey().AssignNode(k); err != nil {
+				return nil, err
+			}
+			v, err = m.rewrite(path.AppendSegment(asPathSegment(k)), v, n)
+			if err != nil {
+				return nil, err
+			}
+			if err := ma.AssembleValue().AssignNode(v); err != nil {
+				return nil, err
+			}
+		}
+		if err := ma.Finish(); err != nil {
+			return nil, err
+		}
+		return nb.Build(), nil
+	case datamodel.Kind_List:
+		nb := n.Prototype().NewBuilder()
+		la, err := nb.BeginList(n.Length())
+		if err != nil {
+			return nil, err
+		}
+		for itr := n.ListIterator(); !itr.Done(); {
+			idx, v, err := itr.Next()
+			if err != nil {
+				return nil, err
+			}
+			v, err = m.rewrite(path.AppendSegmentInt(idx), v, n)
+			if err != nil {
+				return nil, err
+			}
+			if err := la.AssembleValue().AssignNode(v); err != nil {
+				return nil, err
+			}
+		}
+		if err := la.Finish(); err != nil {
+			return nil, err
+		}
+		return nb.Build(), nil
+	default:
+		return n, nil
+	}
+}
+
+// hasLinks reports whether there are any links within a node (without crossing them).
+func hasLinks(n datamodel.Node) bool {
+	switch n.Kind() {
+	case datamodel.Kind_Link:
+		return true
+	case datamodel.Kind_Map:
+		for itr := n.MapIterator(); !itr.Done(); {
+			_, v, err := itr.Next()
+			if err != nil {
+				return false
+			}
+			if hasLinks(v) {
+				return true
+			}
+		}
+	case datamodel.Kind_List:
+		for itr := n.ListIterator(); !itr.Done(); {
+			_, v, err := itr.Next()
+			if err != nil {
+				return false
+			}
+			if hasLinks(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package traversal_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/ipfs/go-cid"
+
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/must"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	nodetests "github.com/ipld/go-ipld-prime/node/tests"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/traversal"
+)
+
+var cborLinkPrototype = cidlink.LinkPrototype{Prefix: cid.Prefix{
+	Version:  1,
+	Codec:    0x71,
+	MhType:   0x12,
+	MhLength: 32,
+}}
+
+func TestMigrate(t *testing.T) {
+	src := cidlink.DefaultLinkSystem()
+	src.SetReadStorage(&store)
+
+	t.Run("whole dag", func(t *testing.T) {
+		dstStore := &memstore.Store{}
+		dst := cidlink.DefaultLinkSystem()
+		dst.SetReadStorage(dstStore)
+		dst.SetWriteStorage(dstStore)
+
+		res, err := traversal.Migrate(traversal.MigrateConfig{
+			Source:        src,
+			Destination:   dst,
+			LinkPrototype: cborLinkPrototype,
+		}, rootNodeLnk)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, res.Links, qt.HasLen, 5)
+		qt.Check(t, res.Root, qt.Equals, res.Links[rootNodeLnk])
+		qt.Check(t, dstStore.Bag, qt.HasLen, 5)
+		for oldLnk, newLnk := range res.Links {
+			qt.Check(t, newLnk.(cidlink.Link).Prefix(), qt.Equals, cborLinkPrototype.Prefix)
+			qt.Check(t, oldLnk, qt.Not(qt.Equals), newLnk)
+		}
+
+		// The migrated blocks hold the same data, with links pointing at the new blocks.
+		n, err := dst.Load(linking.LinkContext{}, res.Root, basicnode.Prototype.Any)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, must.Node(n.LookupByString("plain")), nodetests.NodeContentEquals, basicnode.NewString("olde string"))
+		lnk, err := must.Node(n.LookupByString("linkedList")).AsLink()
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, lnk, qt.Equals, res.Links[middleListNodeLnk])
+		list, err := dst.Load(linking.LinkContext{}, lnk, basicnode.Prototype.Any)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, list.Length(), qt.Equals, int64(4))
+		for i, want := range []datamodel.Link{leafAlphaLnk, leafAlphaLnk, leafBetaLnk, leafAlphaLnk} {
+			elem, err := list.LookupByIndex(int64(i))
+			qt.Assert(t, err, qt.IsNil)
+			lnk, err := elem.AsLink()
+			qt.Assert(t, err, qt.IsNil)
+			qt.Check(t, lnk, qt.Equals, res.Links[want])
+		}
+		leaf, err := dst.Load(linking.LinkContext{}, res.Links[leafBetaLnk], basicnode.Prototype.Any)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, leaf, nodetests.NodeContentEquals, leafBeta)
+	})
+
+	t.Run("leaves copied unchanged", func(t *testing.T) {
+		dstStore := &memstore.Store{}
+		dst := cidlink.DefaultLinkSystem()
+		dst.SetReadStorage(dstStore)
+		dst.SetWriteStorage(dstStore)
+
+		// Count the reads, to make sure each block is only loaded once.
+		counting := src
+		reads := 0
+		counting.StorageReadOpener = func(lnkCtx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+			reads++
+			return src.StorageReadOpener(lnkCtx, lnk)
+		}
+
+		leaves := map[datamodel.Link]bool{leafAlphaLnk: true, leafBetaLnk: true}
+		res, err := traversal.Migrate(traversal.MigrateConfig{
+			Source:      counting,
+			Destination: dst,
+			LinkPrototypeChooser: func(lnk datamodel.Link, _ linking.LinkContext) (datamodel.LinkPrototype, error) {
+				if leaves[lnk] {
+					return nil, nil
+				}
+				return cborLinkPrototype, nil
+			},
+		}, middleListNodeLnk)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, res.Links, qt.HasLen, 3)
+		qt.Check(t, reads, qt.Equals, 3)
+		qt.Check(t, res.Links[leafAlphaLnk], qt.Equals, leafAlphaLnk)
+		qt.Check(t, res.Links[leafBetaLnk], qt.Equals, leafBetaLnk)
+		qt.Check(t, res.Root.(cidlink.Link).Prefix(), qt.Equals, cborLinkPrototype.Prefix)
+		has, err := dstStore.Has(context.Background(), string(leafAlphaLnk.(cidlink.Link).Bytes()))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Check(t, has, qt.IsTrue)
+	})
+
+	t.Run("cannot copy block with links unchanged", func(t *testing.T) {
+		dst := cidlink.DefaultLinkSystem()
+		dst.SetWriteStorage(&memstore.Store{})
+		_, err := traversal.Migrate(traversal.MigrateConfig{
+			Source:      src,
+			Destination: dst,
+			LinkPrototypeChooser: func(datamodel.Link, linking.LinkContext) (datamodel.LinkPrototype, error) {
+				return nil, nil
+			},
+		}, middleMapNodeLnk)
+		qt.Check(t, err, qt.ErrorMatches, `.*contains links, so it cannot be copied unchanged`)
+	})
+
+	t.Run("no link prototype", func(t *testing.T) {
+		_, err := traversal.Migrate(traversal.MigrateConfig{Source: src}, rootNodeLnk)
+		qt.Check(t, err, qt.IsNotNil)
+	})
+}
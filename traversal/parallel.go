@@ -0,0 +1,415 @@
+package traversal
+
+import (
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+)
+
+// This file implements Config.Parallelism.
+//
+// In the default (ordered) mode, the walk itself proceeds exactly as usual, in one goroutine,
+// except that when it reaches a link, it first checks whether a worker has loaded it already.
+// Workers find out what to load by "speculating": scanning each block as it's loaded, in the same way as a preload pass,
+// and queueing up every link the selector would explore.  Each of those is identified by its ordinal:
+// the index of the link within its block, appended to the ordinal of the block.
+// Ordinals sort in the order the walk will reach them, so the queue hands out the earliest first,
+// and anything earlier than the walk's current position can be dropped, since the walk won't come back for it.
+// The walk never waits for a link that isn't being loaded yet: it loads those itself, so nothing can deadlock.
+//
+// In completion order mode, the walk doesn't follow links at all: it hands them to the workers,
+// and then walks each block as its load finishes.
+
+// blockState tracks a parallel walk's position within a block.
+type blockState struct {
+	ordinal []int // the position of the block in the walk.
+	links   int   // the number of links seen in the block so far.
+}
+
+// nextOrdinal returns the ordinal for the next link seen in the block.
+func (b *blockState) nextOrdinal() []int {
+	ord := make([]int, len(b.ordinal)+1)
+	copy(ord, b.ordinal)
+	ord[len(b.ordinal)] = b.links
+	b.links++
+	return ord
+}
+
+// ordinalLess reports whether a parallel walk reaches the link with ordinal a before the one with ordinal b.
+func ordinalLess(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func ordinalKey(ord []int) string {
+	var buf []byte
+	for _, i := range ord {
+		buf = binary.AppendUvarint(buf, uint64(i))
+	}
+	return string(buf)
+}
+
+type loadState uint8
+
+const (
+	loadQueued loadState = iota
+	loadRunning
+	loadDone
+)
+
+// loadJob is a link for a worker to load.
+type loadJob struct {
+	prog    Progress // the Progress at the link (with LastBlock set to it).
+	lnk     datamodel.Link
+	lnkNode datamodel.Node
+	parent  datamodel.Node
+	s       selector.Selector
+	ord     []int
+	key     string
+
+	// Only used in ordered mode:
+	index int // in parallelWalk.queue.
+	state loadState
+	done  chan struct{} // closed when state becomes loadDone.
+
+	limit int64 // passed to loadBlock.
+
+	n    datamodel.Node
+	size int64
+	err  error
+}
+
+// loadQueue is a heap of jobs, earliest ordinal first.
+type loadQueue []*loadJob
+
+func (q loadQueue) Len() int           { return len(q) }
+func (q loadQueue) Less(i, j int) bool { return ordinalLess(q[i].ord, q[j].ord) }
+func (q loadQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+func (q *loadQueue) Push(x any) {
+	job := x.(*loadJob)
+	job.index = len(*q)
+	*q = append(*q, job)
+}
+func (q *loadQueue) Pop() any {
+	old := *q
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	job.index = -1
+	return job
+}
+
+type parallelWalk struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	cfg     Config // the config workers use: the walk's, with a context that ends with the walk, and without LinkVisitOnlyOnce or a Preloader.
+	ordered bool
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	cond   *sync.Cond // signalled when there's a job to start, when a loaded job is taken, or when the walk ends.
+	closed bool
+
+	// Used in ordered mode:
+	queue   loadQueue           // jobs waiting for a worker.
+	jobs    map[string]*loadJob // all jobs the walk hasn't taken or passed yet, by ordinal key.
+	loaded  map[string]*loadJob // jobs which are done, but not yet taken by the walk.
+	held    int                 // the number of jobs running or loaded.
+	maxHeld int
+	cursor  []int // the ordinal of the last link the walk reached.
+	budget  int64 // how many more links may be queued, if the walk has a link budget; otherwise -1.
+	bytes   int64 // how many more bytes workers may load, if the walk has a byte budget; otherwise -1.
+
+	// Used in completion order mode:
+	fifo    []*loadJob
+	pending int // the number of jobs the walk is still waiting for.  (Only touched by the walk's goroutine.)
+	results chan *loadJob
+}
+
+func newParallelWalk(prog Progress) *parallelWalk {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if deadline := prog.deadline(); !deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(prog.Cfg.Ctx, deadline)
+	} else {
+		ctx, cancel = context.WithCancel(prog.Cfg.Ctx)
+	}
+	p := &parallelWalk{
+		ctx:     ctx,
+		cancel:  cancel,
+		cfg:     *prog.Cfg,
+		ordered: !prog.Cfg.ParallelCompletionOrder,
+		jobs:    make(map[string]*loadJob),
+		loaded:  make(map[string]*loadJob),
+		maxHeld: 4 * prog.Cfg.Parallelism,
+		budget:  -1,
+		bytes:   prog.byteLimit(),
+		results: make(chan *loadJob),
+	}
+	p.cfg.Ctx = ctx
+	p.cfg.LinkVisitOnlyOnce = false
+	p.cfg.Preloader = nil
+	if prog.Budget != nil {
+		p.budget = prog.Budget.LinkBudget
+	}
+	p.cond = sync.NewCond(&p.mu)
+	context.AfterFunc(ctx, func() {
+		p.mu.Lock()
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	})
+	for i := 0; i < prog.Cfg.Parallelism; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// close stops the workers, and waits for them to exit.
+func (p *parallelWalk) close() {
+	p.cancel()
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// walk runs the whole walk, starting from the root node.
+func (p *parallelWalk) walk(prog Progress, n datamodel.Node, s selector.Selector, visitFn AdvVisitFn) error {
+	if p.ordered {
+		p.scan(prog, n, s)
+		return prog.walkBlock(n, s, visitFn)
+	}
+	if err := prog.walkBlock(n, s, visitFn); err != nil {
+		return err
+	}
+	for p.pending > 0 {
+		var job *loadJob
+		select {
+		case job = <-p.results:
+		case <-p.ctx.Done():
+			return prog.timeBudgetError(nil, p.ctx.Err())
+		}
+		p.pending--
+		if job.err != nil {
+			if _, ok := job.err.(SkipMe); ok {
+				continue
+			}
+			return job.prog.timeBudgetError(job.lnk, job.err)
+		}
+		if err := job.prog.checkByteBudget(job.lnk, job.size); err != nil {
+			return err
+		}
+		job.prog.blk = &blockState{ordinal: job.ord}
+		if err := job.prog.walkBlock(job.n, job.s, visitFn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// follow is called by the walk when it reaches a link (in place of loading it, and walking the block).
+func (p *parallelWalk) follow(prog Progress, lnk datamodel.Link, lnkNode, parent datamodel.Node, s selector.Selector, ord []int, visitFn AdvVisitFn) error {
+	if err := prog.Cfg.Ctx.Err(); err != nil {
+		return err
+	}
+	if !p.ordered {
+		if err := prog.checkLinkBudget(lnk); err != nil {
+			return err
+		}
+		p.mu.Lock()
+		p.fifo = append(p.fifo, &loadJob{prog: prog, lnk: lnk, lnkNode: lnkNode, parent: parent, s: s, ord: ord})
+		p.cond.Signal()
+		p.mu.Unlock()
+		p.pending++
+		return nil
+	}
+
+	prog.blk = &blockState{ordinal: ord}
+	n, size, err, ok := p.take(lnk, ord)
+	if ok {
+		if err := prog.checkLinkBudget(lnk); err != nil {
+			return err
+		}
+		if err == nil {
+			err = prog.checkByteBudget(lnk, size)
+		}
+		err = prog.timeBudgetError(lnk, err)
+	} else {
+		n, err = prog.loadLink(lnk, lnkNode, parent)
+		if err == nil {
+			p.scan(prog, n, s)
+		}
+	}
+	if err != nil {
+		if _, ok := err.(SkipMe); ok {
+			return nil
+		}
+		return err
+	}
+	return prog.walkBlock(n, s, visitFn)
+}
+
+// take returns the result of a worker loading the link at ord, if there is one, waiting for it if it's being loaded.
+// It returns false if the link wasn't being loaded, in which case the walk should load it itself.
+// (That includes when a worker gave up on it because it was bigger than the bytes left for loading ahead.)
+//
+// Since the walk has reached ord, everything before it won't be wanted, and is dropped.
+func (p *parallelWalk) take(lnk datamodel.Link, ord []int) (datamodel.Node, int64, error, bool) {
+	key := ordinalKey(ord)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cursor = ord
+	for _, job := range p.loaded {
+		if ordinalLess(job.ord, ord) {
+			p.drop(job)
+		}
+	}
+	job := p.jobs[key]
+	if job == nil || job.lnk.Binary() != lnk.Binary() {
+		return nil, 0, nil, false
+	}
+	switch job.state {
+	case loadQueued:
+		heap.Remove(&p.queue, job.index)
+		delete(p.jobs, key)
+		return nil, 0, nil, false
+	case loadRunning:
+		p.mu.Unlock()
+		select {
+		case <-job.done:
+		case <-p.ctx.Done():
+			p.mu.Lock()
+			return nil, 0, p.ctx.Err(), true
+		}
+		p.mu.Lock()
+	}
+	p.drop(job)
+	if _, ok := job.err.(*ErrBudgetExceeded); ok {
+		return nil, 0, nil, false
+	}
+	return job.n, job.size, job.err, true
+}
+
+// drop forgets a job which is loaded (or being loaded).  p.mu must be held.
+func (p *parallelWalk) drop(job *loadJob) {
+	delete(p.jobs, job.key)
+	delete(p.loaded, job.key)
+	p.held--
+	p.cond.Signal()
+}
+
+// scan looks through a block (which the walk is about to reach, in the ordered mode) for links to load.
+func (p *parallelWalk) scan(prog Progress, n datamodel.Node, s selector.Selector) {
+	prog.Cfg = &p.cfg
+	prog.Budget = nil
+	prog.SeenLinks = nil
+	prog.blk = &blockState{ordinal: prog.blk.ordinal}
+	prog.walkAdv(phaseSpeculate, n, s, nil) // Errors will happen again, and be reported, when the walk gets here.
+}
+
+// speculate is called by scan for each link found, and queues it to be loaded.
+func (p *parallelWalk) speculate(prog Progress, lnk datamodel.Link, lnkNode, parent datamodel.Node, s selector.Selector, ord []int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.budget == 0 || ordinalLess(ord, p.cursor) {
+		return
+	}
+	key := ordinalKey(ord)
+	if _, exists := p.jobs[key]; exists {
+		return
+	}
+	if p.budget > 0 {
+		p.budget--
+	}
+	prog.LastBlock.Path = prog.Path
+	prog.LastBlock.Link = lnk
+	job := &loadJob{prog: prog, lnk: lnk, lnkNode: lnkNode, parent: parent, s: s, ord: ord, key: key, done: make(chan struct{})}
+	p.jobs[key] = job
+	heap.Push(&p.queue, job)
+	p.cond.Signal()
+}
+
+// next waits for a job for a worker to start.  It returns nil when the walk is over.
+func (p *parallelWalk) next() *loadJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if p.closed || p.ctx.Err() != nil {
+			return nil
+		}
+		if p.ordered {
+			for len(p.queue) > 0 && ordinalLess(p.queue[0].ord, p.cursor) {
+				delete(p.jobs, heap.Pop(&p.queue).(*loadJob).key)
+			}
+			if len(p.queue) > 0 && p.held < p.maxHeld && p.bytes != 0 {
+				job := heap.Pop(&p.queue).(*loadJob)
+				job.state = loadRunning
+				job.limit = p.bytes
+				p.held++
+				return job
+			}
+		} else if len(p.fifo) > 0 {
+			job := p.fifo[0]
+			p.fifo = p.fifo[1:]
+			job.limit = -1 // The walk is waiting for this, and counts it against its own budget.
+			return job
+		}
+		p.cond.Wait()
+	}
+}
+
+func (p *parallelWalk) work() {
+	defer p.wg.Done()
+	for {
+		job := p.next()
+		if job == nil {
+			return
+		}
+		prog := job.prog
+		prog.Cfg = &p.cfg
+		prog.Budget = nil
+		prog.SeenLinks = nil
+		n, size, err := prog.loadBlock(job.lnk, job.lnkNode, job.parent, job.limit)
+		if !p.ordered {
+			job.n, job.size, job.err = n, size, err
+			select {
+			case p.results <- job:
+			case <-p.ctx.Done():
+				return
+			}
+			continue
+		}
+		p.mu.Lock()
+		job.n, job.size, job.err = n, size, err
+		if p.bytes > 0 {
+			p.bytes = max(p.bytes-size, 0)
+		}
+		job.state = loadDone
+		close(job.done)
+		stale := ordinalLess(job.ord, p.cursor)
+		if stale {
+			p.drop(job)
+		} else {
+			p.loaded[job.key] = job
+		}
+		p.mu.Unlock()
+		if err == nil && !stale {
+			prog.blk = &blockState{ordinal: job.ord}
+			p.scan(prog, n, job.s)
+		}
+	}
+}
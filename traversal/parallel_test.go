@@ -0,0 +1,174 @@
+package traversal_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/printer"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+)
+
+// parallelFixture builds a tree of blocks, depth levels deep and fanout wide,
+// where every leaf block is shared by several parents (so that LinkVisitOnlyOnce has something to do).
+func parallelFixture(t *testing.T, depth, fanout int) (*memstore.Store, datamodel.Link) {
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetWriteStorage(store)
+	lp := cidlink.LinkPrototype{Prefix: rootNodeLnk.(cidlink.Link).Prefix()}
+	var build func(level, index int) datamodel.Link
+	build = func(level, index int) datamodel.Link {
+		n, err := qp.BuildMap(basicnode.Prototype.Any, -1, func(ma datamodel.MapAssembler) {
+			if level == depth {
+				qp.MapEntry(ma, "leaf", qp.Int(int64(index%fanout)))
+				return
+			}
+			qp.MapEntry(ma, "level", qp.Int(int64(level)))
+			qp.MapEntry(ma, "children", qp.List(-1, func(la datamodel.ListAssembler) {
+				for i := 0; i < fanout; i++ {
+					qp.ListEntry(la, qp.Link(build(level+1, index*fanout+i)))
+				}
+			}))
+		})
+		qt.Assert(t, err, qt.IsNil)
+		lnk, err := lsys.Store(linking.LinkContext{}, lp, n)
+		qt.Assert(t, err, qt.IsNil)
+		return lnk
+	}
+	return store, build(0, 0)
+}
+
+// slowLinkSystem reads from store, taking a little while for each block, and counting how many reads happen at once.
+func slowLinkSystem(store *memstore.Store, delay time.Duration, concurrent *atomic.Int64, maxConcurrent *atomic.Int64) linking.LinkSystem {
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	read := lsys.StorageReadOpener
+	lsys.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		n := concurrent.Add(1)
+		defer concurrent.Add(-1)
+		for {
+			max := maxConcurrent.Load()
+			if n <= max || maxConcurrent.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(delay)
+		return read(lctx, lnk)
+	}
+	return lsys
+}
+
+type visit struct {
+	Path, Reason, Node, LastBlock string
+}
+
+func walkRecording(t *testing.T, cfg traversal.Config, budget *traversal.Budget, root datamodel.Link, s selector.Selector) ([]visit, error) {
+	cfg.LinkTargetNodePrototypeChooser = basicnode.Chooser
+	n, err := cfg.LinkSystem.Load(linking.LinkContext{}, root, basicnode.Prototype.Any)
+	qt.Assert(t, err, qt.IsNil)
+	var visits []visit
+	err = traversal.Progress{Cfg: &cfg, Budget: budget}.WalkAdv(n, s, func(prog traversal.Progress, n datamodel.Node, reason traversal.VisitReason) error {
+		var lastBlock string
+		if prog.LastBlock.Link != nil {
+			lastBlock = prog.LastBlock.Path.String() + "@" + prog.LastBlock.Link.String()
+		}
+		visits = append(visits, visit{prog.Path.String(), string(reason), printer.Sprint(n), lastBlock})
+		return nil
+	})
+	return visits, err
+}
+
+func TestWalkParallel(t *testing.T) {
+	store, root := parallelFixture(t, 3, 4)
+	exploreAll, err := selector.CompileSelector(selectorparse.CommonSelector_ExploreAllRecursively)
+	qt.Assert(t, err, qt.IsNil)
+
+	for _, tc := range []struct {
+		name   string
+		cfg    traversal.Config
+		budget *traversal.Budget
+	}{
+		{name: "plain"},
+		{name: "LinkVisitOnlyOnce", cfg: traversal.Config{LinkVisitOnlyOnce: true}},
+		{name: "StartAtPath", cfg: traversal.Config{StartAtPath: datamodel.ParsePath("children/2/children/1")}},
+		{name: "budget", budget: &traversal.Budget{NodeBudget: 1000, LinkBudget: 30}},
+		{name: "byte budget", budget: (&traversal.Budget{NodeBudget: 1000, LinkBudget: 1000}).LimitBytes(2000)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var concurrent, maxConcurrent atomic.Int64
+			cfg := tc.cfg
+			cfg.LinkSystem = slowLinkSystem(store, 0, &concurrent, &maxConcurrent)
+			expected, expectedErr := walkRecording(t, cfg, tc.budget.Clone(), root, exploreAll)
+			qt.Assert(t, len(expected) > 0, qt.IsTrue)
+			qt.Assert(t, expectedErr != nil, qt.Equals, tc.budget != nil)
+
+			cfg.LinkSystem = slowLinkSystem(store, 2*time.Millisecond, &concurrent, &maxConcurrent)
+			cfg.Parallelism = 8
+			start := time.Now()
+			actual, err := walkRecording(t, cfg, tc.budget.Clone(), root, exploreAll)
+			elapsed := time.Since(start)
+			qt.Check(t, fmt.Sprint(err), qt.Equals, fmt.Sprint(expectedErr))
+			qt.Check(t, actual, qt.DeepEquals, expected)
+			qt.Check(t, maxConcurrent.Load() > 1, qt.IsTrue)
+			t.Logf("%d visits in %v, with up to %d loads at once", len(actual), elapsed, maxConcurrent.Load())
+
+			// In completion order, the same nodes are visited (the budget aside, since where it runs out depends on the order).
+			if tc.budget != nil {
+				return
+			}
+			cfg.ParallelCompletionOrder = true
+			unordered, err := walkRecording(t, cfg, nil, root, exploreAll)
+			qt.Assert(t, err, qt.IsNil)
+			sortVisits := func(visits []visit) []visit {
+				visits = append([]visit(nil), visits...)
+				sort.Slice(visits, func(i, j int) bool {
+					return fmt.Sprint(visits[i]) < fmt.Sprint(visits[j])
+				})
+				return visits
+			}
+			if tc.cfg.LinkVisitOnlyOnce {
+				// Which path each shared block is reached by depends on the order, so just count.
+				qt.Check(t, len(unordered), qt.Equals, len(expected))
+			} else {
+				qt.Check(t, sortVisits(unordered), qt.DeepEquals, sortVisits(expected))
+			}
+		})
+	}
+
+	t.Run("cancellation", func(t *testing.T) {
+		var concurrent, maxConcurrent atomic.Int64
+		ctx, cancel := context.WithCancel(context.Background())
+		cfg := traversal.Config{
+			Ctx:         ctx,
+			LinkSystem:  slowLinkSystem(store, time.Millisecond, &concurrent, &maxConcurrent),
+			Parallelism: 4,
+		}
+		cfg.LinkTargetNodePrototypeChooser = basicnode.Chooser
+		n, err := cfg.LinkSystem.Load(linking.LinkContext{}, root, basicnode.Prototype.Any)
+		qt.Assert(t, err, qt.IsNil)
+		var visits int
+		err = traversal.Progress{Cfg: &cfg}.WalkAdv(n, exploreAll, func(traversal.Progress, datamodel.Node, traversal.VisitReason) error {
+			visits++
+			if visits == 10 {
+				cancel()
+			}
+			return nil
+		})
+		qt.Check(t, err, qt.ErrorIs, context.Canceled)
+		qt.Check(t, concurrent.Load(), qt.Equals, int64(0))
+	})
+}
@@ -0,0 +1,327 @@
+package traversal
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+)
+
+// Snapshot records how far a walk has got, as a node, so that the walk can be carried on later with ResumeWalkAdv
+// (perhaps in another process, after this one has stopped or crashed).
+// The node is made of plain data and links, so it can be encoded with any codec that supports links, such as dag-json or dag-cbor.
+//
+// Snapshot is meant to be called on the Progress handed to a visit function during WalkAdv or WalkMatching.
+// It records the Path of the visit, the LastBlock, what's left of the Budget,
+// and, if LinkVisitOnlyOnce is set, the SeenLinks;
+// a walk resumed from it carries on with whatever would have come after that visit.
+//
+// A compiled Selector can't be turned back into data, so the selector's state isn't recorded as such:
+// ResumeWalkAdv recovers it by following the path again from the root, exploring the selector as it goes.
+// If selectorSpec is not nil, it's recorded in the snapshot, so that ResumeWalkAdv doesn't need the selector to be given to it again;
+// it should be the node the walk's Selector was compiled from.
+//
+// A walk with ParallelCompletionOrder visits nodes in no particular order, and one with a Frontier doesn't walk depth-first,
+// so there's no telling what comes after a given visit from its path, and Snapshot returns an error.
+func (prog Progress) Snapshot(selectorSpec datamodel.Node) (datamodel.Node, error) {
+	if prog.Cfg != nil && prog.Cfg.NewFrontier != nil {
+		return nil, fmt.Errorf("cannot snapshot a walk with a Frontier")
+	}
+	if prog.Cfg != nil && prog.Cfg.Parallelism > 1 && prog.Cfg.ParallelCompletionOrder {
+		return nil, fmt.Errorf("cannot snapshot a walk with ParallelCompletionOrder")
+	}
+	var seenLinks []datamodel.Link
+	if prog.Cfg != nil && prog.Cfg.LinkVisitOnlyOnce {
+		seenLinks = make([]datamodel.Link, 0, len(prog.SeenLinks))
+		for lnk := range prog.SeenLinks {
+			seenLinks = append(seenLinks, lnk)
+		}
+		// Sort them, so that the same progress always makes the same snapshot.
+		sort.Slice(seenLinks, func(i, j int) bool { return seenLinks[i].Binary() < seenLinks[j].Binary() })
+	}
+	return qp.BuildMap(basicnode.Prototype.Map, -1, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "path", snapshotPath(prog.Path))
+		if prog.LastBlock.Link != nil {
+			qp.MapEntry(ma, "lastBlock", qp.Map(2, func(ma datamodel.MapAssembler) {
+				qp.MapEntry(ma, "path", snapshotPath(prog.LastBlock.Path))
+				qp.MapEntry(ma, "link", qp.Link(prog.LastBlock.Link))
+			}))
+		}
+		if prog.Budget != nil {
+			qp.MapEntry(ma, "budget", qp.Map(-1, func(ma datamodel.MapAssembler) {
+				qp.MapEntry(ma, "nodes", qp.Int(prog.Budget.NodeBudget))
+				qp.MapEntry(ma, "links", qp.Int(prog.Budget.LinkBudget))
+				if prog.Budget.limitBytes {
+					qp.MapEntry(ma, "bytes", qp.Int(prog.Budget.ByteBudget))
+				}
+				if !prog.Budget.Deadline.IsZero() {
+					qp.MapEntry(ma, "deadline", qp.String(prog.Budget.Deadline.Format(time.RFC3339Nano)))
+				}
+			}))
+		}
+		if seenLinks != nil {
+			qp.MapEntry(ma, "seenLinks", qp.List(int64(len(seenLinks)), func(la datamodel.ListAssembler) {
+				for _, lnk := range seenLinks {
+					qp.ListEntry(la, qp.Link(lnk))
+				}
+			}))
+		}
+		if selectorSpec != nil {
+			qp.MapEntry(ma, "selector", qp.Node(selectorSpec))
+		}
+	})
+}
+
+func snapshotPath(p datamodel.Path) qp.Assemble {
+	return qp.List(int64(p.Len()), func(la datamodel.ListAssembler) {
+		for _, ps := range p.Segments() {
+			qp.ListEntry(la, qp.String(ps.String()))
+		}
+	})
+}
+
+// ResumeWalkAdv carries on a WalkAdv from a snapshot made by Snapshot.
+// The visit function is called exactly as it would have been by the rest of the walk the snapshot was made during,
+// had that walk not stopped.
+//
+// The walk has to be set up as the original one was:
+// n must be the same root node, and the Progress must have the same Path and a Cfg with the same options.
+// s must be the same selector, or can be nil if the snapshot records the selector's spec.
+// The Budget and SeenLinks are restored from the snapshot, if it has them;
+// if the Progress already has SeenLinks and Cfg.KeepSeenLinks is set, the snapshot's are added to them.
+//
+// The blocks on the path from the root to where the walk left off are loaded again,
+// but nothing else that was walked already is, and none of it is visited again or counted against the budget.
+// If the path can't be followed again, or doesn't go through the same LastBlock, ResumeWalkAdv returns an error.
+func (prog Progress) ResumeWalkAdv(snapshot datamodel.Node, n datamodel.Node, s selector.Selector, fn AdvVisitFn) error {
+	snap, err := parseSnapshot(snapshot)
+	if err != nil {
+		return fmt.Errorf("cannot resume walk: invalid snapshot: %w", err)
+	}
+	if s == nil {
+		if snap.selectorSpec == nil {
+			return fmt.Errorf("cannot resume walk: no selector given, and the snapshot doesn't record one")
+		}
+		if s, err = selector.CompileSelector(snap.selectorSpec); err != nil {
+			return fmt.Errorf("cannot resume walk: invalid selector in snapshot: %w", err)
+		}
+	}
+	if !hasPathPrefix(snap.state.at, prog.Path) {
+		return fmt.Errorf("cannot resume walk: snapshot path %q is not within the walk's path %q", snap.state.at, prog.Path)
+	}
+
+	prog.init()
+	if prog.Cfg.NewFrontier != nil {
+		return fmt.Errorf("cannot resume walk: resuming a walk with a Frontier is not supported")
+	}
+	if snap.budget != nil {
+		prog.Budget = snap.budget
+	}
+	if snap.seenLinks != nil {
+		if prog.SeenLinks == nil {
+			prog.SeenLinks = make(map[datamodel.Link]struct{}, len(snap.seenLinks))
+		}
+		for _, lnk := range snap.seenLinks {
+			prog.SeenLinks[lnk] = struct{}{}
+		}
+	}
+	prog.resume = &snap.state
+	if err := prog.walkRoot(n, s, fn); err != nil {
+		return err
+	}
+	if !snap.state.reached {
+		return fmt.Errorf("cannot resume walk: the walk does not reach the snapshot path %q", snap.state.at)
+	}
+	return nil
+}
+
+// resumeState is where a resumed walk left off.
+// It's shared by every Progress on the path back there.
+type resumeState struct {
+	at        datamodel.Path
+	lastBlock struct {
+		Path datamodel.Path
+		Link datamodel.Link
+	}
+	sawLastBlock bool // set when the walk follows lastBlock.Link again.
+	reached      bool // set when the walk gets back to at.
+}
+
+// checkLastBlock is called for each link the walk follows on its way back.
+func (rs *resumeState) checkLastBlock(p datamodel.Path, lnk datamodel.Link) error {
+	if rs.lastBlock.Link == nil || p.String() != rs.lastBlock.Path.String() {
+		return nil
+	}
+	if lnk.Binary() != rs.lastBlock.Link.Binary() {
+		return fmt.Errorf("cannot resume walk: link at %q is %q, not %q as in the snapshot", p, lnk, rs.lastBlock.Link)
+	}
+	rs.sawLastBlock = true
+	return nil
+}
+
+// arrive is called when the walk gets back to where it left off.
+func (rs *resumeState) arrive() error {
+	if rs.lastBlock.Link != nil && !rs.sawLastBlock {
+		return fmt.Errorf("cannot resume walk: no link at %q, where the snapshot has %q", rs.lastBlock.Path, rs.lastBlock.Link)
+	}
+	rs.reached = true
+	return nil
+}
+
+func hasPathPrefix(p, prefix datamodel.Path) bool {
+	if p.Len() < prefix.Len() {
+		return false
+	}
+	segs := p.Segments()
+	for i, ps := range prefix.Segments() {
+		if !ps.Equals(segs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+type parsedSnapshot struct {
+	state        resumeState
+	budget       *Budget
+	seenLinks    []datamodel.Link
+	selectorSpec datamodel.Node
+}
+
+func parseSnapshot(n datamodel.Node) (*parsedSnapshot, error) {
+	var snap parsedSnapshot
+	if n.Kind() != datamodel.Kind_Map {
+		return nil, fmt.Errorf("expected a map, got %s", n.Kind())
+	}
+	var err error
+	if snap.state.at, err = parseSnapshotPath(n, "path"); err != nil {
+		return nil, err
+	}
+	if lb, err := lookupOptional(n, "lastBlock"); err != nil {
+		return nil, err
+	} else if lb != nil {
+		if snap.state.lastBlock.Path, err = parseSnapshotPath(lb, "lastBlock.path"); err != nil {
+			return nil, err
+		}
+		if snap.state.lastBlock.Link, err = lookupLink(lb, "link"); err != nil {
+			return nil, fmt.Errorf("lastBlock.%w", err)
+		}
+	}
+	if b, err := lookupOptional(n, "budget"); err != nil {
+		return nil, err
+	} else if b != nil {
+		snap.budget = &Budget{}
+		if snap.budget.NodeBudget, err = lookupInt(b, "nodes"); err != nil {
+			return nil, fmt.Errorf("budget.%w", err)
+		}
+		if snap.budget.LinkBudget, err = lookupInt(b, "links"); err != nil {
+			return nil, fmt.Errorf("budget.%w", err)
+		}
+		if v, err := lookupOptional(b, "bytes"); err != nil {
+			return nil, fmt.Errorf("budget.%w", err)
+		} else if v != nil {
+			n, err := v.AsInt()
+			if err != nil {
+				return nil, fmt.Errorf("budget.bytes: %w", err)
+			}
+			snap.budget.LimitBytes(n)
+		}
+		if v, err := lookupOptional(b, "deadline"); err != nil {
+			return nil, fmt.Errorf("budget.%w", err)
+		} else if v != nil {
+			s, err := v.AsString()
+			if err == nil {
+				snap.budget.Deadline, err = time.Parse(time.RFC3339Nano, s)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("budget.deadline: %w", err)
+			}
+		}
+	}
+	if sl, err := lookupOptional(n, "seenLinks"); err != nil {
+		return nil, err
+	} else if sl != nil {
+		if sl.Kind() != datamodel.Kind_List {
+			return nil, fmt.Errorf("seenLinks: expected a list, got %s", sl.Kind())
+		}
+		snap.seenLinks = make([]datamodel.Link, 0, sl.Length())
+		for itr := sl.ListIterator(); !itr.Done(); {
+			_, v, err := itr.Next()
+			if err != nil {
+				return nil, err
+			}
+			lnk, err := v.AsLink()
+			if err != nil {
+				return nil, fmt.Errorf("seenLinks: %w", err)
+			}
+			snap.seenLinks = append(snap.seenLinks, lnk)
+		}
+	}
+	if snap.selectorSpec, err = lookupOptional(n, "selector"); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+func lookupOptional(n datamodel.Node, key string) (datamodel.Node, error) {
+	v, err := n.LookupByString(key)
+	if _, ok := err.(datamodel.ErrNotExists); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return v, nil
+}
+
+// parseSnapshotPath parses the "path" entry of n; what names it in errors.
+func parseSnapshotPath(n datamodel.Node, what string) (datamodel.Path, error) {
+	v, err := n.LookupByString("path")
+	if err != nil {
+		return datamodel.Path{}, fmt.Errorf("%s: %w", what, err)
+	}
+	if v.Kind() != datamodel.Kind_List {
+		return datamodel.Path{}, fmt.Errorf("%s: expected a list, got %s", what, v.Kind())
+	}
+	segs := make([]datamodel.PathSegment, 0, v.Length())
+	for itr := v.ListIterator(); !itr.Done(); {
+		_, seg, err := itr.Next()
+		if err != nil {
+			return datamodel.Path{}, fmt.Errorf("%s: %w", what, err)
+		}
+		s, err := seg.AsString()
+		if err != nil {
+			return datamodel.Path{}, fmt.Errorf("%s: %w", what, err)
+		}
+		segs = append(segs, datamodel.PathSegmentOfString(s))
+	}
+	return datamodel.NewPath(segs), nil
+}
+
+func lookupLink(n datamodel.Node, key string) (datamodel.Link, error) {
+	v, err := n.LookupByString(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	lnk, err := v.AsLink()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return lnk, nil
+}
+
+func lookupInt(n datamodel.Node, key string) (int64, error) {
+	v, err := n.LookupByString(key)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	i, err := v.AsInt()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return i, nil
+}
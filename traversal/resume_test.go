@@ -0,0 +1,139 @@
+package traversal_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/printer"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+)
+
+var errStop = errors.New("stop")
+
+func recordVisits(visits *[]visit) traversal.AdvVisitFn {
+	return func(prog traversal.Progress, n datamodel.Node, reason traversal.VisitReason) error {
+		var lastBlock string
+		if prog.LastBlock.Link != nil {
+			lastBlock = prog.LastBlock.Path.String() + "@" + prog.LastBlock.Link.String()
+		}
+		*visits = append(*visits, visit{prog.Path.String(), string(reason), printer.Sprint(n), lastBlock})
+		return nil
+	}
+}
+
+func TestResumeWalkAdv(t *testing.T) {
+	store, root := parallelFixture(t, 3, 3)
+	spec := selectorparse.CommonSelector_ExploreAllRecursively
+	exploreAll, err := selector.CompileSelector(spec)
+	qt.Assert(t, err, qt.IsNil)
+
+	for _, tc := range []struct {
+		name   string
+		cfg    traversal.Config
+		budget *traversal.Budget
+	}{
+		{name: "plain"},
+		{name: "LinkVisitOnlyOnce", cfg: traversal.Config{LinkVisitOnlyOnce: true}},
+		{name: "StartAtPath", cfg: traversal.Config{StartAtPath: datamodel.ParsePath("children/1/children/2")}},
+		{name: "budget", budget: &traversal.Budget{NodeBudget: 1000, LinkBudget: 20}},
+		{name: "byte budget", budget: (&traversal.Budget{NodeBudget: 1000, LinkBudget: 1000, Deadline: time.Now().Add(time.Hour)}).LimitBytes(1000)},
+		{name: "Parallelism", cfg: traversal.Config{Parallelism: 4, LinkVisitOnlyOnce: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var loads int
+			lsys := cidlink.DefaultLinkSystem()
+			lsys.SetReadStorage(store)
+			read := lsys.StorageReadOpener
+			lsys.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+				loads++
+				return read(lctx, lnk)
+			}
+			cfg := tc.cfg
+			cfg.LinkSystem = lsys
+			cfg.LinkTargetNodePrototypeChooser = basicnode.Chooser
+			n, err := lsys.Load(linking.LinkContext{}, root, basicnode.Prototype.Any)
+			qt.Assert(t, err, qt.IsNil)
+
+			var expected []visit
+			expectedErr := traversal.Progress{Cfg: &cfg, Budget: tc.budget.Clone()}.WalkAdv(n, exploreAll, recordVisits(&expected))
+			qt.Assert(t, expectedErr != nil, qt.Equals, tc.budget != nil)
+
+			resumeLoads := make([]int, len(expected))
+			for stopAt := range expected {
+				// Walk as far as the visit to stop at, and snapshot there.
+				var before []visit
+				var snapshot bytes.Buffer
+				err := traversal.Progress{Cfg: &cfg, Budget: tc.budget.Clone()}.WalkAdv(n, exploreAll, func(prog traversal.Progress, n datamodel.Node, reason traversal.VisitReason) error {
+					if err := recordVisits(&before)(prog, n, reason); err != nil {
+						return err
+					}
+					if len(before) <= stopAt {
+						return nil
+					}
+					snap, err := prog.Snapshot(spec)
+					qt.Assert(t, err, qt.IsNil)
+					qt.Assert(t, ipld.EncodeStreaming(&snapshot, snap, dagjson.Encode), qt.IsNil)
+					return errStop
+				})
+				qt.Assert(t, err, qt.Equals, errStop)
+
+				// Resume, in a fresh walk, from the encoded snapshot.
+				snap, err := ipld.Decode(snapshot.Bytes(), dagjson.Decode)
+				qt.Assert(t, err, qt.IsNil)
+				loads = 0
+				var after []visit
+				err = traversal.Progress{Cfg: &cfg}.ResumeWalkAdv(snap, n, nil, recordVisits(&after))
+				resumeLoads[stopAt] = loads
+				qt.Check(t, fmt.Sprint(err), qt.Equals, fmt.Sprint(expectedErr))
+				qt.Assert(t, append(before, after...), qt.DeepEquals, expected, qt.Commentf("stopped at %d, snapshot %s", stopAt, snapshot.String()))
+			}
+
+			// Resuming after the last visit in the last block only reloads the blocks on the path to it.
+			if tc.budget == nil && tc.cfg.Parallelism == 0 {
+				for i, v := range expected {
+					if v.Path == "children/2/children/2/children/2/leaf" {
+						qt.Check(t, resumeLoads[i], qt.Equals, 3)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("mismatch", func(t *testing.T) {
+		cfg := traversal.Config{LinkSystem: cidlink.DefaultLinkSystem(), LinkTargetNodePrototypeChooser: basicnode.Chooser}
+		cfg.LinkSystem.SetReadStorage(store)
+		n, err := cfg.LinkSystem.Load(linking.LinkContext{}, root, basicnode.Prototype.Any)
+		qt.Assert(t, err, qt.IsNil)
+		var snap datamodel.Node
+		err = traversal.Progress{Cfg: &cfg}.WalkAdv(n, exploreAll, func(prog traversal.Progress, n datamodel.Node, reason traversal.VisitReason) error {
+			if prog.Path.Len() < 5 {
+				return nil
+			}
+			snap, err = prog.Snapshot(nil)
+			qt.Assert(t, err, qt.IsNil)
+			return errStop
+		})
+		qt.Assert(t, err, qt.Equals, errStop)
+
+		err = traversal.Progress{Cfg: &cfg}.ResumeWalkAdv(snap, n, nil, recordVisits(new([]visit)))
+		qt.Check(t, err, qt.ErrorMatches, "cannot resume walk: no selector given, and the snapshot doesn't record one")
+		err = traversal.Progress{Cfg: &cfg}.ResumeWalkAdv(snap, rootNode, exploreAll, recordVisits(new([]visit)))
+		qt.Check(t, err, qt.ErrorMatches, "cannot resume walk: the walk does not reach the snapshot path .*")
+		err = traversal.Progress{Cfg: &cfg}.ResumeWalkAdv(snap, leafAlpha, exploreAll, recordVisits(new([]visit)))
+		qt.Check(t, err, qt.ErrorMatches, "cannot resume walk: the walk does not reach the snapshot path .*")
+	})
+}
@@ -1,8 +1,11 @@
 package traversal
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"time"
 
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/linking"
@@ -15,8 +18,9 @@ import (
 type phase int
 
 const (
-	phasePreload  phase = iota
-	phaseTraverse phase = iota
+	phasePreload   phase = iota
+	phaseTraverse  phase = iota
+	phaseSpeculate phase = iota // a parallel walk looking ahead for links to load.
 )
 
 // WalkLocal walks a tree of Nodes, visiting each of them,
@@ -96,7 +100,7 @@ func WalkTransforming(n datamodel.Node, s selector.Selector, fn TransformFn) (da
 // potentially asynchronously preload any blocks that are going to be encountered at a future point in the walk.
 func (prog Progress) WalkMatching(n datamodel.Node, s selector.Selector, fn VisitFn) error {
 	prog.init()
-	return prog.walkBlock(n, s, func(prog Progress, n datamodel.Node, tr VisitReason) error {
+	return prog.walkRoot(n, s, func(prog Progress, n datamodel.Node, tr VisitReason) error {
 		if tr != VisitReason_SelectionMatch {
 			return nil
 		}
@@ -157,7 +161,22 @@ func (prog Progress) WalkLocal(n datamodel.Node, fn VisitFn) error {
 // An AdvVisitFn is used instead of a VisitFn, so that the reason can be provided.
 func (prog Progress) WalkAdv(n datamodel.Node, s selector.Selector, fn AdvVisitFn) error {
 	prog.init()
-	return prog.walkBlock(n, s, fn)
+	return prog.walkRoot(n, s, fn)
+}
+
+// walkRoot begins a walk, setting up a parallel walk or a frontier if configured.
+func (prog Progress) walkRoot(n datamodel.Node, s selector.Selector, visitFn AdvVisitFn) error {
+	prog.par, prog.blk, prog.frontier = nil, nil, nil
+	if prog.Cfg.NewFrontier != nil {
+		return prog.walkFrontier(n, s, visitFn)
+	}
+	if prog.Cfg.Parallelism <= 1 {
+		return prog.walkBlock(n, s, visitFn)
+	}
+	par := newParallelWalk(prog)
+	defer par.close()
+	prog.par, prog.blk = par, &blockState{}
+	return par.walk(prog, n, s, visitFn)
 }
 
 // walkBlock anchors a walk at the beginning of the traversal and at the
@@ -211,6 +230,16 @@ func (prog Progress) walkAdv(ph phase, n datamodel.Node, s selector.Selector, vi
 		return err
 	}
 
+	// If we're resuming a walk, and this is where it left off, everything from here on is new.
+	if prog.resume != nil && prog.Path.Len() == prog.resume.at.Len() {
+		if ph == phaseTraverse {
+			if err := prog.resume.arrive(); err != nil {
+				return err
+			}
+		}
+		prog.resume = nil
+	}
+
 	// If we're handling scalars (e.g. not maps and lists) we can return now.
 	switch n.Kind() {
 	case datamodel.Kind_Map, datamodel.Kind_List: // continue
@@ -221,7 +250,7 @@ func (prog Progress) walkAdv(ph phase, n datamodel.Node, s selector.Selector, vi
 	// For maps and lists: recurse (in one of two ways, depending on if the selector also states specific interests).
 
 	haveStartAtPath := prog.Cfg.StartAtPath.Len() > 0
-	var reachedStartAtPath bool
+	var reachedStartAtPath, reachedResume bool
 	recurse := func(v datamodel.Node, ps datamodel.PathSegment) error {
 		// First, make sure we're past the start path; if one is specified.
 		if haveStartAtPath {
@@ -237,7 +266,21 @@ func (prog Progress) walkAdv(ph phase, n datamodel.Node, s selector.Selector, vi
 			}
 		}
 
-		if err := prog.explore(ph, s, n, visitFn, v, ps); err != nil {
+		progNext := prog
+		// If we're resuming a walk, skip the children it walked already, and follow the path to where it left off;
+		// children after that one are new.
+		if prog.resume != nil {
+			switch {
+			case reachedResume:
+				progNext.resume = nil
+			case ps.Equals(prog.resume.at.Segments()[prog.Path.Len()]):
+				reachedResume = true
+			default:
+				return nil
+			}
+		}
+
+		if err := progNext.explore(ph, s, n, visitFn, v, ps); err != nil {
 			return err
 		}
 
@@ -277,25 +320,71 @@ func (prog Progress) walkAdv(ph phase, n datamodel.Node, s selector.Selector, vi
 }
 
 func (prog Progress) checkNodeBudget() error {
-	if prog.Budget != nil {
+	if prog.Budget != nil && prog.resume == nil { // A resumed walk counted the nodes on its way back already, before it stopped.
 		if prog.Budget.NodeBudget <= 0 {
 			return &ErrBudgetExceeded{BudgetKind: "node", Path: prog.Path}
 		}
 		prog.Budget.NodeBudget--
 	}
-	return nil
+	return prog.checkDeadline(nil)
 }
 
 func (prog Progress) checkLinkBudget(lnk datamodel.Link) error {
-	if prog.Budget != nil {
+	if prog.Budget != nil && prog.resume == nil {
 		if prog.Budget.LinkBudget <= 0 {
 			return &ErrBudgetExceeded{BudgetKind: "link", Path: prog.Path, Link: lnk}
 		}
 		prog.Budget.LinkBudget--
 	}
+	return prog.checkDeadline(lnk)
+}
+
+// byteLimit returns how many more bytes of blocks the walk may load, or -1 if there's no limit.
+func (prog Progress) byteLimit() int64 {
+	if prog.Budget == nil || !prog.Budget.limitBytes || prog.resume != nil {
+		return -1
+	}
+	return max(prog.Budget.ByteBudget, 0)
+}
+
+// checkByteBudget counts a block of the given size, which has been loaded, against the byte budget.
+func (prog Progress) checkByteBudget(lnk datamodel.Link, size int64) error {
+	if limit := prog.byteLimit(); limit >= 0 {
+		if size > limit {
+			return &ErrBudgetExceeded{BudgetKind: "byte", Path: prog.Path, Link: lnk}
+		}
+		prog.Budget.ByteBudget -= size
+	}
 	return nil
 }
 
+func (prog Progress) checkDeadline(lnk datamodel.Link) error {
+	if prog.deadline().IsZero() || time.Now().Before(prog.Budget.Deadline) {
+		return nil
+	}
+	return &ErrBudgetExceeded{BudgetKind: "time", Path: prog.Path, Link: lnk}
+}
+
+// missedDeadline reports whether err is due to the deadline passing (rather than the walk's own context ending).
+func (prog Progress) missedDeadline(err error) bool {
+	return err != nil && !prog.deadline().IsZero() && errors.Is(err, context.DeadlineExceeded) && prog.Cfg.Ctx.Err() == nil
+}
+
+// timeBudgetError replaces err with a time budget error if it's due to the deadline passing.
+func (prog Progress) timeBudgetError(lnk datamodel.Link, err error) error {
+	if prog.missedDeadline(err) {
+		return &ErrBudgetExceeded{BudgetKind: "time", Path: prog.Path, Link: lnk}
+	}
+	return err
+}
+
+func (prog Progress) deadline() time.Time {
+	if prog.Budget == nil || prog.resume != nil {
+		return time.Time{}
+	}
+	return prog.Budget.Deadline
+}
+
 func (prog Progress) reify(n datamodel.Node, s selector.Selector) (datamodel.Node, selector.Selector, error) {
 	// refiy the node if advised.
 	if rs, ok := s.(selector.Reifiable); ok {
@@ -337,6 +426,9 @@ func (prog Progress) visit(ph phase, n datamodel.Node, s selector.Selector, visi
 	if !prog.PastStartAtPath && prog.Path.Len() < prog.Cfg.StartAtPath.Len() {
 		return nil
 	}
+	if prog.resume != nil { // Visited before the walk stopped.
+		return nil
+	}
 
 	// Decide if this node is matched -- do callbacks as appropriate.
 	match, err := s.Match(n)
@@ -375,7 +467,16 @@ func (prog Progress) explore(
 	}
 
 	lnk, _ := v.AsLink()
-	if prog.Cfg.LinkVisitOnlyOnce {
+	var ord []int
+	if prog.par != nil && ph != phasePreload {
+		ord = prog.blk.nextOrdinal()
+	}
+	if ph == phaseSpeculate {
+		prog.par.speculate(progNext, lnk, v, n, sNext, ord)
+		return nil
+	}
+
+	if prog.Cfg.LinkVisitOnlyOnce && progNext.resume == nil { // A resumed walk has seen the links on its way back before, but has to follow them again.
 		if _, seen := prog.SeenLinks[lnk]; seen {
 			return nil
 		}
@@ -393,6 +494,11 @@ func (prog Progress) explore(
 			BasePath:   prog.Path,
 			ParentNode: n,
 		}
+		if limit := prog.byteLimit(); limit == 0 {
+			return nil // Nothing more can be loaded, so there's no point preloading anything.
+		} else if limit > 0 {
+			pctx.MaxBytes = limit
+		}
 		pl := preload.Link{
 			Segment:  ps,
 			LinkNode: v,
@@ -404,6 +510,19 @@ func (prog Progress) explore(
 
 	progNext.LastBlock.Path = progNext.Path
 	progNext.LastBlock.Link = lnk
+	if progNext.resume != nil && ph == phaseTraverse {
+		if err := progNext.resume.checkLastBlock(progNext.Path, lnk); err != nil {
+			return err
+		}
+	}
+
+	if prog.frontier != nil {
+		prog.frontier.push(progNext, lnk, v, n, sNext, visitFn)
+		return nil
+	}
+	if prog.par != nil {
+		return prog.par.follow(progNext, lnk, v, n, sNext, ord, visitFn)
+	}
 
 	v, err = progNext.loadLink(lnk, v, n)
 	if err != nil {
@@ -422,6 +541,21 @@ func (prog Progress) loadLink(lnk datamodel.Link, v datamodel.Node, parent datam
 	if err := prog.checkLinkBudget(lnk); err != nil {
 		return nil, err
 	}
+	n, size, err := prog.loadBlock(lnk, v, parent, prog.byteLimit())
+	if err != nil {
+		return nil, err
+	}
+	if err := prog.checkByteBudget(lnk, size); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// loadBlock does the loading for loadLink, without counting the load against any budget.
+// It returns the size of the block, too.
+// If limit isn't -1, it fails with a byte budget error as soon as more than that many bytes have been read.
+// It also fails with a time budget error if the deadline passes during the load.
+func (prog Progress) loadBlock(lnk datamodel.Link, v datamodel.Node, parent datamodel.Node, limit int64) (datamodel.Node, int64, error) {
 	// Put together the context info we'll offer to the loader and prototypeChooser.
 	lnkCtx := linking.LinkContext{
 		Ctx:        prog.Cfg.Ctx,
@@ -429,22 +563,79 @@ func (prog Progress) loadLink(lnk datamodel.Link, v datamodel.Node, parent datam
 		LinkNode:   v,
 		ParentNode: parent,
 	}
+	if deadline := prog.deadline(); !deadline.IsZero() {
+		ctx, cancel := context.WithDeadline(lnkCtx.Ctx, deadline)
+		defer cancel()
+		lnkCtx.Ctx = ctx
+	}
 	// Pick what in-memory format we will build.
 	np, err := prog.Cfg.LinkTargetNodePrototypeChooser(lnk, lnkCtx)
 	if err != nil {
-		return nil, fmt.Errorf("error traversing node at %q: could not load link %q: %w", prog.Path, lnk, err)
+		return nil, 0, fmt.Errorf("error traversing node at %q: could not load link %q: %w", prog.Path, lnk, err)
+	}
+	// Load link, counting the bytes read.
+	lsys := prog.Cfg.LinkSystem
+	cr := &countingReader{limit: limit}
+	if open := lsys.StorageReadOpener; open != nil {
+		lsys.StorageReadOpener = func(lnkCtx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+			r, err := open(lnkCtx, lnk)
+			if err != nil {
+				return nil, err
+			}
+			cr.r = r
+			return cr, nil
+		}
 	}
-	// Load link!
-	n, err := prog.Cfg.LinkSystem.Load(lnkCtx, lnk, np)
+	n, err := lsys.Load(lnkCtx, lnk, np)
 	if err != nil {
+		if cr.tripped {
+			return nil, 0, &ErrBudgetExceeded{BudgetKind: "byte", Path: prog.Path, Link: lnk}
+		}
+		if prog.missedDeadline(err) {
+			return nil, 0, &ErrBudgetExceeded{BudgetKind: "time", Path: prog.Path, Link: lnk}
+		}
 		if _, ok := err.(SkipMe); ok {
-			return nil, err
+			return nil, 0, err
 		}
-		return nil, fmt.Errorf("error traversing node at %q: could not load link %q: %w", prog.Path, lnk, err)
+		return nil, 0, fmt.Errorf("error traversing node at %q: could not load link %q: %w", prog.Path, lnk, err)
 	}
-	return n, nil
+	return n, cr.n, nil
 }
 
+// countingReader counts the bytes read through it, and fails once there are more than limit (unless limit is -1).
+type countingReader struct {
+	r       io.Reader
+	n       int64
+	limit   int64
+	tripped bool
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	if cr.tripped {
+		return 0, errByteBudget
+	}
+	if cr.limit >= 0 && int64(len(p)) > cr.limit-cr.n+1 {
+		p = p[:cr.limit-cr.n+1] // No need to read more than one byte past the limit.
+	}
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	if cr.limit >= 0 && cr.n > cr.limit {
+		cr.tripped = true
+		return n, errByteBudget
+	}
+	return n, err
+}
+
+func (cr *countingReader) Close() error {
+	if closer, ok := cr.r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// errByteBudget is returned by a countingReader to the LinkSystem; loadBlock replaces it with a proper ErrBudgetExceeded.
+var errByteBudget = errors.New("byte budget exceeded")
+
 // WalkTransforming walks a graph of Nodes, deciding which to alter by applying a Selector,
 // and calls the given TransformFn to decide what new node to replace the visited node with.
 // A new Node tree will be returned (the original is unchanged).
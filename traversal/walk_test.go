@@ -1,9 +1,11 @@
 package traversal_test
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 
@@ -454,10 +456,123 @@ func TestWalkBudgets(t *testing.T) {
 					qt.Check(t, preloadLinks[2].Link, qt.Equals, leafBetaLnk)
 				}
 			})
+
+			t.Run("byte-budget-halts", func(t *testing.T) {
+				ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+				s, err := ssb.ExploreAll(ssb.Matcher()).Selector()
+				qt.Assert(t, err, qt.Equals, nil)
+				var order int
+				lsys := cidlink.DefaultLinkSystem()
+				lsys.SetReadStorage(&store)
+				prog := traversal.Progress{
+					Cfg: &traversal.Config{
+						LinkSystem:                     lsys,
+						LinkTargetNodePrototypeChooser: basicnode.Chooser,
+					},
+					Budget: (&traversal.Budget{
+						NodeBudget: 9000,
+						LinkBudget: 9000,
+					}).LimitBytes(7 + 7 + 6), // `"alpha"`, `"alpha"`, `"beta"`.
+				}
+				var preloadContexts []preload.PreloadContext
+				if preloader {
+					prog.Cfg.Preloader = func(pctx preload.PreloadContext, link preload.Link) {
+						preloadContexts = append(preloadContexts, pctx)
+					}
+				}
+				err = prog.WalkMatching(middleListNode, s, func(prog traversal.Progress, n datamodel.Node) error {
+					order++
+					return nil
+				})
+				qt.Check(t, order, qt.Equals, 3)
+				qt.Assert(t, err, qt.Not(qt.Equals), nil)
+				qt.Check(t, err.Error(), qt.Equals, `traversal budget exceeded: budget for bytes ran out while on path "3" (link: "baguqeeyexkjwnfy")`)
+				if !preloader { // (With a preloader, the walk works on a copy of the budget.)
+					qt.Check(t, prog.Budget.ByteBudget, qt.Equals, int64(0))
+				}
+				if preloader {
+					qt.Assert(t, preloadContexts, qt.HasLen, 4)
+					qt.Check(t, preloadContexts[0].MaxBytes, qt.Equals, int64(20))
+				}
+			})
+
+			t.Run("byte-budget-stops-reading", func(t *testing.T) {
+				var read int
+				lsys := cidlink.DefaultLinkSystem()
+				lsys.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+					data, err := store.Get(lctx.Ctx, lnk.Binary())
+					if err != nil {
+						return nil, err
+					}
+					return readCounter{data: data, read: &read}, nil
+				}
+				prog := traversal.Progress{
+					Cfg: &traversal.Config{
+						LinkSystem:                     lsys,
+						LinkTargetNodePrototypeChooser: basicnode.Chooser,
+					},
+					Budget: (&traversal.Budget{NodeBudget: 9000, LinkBudget: 9000}).LimitBytes(3),
+				}
+				if preloader {
+					prog.Cfg.Preloader = func(preload.PreloadContext, preload.Link) {}
+				}
+				err := prog.WalkMatching(middleListNode, matchAll(t), func(traversal.Progress, datamodel.Node) error { return nil })
+				qt.Check(t, err, qt.ErrorMatches, `traversal budget exceeded: budget for bytes ran out while on path "0" .*`)
+				qt.Check(t, read, qt.Equals, 4) // One more than the budget, and no more.
+			})
+
+			t.Run("deadline-halts", func(t *testing.T) {
+				lsys := cidlink.DefaultLinkSystem()
+				lsys.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+					<-lctx.Ctx.Done() // A very slow load.
+					return nil, lctx.Ctx.Err()
+				}
+				prog := traversal.Progress{
+					Cfg: &traversal.Config{
+						LinkSystem:                     lsys,
+						LinkTargetNodePrototypeChooser: basicnode.Chooser,
+					},
+					Budget: &traversal.Budget{NodeBudget: 9000, LinkBudget: 9000, Deadline: time.Now().Add(10 * time.Millisecond)},
+				}
+				if preloader {
+					prog.Cfg.Preloader = func(preload.PreloadContext, preload.Link) {}
+				}
+				err := prog.WalkMatching(middleListNode, matchAll(t), func(traversal.Progress, datamodel.Node) error { return nil })
+				qt.Check(t, err, qt.ErrorMatches, `traversal budget exceeded: deadline passed while on path "0" \(link: "baguqeeyexkjwnfy"\)`)
+				qt.Check(t, errors.Is(err, &traversal.ErrBudgetExceeded{}), qt.IsTrue)
+
+				// Once the deadline has passed, the walk stops at the next node.
+				err = prog.WalkMatching(middleMapNode, matchAll(t), func(traversal.Progress, datamodel.Node) error { return nil })
+				qt.Check(t, err, qt.ErrorMatches, `traversal budget exceeded: deadline passed while on path ""`)
+			})
 		})
 	}
 }
 
+func matchAll(t *testing.T) selector.Selector {
+	s, err := selector.CompileSelector(selectorparse.CommonSelector_MatchAllRecursively)
+	qt.Assert(t, err, qt.IsNil)
+	return s
+}
+
+// readCounter is a reader which counts how many bytes have been read from it, one byte at a time.
+type readCounter struct {
+	data []byte
+	read *int
+}
+
+func (r readCounter) Read(p []byte) (int, error) {
+	if *r.read >= len(r.data) {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	p[0] = r.data[*r.read]
+	*r.read++
+	return 1, nil
+}
+
 func TestWalkBlockLoadOrder(t *testing.T) {
 	// a more nested root that we can use to test SkipMe as well
 	// note that in using `rootNodeLnk` here rather than `rootNode` we're using the
@@ -723,6 +838,55 @@ func TestWalkBlockLoadOrder(t *testing.T) {
 	})
 }
 
+func TestWalkSeenLinks(t *testing.T) {
+	store, root := parallelFixture(t, 2, 3)
+	exploreAll, err := selector.CompileSelector(selectorparse.CommonSelector_ExploreAllRecursively)
+	qt.Assert(t, err, qt.IsNil)
+	cfg := traversal.Config{
+		LinkSystem:                     cidlink.DefaultLinkSystem(),
+		LinkTargetNodePrototypeChooser: basicnode.Chooser,
+		LinkVisitOnlyOnce:              true,
+	}
+	cfg.LinkSystem.SetReadStorage(store)
+	n, err := cfg.LinkSystem.Load(linking.LinkContext{}, root, basicnode.Prototype.Any)
+	qt.Assert(t, err, qt.IsNil)
+
+	var expected []visit
+	qt.Assert(t, traversal.Progress{Cfg: &cfg}.WalkAdv(n, exploreAll, recordVisits(&expected)), qt.IsNil)
+
+	t.Run("nested walks start afresh", func(t *testing.T) {
+		var actual []visit
+		record := recordVisits(&actual)
+		err := traversal.Progress{Cfg: &cfg}.WalkAdv(n, exploreAll, func(prog traversal.Progress, n datamodel.Node, reason traversal.VisitReason) error {
+			if prog.Path.String() == "children" {
+				// A walk of the same subtree from inside the visit function sees every link in it...
+				var nested []visit
+				qt.Assert(t, prog.WalkAdv(n, exploreAll, recordVisits(&nested)), qt.IsNil)
+				qt.Check(t, len(nested) > 1, qt.IsTrue)
+			}
+			return record(prog, n, reason)
+		})
+		qt.Assert(t, err, qt.IsNil)
+		// ... and doesn't stop the outer walk from seeing them too.
+		qt.Check(t, actual, qt.DeepEquals, expected)
+	})
+
+	t.Run("KeepSeenLinks", func(t *testing.T) {
+		cfg := cfg
+		cfg.KeepSeenLinks = true
+		seen := make(map[datamodel.Link]struct{})
+		var first, second []visit
+		qt.Assert(t, traversal.Progress{Cfg: &cfg, SeenLinks: seen}.WalkAdv(n, exploreAll, recordVisits(&first)), qt.IsNil)
+		qt.Check(t, first, qt.DeepEquals, expected)
+		qt.Check(t, len(seen) > 0, qt.IsTrue)
+		// The second walk shares the first's SeenLinks, so follows none of the links again.
+		qt.Assert(t, traversal.Progress{Cfg: &cfg, SeenLinks: seen}.WalkAdv(n, exploreAll, recordVisits(&second)), qt.IsNil)
+		for _, v := range second {
+			qt.Check(t, v.LastBlock, qt.Equals, "")
+		}
+	})
+}
+
 func TestWalk_ADLs(t *testing.T) {
 	// we'll make a reifier that when it sees a list returns a custom element instead.
 	customReifier := func(_ linking.LinkContext, n datamodel.Node, _ *linking.LinkSystem) (datamodel.Node, error) {